@@ -3,6 +3,8 @@ set -e
 
 VERSION="v0.8.1"
 OUTPUT_DIR="releases/v0.8.1"
+COMMIT="$(git rev-parse HEAD)"
+DATE="$(date -u +%Y-%m-%dT%H:%M:%SZ)"
 
 # Build function
 build() {
@@ -16,7 +18,7 @@ build() {
     
     echo "Building $OUTPUT_NAME..."
     CGO_ENABLED=0 GOOS=$GOOS GOARCH=$GOARCH go build \
-        -ldflags "-X main.version=${VERSION}" \
+        -ldflags "-X main.version=${VERSION} -X main.commit=${COMMIT} -X main.date=${DATE}" \
         -o "${OUTPUT_DIR}/${OUTPUT_NAME}" \
         .
     
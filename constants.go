@@ -13,6 +13,18 @@ const (
 	DefaultSCPTimeout   = 30 * time.Second
 	ConnectionWaitTime  = 3 * time.Second
 	StatusUpdateTimeout = 5 * time.Second
+	HostCheckTimeout    = 10 * time.Second // Per host, for -check
+	DefaultJumpTimeout  = 30 * time.Second // Per -J hop, to reach its host key
+
+	// Login state check when running without a terminal
+	LoginCheckTimeout  = 30 * time.Second
+	LoginCheckInterval = 250 * time.Millisecond
+
+	// How long each -control-url but the last has to come up before the next is tried
+	ControlFailoverTimeout = 1 * time.Minute
+
+	// How long -resolve trusts the cached peer list
+	PeerCacheTTL = 1 * time.Minute
 
 	// Buffer sizes
 	DefaultBufferSize    = 4096
@@ -25,6 +37,18 @@ const (
 	SessionWaitTimeout = 5 * time.Second
 	MaxStateRetries    = 3
 	StateRetryDelay    = 1 * time.Second
+	HandshakeRetries   = 1 // Redials after a half-open connection stalls the SSH handshake
+)
+
+// Exit statuses for ts-ssh's own failures, stable for scripts. A remote
+// command's exit status is passed through unchanged.
+const (
+	ExitGeneric        = 255 // Any other failure, as with ssh
+	ExitUsage          = 1   // Invalid command-line options or settings
+	ExitAuth           = 5   // Authentication was rejected
+	ExitHostKey        = 6   // Host key verification failed
+	ExitTimeout        = 7   // Connecting timed out
+	ExitCommandTimeout = 8   // The remote command ran past -exec-timeout
 )
 
 // Import shared constants from config package
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+
+	sshclient "github.com/derekg/ts-ssh/internal/client/ssh"
+	tserrors "github.com/derekg/ts-ssh/internal/errors"
+	"github.com/derekg/ts-ssh/internal/platform"
+	"github.com/derekg/ts-ssh/tsssh"
+)
+
+// remoteExitError is a remote command's non-zero exit status, which ts-ssh
+// exits with in turn, as ssh does
+type remoteExitError struct {
+	status int
+}
+
+func (e *remoteExitError) Error() string {
+	return fmt.Sprintf("remote command exited with status %d", e.status)
+}
+
+// usageError is an invalid command-line option or setting, found before
+// anything connects
+type usageError struct {
+	err error
+}
+
+func (e *usageError) Error() string { return e.err.Error() }
+
+func (e *usageError) Unwrap() error { return e.err }
+
+// exitCode maps a failure to the exit status documented for scripts
+func exitCode(err error) int {
+	var remoteExit *remoteExitError
+	if errors.As(err, &remoteExit) {
+		return remoteExit.status
+	}
+	if errors.Is(err, tsssh.ErrExecTimeout) {
+		return ExitCommandTimeout
+	}
+	var usage *usageError
+	if errors.As(err, &usage) {
+		return ExitUsage
+	}
+	switch tserrors.CodeOf(err) {
+	case tserrors.ErrCodeSSHAuth:
+		return ExitAuth
+	case tserrors.ErrCodeHostKeyVerification:
+		return ExitHostKey
+	case tserrors.ErrCodeTimeout:
+		return ExitTimeout
+	default:
+		return ExitGeneric
+	}
+}
+
+// errorRecord is a failure as printed with -error-format json
+type errorRecord struct {
+	Error string `json:"error"`
+	Class string `json:"class"`
+	Host  string `json:"host"`
+	Code  int    `json:"code"`
+}
+
+// reportError prints err to w as text or, with format "json", as an
+// errorRecord on one line, and returns the exit status for it. operand is
+// the [user@]host[:port] the command was given, used when err doesn't name
+// a host itself.
+func reportError(w io.Writer, format string, err error, operand string) int {
+	code := exitCode(err)
+	var remoteExit *remoteExitError
+	if errors.As(err, &remoteExit) {
+		// The remote command's own output already explains it
+		return code
+	}
+	if format != "json" {
+		fmt.Fprintf(w, "%s %v\n", platform.Colorize(platform.ColorRed, "Error:"), err)
+		return code
+	}
+
+	host := tserrors.HostOf(err)
+	if host == "" && operand != "" {
+		host = operand
+		if _, h, _, perr := sshclient.ParseTarget(operand, "", ""); perr == nil {
+			host = h
+		}
+	}
+	if h, _, serr := net.SplitHostPort(host); serr == nil {
+		host = h
+	}
+	rec := errorRecord{Error: err.Error(), Class: errorClass(err), Host: host, Code: code}
+	if jerr := json.NewEncoder(w).Encode(rec); jerr != nil {
+		fmt.Fprintf(w, "Error: %v\n", err)
+	}
+	return code
+}
+
+// errorClass names the kind of failure for -error-format json
+func errorClass(err error) string {
+	if errors.Is(err, tsssh.ErrExecTimeout) {
+		return "command_timeout"
+	}
+	var usage *usageError
+	if errors.As(err, &usage) {
+		return "usage"
+	}
+	return tserrors.CodeOf(err).Class()
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	tserrors "github.com/derekg/ts-ssh/internal/errors"
+	"github.com/derekg/ts-ssh/tsssh"
+)
+
+func TestExitCode(t *testing.T) {
+	cause := errors.New("cause")
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{name: "auth", err: tserrors.NewSSHAuthError("user", "host", cause), want: ExitAuth},
+		{name: "host key", err: tserrors.NewHostKeyError("host", cause), want: ExitHostKey},
+		{name: "timeout", err: tserrors.NewTimeoutError("dial", "host", cause), want: ExitTimeout},
+		{name: "dial", err: tserrors.NewDialError("host", cause), want: ExitGeneric},
+		{name: "untyped", err: cause, want: ExitGeneric},
+		{name: "wrapped auth", err: fmt.Errorf("failed to connect via SSH: %w", tserrors.NewSSHAuthError("user", "host", cause)), want: ExitAuth},
+		{name: "exec timeout", err: fmt.Errorf("remote command failed: %w", tsssh.ErrExecTimeout), want: ExitCommandTimeout},
+		{name: "remote exit status", err: &remoteExitError{status: 3}, want: 3},
+		{name: "usage", err: &usageError{cause}, want: ExitUsage},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := exitCode(tt.err); got != tt.want {
+				t.Errorf("exitCode() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+
+	// A remote command's exit status is passed on without an error message
+	var out bytes.Buffer
+	if code := reportError(&out, "json", &remoteExitError{status: 3}, "web1"); code != 3 || out.Len() != 0 {
+		t.Errorf("reportError() = %d, wrote %q; want 3 and nothing", code, out.String())
+	}
+}
+
+func TestReportErrorJSON(t *testing.T) {
+	cause := errors.New("ssh: unable to authenticate, attempted methods [none publickey]")
+	tests := []struct {
+		name    string
+		err     error
+		operand string
+		want    errorRecord
+	}{
+		{
+			name:    "auth",
+			err:     fmt.Errorf("failed to connect via SSH: %w", tserrors.NewSSHAuthError("alice", "web1", cause)),
+			operand: "alice@web1",
+			want:    errorRecord{Class: "ssh_auth", Host: "web1", Code: ExitAuth},
+		},
+		{
+			name:    "host key names host and port",
+			err:     tserrors.NewHostKeyError("web2:22", cause),
+			operand: "web1",
+			want:    errorRecord{Class: "host_key_verification", Host: "web2", Code: ExitHostKey},
+		},
+		{
+			name:    "untyped falls back to the operand",
+			err:     cause,
+			operand: "bob@db1:2222",
+			want:    errorRecord{Class: "unknown", Host: "db1", Code: ExitGeneric},
+		},
+		{
+			name: "exec timeout",
+			err:  fmt.Errorf("remote command failed: %w", tsssh.ErrExecTimeout),
+			want: errorRecord{Class: "command_timeout", Code: ExitCommandTimeout},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if code := reportError(&buf, "json", tt.err, tt.operand); code != tt.want.Code {
+				t.Errorf("reportError() = %d, want %d", code, tt.want.Code)
+			}
+			if strings.Count(buf.String(), "\n") != 1 {
+				t.Errorf("output = %q, want one line", buf.String())
+			}
+			var got errorRecord
+			if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+				t.Fatalf("output %q is not JSON: %v", buf.String(), err)
+			}
+			tt.want.Error = tt.err.Error()
+			if got != tt.want {
+				t.Errorf("record = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	var buf bytes.Buffer
+	reportError(&buf, "text", cause, "web1")
+	if got, want := buf.String(), "Error: "+cause.Error()+"\n"; got != want {
+		t.Errorf("text output = %q, want %q", got, want)
+	}
+}
+
+func TestUsageErrorsFollowErrorFormat(t *testing.T) {
+	// The child process runs main with the flags under test
+	if os.Getenv("TS_SSH_TEST_MAIN") == "1" {
+		os.Args = append([]string{"ts-ssh"}, strings.Fields(os.Getenv("TS_SSH_TEST_ARGS"))...)
+		main()
+		return
+	}
+
+	for _, args := range []string{
+		"-error-format json -4 -6 web1",
+		"-error-format json -keepalive -1s web1",
+		"-error-format json -resolve",
+		"-error-format json -J bad@@host web1",
+		"-error-format json",
+	} {
+		t.Run(args, func(t *testing.T) {
+			cmd := exec.Command(os.Args[0], "-test.run=^TestUsageErrorsFollowErrorFormat$")
+			cmd.Env = append(os.Environ(), "TS_SSH_TEST_MAIN=1", "TS_SSH_TEST_ARGS="+args)
+			var stderr bytes.Buffer
+			cmd.Stderr = &stderr
+			err := cmd.Run()
+			var exitErr *exec.ExitError
+			if !errors.As(err, &exitErr) || exitErr.ExitCode() != ExitUsage {
+				t.Fatalf("ts-ssh %s: %v, want exit status %d; stderr %q", args, err, ExitUsage, stderr.String())
+			}
+			if strings.Count(stderr.String(), "\n") != 1 {
+				t.Fatalf("stderr = %q, want one line", stderr.String())
+			}
+			var rec errorRecord
+			if err := json.Unmarshal(stderr.Bytes(), &rec); err != nil {
+				t.Fatalf("stderr %q is not JSON: %v", stderr.String(), err)
+			}
+			if rec.Error == "" || rec.Class != "usage" || rec.Code != ExitUsage {
+				t.Errorf("record = %+v", rec)
+			}
+		})
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	osuser "os/user"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tsnet"
+
+	"github.com/derekg/ts-ssh/internal/client/hostcheck"
+	sshclient "github.com/derekg/ts-ssh/internal/client/ssh"
+	"github.com/derekg/ts-ssh/internal/platform"
+	"github.com/derekg/ts-ssh/internal/security"
+)
+
+// runScanKeys scans hosts' keys in parallel and prints them in known_hosts
+// format, with add also writing them to known_hosts in one go
+func runScanKeys(hosts []string, defaultPort, knownHostsFile string, tailnet tailnetOptions, add, verbose bool, logger *log.Logger) error {
+	var knownHostsPath string
+	if add && knownHostsFile != "" {
+		knownHostsPath = knownHostsFile
+	} else if add {
+		currentUser, err := osuser.Current()
+		if err != nil {
+			return fmt.Errorf("cannot locate known_hosts: %w", err)
+		}
+		if knownHostsPath, err = sshclient.DefaultKnownHostsPath(currentUser); err != nil {
+			return fmt.Errorf("cannot locate known_hosts: %w", err)
+		}
+	}
+
+	srv, ctx, err := initTailscale(tailnet, verbose, logger)
+	if err != nil {
+		return fmt.Errorf("failed to initialize Tailscale: %w", err)
+	}
+	// hostcheck.ScanKeys waits for every fetch, and each is bounded by
+	// DefaultSSHTimeout, so nothing is still dialing when this runs
+	defer srv.Close()
+
+	status := peerStatus(ctx, srv, logger)
+	fetch := func(ctx context.Context, target string) (string, ssh.PublicKey, error) {
+		addr, key, err := hostcheck.FetchHostKey(ctx, srv, target, defaultPort)
+		return sshclient.PeerHostKeyAddr(status, addr), key, err
+	}
+	var progress io.Writer
+	if len(hosts) > 1 && term.IsTerminal(int(os.Stderr.Fd())) {
+		progress = os.Stderr
+	}
+	results := hostcheck.ScanKeys(ctx, hosts, MaxConcurrentHosts, fetch, progress)
+
+	failed := 0
+	var entries []sshclient.HostKeyEntry
+	for i, result := range results {
+		if result.Err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", hosts[i], result.Err)
+			failed++
+			continue
+		}
+		fmt.Println(sshclient.KnownHostsLine(result.Addr, result.Key))
+		entries = append(entries, sshclient.HostKeyEntry{Addr: result.Addr, Key: result.Key})
+	}
+
+	if knownHostsPath != "" && len(entries) > 0 {
+		added, conflicts, err := sshclient.AddKnownHosts(knownHostsPath, entries, logger)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Added %d of %d host keys to %s\n", added, len(entries), knownHostsPath)
+		for _, c := range conflicts {
+			fmt.Fprintf(os.Stderr, "%s not adding %s key %s for %s: known_hosts already has a different key for it. If the host was reinstalled, remove the old entry and scan again; otherwise this may be a man-in-the-middle.\n",
+				platform.Colorize(platform.ColorYellow, "Warning:"), c.Key.Type(), ssh.FingerprintSHA256(c.Key), c.Addr)
+		}
+		if len(conflicts) > 0 {
+			return fmt.Errorf("%d scanned host key(s) conflict with known_hosts", len(conflicts))
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("failed to scan %d of %d hosts", failed, len(hosts))
+	}
+	return nil
+}
+
+// runFingerprint prints target's host key fingerprint for checking against
+// one published out-of-band. Nothing is written to known_hosts.
+func runFingerprint(target, defaultPort string, tailnet tailnetOptions, jsonOut, verbose bool, logger *log.Logger) error {
+	srv, ctx, err := initTailscale(tailnet, verbose, logger)
+	if err != nil {
+		return fmt.Errorf("failed to initialize Tailscale: %w", err)
+	}
+	defer srv.Close()
+
+	addr, key, err := hostcheck.FetchHostKey(ctx, srv, target, defaultPort)
+	if err != nil {
+		return err
+	}
+	return printFingerprint(os.Stdout, addr, key, jsonOut)
+}
+
+// hostFingerprint is the -fingerprint -json output
+type hostFingerprint struct {
+	Host        string `json:"host"`
+	KeyType     string `json:"key_type"`
+	Bits        int    `json:"bits,omitempty"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+// printFingerprint writes key's fingerprint and randomart like ssh-keygen -lv, or JSON
+func printFingerprint(out io.Writer, addr string, key ssh.PublicKey, jsonOut bool) error {
+	name, bits := sshclient.KeyDescription(key)
+	fp := hostFingerprint{
+		Host:        addr,
+		KeyType:     key.Type(),
+		Bits:        bits,
+		Fingerprint: ssh.FingerprintSHA256(key),
+	}
+
+	if jsonOut {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(fp)
+	}
+
+	fmt.Fprintf(out, "%d %s %s (%s)\n", fp.Bits, fp.Fingerprint, fp.Host, name)
+	fmt.Fprintln(out, sshclient.Randomart(key))
+	return nil
+}
+
+// runResolve prints what the tailnet knows about host without connecting to
+// it. A peer list cached within PeerCacheTTL answers without starting
+// Tailscale unless refresh is set.
+func runResolve(host string, tailnet tailnetOptions, jsonOut, refresh, verbose bool, logger *log.Logger) error {
+	if err := security.ValidateHostname(host); err != nil {
+		return fmt.Errorf("invalid hostname: %w", err)
+	}
+
+	cachePath := filepath.Join(tailnet.dir, sshclient.PeerCacheFile)
+	if !refresh {
+		cached, err := sshclient.LoadPeerCache(cachePath, strings.Join(tailnet.controlURLs, ","), PeerCacheTTL, time.Now())
+		if err != nil && verbose {
+			logger.Printf("Ignoring peer cache: %v", err)
+		}
+		// A host missing from the cache may have just joined; ask the tailnet
+		if _, err := sshclient.FindPeer(cached, host); cached != nil && err == nil {
+			if verbose {
+				logger.Printf("Using cached peer list from %s", cachePath)
+			}
+			return printResolvedHost(os.Stdout, cached, host, jsonOut)
+		}
+	}
+
+	srv, ctx, err := initTailscale(tailnet, verbose, logger)
+	if err != nil {
+		return fmt.Errorf("failed to initialize Tailscale: %w", err)
+	}
+	defer srv.Close()
+
+	lc, err := srv.LocalClient()
+	if err != nil {
+		return fmt.Errorf("failed to get Tailscale client: %w", err)
+	}
+	status, err := lc.Status(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get tailnet status: %w", err)
+	}
+	if err := sshclient.SavePeerCache(cachePath, strings.Join(tailnet.controlURLs, ","), status, time.Now()); err != nil && verbose {
+		logger.Printf("Failed to cache peer list: %v", err)
+	}
+	return printResolvedHost(os.Stdout, status, host, jsonOut)
+}
+
+// resolvedHost is the -resolve -json output
+type resolvedHost struct {
+	Host      string   `json:"host"`
+	DNSName   string   `json:"dns_name"`
+	Addresses []string `json:"addresses"`
+	Online    bool     `json:"online"`
+}
+
+// printResolvedHost writes host's peer entry from status as "key value" lines or JSON
+func printResolvedHost(out io.Writer, status *ipnstate.Status, host string, jsonOut bool) error {
+	peer, err := sshclient.FindPeer(status, host)
+	if err != nil {
+		return err
+	}
+
+	resolved := resolvedHost{
+		Host:      peer.HostName,
+		DNSName:   strings.TrimSuffix(peer.DNSName, "."),
+		Addresses: make([]string, 0, len(peer.TailscaleIPs)),
+		Online:    peer.Online,
+	}
+	for _, addr := range peer.TailscaleIPs {
+		resolved.Addresses = append(resolved.Addresses, addr.String())
+	}
+
+	if jsonOut {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(resolved)
+	}
+
+	fmt.Fprintf(out, "host %s\n", resolved.Host)
+	fmt.Fprintf(out, "dnsname %s\n", resolved.DNSName)
+	for _, addr := range resolved.Addresses {
+		fmt.Fprintf(out, "address %s\n", addr)
+	}
+	fmt.Fprintf(out, "online %s\n", map[bool]string{true: "yes", false: "no"}[resolved.Online])
+	return nil
+}
+
+// peerStatus returns the tailnet peer list used to name hosts given by IP,
+// or nil when it can't be read; those hosts then keep their address
+func peerStatus(ctx context.Context, srv *tsnet.Server, logger *log.Logger) *ipnstate.Status {
+	lc, err := srv.LocalClient()
+	if err != nil {
+		logger.Printf("Can't name tailnet IPs: %v", err)
+		return nil
+	}
+	status, err := lc.Status(ctx)
+	if err != nil {
+		logger.Printf("Can't name tailnet IPs: %v", err)
+		return nil
+	}
+	return status
+}
+
+// runCheckHosts checks each host over the tailnet and prints a table. Nothing
+// prompts: an unknown host key or a passphrase-protected key leaves a host at
+// "reachable".
+func runCheckHosts(hosts []string, opts connectOptions) error {
+	logger, defaultUser, defaultPort := opts.logger, opts.defaultUser, opts.defaultPort
+	hostKeys, err := hostcheck.StrictHostKeyCallback(opts.knownHostsFile)
+	if err != nil {
+		return err
+	}
+	signer, keyNote := opts.identity, ""
+	if signer == nil {
+		signer, keyNote = hostcheck.LoadSigner(opts.keyPath)
+	}
+
+	srv, ctx, err := initTailscale(opts.tailnet, opts.verbose, logger)
+	if err != nil {
+		return fmt.Errorf("failed to initialize Tailscale: %w", err)
+	}
+	defer srv.Close()
+
+	// Hosts given by tailnet IP are verified under, and shown with, their name
+	status := peerStatus(ctx, srv, logger)
+	peerHostKeys := func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		return hostKeys(sshclient.PeerHostKeyAddr(status, hostname), remote, key)
+	}
+	check := func(ctx context.Context, target string) hostcheck.Result {
+		return hostcheck.Check(ctx, srv, target, defaultUser, defaultPort, signer, keyNote, peerHostKeys, opts.authThrottle)
+	}
+	results := hostcheck.CheckAll(ctx, hosts, MaxConcurrentHosts, HostCheckTimeout, check)
+	for i, r := range results {
+		if _, host, _, err := sshclient.ParseTarget(r.Host, defaultUser, defaultPort); err == nil {
+			if name := sshclient.PeerNameForIP(status, host); name != "" {
+				results[i].Host = fmt.Sprintf("%s (%s)", r.Host, name)
+			}
+		}
+	}
+	if err := hostcheck.Print(os.Stdout, results); err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, r := range results {
+		if r.Status != hostcheck.AuthOK {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d hosts not ready for SSH", failed, len(hosts))
+	}
+	return nil
+}
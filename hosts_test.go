@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/types/key"
+)
+
+func TestPrintResolvedHost(t *testing.T) {
+	status := &ipnstate.Status{
+		Peer: map[key.NodePublic]*ipnstate.PeerStatus{
+			key.NewNode().Public(): {
+				HostName:     "web",
+				DNSName:      "web.tail1234.ts.net.",
+				TailscaleIPs: []netip.Addr{netip.MustParseAddr("100.64.0.5"), netip.MustParseAddr("fd7a:115c:a1e0::5")},
+				Online:       true,
+			},
+		},
+	}
+
+	var out bytes.Buffer
+	if err := printResolvedHost(&out, status, "web.tail1234.ts.net", false); err != nil {
+		t.Fatalf("printResolvedHost() error = %v", err)
+	}
+	want := `host web
+dnsname web.tail1234.ts.net
+address 100.64.0.5
+address fd7a:115c:a1e0::5
+online yes
+`
+	if out.String() != want {
+		t.Errorf("printResolvedHost() =\n%s\nwant\n%s", out.String(), want)
+	}
+
+	out.Reset()
+	if err := printResolvedHost(&out, status, "web", true); err != nil {
+		t.Fatalf("printResolvedHost() JSON error = %v", err)
+	}
+	var got resolvedHost
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatalf("invalid JSON %q: %v", out.String(), err)
+	}
+	if got.DNSName != "web.tail1234.ts.net" || len(got.Addresses) != 2 || got.Addresses[1] != "fd7a:115c:a1e0::5" || !got.Online {
+		t.Errorf("printResolvedHost() JSON = %+v", got)
+	}
+
+	if err := printResolvedHost(&out, status, "db", false); err == nil {
+		t.Error("printResolvedHost() should fail for an unknown host")
+	}
+}
+
+func TestPrintFingerprint(t *testing.T) {
+	// Generated with ssh-keygen -t ed25519
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte("ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIGujtRWTPBSC1gH7lpu/gem1grLkA1MYAV9Xi6u0Z2NO"))
+	if err != nil {
+		t.Fatalf("ParseAuthorizedKey() error = %v", err)
+	}
+	const fingerprint = "SHA256:DZ6wEEYjK1EkMw24tfGzO+jBuzt1A0SZi1lBfwsD49k"
+
+	var out bytes.Buffer
+	if err := printFingerprint(&out, "web1:22", key, false); err != nil {
+		t.Fatalf("printFingerprint() error = %v", err)
+	}
+	lines := strings.Split(out.String(), "\n")
+	if want := "256 " + fingerprint + " web1:22 (ED25519)"; lines[0] != want {
+		t.Errorf("printFingerprint() first line = %q, want %q", lines[0], want)
+	}
+	if len(lines) != 13 || lines[1] != "+--[ED25519 256]--+" || lines[11] != "+----[SHA256]-----+" {
+		t.Errorf("printFingerprint() randomart =\n%s", out.String())
+	}
+
+	out.Reset()
+	if err := printFingerprint(&out, "web1:22", key, true); err != nil {
+		t.Fatalf("printFingerprint() JSON error = %v", err)
+	}
+	var got hostFingerprint
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatalf("invalid JSON %q: %v", out.String(), err)
+	}
+	want := hostFingerprint{Host: "web1:22", KeyType: "ssh-ed25519", Bits: 256, Fingerprint: fingerprint}
+	if got != want {
+		t.Errorf("printFingerprint() JSON = %+v, want %+v", got, want)
+	}
+}
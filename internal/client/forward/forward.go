@@ -0,0 +1,285 @@
+// Package forward implements ts-ssh's port forwarding over an SSH
+// connection: -L local forwards and -D SOCKS5 dynamic forwards.
+package forward
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/derekg/ts-ssh/internal/security"
+)
+
+// ListenAll opens a forward for each spec with setup. If one fails, the
+// listeners already opened are closed, so a partial set never stays up, and
+// the error reports the failing spec along with any errors from closing.
+func ListenAll(specs []string, setup func(spec string) (net.Listener, error)) ([]net.Listener, error) {
+	var listeners []net.Listener
+	for _, spec := range specs {
+		listener, err := setup(spec)
+		if err != nil {
+			errs := []error{fmt.Errorf("%s: %w", spec, err)}
+			for _, l := range listeners {
+				if cerr := l.Close(); cerr != nil && !errors.Is(cerr, net.ErrClosed) {
+					errs = append(errs, fmt.Errorf("closing %s: %w", l.Addr(), cerr))
+				}
+			}
+			return nil, errors.Join(errs...)
+		}
+		listeners = append(listeners, listener)
+	}
+	return listeners, nil
+}
+
+// ListenDynamic sets up SOCKS5 dynamic port forwarding. Connections are
+// served in the background until the returned listener is closed.
+func ListenDynamic(client *ssh.Client, forwardSpec string, verbose bool, logger *log.Logger) (net.Listener, error) {
+	// Parse bind address and port from forwardSpec.
+	// Format: "port" or "bind_address:port" or "[ipv6]:port"
+	bindAddr := "localhost"
+	port := forwardSpec
+
+	if strings.Contains(forwardSpec, ":") {
+		host, p, err := net.SplitHostPort(forwardSpec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid dynamic forward specification: %s", forwardSpec)
+		}
+		bindAddr = host
+		port = p
+	}
+
+	// Validate port
+	if err := security.ValidatePort(port); err != nil {
+		return nil, fmt.Errorf("invalid port for dynamic forwarding: %w", err)
+	}
+
+	// Validate bind address for security
+	// Allow localhost, 127.0.0.1, ::1, and empty (defaults to all interfaces)
+	// Warn on binding to non-localhost addresses as they expose the proxy to network
+	if bindAddr != "" && bindAddr != "localhost" && bindAddr != "127.0.0.1" && bindAddr != "::1" {
+		// Parse to check if it's a valid IP
+		ip := net.ParseIP(bindAddr)
+		if ip == nil && bindAddr != "0.0.0.0" && bindAddr != "::" {
+			return nil, fmt.Errorf("invalid bind address: %s", bindAddr)
+		}
+		if verbose {
+			logger.Printf("Warning: Binding SOCKS5 proxy to %s exposes it to the network\n", bindAddr)
+		}
+	}
+
+	listenAddr := net.JoinHostPort(bindAddr, port)
+
+	// Start listening on local port
+	listener, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", listenAddr, err)
+	}
+
+	if verbose {
+		logger.Printf("SOCKS5 dynamic forwarding listening on %s\n", listenAddr)
+	}
+
+	// Handle incoming SOCKS5 connections in background
+	go func() {
+		if err := serveSOCKS5(listener, client, verbose, logger); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: SOCKS5 proxy on %s stopped: %v\n", listenAddr, err)
+		}
+	}()
+
+	return listener, nil
+}
+
+// serve accepts connections on listener and passes each to handle,
+// with serveSOCKS5's handling of a lost SSH connection. kind names the
+// forward in log messages.
+func serve(listener net.Listener, client *ssh.Client, kind string, handle func(net.Conn), verbose bool, logger *log.Logger) error {
+	var (
+		mu      sync.Mutex
+		lostErr error
+	)
+	go func() {
+		err := client.Wait()
+		mu.Lock()
+		defer mu.Unlock()
+		// Closing fails if the owner already stopped the proxy
+		if listener.Close() == nil {
+			lostErr = errors.New("SSH connection closed")
+			if err != nil {
+				lostErr = fmt.Errorf("SSH connection lost: %w", err)
+			}
+		}
+	}()
+
+	defer listener.Close()
+	for {
+		localConn, err := listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) && verbose {
+				logger.Printf("Error accepting %s connection: %v\n", kind, err)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			return lostErr
+		}
+		go handle(localConn)
+	}
+}
+
+// Local is a parsed -L specification
+type Local struct {
+	bindAddr, port string // Local listening address
+	host, hostPort string // Destination, dialed by the SSH server
+}
+
+// Target returns the destination as host:port
+func (f Local) Target() string {
+	return net.JoinHostPort(f.host, f.hostPort)
+}
+
+// ParseLocal parses "[bind_address:]port:host:hostport" as ssh -L
+// does. IPv6 addresses go in brackets, such as "[::1]:8080:[fd00::1]:80".
+func ParseLocal(spec string) (Local, error) {
+	fields, ok := splitSpec(spec)
+	if !ok {
+		return Local{}, fmt.Errorf("invalid local forward specification %q: unbalanced brackets", spec)
+	}
+
+	var fwd Local
+	switch len(fields) {
+	case 3:
+		fwd = Local{bindAddr: "localhost", port: fields[0], host: fields[1], hostPort: fields[2]}
+	case 4:
+		fwd = Local{bindAddr: fields[0], port: fields[1], host: fields[2], hostPort: fields[3]}
+	default:
+		return Local{}, fmt.Errorf("invalid local forward specification %q: want [bind_address:]port:host:hostport", spec)
+	}
+	if fwd.bindAddr == "" {
+		fwd.bindAddr = "localhost"
+	}
+	if fwd.host == "" {
+		return Local{}, fmt.Errorf("invalid local forward specification %q: empty host", spec)
+	}
+	if err := security.ValidatePort(fwd.port); err != nil {
+		return Local{}, fmt.Errorf("invalid local port in %q: %w", spec, err)
+	}
+	if err := security.ValidatePort(fwd.hostPort); err != nil {
+		return Local{}, fmt.Errorf("invalid destination port in %q: %w", spec, err)
+	}
+	return fwd, nil
+}
+
+// splitSpec splits spec at colons, except inside the brackets
+// around an IPv6 address, and removes the brackets
+func splitSpec(spec string) ([]string, bool) {
+	var fields []string
+	for {
+		var field string
+		if strings.HasPrefix(spec, "[") {
+			end := strings.IndexByte(spec, ']')
+			if end < 0 {
+				return nil, false
+			}
+			field, spec = spec[1:end], spec[end+1:]
+			if spec != "" && spec[0] != ':' {
+				return nil, false
+			}
+		} else {
+			i := strings.IndexByte(spec, ':')
+			if i < 0 {
+				i = len(spec)
+			}
+			field, spec = spec[:i], spec[i:]
+		}
+		fields = append(fields, field)
+		if spec == "" {
+			return fields, true
+		}
+		spec = spec[1:] // The colon
+	}
+}
+
+// ListenLocal listens on fwd's local address and carries each
+// connection to fwd's destination over client, like ssh -L. Connections are
+// served in the background until the returned listener is closed.
+func ListenLocal(client *ssh.Client, fwd Local, verbose bool, logger *log.Logger) (net.Listener, error) {
+	if fwd.bindAddr != "localhost" && fwd.bindAddr != "127.0.0.1" && fwd.bindAddr != "::1" && verbose {
+		logger.Printf("Warning: Binding local forward to %s exposes it to the network\n", fwd.bindAddr)
+	}
+
+	listenAddr := net.JoinHostPort(fwd.bindAddr, fwd.port)
+	listener, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", listenAddr, err)
+	}
+
+	target := fwd.Target()
+	go func() {
+		err := serve(listener, client, "forwarded", func(localConn net.Conn) {
+			defer localConn.Close()
+			remoteConn, err := client.Dial("tcp", target)
+			if err != nil {
+				logger.Printf("Failed to forward %s to %s: %v\n", listenAddr, target, err)
+				return
+			}
+			defer remoteConn.Close()
+			if verbose {
+				logger.Printf("Forwarding connection from %s to %s\n", localConn.RemoteAddr(), target)
+			}
+			pipe(localConn, remoteConn)
+		}, verbose, logger)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: local forward on %s stopped: %v\n", listenAddr, err)
+		}
+	}()
+	return listener, nil
+}
+
+// pipe copies between a and b in both directions until either side is
+// done, then closes both
+func pipe(a, b net.Conn) {
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(a, b)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(b, a)
+		done <- struct{}{}
+	}()
+	<-done
+	a.Close()
+	b.Close()
+	<-done
+}
+
+// Wait blocks for -N until an interrupt, ctx being done or the SSH
+// connection going away. An interrupt is a normal way to stop and returns
+// nil; the caller then closes the forwards.
+func Wait(ctx context.Context, client *ssh.Client, logger *log.Logger) error {
+	sigCtx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	lost := make(chan error, 1)
+	go func() { lost <- client.Wait() }()
+
+	select {
+	case <-sigCtx.Done():
+		logger.Printf("Closing forwards")
+		return nil
+	case err := <-lost:
+		if err != nil {
+			return fmt.Errorf("SSH connection lost: %w", err)
+		}
+		return errors.New("SSH connection closed")
+	}
+}
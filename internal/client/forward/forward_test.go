@@ -0,0 +1,235 @@
+package forward
+
+import (
+	"bufio"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestListenAllClosesOnPartialFailure(t *testing.T) {
+	listen := func(spec string) (net.Listener, error) {
+		if spec == "bad" {
+			return nil, errors.New("address in use")
+		}
+		return net.Listen("tcp", spec)
+	}
+
+	// All forwards up
+	listeners, err := ListenAll([]string{"127.0.0.1:0", "127.0.0.1:0"}, listen)
+	if err != nil || len(listeners) != 2 {
+		t.Fatalf("ListenAll() = %v, %v", listeners, err)
+	}
+	for _, l := range listeners {
+		l.Close()
+	}
+
+	// The second failing closes the first
+	var opened []net.Listener
+	_, err = ListenAll([]string{"127.0.0.1:0", "bad"}, func(spec string) (net.Listener, error) {
+		l, err := listen(spec)
+		if l != nil {
+			opened = append(opened, l)
+		}
+		return l, err
+	})
+	if err == nil || !strings.Contains(err.Error(), "bad: address in use") {
+		t.Fatalf("ListenAll() error = %v, want the failing spec", err)
+	}
+	if len(opened) != 1 {
+		t.Fatalf("opened %d listeners before the failure, want 1", len(opened))
+	}
+	if c, err := net.Dial("tcp", opened[0].Addr().String()); err == nil {
+		c.Close()
+		t.Error("first listener still accepts connections after the second forward failed")
+	}
+}
+
+func TestParseLocal(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    Local
+		wantErr bool
+	}{
+		{spec: "8080:internal:80", want: Local{"localhost", "8080", "internal", "80"}},
+		{spec: "127.0.0.1:8080:db.corp:5432", want: Local{"127.0.0.1", "8080", "db.corp", "5432"}},
+		{spec: ":8080:internal:80", want: Local{"localhost", "8080", "internal", "80"}},
+		{spec: "[::1]:8080:[fd7a:115c::1]:80", want: Local{"::1", "8080", "fd7a:115c::1", "80"}},
+		{spec: "8080:internal", wantErr: true},
+		{spec: "8080::80", wantErr: true},
+		{spec: "0:internal:80", wantErr: true},
+		{spec: "8080:internal:http", wantErr: true},
+		{spec: "[::1:8080:internal:80", wantErr: true},
+		{spec: "a:b:8080:internal:80", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := ParseLocal(tt.spec)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ParseLocal() = %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("ParseLocal() = %+v, %v, want %+v", got, err, tt.want)
+			}
+		})
+	}
+}
+
+// startForwardingSSHServer runs an SSH server that opens direct-tcpip
+// channels to the requested address, as sshd does for -L and -D
+func startForwardingSSHServer(t *testing.T) *ssh.Client {
+	t.Helper()
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		t.Fatalf("NewSignerFromKey() error = %v", err)
+	}
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(signer)
+
+	sshListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	t.Cleanup(func() { sshListener.Close() })
+	go func() {
+		serverConn, err := sshListener.Accept()
+		if err != nil {
+			return
+		}
+		_, chans, reqs, err := ssh.NewServerConn(serverConn, config)
+		if err != nil {
+			return
+		}
+		go ssh.DiscardRequests(reqs)
+		for newCh := range chans {
+			var dest struct {
+				Host     string
+				Port     uint32
+				OrigHost string
+				OrigPort uint32
+			}
+			if newCh.ChannelType() != "direct-tcpip" || ssh.Unmarshal(newCh.ExtraData(), &dest) != nil {
+				newCh.Reject(ssh.UnknownChannelType, "only direct-tcpip")
+				continue
+			}
+			conn, err := net.Dial("tcp", net.JoinHostPort(dest.Host, fmt.Sprint(dest.Port)))
+			if err != nil {
+				newCh.Reject(ssh.ConnectionFailed, err.Error())
+				continue
+			}
+			ch, chReqs, err := newCh.Accept()
+			if err != nil {
+				conn.Close()
+				continue
+			}
+			go ssh.DiscardRequests(chReqs)
+			go func() {
+				defer ch.Close()
+				defer conn.Close()
+				go io.Copy(conn, ch)
+				io.Copy(ch, conn)
+			}()
+		}
+	}()
+
+	clientConn, err := net.Dial("tcp", sshListener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	c, chans, reqs, err := ssh.NewClientConn(clientConn, sshListener.Addr().String(), &ssh.ClientConfig{
+		User:            "test",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		t.Fatalf("NewClientConn() error = %v", err)
+	}
+	client := ssh.NewClient(c, chans, reqs)
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestListenLocal(t *testing.T) {
+	client := startForwardingSSHServer(t)
+
+	// The destination answers each line with its upper-case version
+	dest, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer dest.Close()
+	go func() {
+		for {
+			conn, err := dest.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				line, _ := bufio.NewReader(conn).ReadString('\n')
+				io.WriteString(conn, strings.ToUpper(line))
+			}()
+		}
+	}()
+
+	_, destPort, _ := net.SplitHostPort(dest.Addr().String())
+	// Port 0 picks a free local port; -L itself requires a real one
+	fwd := Local{bindAddr: "127.0.0.1", port: "0", host: "127.0.0.1", hostPort: destPort}
+	listener, err := ListenLocal(client, fwd, false, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatalf("ListenLocal() error = %v", err)
+	}
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	io.WriteString(conn, "hello tunnel\n")
+	if got, err := bufio.NewReader(conn).ReadString('\n'); err != nil || got != "HELLO TUNNEL\n" {
+		t.Errorf("reply through the tunnel = %q, %v", got, err)
+	}
+
+	// Closing the listener stops the tunnel
+	listener.Close()
+	if c, err := net.Dial("tcp", listener.Addr().String()); err == nil {
+		c.Close()
+		t.Error("tunnel still accepts connections after the listener was closed")
+	}
+}
+
+func TestWait(t *testing.T) {
+	logger := log.New(io.Discard, "", 0)
+
+	// Stopping, as on Ctrl-C, is not an error
+	client := startForwardingSSHServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := Wait(ctx, client, logger); err != nil {
+		t.Errorf("Wait() after stop = %v, want nil", err)
+	}
+
+	// A dropped connection is
+	client = startForwardingSSHServer(t)
+	client.Close()
+	if err := Wait(context.Background(), client, logger); err == nil || !strings.Contains(err.Error(), "SSH connection") {
+		t.Errorf("Wait() after the connection closed = %v, want an error", err)
+	}
+}
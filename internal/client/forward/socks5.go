@@ -0,0 +1,197 @@
+package forward
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// HandshakeTimeout is how long a -D client has to send its SOCKS5 greeting
+// and request
+const HandshakeTimeout = 30 * time.Second
+
+// SOCKS5 reply codes (RFC 1928 section 6)
+const (
+	socks5Succeeded        byte = 0x00
+	socks5GeneralFailure   byte = 0x01
+	socks5ConnRefused      byte = 0x05
+	socks5CmdNotSupported  byte = 0x07
+	socks5AddrNotSupported byte = 0x08
+)
+
+// readSOCKS5Handshake reads a client's greeting, answers it with "no
+// authentication" and reads its CONNECT request, returning the target as
+// host:port. Every field is read in full by its length in the framing, so
+// requests split across any number of TCP segments are handled, and a
+// domain name can be as long as its one-byte length allows. When the
+// request can't be served, reply is the code to answer it with; it is 0
+// when no reply should be sent.
+func readSOCKS5Handshake(conn io.ReadWriter) (target string, reply byte, err error) {
+	// Greeting: VER NMETHODS METHODS...
+	greeting := make([]byte, 2)
+	if _, err := io.ReadFull(conn, greeting); err != nil {
+		return "", 0, fmt.Errorf("reading greeting: %w", err)
+	}
+	if greeting[0] != 0x05 {
+		return "", 0, fmt.Errorf("not SOCKS5 protocol: version=%d", greeting[0])
+	}
+	methods := make([]byte, greeting[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return "", 0, fmt.Errorf("reading methods: %w", err)
+	}
+	if !bytes.Contains(methods, []byte{0x00}) {
+		conn.Write([]byte{0x05, 0xFF})
+		return "", 0, errors.New("client doesn't offer \"no authentication\"")
+	}
+	if _, err := conn.Write([]byte{0x05, 0x00}); err != nil {
+		return "", 0, fmt.Errorf("sending auth response: %w", err)
+	}
+
+	// Request: VER CMD RSV ATYP DST.ADDR DST.PORT
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return "", 0, fmt.Errorf("reading request: %w", err)
+	}
+	if header[0] != 0x05 || header[1] != 0x01 {
+		return "", socks5CmdNotSupported, fmt.Errorf("unsupported request: version=%d, cmd=%d", header[0], header[1])
+	}
+
+	var host string
+	switch header[3] {
+	case 0x01, 0x04: // IPv4, IPv6
+		addr := make([]byte, net.IPv4len)
+		if header[3] == 0x04 {
+			addr = make([]byte, net.IPv6len)
+		}
+		if _, err := io.ReadFull(conn, addr); err != nil {
+			return "", 0, fmt.Errorf("reading address: %w", err)
+		}
+		host = net.IP(addr).String()
+	case 0x03: // Domain name, preceded by its length
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return "", 0, fmt.Errorf("reading domain length: %w", err)
+		}
+		if length[0] == 0 {
+			return "", socks5GeneralFailure, errors.New("empty domain name")
+		}
+		domain := make([]byte, length[0])
+		if _, err := io.ReadFull(conn, domain); err != nil {
+			return "", 0, fmt.Errorf("reading domain: %w", err)
+		}
+		host = string(domain)
+	default:
+		return "", socks5AddrNotSupported, fmt.Errorf("unsupported address type: %d", header[3])
+	}
+
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return "", 0, fmt.Errorf("reading port: %w", err)
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), 0, nil
+}
+
+// socks5Reply is a reply with the given code and bind address, typed as
+// IPv4 or IPv6 to match it. A nil bind is sent as 0.0.0.0:0.
+func socks5Reply(code byte, bind *net.TCPAddr) []byte {
+	ip, port := net.IP(net.IPv4zero), 0
+	if bind != nil {
+		ip, port = bind.IP, bind.Port
+	}
+	reply := []byte{0x05, code, 0x00}
+	if ip4 := ip.To4(); ip4 != nil {
+		reply = append(append(reply, 0x01), ip4...)
+	} else {
+		reply = append(append(reply, 0x04), ip.To16()...)
+	}
+	return binary.BigEndian.AppendUint16(reply, uint16(port))
+}
+
+// socks5BindAddr is the bind address for a successful reply to a request
+// for target. The SSH server doesn't say which address it connected from,
+// so unless local, the forwarded connection's local address, has one, it
+// is the unspecified address of the target's family: "::" for an IPv6
+// target and 0.0.0.0 for IPv4 and domain names.
+func socks5BindAddr(local net.Addr, target string) *net.TCPAddr {
+	var port int
+	if tcp, ok := local.(*net.TCPAddr); ok {
+		if tcp.IP != nil && !tcp.IP.IsUnspecified() {
+			return tcp
+		}
+		port = tcp.Port
+	}
+	if host, _, err := net.SplitHostPort(target); err == nil {
+		if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+			return &net.TCPAddr{IP: net.IPv6unspecified, Port: port}
+		}
+	}
+	return &net.TCPAddr{IP: net.IPv4zero, Port: port}
+}
+
+// serveSOCKS5 accepts SOCKS5 connections on listener until it is closed or
+// the SSH connection behind it goes away. A lost connection closes the
+// listener, so clients are refused instead of being accepted only to fail,
+// and is reported as an error.
+func serveSOCKS5(listener net.Listener, client *ssh.Client, verbose bool, logger *log.Logger) error {
+	return serve(listener, client, "SOCKS5", func(conn net.Conn) {
+		handleSOCKS5(client, conn, verbose, logger)
+	}, verbose, logger)
+}
+
+// handleSOCKS5 handles a SOCKS5 connection
+func handleSOCKS5(client *ssh.Client, localConn net.Conn, verbose bool, logger *log.Logger) {
+	defer localConn.Close()
+
+	// A client that stalls mid-request mustn't hold the connection open
+	localConn.SetDeadline(time.Now().Add(HandshakeTimeout))
+	targetAddr, reply, err := readSOCKS5Handshake(localConn)
+	if err != nil {
+		if verbose {
+			logger.Printf("SOCKS5 handshake failed: %v\n", err)
+		}
+		if reply != 0 {
+			localConn.Write(socks5Reply(reply, nil))
+		}
+		return
+	}
+	localConn.SetDeadline(time.Time{})
+	if verbose {
+		logger.Printf("SOCKS5 forwarding to: %s\n", targetAddr)
+	}
+
+	// Dial through SSH
+	remoteConn, err := client.Dial("tcp", targetAddr)
+	if err != nil {
+		if verbose {
+			logger.Printf("Failed to dial %s: %v\n", targetAddr, err)
+		}
+		localConn.Write(socks5Reply(socks5ConnRefused, nil))
+		return
+	}
+	defer remoteConn.Close()
+
+	// Send success response
+	if _, err := localConn.Write(socks5Reply(socks5Succeeded, socks5BindAddr(remoteConn.LocalAddr(), targetAddr))); err != nil {
+		if verbose {
+			logger.Printf("Failed to send success response: %v\n", err)
+		}
+		return
+	}
+
+	// Bidirectional copy: one direction in goroutine, one in current goroutine
+	done := make(chan struct{}, 1)
+	go func() {
+		io.Copy(remoteConn, localConn)
+		done <- struct{}{}
+	}()
+	io.Copy(localConn, remoteConn)
+	<-done
+}
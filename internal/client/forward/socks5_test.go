@@ -0,0 +1,555 @@
+package forward
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestParseDynamicForwardSpec(t *testing.T) {
+	tests := []struct {
+		name         string
+		forwardSpec  string
+		wantBindAddr string
+		wantPort     string
+		wantErr      bool
+	}{
+		{
+			name:         "port only",
+			forwardSpec:  "1080",
+			wantBindAddr: "localhost",
+			wantPort:     "1080",
+			wantErr:      false,
+		},
+		{
+			name:         "localhost:port",
+			forwardSpec:  "localhost:1080",
+			wantBindAddr: "localhost",
+			wantPort:     "1080",
+			wantErr:      false,
+		},
+		{
+			name:         "127.0.0.1:port",
+			forwardSpec:  "127.0.0.1:1080",
+			wantBindAddr: "127.0.0.1",
+			wantPort:     "1080",
+			wantErr:      false,
+		},
+		{
+			name:         "ipv6 localhost:port",
+			forwardSpec:  "[::1]:1080",
+			wantBindAddr: "::1",
+			wantPort:     "1080",
+			wantErr:      false,
+		},
+		{
+			name:         "0.0.0.0:port (all interfaces)",
+			forwardSpec:  "0.0.0.0:1080",
+			wantBindAddr: "0.0.0.0",
+			wantPort:     "1080",
+			wantErr:      false,
+		},
+		{
+			name:        "invalid port - too high",
+			forwardSpec: "70000",
+			wantErr:     true,
+		},
+		{
+			name:        "invalid port - not numeric",
+			forwardSpec: "localhost:abc",
+			wantErr:     true,
+		},
+		{
+			name:        "invalid format - too many colons",
+			forwardSpec: "localhost:1080:extra",
+			wantErr:     true,
+		},
+		{
+			name:         "valid high port",
+			forwardSpec:  "8080",
+			wantBindAddr: "localhost",
+			wantPort:     "8080",
+			wantErr:      false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Parse the forwardSpec manually to test logic
+			bindAddr := "localhost"
+			port := tt.forwardSpec
+
+			if strings.Contains(tt.forwardSpec, ":") {
+				host, p, err := net.SplitHostPort(tt.forwardSpec)
+				if err != nil {
+					if !tt.wantErr {
+						t.Errorf("Expected success but got invalid format: %v", err)
+					}
+					return
+				}
+				bindAddr = host
+				port = p
+			}
+
+			if tt.wantErr {
+				// For error cases, we just verify the parsing logic
+				return
+			}
+
+			if bindAddr != tt.wantBindAddr {
+				t.Errorf("bindAddr = %v, want %v", bindAddr, tt.wantBindAddr)
+			}
+			if port != tt.wantPort {
+				t.Errorf("port = %v, want %v", port, tt.wantPort)
+			}
+		})
+	}
+}
+
+func TestServeSOCKS5StopsWhenConnectionLost(t *testing.T) {
+	serve := func(client *ssh.Client) (net.Listener, chan error) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Listen() error = %v", err)
+		}
+		done := make(chan error, 1)
+		go func() { done <- serveSOCKS5(listener, client, false, log.New(io.Discard, "", 0)) }()
+		return listener, done
+	}
+	wait := func(done chan error) error {
+		select {
+		case err := <-done:
+			return err
+		case <-time.After(5 * time.Second):
+			t.Fatal("serveSOCKS5 kept accepting")
+			return nil
+		}
+	}
+
+	// A dropped connection stops the proxy with an error and refuses clients
+	client := startForwardingSSHServer(t)
+	listener, done := serve(client)
+	client.Close()
+	if err := wait(done); err == nil || !strings.Contains(err.Error(), "SSH connection") {
+		t.Errorf("serveSOCKS5() error = %v, want the lost connection reported", err)
+	}
+	if c, err := net.Dial("tcp", listener.Addr().String()); err == nil {
+		c.Close()
+		t.Error("proxy still accepts connections after the SSH connection was lost")
+	}
+
+	// Stopping the proxy while the connection is up is not an error
+	listener, done = serve(startForwardingSSHServer(t))
+	listener.Close()
+	if err := wait(done); err != nil {
+		t.Errorf("serveSOCKS5() error = %v after the listener was closed", err)
+	}
+}
+
+func TestSOCKS5AddressParsing(t *testing.T) {
+	tests := []struct {
+		name     string
+		addrType byte
+		data     []byte
+		wantHost string
+		wantPort uint16
+		wantErr  bool
+	}{
+		{
+			name:     "IPv4 address",
+			addrType: 0x01,
+			data:     []byte{0x05, 0x01, 0x00, 0x01, 192, 168, 1, 1, 0x00, 0x50}, // 192.168.1.1:80
+			wantHost: "192.168.1.1",
+			wantPort: 80,
+			wantErr:  false,
+		},
+		{
+			name:     "Domain name",
+			addrType: 0x03,
+			data:     append([]byte{0x05, 0x01, 0x00, 0x03, 11}, []byte("example.com")...), // example.com + port will be added
+			wantHost: "example.com",
+			wantErr:  false,
+		},
+		{
+			name:     "Port 443",
+			addrType: 0x01,
+			data:     []byte{0x05, 0x01, 0x00, 0x01, 10, 0, 0, 1, 0x01, 0xBB}, // 10.0.0.1:443
+			wantHost: "10.0.0.1",
+			wantPort: 443,
+			wantErr:  false,
+		},
+		{
+			name:     "Port 8080",
+			addrType: 0x01,
+			data:     []byte{0x05, 0x01, 0x00, 0x01, 127, 0, 0, 1, 0x1F, 0x90}, // 127.0.0.1:8080
+			wantHost: "127.0.0.1",
+			wantPort: 8080,
+			wantErr:  false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Test the address parsing logic based on SOCKS5 specification
+			var host string
+			var port uint16
+
+			switch tt.addrType {
+			case 0x01: // IPv4
+				if len(tt.data) < 10 {
+					if !tt.wantErr {
+						t.Errorf("Expected success but got insufficient data")
+					}
+					return
+				}
+				host = formatIPv4(tt.data[4], tt.data[5], tt.data[6], tt.data[7])
+				port = uint16(tt.data[8])<<8 | uint16(tt.data[9])
+			case 0x03: // Domain name
+				addrLen := int(tt.data[4])
+				if len(tt.data) < 5+addrLen+2 {
+					// For this test, we're just checking domain parsing
+					if len(tt.data) >= 5+addrLen {
+						host = string(tt.data[5 : 5+addrLen])
+					}
+				} else {
+					host = string(tt.data[5 : 5+addrLen])
+					port = uint16(tt.data[5+addrLen])<<8 | uint16(tt.data[5+addrLen+1])
+				}
+			}
+
+			if tt.wantErr {
+				return
+			}
+
+			if host != tt.wantHost {
+				t.Errorf("host = %v, want %v", host, tt.wantHost)
+			}
+			if tt.wantPort != 0 && port != tt.wantPort {
+				t.Errorf("port = %v, want %v", port, tt.wantPort)
+			}
+		})
+	}
+}
+
+func TestSOCKS5ProtocolVersions(t *testing.T) {
+	tests := []struct {
+		name        string
+		version     byte
+		shouldAllow bool
+	}{
+		{
+			name:        "SOCKS5",
+			version:     0x05,
+			shouldAllow: true,
+		},
+		{
+			name:        "SOCKS4",
+			version:     0x04,
+			shouldAllow: false,
+		},
+		{
+			name:        "Invalid version 0",
+			version:     0x00,
+			shouldAllow: false,
+		},
+		{
+			name:        "Invalid version 255",
+			version:     0xFF,
+			shouldAllow: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			isValid := tt.version == 0x05
+			if isValid != tt.shouldAllow {
+				t.Errorf("version 0x%02x: isValid = %v, want %v", tt.version, isValid, tt.shouldAllow)
+			}
+		})
+	}
+}
+
+func TestSOCKS5Commands(t *testing.T) {
+	tests := []struct {
+		name        string
+		command     byte
+		shouldAllow bool
+	}{
+		{
+			name:        "CONNECT",
+			command:     0x01,
+			shouldAllow: true,
+		},
+		{
+			name:        "BIND",
+			command:     0x02,
+			shouldAllow: false,
+		},
+		{
+			name:        "UDP ASSOCIATE",
+			command:     0x03,
+			shouldAllow: false,
+		},
+		{
+			name:        "Invalid command",
+			command:     0xFF,
+			shouldAllow: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// We only support CONNECT command (0x01)
+			isSupported := tt.command == 0x01
+			if isSupported != tt.shouldAllow {
+				t.Errorf("command 0x%02x: isSupported = %v, want %v", tt.command, isSupported, tt.shouldAllow)
+			}
+		})
+	}
+}
+
+// socks5TestConn feeds a SOCKS5 handshake from Reader and records replies
+type socks5TestConn struct {
+	io.Reader
+	replies bytes.Buffer
+}
+
+func (c *socks5TestConn) Write(p []byte) (int, error) { return c.replies.Write(p) }
+
+// socks5Request builds a greeting offering "no authentication" followed by
+// a request with the given command, address type and address
+func socks5Request(cmd, atyp byte, addr []byte, port uint16) []byte {
+	req := []byte{0x05, 0x01, 0x00, 0x05, cmd, 0x00, atyp}
+	req = append(req, addr...)
+	return append(req, byte(port>>8), byte(port))
+}
+
+func TestReadSOCKS5HandshakeFragmented(t *testing.T) {
+	// The longest name its one-byte length allows
+	longDomain := strings.Repeat("a", 63) + "." + strings.Repeat("b", 63) + "." + strings.Repeat("c", 63) + "." + strings.Repeat("d", 56) + ".ts.net"
+	tests := []struct {
+		name string
+		req  []byte
+		want string
+	}{
+		{"IPv4", socks5Request(0x01, 0x01, []byte{192, 168, 1, 1}, 80), "192.168.1.1:80"},
+		{"IPv6", socks5Request(0x01, 0x04, net.ParseIP("fd7a:115c:a1e0::5"), 8080), "[fd7a:115c:a1e0::5]:8080"},
+		{"domain", socks5Request(0x01, 0x03, append([]byte{11}, "example.com"...), 443), "example.com:443"},
+		{"255-byte domain", socks5Request(0x01, 0x03, append([]byte{byte(len(longDomain))}, longDomain...), 22), longDomain + ":22"},
+	}
+
+	readers := []struct {
+		name string
+		wrap func([]byte) io.Reader
+	}{
+		{"whole", func(b []byte) io.Reader { return bytes.NewReader(b) }},
+		{"one byte at a time", func(b []byte) io.Reader { return iotest.OneByteReader(bytes.NewReader(b)) }},
+		{"half reads", func(b []byte) io.Reader { return iotest.HalfReader(bytes.NewReader(b)) }},
+	}
+
+	for _, tt := range tests {
+		for _, r := range readers {
+			t.Run(tt.name+"/"+r.name, func(t *testing.T) {
+				conn := &socks5TestConn{Reader: r.wrap(tt.req)}
+				target, reply, err := readSOCKS5Handshake(conn)
+				if err != nil || reply != 0 {
+					t.Fatalf("readSOCKS5Handshake() = %q, %d, %v", target, reply, err)
+				}
+				if target != tt.want {
+					t.Errorf("target = %q, want %q", target, tt.want)
+				}
+				if !bytes.Equal(conn.replies.Bytes(), []byte{0x05, 0x00}) {
+					t.Errorf("replies = %x, want only the no-auth choice", conn.replies.Bytes())
+				}
+			})
+		}
+	}
+
+	// Segments arriving with pauses between them over a real connection
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+	req := tests[2].req
+	go func() {
+		for _, cut := range [][2]int{{0, 1}, {1, 4}, {4, 8}, {8, 12}, {12, len(req)}} {
+			client.Write(req[cut[0]:cut[1]])
+			time.Sleep(5 * time.Millisecond)
+		}
+	}()
+	go io.Copy(io.Discard, client)
+	if target, _, err := readSOCKS5Handshake(server); err != nil || target != "example.com:443" {
+		t.Errorf("readSOCKS5Handshake() over a pipe = %q, %v", target, err)
+	}
+}
+
+func TestReadSOCKS5HandshakeRejects(t *testing.T) {
+	tests := []struct {
+		name      string
+		req       []byte
+		wantReply byte
+	}{
+		{"SOCKS4", []byte{0x04, 0x01, 0x00, 0x50, 127, 0, 0, 1, 0x00}, 0},
+		{"BIND", socks5Request(0x02, 0x01, []byte{10, 0, 0, 1}, 80), socks5CmdNotSupported},
+		{"unknown address type", socks5Request(0x01, 0x05, nil, 80), socks5AddrNotSupported},
+		{"empty domain", socks5Request(0x01, 0x03, []byte{0}, 80), socks5GeneralFailure},
+		{"truncated domain", socks5Request(0x01, 0x03, []byte{200, 'a', 'b'}, 80)[:12], 0},
+		{"missing port", socks5Request(0x01, 0x01, []byte{10, 0, 0, 1}, 80)[:11], 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &socks5TestConn{Reader: iotest.OneByteReader(bytes.NewReader(tt.req))}
+			_, reply, err := readSOCKS5Handshake(conn)
+			if err == nil {
+				t.Fatal("readSOCKS5Handshake() accepted the request")
+			}
+			if reply != tt.wantReply {
+				t.Errorf("reply = %#x, want %#x", reply, tt.wantReply)
+			}
+		})
+	}
+
+	// A client that needs authentication is told no method is acceptable
+	conn := &socks5TestConn{Reader: bytes.NewReader([]byte{0x05, 0x01, 0x02})}
+	if _, _, err := readSOCKS5Handshake(conn); err == nil {
+		t.Error("readSOCKS5Handshake() accepted a client without the no-auth method")
+	}
+	if !bytes.Equal(conn.replies.Bytes(), []byte{0x05, 0xFF}) {
+		t.Errorf("replies = %x, want 05ff", conn.replies.Bytes())
+	}
+}
+
+func TestSOCKS5SuccessReply(t *testing.T) {
+	// x/crypto/ssh gives forwarded connections a zero local address
+	zero := &net.TCPAddr{IP: net.IPv4zero}
+	tests := []struct {
+		name   string
+		local  net.Addr
+		target string
+		want   []byte
+	}{
+		{
+			name:   "IPv6 target",
+			local:  zero,
+			target: "[fd7a:115c:a1e0::5]:443",
+			want:   append(append([]byte{0x05, 0x00, 0x00, 0x04}, make([]byte, 16)...), 0x00, 0x00),
+		},
+		{
+			name:   "IPv4 target",
+			local:  zero,
+			target: "100.64.0.5:80",
+			want:   []byte{0x05, 0x00, 0x00, 0x01, 0, 0, 0, 0, 0x00, 0x00},
+		},
+		{
+			name:   "domain target",
+			local:  zero,
+			target: "example.com:443",
+			want:   []byte{0x05, 0x00, 0x00, 0x01, 0, 0, 0, 0, 0x00, 0x00},
+		},
+		{
+			name:   "known local address",
+			local:  &net.TCPAddr{IP: net.ParseIP("fd7a::1"), Port: 40000},
+			target: "100.64.0.5:80",
+			want:   append(append([]byte{0x05, 0x00, 0x00, 0x04}, net.ParseIP("fd7a::1")...), 0x9c, 0x40),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := socks5Reply(socks5Succeeded, socks5BindAddr(tt.local, tt.target))
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("reply = %x, want %x", got, tt.want)
+			}
+		})
+	}
+
+	// Failures carry a zero IPv4 address
+	if got := socks5Reply(socks5ConnRefused, nil); !bytes.Equal(got, []byte{0x05, 0x05, 0x00, 0x01, 0, 0, 0, 0, 0, 0}) {
+		t.Errorf("refused reply = %x", got)
+	}
+}
+
+func TestBindAddressSecurity(t *testing.T) {
+	tests := []struct {
+		name          string
+		bindAddr      string
+		shouldWarn    bool
+		shouldBeValid bool
+	}{
+		{
+			name:          "localhost",
+			bindAddr:      "localhost",
+			shouldWarn:    false,
+			shouldBeValid: true,
+		},
+		{
+			name:          "127.0.0.1",
+			bindAddr:      "127.0.0.1",
+			shouldWarn:    false,
+			shouldBeValid: true,
+		},
+		{
+			name:          "::1",
+			bindAddr:      "::1",
+			shouldWarn:    false,
+			shouldBeValid: true,
+		},
+		{
+			name:          "0.0.0.0 - all interfaces",
+			bindAddr:      "0.0.0.0",
+			shouldWarn:    true,
+			shouldBeValid: true,
+		},
+		{
+			name:          "192.168.1.1 - LAN IP",
+			bindAddr:      "192.168.1.1",
+			shouldWarn:    true,
+			shouldBeValid: true,
+		},
+		{
+			name:          "invalid hostname",
+			bindAddr:      "invalid!@#host",
+			shouldWarn:    true,
+			shouldBeValid: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Test bind address security logic
+			shouldWarn := tt.bindAddr != "" && tt.bindAddr != "localhost" &&
+				tt.bindAddr != "127.0.0.1" && tt.bindAddr != "::1"
+
+			if shouldWarn != tt.shouldWarn {
+				t.Errorf("bindAddr %v: shouldWarn = %v, want %v", tt.bindAddr, shouldWarn, tt.shouldWarn)
+			}
+		})
+	}
+}
+
+func formatIPv4(a, b, c, d byte) string {
+	// Simple format function for testing IPv4 addresses
+	result := ""
+	result += byteToString(a) + "."
+	result += byteToString(b) + "."
+	result += byteToString(c) + "."
+	result += byteToString(d)
+	return result
+}
+
+func byteToString(b byte) string {
+	if b == 0 {
+		return "0"
+	}
+	var digits []byte
+	for b > 0 {
+		digits = append([]byte{byte('0' + b%10)}, digits...)
+		b /= 10
+	}
+	return string(digits)
+}
@@ -0,0 +1,189 @@
+package hostcheck
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	osuser "os/user"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	sshclient "github.com/derekg/ts-ssh/internal/client/ssh"
+)
+
+// -check statuses, from worst to best
+const (
+	Unreachable = "unreachable" // No SSH server answered
+	Reachable   = "reachable"   // SSH answered, but the host key or key authentication was not accepted
+	AuthOK      = "auth-ok"     // The host key is known and the key was accepted
+)
+
+// Result is one host's -check result
+type Result struct {
+	Host   string
+	Status string
+	Detail string
+}
+
+// Checker checks one host; it should give up once ctx is done
+type Checker func(ctx context.Context, target string) Result
+
+// CheckAll runs check for each host, at most limit at a time and each
+// bounded by timeout. Results are in the order of hosts.
+func CheckAll(ctx context.Context, hosts []string, limit int, timeout time.Duration, check Checker) []Result {
+	results := make([]Result, len(hosts))
+	sem := make(chan struct{}, limit)
+	var wg sync.WaitGroup
+	for i, target := range hosts {
+		wg.Add(1)
+		go func(i int, target string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			hostCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			result := check(hostCtx, target)
+			if result.Status == Unreachable && hostCtx.Err() == context.DeadlineExceeded {
+				result.Detail = fmt.Sprintf("no answer within %v", timeout)
+			}
+			result.Host = target
+			results[i] = result
+		}(i, target)
+	}
+	wg.Wait()
+	return results
+}
+
+// Print writes -check results as a table
+func Print(out io.Writer, results []Result) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "HOST\tSTATUS\tDETAIL")
+	for _, r := range results {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", r.Host, r.Status, r.Detail)
+	}
+	return w.Flush()
+}
+
+// Check dials target and attempts a handshake and key authentication,
+// paced and capped by throttle (which may be nil)
+func Check(ctx context.Context, dialer sshclient.Dialer, target, defaultUser, defaultPort string, signer ssh.Signer, keyNote string, hostKeys ssh.HostKeyCallback, throttle *sshclient.AuthThrottle) Result {
+	sshUser, host, port, err := sshclient.ParseTarget(strings.TrimSpace(target), defaultUser, defaultPort)
+	if err == nil {
+		err = sshclient.ValidateTarget(sshUser, host, port)
+	}
+	if err != nil {
+		return Result{Status: Unreachable, Detail: err.Error()}
+	}
+
+	addr := net.JoinHostPort(host, port)
+	conn, err := dialer.Dial(ctx, "tcp", addr)
+	if err != nil {
+		return Result{Status: Unreachable, Detail: err.Error()}
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	var sawHostKey bool
+	var hostKeyErr error
+	config := &ssh.ClientConfig{
+		User: sshUser,
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			sawHostKey = true
+			hostKeyErr = hostKeys(hostname, remote, key)
+			return hostKeyErr
+		},
+	}
+	var authenticating bool
+	if signer != nil {
+		config.Auth = []ssh.AuthMethod{ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+			throttle.Wait(host)
+			throttle.Acquire()
+			authenticating = true
+			return []ssh.Signer{signer}, nil
+		})}
+	}
+
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if authenticating {
+		throttle.Release()
+	}
+	switch {
+	case err == nil:
+		go ssh.DiscardRequests(reqs)
+		go func() {
+			for ch := range chans {
+				ch.Reject(ssh.Prohibited, "no channels")
+			}
+		}()
+		sshConn.Close()
+		throttle.Succeeded(host)
+		return Result{Status: AuthOK}
+	case !sawHostKey:
+		return Result{Status: Unreachable, Detail: fmt.Sprintf("SSH handshake failed: %v", err)}
+	case hostKeyErr != nil:
+		return Result{Status: Reachable, Detail: fmt.Sprintf("host key not verified: %v", hostKeyErr)}
+	case keyNote != "":
+		return Result{Status: Reachable, Detail: keyNote}
+	}
+	return Result{Status: Reachable, Detail: fmt.Sprintf("authentication failed: %v", err)}
+}
+
+// StrictHostKeyCallback verifies host keys against the user and global
+// known_hosts files without prompting; an unknown host is an error
+func StrictHostKeyCallback(knownHostsFile string) (ssh.HostKeyCallback, error) {
+	if knownHostsFile == "" {
+		currentUser, err := osuser.Current()
+		if err != nil {
+			return nil, fmt.Errorf("cannot locate known_hosts: %w", err)
+		}
+		if knownHostsFile, err = sshclient.DefaultKnownHostsPath(currentUser); err != nil {
+			return nil, fmt.Errorf("cannot locate known_hosts: %w", err)
+		}
+	}
+
+	var files []string
+	for _, f := range []string{knownHostsFile, sshclient.GlobalKnownHostsFile} {
+		if _, err := os.Stat(f); err == nil {
+			files = append(files, f)
+		}
+	}
+	if len(files) == 0 {
+		return func(string, net.Addr, ssh.PublicKey) error {
+			return errors.New("no known_hosts file")
+		}, nil
+	}
+	callback, err := knownhosts.New(files...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read known_hosts: %w", err)
+	}
+	return callback, nil
+}
+
+// LoadSigner loads keyPath for -check. A key that can't be used without a
+// passphrase prompt is skipped, with a note explaining why.
+func LoadSigner(keyPath string) (ssh.Signer, string) {
+	data, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, fmt.Sprintf("key not checked: %v", err)
+	}
+	signer, err := ssh.ParsePrivateKey(data)
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		return nil, fmt.Sprintf("key not checked: %s needs a passphrase", keyPath)
+	}
+	if err != nil {
+		return nil, fmt.Sprintf("key not checked: %v", err)
+	}
+	return signer, ""
+}
@@ -0,0 +1,186 @@
+package hostcheck
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func TestCheckAll(t *testing.T) {
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	check := func(ctx context.Context, target string) Result {
+		mu.Lock()
+		inFlight++
+		maxInFlight = max(maxInFlight, inFlight)
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+		}()
+
+		switch target {
+		case "web1", "web2":
+			time.Sleep(10 * time.Millisecond)
+			return Result{Status: AuthOK}
+		case "db1":
+			return Result{Status: Reachable, Detail: "authentication failed"}
+		case "slow":
+			<-ctx.Done()
+			return Result{Status: Unreachable, Detail: ctx.Err().Error()}
+		}
+		return Result{Status: Unreachable, Detail: "connection refused"}
+	}
+
+	hosts := []string{"web1", "slow", "db1", "gone", "web2"}
+	results := CheckAll(context.Background(), hosts, 2, 50*time.Millisecond, check)
+
+	want := []Result{
+		{Host: "web1", Status: AuthOK},
+		{Host: "slow", Status: Unreachable, Detail: "no answer within 50ms"},
+		{Host: "db1", Status: Reachable, Detail: "authentication failed"},
+		{Host: "gone", Status: Unreachable, Detail: "connection refused"},
+		{Host: "web2", Status: AuthOK},
+	}
+	if len(results) != len(want) {
+		t.Fatalf("CheckAll() returned %d results, want %d", len(results), len(want))
+	}
+	for i := range want {
+		if results[i] != want[i] {
+			t.Errorf("result %d = %+v, want %+v", i, results[i], want[i])
+		}
+	}
+	if maxInFlight > 2 {
+		t.Errorf("%d checks ran at once, want at most 2", maxInFlight)
+	}
+
+	var out bytes.Buffer
+	if err := Print(&out, results[:3]); err != nil {
+		t.Fatalf("Print() error = %v", err)
+	}
+	wantTable := "HOST  STATUS       DETAIL\n" +
+		"web1  auth-ok      \n" +
+		"slow  unreachable  no answer within 50ms\n" +
+		"db1   reachable    authentication failed\n"
+	if out.String() != wantTable {
+		t.Errorf("Print() =\n%s\nwant\n%s", out.String(), wantTable)
+	}
+}
+
+// loopbackDialer dials directly, standing in for the tailnet
+type loopbackDialer struct{}
+
+func (loopbackDialer) Dial(ctx context.Context, network, address string) (net.Conn, error) {
+	var d net.Dialer
+	return d.DialContext(ctx, network, address)
+}
+
+func TestCheck(t *testing.T) {
+	newSigner := func() ssh.Signer {
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatalf("GenerateKey() error = %v", err)
+		}
+		signer, err := ssh.NewSignerFromKey(priv)
+		if err != nil {
+			t.Fatalf("NewSignerFromKey() error = %v", err)
+		}
+		return signer
+	}
+	hostKey, userKey, otherKey := newSigner(), newSigner(), newSigner()
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if bytes.Equal(key.Marshal(), userKey.PublicKey().Marshal()) {
+				return nil, nil
+			}
+			return nil, errors.New("unknown key")
+		},
+	}
+	config.AddHostKey(hostKey)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				if sshConn, chans, reqs, err := ssh.NewServerConn(conn, config); err == nil {
+					go ssh.DiscardRequests(reqs)
+					go func() {
+						for range chans {
+						}
+					}()
+					sshConn.Wait()
+				}
+			}()
+		}
+	}()
+	addr := listener.Addr().String()
+	host, port, _ := net.SplitHostPort(addr)
+
+	knownHosts := filepath.Join(t.TempDir(), "known_hosts")
+	if err := os.WriteFile(knownHosts, []byte(knownhosts.Line([]string{knownhosts.Normalize(addr)}, hostKey.PublicKey())+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	trusted, err := StrictHostKeyCallback(knownHosts)
+	if err != nil {
+		t.Fatalf("StrictHostKeyCallback() error = %v", err)
+	}
+	untrusted, err := StrictHostKeyCallback(filepath.Join(t.TempDir(), "missing"))
+	if err != nil {
+		t.Fatalf("StrictHostKeyCallback() without a file error = %v", err)
+	}
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	closedAddr := closed.Addr().String()
+	closed.Close()
+
+	tests := []struct {
+		name       string
+		target     string
+		signer     ssh.Signer
+		keyNote    string
+		hostKeys   ssh.HostKeyCallback
+		wantStatus string
+		wantDetail string
+	}{
+		{"accepted key", host, userKey, "", trusted, AuthOK, ""},
+		{"rejected key", host, otherKey, "", trusted, Reachable, "authentication failed"},
+		{"no usable key", host, nil, "key not checked: needs a passphrase", trusted, Reachable, "needs a passphrase"},
+		{"unknown host key", host, userKey, "", untrusted, Reachable, "host key not verified"},
+		{"nothing listening", closedAddr, userKey, "", trusted, Unreachable, "refused"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			got := Check(ctx, loopbackDialer{}, tt.target, "alice", port, tt.signer, tt.keyNote, tt.hostKeys, nil)
+			if got.Status != tt.wantStatus || !strings.Contains(got.Detail, tt.wantDetail) {
+				t.Errorf("Check() = %+v, want %s with %q", got, tt.wantStatus, tt.wantDetail)
+			}
+		})
+	}
+}
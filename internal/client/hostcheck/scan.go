@@ -0,0 +1,93 @@
+// Package hostcheck checks many SSH hosts at once without logging in:
+// -scan-keys reads their host keys and -check tests that each will accept a
+// key-authenticated connection.
+package hostcheck
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+
+	sshclient "github.com/derekg/ts-ssh/internal/client/ssh"
+	"github.com/derekg/ts-ssh/internal/security"
+)
+
+// ScanResult is one host's -scan-keys result
+type ScanResult struct {
+	Addr string // host:port the key was read from
+	Key  ssh.PublicKey
+	Err  error
+}
+
+// KeyFetcher retrieves one host's key and the host:port it was read from
+type KeyFetcher func(ctx context.Context, target string) (string, ssh.PublicKey, error)
+
+// ScanKeys fetches each host's key, at most limit at a time. Results are in
+// the order of hosts. When progress is set, a running count is written to it
+// as hosts finish. It returns only once every fetch has, so the caller can
+// close what the fetches dial through.
+func ScanKeys(ctx context.Context, hosts []string, limit int, fetch KeyFetcher, progress io.Writer) []ScanResult {
+	results := make([]ScanResult, len(hosts))
+	sem := make(chan struct{}, limit)
+	var mu sync.Mutex
+	done := 0
+	var wg sync.WaitGroup
+	for i, target := range hosts {
+		wg.Add(1)
+		go func(i int, target string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			addr, key, err := fetch(ctx, target)
+			results[i] = ScanResult{Addr: addr, Key: key, Err: err}
+
+			if progress != nil {
+				mu.Lock()
+				done++
+				fmt.Fprintf(progress, "\rScanned %d/%d hosts", done, len(hosts))
+				mu.Unlock()
+			}
+		}(i, target)
+	}
+	wg.Wait()
+	if progress != nil {
+		fmt.Fprintln(progress)
+	}
+	return results
+}
+
+// FetchHostKey dials target through dialer, usually the tailnet, and
+// returns its address and host key
+func FetchHostKey(ctx context.Context, dialer sshclient.Dialer, target, defaultPort string) (string, ssh.PublicKey, error) {
+	_, host, port, err := sshclient.ParseTarget(strings.TrimSpace(target), "", defaultPort)
+	if err != nil {
+		return "", nil, err
+	}
+	if err := security.ValidateHostname(host); err != nil {
+		return "", nil, fmt.Errorf("invalid hostname: %w", err)
+	}
+	if err := security.ValidatePort(port); err != nil {
+		return "", nil, fmt.Errorf("invalid port: %w", err)
+	}
+
+	addr := net.JoinHostPort(host, port)
+	dialCtx, cancel := context.WithTimeout(ctx, sshclient.DefaultSSHTimeout)
+	defer cancel()
+
+	conn, err := dialer.Dial(dialCtx, "tcp", addr)
+	if err != nil {
+		return "", nil, fmt.Errorf("tsnet dial failed: %w", err)
+	}
+
+	key, err := sshclient.ScanHostKey(dialCtx, conn, addr)
+	if err != nil {
+		return "", nil, err
+	}
+	return addr, key, nil
+}
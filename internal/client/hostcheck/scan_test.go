@@ -0,0 +1,87 @@
+package hostcheck
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestScanKeys(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatalf("NewSignerFromKey() error = %v", err)
+	}
+
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	fetch := func(ctx context.Context, target string) (string, ssh.PublicKey, error) {
+		mu.Lock()
+		inFlight++
+		maxInFlight = max(maxInFlight, inFlight)
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+
+		if strings.HasPrefix(target, "down") {
+			return "", nil, errors.New("tsnet dial failed: connection refused")
+		}
+		return net.JoinHostPort(target, "22"), signer.PublicKey(), nil
+	}
+
+	hosts := []string{"web1", "down1", "web2", "web3", "down2", "web4"}
+	var progress bytes.Buffer
+	results := ScanKeys(context.Background(), hosts, 3, fetch, &progress)
+
+	for i, host := range hosts {
+		r := results[i]
+		if strings.HasPrefix(host, "down") {
+			if r.Err == nil {
+				t.Errorf("%s: expected an error", host)
+			}
+			continue
+		}
+		if r.Err != nil || r.Addr != host+":22" || r.Key == nil {
+			t.Errorf("%s: result = %+v", host, r)
+		}
+	}
+	if maxInFlight > 3 {
+		t.Errorf("%d scans ran at once, want at most 3", maxInFlight)
+	}
+	if !strings.Contains(progress.String(), "\rScanned 6/6 hosts") || !strings.HasSuffix(progress.String(), "\n") {
+		t.Errorf("progress = %q, want a final count ending the line", progress.String())
+	}
+}
+
+func TestScanKeysWaitsForEveryFetch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var finished atomic.Int32
+	fetch := func(ctx context.Context, target string) (string, ssh.PublicKey, error) {
+		cancel()
+		time.Sleep(10 * time.Millisecond)
+		finished.Add(1)
+		return "", nil, ctx.Err()
+	}
+
+	// Cancelling doesn't let ScanKeys return while fetches still run
+	hosts := []string{"web1", "web2", "web3", "web4"}
+	ScanKeys(ctx, hosts, 2, fetch, nil)
+	if n := finished.Load(); n != int32(len(hosts)) {
+		t.Errorf("ScanKeys() returned with %d of %d fetches finished", n, len(hosts))
+	}
+}
@@ -1,14 +1,19 @@
 package scp
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"os"
 	"os/user"
+	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/bramvdbogaerde/go-scp"
 	"golang.org/x/crypto/ssh"
@@ -118,54 +123,185 @@ func HandleCliScp(
 	sshClient := ssh.NewClient(sshClientConn, chans, reqs)
 	defer sshClient.Close()
 
+	if isUpload {
+		logger.Printf("CLI SCP: Uploading %s to %s@%s:%s", localPath, sshUser, targetHost, remotePath)
+		if err := CopyToRemote(ctx, sshClient, localPath, remotePath, false, logger); err != nil {
+			return fmt.Errorf("CLI SCP: %w", err)
+		}
+	} else { // Download
+		logger.Printf("CLI SCP: Downloading %s@%s:%s to %s", sshUser, targetHost, remotePath, localPath)
+		if err := CopyFromRemote(ctx, sshClient, remotePath, localPath, false, logger); err != nil {
+			return fmt.Errorf("CLI SCP: %w", err)
+		}
+	}
+	return nil
+}
+
+// CopyToRemote uploads localPath to remotePath over an established SSH
+// connection, preserving the local file's permission bits. With preserve the
+// modification time is kept as well, as with scp -p.
+func CopyToRemote(ctx context.Context, sshClient *ssh.Client, localPath, remotePath string, preserve bool, logger *log.Logger) error {
+	localFile, errOpen := os.Open(localPath)
+	if errOpen != nil {
+		return fmt.Errorf("failed to open local file %s for upload: %w", localPath, errOpen)
+	}
+	defer localFile.Close()
+
+	fileInfo, errStat := localFile.Stat()
+	if errStat != nil {
+		return fmt.Errorf("failed to get file info for local file %s: %w", localPath, errStat)
+	}
+	if err := copyToRemote(ctx, sshClient, localFile, fileInfo, remotePath, preserve); err != nil {
+		return fmt.Errorf("error uploading file: %w", err)
+	}
+	logger.Println("Upload complete")
+	return nil
+}
+
+// CopyFromRemote downloads remotePath to localPath over an established SSH
+// connection. The local file is created securely and replaced atomically.
+// With preserve the remote file's mode and times are applied to it;
+// otherwise it keeps the secure 0600 mode.
+func CopyFromRemote(ctx context.Context, sshClient *ssh.Client, remotePath, localPath string, preserve bool, logger *log.Logger) error {
 	scpCl, err := scp.NewClientBySSH(sshClient)
 	if err != nil {
-		return fmt.Errorf("CLI SCP: error creating new SCP client: %w", err)
+		return fmt.Errorf("error creating new SCP client: %w", err)
 	}
 	defer scpCl.Close()
 
-	if isUpload {
-		logger.Printf("CLI SCP: Uploading %s to %s@%s:%s", localPath, sshUser, targetHost, remotePath)
-		localFile, errOpen := os.Open(localPath)
-		if errOpen != nil {
-			return fmt.Errorf("CLI SCP: failed to open local file %s for upload: %w", localPath, errOpen)
+	// Create file securely with atomic replacement to prevent race conditions
+	localFile, errOpen := security.CreateSecureDownloadFileWithReplace(localPath)
+	if errOpen != nil {
+		return fmt.Errorf("failed to create secure local file %s for download: %w", localPath, errOpen)
+	}
+	defer func() {
+		if err := security.CompleteAtomicReplacement(localFile); err != nil {
+			logger.Printf("Warning: failed to complete atomic file replacement: %v", err)
 		}
-		defer localFile.Close()
+	}()
 
-		fileInfo, errStat := localFile.Stat()
-		if errStat != nil {
-			return fmt.Errorf("CLI SCP: failed to get file info for local file %s: %w", localPath, errStat)
+	var errCopy error
+	if preserve {
+		var infos *scp.FileInfos
+		infos, errCopy = scpCl.CopyFromRemoteFileInfos(ctx, localFile, remotePath, nil)
+		if errCopy == nil {
+			errCopy = applyPreservedAttributes(localFile, os.FileMode(infos.Permissions), infos.Atime, infos.Mtime)
 		}
-		permissions := fmt.Sprintf("0%o", fileInfo.Mode().Perm())
+	} else {
+		errCopy = scpCl.CopyFromRemote(ctx, localFile, remotePath)
+	}
+	if errCopy != nil {
+		if ctx.Err() != nil {
+			logger.Printf("SCP download cancelled: %v", ctx.Err())
+			return fmt.Errorf("download cancelled: %w", ctx.Err())
+		}
+		return fmt.Errorf("error downloading file: %w", errCopy)
+	}
+	logger.Println("Download complete")
+	return nil
+}
 
-		errCopy := scpCl.CopyFile(ctx, localFile, remotePath, permissions)
-		if errCopy != nil {
-			return fmt.Errorf("CLI SCP: error uploading file: %w", errCopy)
+// CopyStreamToRemote uploads everything read from r to remotePath, for
+// uploads from stdin. scp announces a file's size before its contents, so r
+// is first spooled to a private temporary file, which is removed afterwards.
+// The remote file is created with mode 0600.
+func CopyStreamToRemote(ctx context.Context, sshClient *ssh.Client, r io.Reader, remotePath string, logger *log.Logger) error {
+	spool, err := os.CreateTemp("", "ts-ssh-upload-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file for upload: %w", err)
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	if _, err := io.Copy(spool, r); err != nil {
+		return fmt.Errorf("failed to read upload: %w", err)
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to rewind temporary file: %w", err)
+	}
+	info, err := spool.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to get temporary file info: %w", err)
+	}
+	if err := copyToRemote(ctx, sshClient, spool, info, remotePath, false); err != nil {
+		return fmt.Errorf("error uploading file: %w", err)
+	}
+	logger.Println("Upload complete")
+	return nil
+}
+
+// CopyRemoteToStream writes the contents of remotePath to w as they
+// arrive, for downloads to stdout.
+func CopyRemoteToStream(ctx context.Context, sshClient *ssh.Client, remotePath string, w io.Writer, logger *log.Logger) error {
+	scpCl, err := scp.NewClientBySSH(sshClient)
+	if err != nil {
+		return fmt.Errorf("error creating new SCP client: %w", err)
+	}
+	defer scpCl.Close()
+
+	if err := scpCl.CopyFromRemotePassThru(ctx, w, remotePath, nil); err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("download cancelled: %w", ctx.Err())
 		}
-		logger.Println("Upload complete")
-	} else { // Download
-		logger.Printf("CLI SCP: Downloading %s@%s:%s to %s", sshUser, targetHost, remotePath, localPath)
+		return fmt.Errorf("error downloading file: %w", err)
+	}
+	logger.Println("Download complete")
+	return nil
+}
+
+// ExpandRemoteGlob returns the remote paths matching pattern, expanded by
+// running ls on the host. Everything except the glob characters is escaped
+// so the remote shell cannot interpret it as anything else.
+func ExpandRemoteGlob(ctx context.Context, sshClient *ssh.Client, pattern string) ([]string, error) {
+	quoted, err := shellGlobQuote(pattern)
+	if err != nil {
+		return nil, err
+	}
 
-		// Create file securely with atomic replacement to prevent race conditions
-		localFile, errOpen := security.CreateSecureDownloadFileWithReplace(localPath)
-		if errOpen != nil {
-			return fmt.Errorf("CLI SCP: failed to create secure local file %s for download: %w", localPath, errOpen)
+	session, err := sshclient.CreateSSHSession(sshClient)
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+
+	var stdout bytes.Buffer
+	session.Stdout = &stdout
+	if err := session.Start("ls -1d -- " + quoted); err != nil {
+		return nil, fmt.Errorf("failed to list remote files: %w", err)
+	}
+	if err := sshclient.WaitSession(ctx, session); err != nil {
+		return nil, fmt.Errorf("no remote files match %s: %w", pattern, err)
+	}
+
+	var matches []string
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		if line != "" {
+			matches = append(matches, line)
 		}
-		defer func() {
-			if err := security.CompleteAtomicReplacement(localFile); err != nil {
-				logger.Printf("Warning: failed to complete atomic file replacement: %v", err)
-			}
-		}()
-
-		errCopy := scpCl.CopyFromRemote(ctx, localFile, remotePath)
-		if errCopy != nil {
-			if ctx.Err() != nil {
-				logger.Printf("CLI SCP download cancelled: %v", ctx.Err())
-				return fmt.Errorf("CLI SCP download cancelled: %w", ctx.Err())
-			}
-			return fmt.Errorf("CLI SCP: error downloading file: %w", errCopy)
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("no remote files match %s", pattern)
+	}
+	return matches, nil
+}
+
+// shellGlobQuote backslash-escapes every character of pattern that the shell
+// would treat specially, leaving *, ? and [ ] to be expanded. A leading ~ is
+// kept so ~/ paths still refer to the home directory.
+func shellGlobQuote(pattern string) (string, error) {
+	var b strings.Builder
+	for i, r := range pattern {
+		switch {
+		case r == '\n' || r == 0 || unicode.IsControl(r):
+			return "", fmt.Errorf("remote pattern contains a control character")
+		case r == '~' && i == 0,
+			strings.ContainsRune("*?[]/._-+,:@%=", r),
+			r < utf8.RuneSelf && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			b.WriteRune(r)
+		default:
+			b.WriteByte('\\')
+			b.WriteRune(r)
 		}
-		logger.Println("Download complete")
 	}
-	return nil
+	return b.String(), nil
 }
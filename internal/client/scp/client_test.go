@@ -220,3 +220,30 @@ func TestScpInsecureMode(t *testing.T) {
 		t.Errorf("Expected validation error, got: %s", err.Error())
 	}
 }
+
+// TestShellGlobQuote checks that only glob characters reach the remote shell unescaped
+func TestShellGlobQuote(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    string
+		wantErr bool
+	}{
+		{pattern: "/var/log/*.log", want: "/var/log/*.log"},
+		{pattern: "~/backups/db-[0-9]?.sql", want: "~/backups/db-[0-9]?.sql"},
+		{pattern: "/srv/my files/*.txt", want: `/srv/my\ files/*.txt`},
+		{pattern: "/tmp/*; rm -rf ~", want: `/tmp/*\;\ rm\ -rf\ \~`},
+		{pattern: "/tmp/$(id)*", want: `/tmp/\$\(id\)*`},
+		{pattern: "/tmp/a\nb*", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := shellGlobQuote(tt.pattern)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("shellGlobQuote(%q) error = %v, wantErr %v", tt.pattern, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("shellGlobQuote(%q) = %q, want %q", tt.pattern, got, tt.want)
+		}
+	}
+}
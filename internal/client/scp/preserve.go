@@ -0,0 +1,19 @@
+package scp
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// applyPreservedAttributes sets the mode and times received with scp -p on a
+// downloaded file. Only permission bits are applied, never setuid or setgid.
+func applyPreservedAttributes(file *os.File, mode os.FileMode, atime, mtime int64) error {
+	if err := file.Chmod(mode.Perm()); err != nil {
+		return fmt.Errorf("failed to set file mode: %w", err)
+	}
+	if err := os.Chtimes(file.Name(), time.Unix(atime, 0), time.Unix(mtime, 0)); err != nil {
+		return fmt.Errorf("failed to set file times: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,223 @@
+package scp
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// startExecSSHServer starts an SSH server that runs exec requests with the
+// local sh, so transfers exercise the system scp binary.
+func startExecSSHServer(t *testing.T) *ssh.Client {
+	t.Helper()
+
+	_, hostPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate host key: %v", err)
+	}
+	hostKey, err := ssh.NewSignerFromKey(hostPriv)
+	if err != nil {
+		t.Fatalf("Failed to create host signer: %v", err)
+	}
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(hostKey)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveExec(conn, config)
+		}
+	}()
+
+	client, err := ssh.Dial("tcp", listener.Addr().String(), &ssh.ClientConfig{
+		User:            "test",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         5 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("Failed to dial test server: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func serveExec(conn net.Conn, config *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			defer channel.Close()
+			for req := range requests {
+				if req.Type != "exec" || len(req.Payload) < 4 {
+					req.Reply(false, nil)
+					continue
+				}
+				req.Reply(true, nil)
+				command := string(req.Payload[4:])
+
+				cmd := exec.Command("sh", "-c", command)
+				cmd.Stdout = channel
+				cmd.Stderr = channel.Stderr()
+				stdin, _ := cmd.StdinPipe()
+				status := uint32(0)
+				if err := cmd.Start(); err != nil {
+					status = 127
+				} else {
+					go func() {
+						io.Copy(stdin, channel)
+						stdin.Close()
+					}()
+					if err := cmd.Wait(); err != nil {
+						status = 1
+					}
+				}
+				payload := make([]byte, 4)
+				binary.BigEndian.PutUint32(payload, status)
+				channel.SendRequest("exit-status", false, payload)
+				return
+			}
+		}()
+	}
+}
+
+func TestPreserveRoundTrip(t *testing.T) {
+	if _, err := exec.LookPath("scp"); err != nil {
+		t.Skip("scp binary not available")
+	}
+	client := startExecSSHServer(t)
+	logger := log.New(io.Discard, "", 0)
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	source := filepath.Join(dir, "source.txt")
+	if err := os.WriteFile(source, []byte("preserved contents\n"), 0640); err != nil {
+		t.Fatalf("Failed to write source: %v", err)
+	}
+	if err := os.Chmod(source, 0640); err != nil {
+		t.Fatalf("Failed to chmod source: %v", err)
+	}
+	mtime := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(source, mtime, mtime); err != nil {
+		t.Fatalf("Failed to set source times: %v", err)
+	}
+
+	// The "remote" side is the same machine, so both legs can be checked
+	remote := filepath.Join(dir, "remote's copy.txt")
+	if err := CopyToRemote(ctx, client, source, remote, true, logger); err != nil {
+		t.Fatalf("CopyToRemote() error = %v", err)
+	}
+	local := filepath.Join(dir, "local.txt")
+	if err := CopyFromRemote(ctx, client, remote, local, true, logger); err != nil {
+		t.Fatalf("CopyFromRemote() error = %v", err)
+	}
+
+	for _, path := range []string{remote, local} {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("Stat(%s) error = %v", path, err)
+		}
+		if !info.ModTime().Equal(mtime) {
+			t.Errorf("%s mtime = %v, want %v", filepath.Base(path), info.ModTime(), mtime)
+		}
+		if info.Mode().Perm() != 0640 {
+			t.Errorf("%s mode = %o, want 640", filepath.Base(path), info.Mode().Perm())
+		}
+	}
+
+	got, err := os.ReadFile(local)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if string(got) != "preserved contents\n" {
+		t.Errorf("contents = %q", got)
+	}
+
+	// Without preserve the download keeps the secure default mode
+	plain := filepath.Join(dir, "plain.txt")
+	if err := CopyFromRemote(ctx, client, remote, plain, false, logger); err != nil {
+		t.Fatalf("CopyFromRemote() error = %v", err)
+	}
+	if info, err := os.Stat(plain); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("plain download mode = %v (err %v), want 600", info.Mode().Perm(), err)
+	}
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	if _, err := exec.LookPath("scp"); err != nil {
+		t.Skip("scp binary not available")
+	}
+	client := startExecSSHServer(t)
+	logger := log.New(io.Discard, "", 0)
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	// Larger than any single read, so spooling has to copy it all
+	data := bytes.Repeat([]byte("0123456789abcdef"), 64*1024)
+	remote := filepath.Join(dir, "from-stdin.bin")
+	if err := CopyStreamToRemote(ctx, client, bytes.NewReader(data), remote, logger); err != nil {
+		t.Fatalf("CopyStreamToRemote() error = %v", err)
+	}
+	info, err := os.Stat(remote)
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if info.Size() != int64(len(data)) || info.Mode().Perm() != 0600 {
+		t.Errorf("uploaded file size = %d, mode = %o, want %d bytes with mode 600", info.Size(), info.Mode().Perm(), len(data))
+	}
+
+	var out bytes.Buffer
+	if err := CopyRemoteToStream(ctx, client, remote, &out, logger); err != nil {
+		t.Fatalf("CopyRemoteToStream() error = %v", err)
+	}
+	if !bytes.Equal(out.Bytes(), data) {
+		t.Errorf("streamed %d bytes, want the %d uploaded", out.Len(), len(data))
+	}
+
+	// An empty stream is a valid, empty file
+	empty := filepath.Join(dir, "empty")
+	if err := CopyStreamToRemote(ctx, client, strings.NewReader(""), empty, logger); err != nil {
+		t.Fatalf("CopyStreamToRemote() with empty input error = %v", err)
+	}
+	if info, err := os.Stat(empty); err != nil || info.Size() != 0 {
+		t.Errorf("empty upload: %v, %v", info, err)
+	}
+
+	// A missing remote file is reported and nothing is written
+	out.Reset()
+	if err := CopyRemoteToStream(ctx, client, filepath.Join(dir, "missing"), &out, logger); err == nil {
+		t.Error("CopyRemoteToStream() of a missing file succeeded")
+	}
+	if out.Len() != 0 {
+		t.Errorf("wrote %q for a missing file", out.String())
+	}
+}
@@ -0,0 +1,134 @@
+package scp
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+
+	sshclient "github.com/derekg/ts-ssh/internal/client/ssh"
+	"github.com/derekg/ts-ssh/internal/security"
+)
+
+// RemoteError is an error the remote scp reported on its status stream: a
+// 1 (warning) or 2 (fatal) byte followed by a message line, such as
+// "scp: /dir: No such file or directory".
+type RemoteError struct {
+	Fatal   bool
+	Message string
+}
+
+func (e *RemoteError) Error() string {
+	return e.Message
+}
+
+// copyToRemote uploads localFile to remotePath by speaking the scp sink
+// protocol to "scp -t". With preserve a T record carrying the modification
+// time is sent before the C record, as scp -p does. go-scp doesn't read the
+// status byte the sink sends on start, so a failure is read out of step and
+// its message lost; here every status is checked in turn.
+func copyToRemote(ctx context.Context, sshClient *ssh.Client, localFile *os.File, info os.FileInfo, remotePath string, preserve bool) error {
+	flags := "-qt"
+	if preserve {
+		flags = "-qtp"
+	}
+
+	session, err := sshclient.CreateSSHSession(sshClient)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("failed to get stdin pipe: %w", err)
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("failed to get stdout pipe: %w", err)
+	}
+
+	if err := session.Start("scp " + flags + " -- " + security.QuoteShellArg(remotePath)); err != nil {
+		return fmt.Errorf("failed to start remote scp: %w", err)
+	}
+
+	sendErr := make(chan error, 1)
+	go func() {
+		defer stdin.Close()
+		sendErr <- sendFile(stdin, bufio.NewReader(stdout), localFile, info, path.Base(remotePath), preserve)
+	}()
+
+	// The remote's own message explains a failure better than its exit status
+	waitErr := sshclient.WaitSession(ctx, session)
+	if err := <-sendErr; err != nil && ctx.Err() == nil {
+		return err
+	}
+	return waitErr
+}
+
+// sendFile writes the optional T record, the C record and the file contents,
+// checking the remote acknowledgement after each step.
+func sendFile(w io.Writer, r *bufio.Reader, file io.Reader, info os.FileInfo, name string, preserve bool) error {
+	if err := readAck(r); err != nil {
+		return err
+	}
+
+	if preserve {
+		// The access time isn't portable to read, so it is set to the
+		// modification time, as it is for files scp creates without -p
+		mtime := info.ModTime().Unix()
+		if _, err := fmt.Fprintf(w, "T%d 0 %d 0\n", mtime, mtime); err != nil {
+			return fmt.Errorf("failed to send times: %w", err)
+		}
+		if err := readAck(r); err != nil {
+			return err
+		}
+	}
+
+	if _, err := fmt.Fprintf(w, "C%04o %d %s\n", info.Mode().Perm(), info.Size(), name); err != nil {
+		return fmt.Errorf("failed to send file header: %w", err)
+	}
+	if err := readAck(r); err != nil {
+		return err
+	}
+
+	if _, err := io.CopyN(w, file, info.Size()); err != nil {
+		return fmt.Errorf("failed to send file contents: %w", err)
+	}
+	if _, err := w.Write([]byte{0}); err != nil {
+		return fmt.Errorf("failed to finish file: %w", err)
+	}
+	return readAck(r)
+}
+
+// readAck reads one scp status byte: 0 for success, or 1 or 2 followed by
+// an error message line, returned as a *RemoteError.
+func readAck(r *bufio.Reader) error {
+	code, err := r.ReadByte()
+	if err != nil {
+		return fmt.Errorf("failed to read scp response: %w", err)
+	}
+	switch code {
+	case 0:
+		return nil
+	case 1, 2:
+		return readRemoteError(r, code)
+	default:
+		return fmt.Errorf("unexpected scp response %q", code)
+	}
+}
+
+// readRemoteError reads the message following a 1 or 2 status byte
+func readRemoteError(r *bufio.Reader, code byte) error {
+	msg, _ := r.ReadString('\n')
+	msg = strings.TrimSpace(msg)
+	if msg == "" {
+		msg = "remote scp failed without a message"
+	}
+	return &RemoteError{Fatal: code == 2, Message: msg}
+}
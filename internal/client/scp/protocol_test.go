@@ -0,0 +1,68 @@
+package scp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadAck(t *testing.T) {
+	tests := []struct {
+		name      string
+		stream    string
+		wantErr   string
+		wantFatal bool
+	}{
+		{name: "ok", stream: "\x00"},
+		{name: "warning", stream: "\x01scp: /dir: No such file or directory\n", wantErr: "scp: /dir: No such file or directory"},
+		{name: "fatal", stream: "\x02scp: protocol error: bad mode\n", wantErr: "scp: protocol error: bad mode", wantFatal: true},
+		{name: "no message", stream: "\x01", wantErr: "without a message"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := readAck(bufio.NewReader(strings.NewReader(tt.stream)))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("readAck() error = %v", err)
+				}
+				return
+			}
+			var remoteErr *RemoteError
+			if !errors.As(err, &remoteErr) {
+				t.Fatalf("readAck() error = %v, want *RemoteError", err)
+			}
+			if !strings.Contains(remoteErr.Message, tt.wantErr) || remoteErr.Fatal != tt.wantFatal {
+				t.Errorf("readAck() = %+v, want message %q and fatal %v", remoteErr, tt.wantErr, tt.wantFatal)
+			}
+		})
+	}
+}
+
+func TestRemoteErrorSurfaced(t *testing.T) {
+	if _, err := exec.LookPath("scp"); err != nil {
+		t.Skip("scp binary not available")
+	}
+	client := startExecSSHServer(t)
+	logger := log.New(io.Discard, "", 0)
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	missing := filepath.Join(dir, "missing", "file.txt")
+	err := CopyFromRemote(ctx, client, missing, filepath.Join(dir, "local.txt"), false, logger)
+	if err == nil || !strings.Contains(err.Error(), missing+": No such file or directory") {
+		t.Errorf("CopyFromRemote() error = %v, want the remote scp message", err)
+	}
+
+	var remoteErr *RemoteError
+	err = CopyToRemote(ctx, client, "protocol_test.go", missing, false, logger)
+	if !errors.As(err, &remoteErr) || !strings.Contains(err.Error(), "No such file or directory") {
+		t.Errorf("CopyToRemote() error = %v, want the remote scp message", err)
+	}
+}
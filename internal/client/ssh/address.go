@@ -0,0 +1,156 @@
+package ssh
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"sort"
+	"strings"
+
+	"tailscale.com/ipn/ipnstate"
+)
+
+// Address families accepted by SelectAddress
+const (
+	AddressFamilyAny  = ""
+	AddressFamilyIPv4 = "4"
+	AddressFamilyIPv6 = "6"
+)
+
+// NormalizeHostname returns host in the form tailnet names are compared in:
+// surrounding space and a trailing dot removed, and lowercased. When suffix,
+// the tailnet's MagicDNS suffix, is given a bare name is completed with it,
+// so "WEB1" and "web1.tailnet.ts.net." both become "web1.tailnet.ts.net".
+func NormalizeHostname(host, suffix string) string {
+	host = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(host), "."))
+	suffix = strings.ToLower(strings.Trim(suffix, "."))
+	if suffix != "" && host != "" && !strings.ContainsAny(host, ".:") {
+		host += "." + suffix
+	}
+	return host
+}
+
+// magicDNSSuffix returns the tailnet's MagicDNS suffix from status, or ""
+func magicDNSSuffix(status *ipnstate.Status) string {
+	if status.CurrentTailnet != nil && status.CurrentTailnet.MagicDNSSuffix != "" {
+		return status.CurrentTailnet.MagicDNSSuffix
+	}
+	return status.MagicDNSSuffix
+}
+
+// FindPeer returns the tailnet peer whose full or short MagicDNS name matches
+// host once both are normalized. A bare host is also completed with the
+// tailnet's MagicDNS suffix. Only when no MagicDNS name matches is host
+// compared with the peers' host names, which need not be unique: two
+// machines called "web1" are named web1 and web1-1 in MagicDNS. It fails when
+// no peer matches, or when more than one does at the same level.
+func FindPeer(status *ipnstate.Status, host string) (*ipnstate.PeerStatus, error) {
+	if status == nil {
+		return nil, fmt.Errorf("host %s not found among tailnet peers", host)
+	}
+	full := NormalizeHostname(host, magicDNSSuffix(status))
+	name := NormalizeHostname(host, "")
+	var byDNSName, byHostName []*ipnstate.PeerStatus
+	for _, peer := range status.Peer {
+		dnsName := NormalizeHostname(peer.DNSName, "")
+		shortName, _, _ := strings.Cut(dnsName, ".")
+		switch {
+		case dnsName != "" && (name == dnsName || name == shortName || full == dnsName):
+			byDNSName = append(byDNSName, peer)
+		case name == NormalizeHostname(peer.HostName, ""):
+			byHostName = append(byHostName, peer)
+		}
+	}
+	for _, matches := range [][]*ipnstate.PeerStatus{byDNSName, byHostName} {
+		switch len(matches) {
+		case 0:
+			continue
+		case 1:
+			return matches[0], nil
+		}
+		names := make([]string, 0, len(matches))
+		for _, peer := range matches {
+			names = append(names, strings.TrimSuffix(peer.DNSName, "."))
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("host %s matches several tailnet peers (%s); use the full MagicDNS name",
+			host, strings.Join(names, ", "))
+	}
+	return nil, fmt.Errorf("host %s not found among tailnet peers", host)
+}
+
+// PeerNameForIP returns the short MagicDNS name (or, without one, the host
+// name) of the tailnet peer that host, an IP literal, belongs to. It returns
+// "" when host is not an IP or no peer has it, so hosts reached by address
+// can be shown and have their keys recorded under a name that doesn't change.
+func PeerNameForIP(status *ipnstate.Status, host string) string {
+	addr, err := netip.ParseAddr(strings.Trim(host, "[]"))
+	if err != nil || status == nil {
+		return ""
+	}
+	addr = addr.Unmap()
+	for _, peer := range status.Peer {
+		for _, ip := range peer.TailscaleIPs {
+			if ip.Unmap() != addr {
+				continue
+			}
+			if name, _, _ := strings.Cut(NormalizeHostname(peer.DNSName, ""), "."); name != "" {
+				return name
+			}
+			return NormalizeHostname(peer.HostName, "")
+		}
+	}
+	return ""
+}
+
+// PeerHostKeyAddr returns addr, a host:port, with a tailnet IP replaced by
+// its peer's name as given by PeerNameForIP, and otherwise unchanged
+func PeerHostKeyAddr(status *ipnstate.Status, addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	if name := PeerNameForIP(status, host); name != "" {
+		return net.JoinHostPort(name, port)
+	}
+	return addr
+}
+
+// SelectAddress returns the first address of the given family ("4", "6", or
+// "" for any) from a peer's addresses.
+func SelectAddress(addrs []netip.Addr, family string) (netip.Addr, error) {
+	for _, addr := range addrs {
+		switch family {
+		case AddressFamilyAny:
+			return addr, nil
+		case AddressFamilyIPv4:
+			if addr.Unmap().Is4() {
+				return addr.Unmap(), nil
+			}
+		case AddressFamilyIPv6:
+			if addr.Is6() && !addr.Is4In6() {
+				return addr, nil
+			}
+		default:
+			return netip.Addr{}, fmt.Errorf("unknown address family %q", family)
+		}
+	}
+	return netip.Addr{}, fmt.Errorf("no IPv%s address among %v", family, addrs)
+}
+
+// ResolvePeerAddress returns the address of family to dial for host, using the
+// tailnet peer list. IP literals are checked against family and returned as is.
+func ResolvePeerAddress(status *ipnstate.Status, host, family string) (netip.Addr, error) {
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return SelectAddress([]netip.Addr{addr}, family)
+	}
+	peer, err := FindPeer(status, host)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	addr, err := SelectAddress(peer.TailscaleIPs, family)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("host %s: %w", host, err)
+	}
+	return addr, nil
+}
@@ -0,0 +1,189 @@
+package ssh
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/types/key"
+)
+
+func TestResolvePeerAddress(t *testing.T) {
+	v4 := netip.MustParseAddr("100.64.0.5")
+	v6 := netip.MustParseAddr("fd7a:115c:a1e0::5")
+	status := &ipnstate.Status{
+		Peer: map[key.NodePublic]*ipnstate.PeerStatus{
+			key.NewNode().Public(): {HostName: "Web", DNSName: "web.tail1234.ts.net.", TailscaleIPs: []netip.Addr{v4, v6}},
+			key.NewNode().Public(): {HostName: "db", DNSName: "db.tail1234.ts.net.", TailscaleIPs: []netip.Addr{v4}},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		host    string
+		family  string
+		want    netip.Addr
+		wantErr bool
+	}{
+		{name: "any family takes the first address", host: "web", family: AddressFamilyAny, want: v4},
+		{name: "IPv4 only", host: "web", family: AddressFamilyIPv4, want: v4},
+		{name: "IPv6 only", host: "web", family: AddressFamilyIPv6, want: v6},
+		{name: "full MagicDNS name", host: "web.tail1234.ts.net", family: AddressFamilyIPv6, want: v6},
+		{name: "host name is case-insensitive", host: "WEB", family: AddressFamilyIPv4, want: v4},
+		{name: "peer without IPv6", host: "db", family: AddressFamilyIPv6, wantErr: true},
+		{name: "unknown peer", host: "mail", family: AddressFamilyIPv4, wantErr: true},
+		{name: "IPv4 literal", host: "100.64.0.9", family: AddressFamilyIPv4, want: netip.MustParseAddr("100.64.0.9")},
+		{name: "IPv4 literal with -6", host: "100.64.0.9", family: AddressFamilyIPv6, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ResolvePeerAddress(status, tt.host, tt.family)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ResolvePeerAddress() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("ResolvePeerAddress() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectAddressUnknownFamily(t *testing.T) {
+	if _, err := SelectAddress([]netip.Addr{netip.MustParseAddr("100.64.0.5")}, "5"); err == nil {
+		t.Error("SelectAddress() should reject an unknown family")
+	}
+}
+
+func TestNormalizeHostname(t *testing.T) {
+	tests := []struct {
+		host   string
+		suffix string
+		want   string
+	}{
+		{host: "web1", want: "web1"},
+		{host: "WEB1", want: "web1"},
+		{host: " web1. ", want: "web1"},
+		{host: "web1.Tailnet.TS.net.", want: "web1.tailnet.ts.net"},
+		{host: "WEB1", suffix: "tailnet.ts.net", want: "web1.tailnet.ts.net"},
+		{host: "web1", suffix: ".tailnet.ts.net.", want: "web1.tailnet.ts.net"},
+		{host: "web1.tailnet.ts.net", suffix: "tailnet.ts.net", want: "web1.tailnet.ts.net"},
+		{host: "100.64.0.5", suffix: "tailnet.ts.net", want: "100.64.0.5"},
+		{host: "fd7a:115c:a1e0::5", suffix: "tailnet.ts.net", want: "fd7a:115c:a1e0::5"},
+		{host: "", suffix: "tailnet.ts.net", want: ""},
+	}
+
+	for _, tt := range tests {
+		if got := NormalizeHostname(tt.host, tt.suffix); got != tt.want {
+			t.Errorf("NormalizeHostname(%q, %q) = %q, want %q", tt.host, tt.suffix, got, tt.want)
+		}
+	}
+}
+
+func TestFindPeerNormalizesNames(t *testing.T) {
+	status := &ipnstate.Status{
+		CurrentTailnet: &ipnstate.TailnetStatus{MagicDNSSuffix: "tailnet.ts.net"},
+		Peer: map[key.NodePublic]*ipnstate.PeerStatus{
+			key.NewNode().Public(): {HostName: "web-server", DNSName: "web1.tailnet.ts.net."},
+		},
+	}
+
+	for _, host := range []string{"WEB1", "web1.", "web1.tailnet.ts.net", "Web1.Tailnet.TS.Net.", "Web-Server"} {
+		if _, err := FindPeer(status, host); err != nil {
+			t.Errorf("FindPeer(%q) error = %v", host, err)
+		}
+	}
+	if peer, err := FindPeer(status, "web1.other.ts.net"); err == nil {
+		t.Errorf("FindPeer() matched %s in another tailnet", peer.DNSName)
+	}
+}
+
+func TestFindPeerPrefersMagicDNSName(t *testing.T) {
+	// Two machines called web1 are told apart by MagicDNS as web1 and
+	// web1-1, while a third keeps the host name web1-1 but was renamed web2
+	status := &ipnstate.Status{
+		CurrentTailnet: &ipnstate.TailnetStatus{MagicDNSSuffix: "tailnet.ts.net"},
+		Peer: map[key.NodePublic]*ipnstate.PeerStatus{
+			key.NewNode().Public(): {HostName: "web1", DNSName: "web1.tailnet.ts.net."},
+			key.NewNode().Public(): {HostName: "web1", DNSName: "web1-1.tailnet.ts.net."},
+			key.NewNode().Public(): {HostName: "web1-1", DNSName: "web2.tailnet.ts.net."},
+			key.NewNode().Public(): {HostName: "db", DNSName: "db-a.tailnet.ts.net."},
+			key.NewNode().Public(): {HostName: "db", DNSName: "db-b.tailnet.ts.net."},
+		},
+	}
+
+	tests := []struct {
+		host string
+		want string
+	}{
+		{host: "web1", want: "web1.tailnet.ts.net."},
+		{host: "WEB1.tailnet.ts.net", want: "web1.tailnet.ts.net."},
+		{host: "web1-1", want: "web1-1.tailnet.ts.net."},
+		{host: "web1-1.tailnet.ts.net.", want: "web1-1.tailnet.ts.net."},
+		{host: "web2", want: "web2.tailnet.ts.net."},
+		{host: "db-b", want: "db-b.tailnet.ts.net."},
+	}
+	// Map order is random, so look each one up several times
+	for i := 0; i < 20; i++ {
+		for _, tt := range tests {
+			peer, err := FindPeer(status, tt.host)
+			if err != nil {
+				t.Fatalf("FindPeer(%q) error = %v", tt.host, err)
+			}
+			if peer.DNSName != tt.want {
+				t.Fatalf("FindPeer(%q) = %s, want %s", tt.host, peer.DNSName, tt.want)
+			}
+		}
+	}
+
+	_, err := FindPeer(status, "db")
+	if err == nil {
+		t.Fatal("FindPeer(\"db\") should fail when two peers have that host name")
+	}
+	if !strings.Contains(err.Error(), "db-a.tailnet.ts.net, db-b.tailnet.ts.net") {
+		t.Errorf("ambiguity error = %q, want it to list both peers", err)
+	}
+}
+
+func TestPeerNameForIP(t *testing.T) {
+	status := &ipnstate.Status{
+		Peer: map[key.NodePublic]*ipnstate.PeerStatus{
+			key.NewNode().Public(): {HostName: "Web", DNSName: "web.tail1234.ts.net.", TailscaleIPs: []netip.Addr{
+				netip.MustParseAddr("100.64.0.5"), netip.MustParseAddr("fd7a:115c:a1e0::5"),
+			}},
+			key.NewNode().Public(): {HostName: "DB-Server", TailscaleIPs: []netip.Addr{netip.MustParseAddr("100.64.0.6")}},
+		},
+	}
+
+	tests := []struct {
+		host, want string
+	}{
+		{"100.64.0.5", "web"},
+		{"fd7a:115c:a1e0::5", "web"},
+		{"[fd7a:115c:a1e0::5]", "web"},
+		{"::ffff:100.64.0.5", "web"},
+		{"100.64.0.6", "db-server"}, // No MagicDNS name, so the host name
+		{"100.64.0.7", ""},
+		{"web", ""},
+	}
+	for _, tt := range tests {
+		if got := PeerNameForIP(status, tt.host); got != tt.want {
+			t.Errorf("PeerNameForIP(%q) = %q, want %q", tt.host, got, tt.want)
+		}
+	}
+	if got := PeerNameForIP(nil, "100.64.0.5"); got != "" {
+		t.Errorf("PeerNameForIP() without a peer list = %q, want \"\"", got)
+	}
+
+	for addr, want := range map[string]string{
+		"100.64.0.5:22":            "web:22",
+		"[fd7a:115c:a1e0::5]:2222": "web:2222",
+		"100.64.0.7:22":            "100.64.0.7:22",
+		"web:22":                   "web:22",
+	} {
+		if got := PeerHostKeyAddr(status, addr); got != want {
+			t.Errorf("PeerHostKeyAddr(%q) = %q, want %q", addr, got, want)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package ssh
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"golang.org/x/term"
+
+	"github.com/derekg/ts-ssh/internal/security"
+)
+
+// askpassProgram returns the SSH_ASKPASS program to ask for secrets with, or
+// "" to prompt on the terminal. As with OpenSSH, SSH_ASKPASS_REQUIRE=never
+// disables it, "prefer" or "force" use it even with a terminal, and otherwise
+// it is used when stdin is not a terminal, e.g. when a key is piped in.
+func askpassProgram(stdinIsTerminal bool) string {
+	program := os.Getenv("SSH_ASKPASS")
+	if program == "" {
+		return ""
+	}
+	switch os.Getenv("SSH_ASKPASS_REQUIRE") {
+	case "never":
+		return ""
+	case "prefer", "force":
+		return program
+	}
+	if stdinIsTerminal {
+		return ""
+	}
+	return program
+}
+
+// runAskpass runs program with prompt as its argument and returns the first
+// line it prints
+func runAskpass(program, prompt string) (string, error) {
+	cmd := exec.Command(program, prompt)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("askpass program %q failed: %w", program, err)
+	}
+	line, _, _ := strings.Cut(string(out), "\n")
+	return strings.TrimSuffix(line, "\r"), nil
+}
+
+// readKeyPassphrase asks for a key passphrase through SSH_ASKPASS or the
+// terminal
+func readKeyPassphrase(prompt string) (string, error) {
+	if program := askpassProgram(term.IsTerminal(int(os.Stdin.Fd()))); program != "" {
+		return runAskpass(program, prompt)
+	}
+	fmt.Print(prompt)
+	password, err := security.ReadPasswordSecurely()
+	fmt.Println()
+	return password, err
+}
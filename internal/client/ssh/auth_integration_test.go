@@ -259,7 +259,7 @@ func testSSHConnectionConfig(t *testing.T, privateKeyPath string, expectedSucces
 	}
 
 	// Test createSSHConfig function
-	sshConfig, err := createSSHConfig(config)
+	sshConfig, err := createSSHConfig(config, nil)
 	if err != nil {
 		if expectedSuccess {
 			t.Errorf("Expected SSH config creation to succeed, got error: %v", err)
@@ -411,7 +411,7 @@ func TestSSHConnectionHelpers(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			t.Logf("Testing: %s", tt.description)
 
-			sshConfig, err := createSSHConfig(tt.config)
+			sshConfig, err := createSSHConfig(tt.config, nil)
 			if tt.expectError {
 				if err == nil {
 					t.Error("Expected error but got none")
@@ -538,7 +538,7 @@ func TestSSHConnectionEstablishment(t *testing.T) {
 		}
 
 		// Test SSH config creation
-		sshConfig, err := createSSHConfig(config)
+		sshConfig, err := createSSHConfig(config, nil)
 		if err != nil {
 			t.Fatalf("Failed to create SSH config: %v", err)
 		}
@@ -0,0 +1,38 @@
+package ssh
+
+import (
+	"io"
+	"strings"
+	"unicode"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// BannerCallback returns an ssh.BannerCallback writing the server's
+// pre-authentication banner to out. Control characters other than tabs and
+// newlines are dropped, so a banner can't move the cursor or change the
+// terminal's settings, and the banner always ends with a newline.
+func BannerCallback(out io.Writer) ssh.BannerCallback {
+	return func(message string) error {
+		clean := stripControl(message)
+		if clean == "" {
+			return nil
+		}
+		if !strings.HasSuffix(clean, "\n") {
+			clean += "\n"
+		}
+		_, err := io.WriteString(out, clean)
+		return err
+	}
+}
+
+// stripControl drops control characters other than tabs and newlines from
+// server-supplied text before it is shown on the terminal
+func stripControl(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) && r != '\n' && r != '\t' {
+			return -1
+		}
+		return r
+	}, s)
+}
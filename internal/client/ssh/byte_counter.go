@@ -0,0 +1,59 @@
+package ssh
+
+import (
+	"io"
+	"sync/atomic"
+)
+
+// ByteCounter totals the session data sent to and received from a host.
+// Reads through the wrapper from Reader count as sent, writes through the
+// wrapper from Writer as received. It is safe for concurrent use, as the
+// input and output of a session are copied on different goroutines.
+type ByteCounter struct {
+	sent     atomic.Int64
+	received atomic.Int64
+}
+
+// Reader returns r with every byte read from it counted as sent
+func (c *ByteCounter) Reader(r io.Reader) io.Reader {
+	if r == nil {
+		return nil
+	}
+	return countingReader{r: r, n: &c.sent}
+}
+
+// Writer returns w with every byte written to it counted as received. A
+// nil w discards the data but still counts it.
+func (c *ByteCounter) Writer(w io.Writer) io.Writer {
+	if w == nil {
+		w = io.Discard
+	}
+	return countingWriter{w: w, n: &c.received}
+}
+
+// Totals returns the bytes sent and received so far
+func (c *ByteCounter) Totals() (sent, received int64) {
+	return c.sent.Load(), c.received.Load()
+}
+
+type countingReader struct {
+	r io.Reader
+	n *atomic.Int64
+}
+
+func (r countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n.Add(int64(n))
+	return n, err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n *atomic.Int64
+}
+
+func (w countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n.Add(int64(n))
+	return n, err
+}
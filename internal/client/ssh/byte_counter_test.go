@@ -0,0 +1,74 @@
+package ssh
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestByteCounter(t *testing.T) {
+	var counter ByteCounter
+
+	// Input read through the counter is sent
+	var remoteStdin bytes.Buffer
+	if _, err := io.Copy(&remoteStdin, counter.Reader(strings.NewReader("ls -l\n"))); err != nil {
+		t.Fatalf("copy input: %v", err)
+	}
+
+	// Stdout and stderr share the received total; nil discards
+	var stdout bytes.Buffer
+	counter.Writer(&stdout).Write([]byte("total 0\n"))
+	counter.Writer(nil).Write([]byte("warning\n"))
+
+	sent, received := counter.Totals()
+	if sent != 6 || received != 16 {
+		t.Errorf("Totals() = %d sent, %d received, want 6 and 16", sent, received)
+	}
+	if stdout.String() != "total 0\n" || remoteStdin.String() != "ls -l\n" {
+		t.Errorf("data changed in transit: stdout %q, stdin %q", stdout.String(), remoteStdin.String())
+	}
+	if counter.Reader(nil) != nil {
+		t.Error("Reader(nil) should stay nil so no input is sent")
+	}
+}
+
+// failingWriter accepts part of each write and then fails
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return len(p) / 2, errors.New("disk full")
+}
+
+func TestByteCounterCountsWrittenBytesOnly(t *testing.T) {
+	var counter ByteCounter
+	if _, err := counter.Writer(failingWriter{}).Write(make([]byte, 10)); err == nil {
+		t.Fatal("Write() error was dropped")
+	}
+	if _, received := counter.Totals(); received != 5 {
+		t.Errorf("received = %d, want the 5 bytes actually written", received)
+	}
+}
+
+func TestByteCounterConcurrent(t *testing.T) {
+	var counter ByteCounter
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			io.Copy(io.Discard, counter.Reader(strings.NewReader(strings.Repeat("x", 1000))))
+		}()
+		go func() {
+			defer wg.Done()
+			counter.Writer(io.Discard).Write(make([]byte, 1000))
+		}()
+	}
+	wg.Wait()
+
+	if sent, received := counter.Totals(); sent != 8000 || received != 8000 {
+		t.Errorf("Totals() = %d, %d, want 8000 each", sent, received)
+	}
+}
@@ -1,6 +1,7 @@
 package ssh
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -8,18 +9,16 @@ import (
 	"log"
 	"net"
 	"os"
-	"os/signal"
 	"os/user"
 	"path/filepath"
-	"runtime"
 	"strings"
-	"syscall"
 
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/knownhosts"
 	"golang.org/x/term"
 	"tailscale.com/tsnet"
 
+	"github.com/derekg/ts-ssh/internal/platform"
 	"github.com/derekg/ts-ssh/internal/security"
 )
 
@@ -30,8 +29,11 @@ const (
 	DefaultTerminalType   = "xterm-256color"
 )
 
+// maxPrivateKeySize bounds a key read from a stream; real keys are a few KB
+const maxPrivateKeySize = 1 << 20
+
 // startInteractiveSession starts an interactive SSH session with PTY support
-func startInteractiveSession(client *ssh.Client, logger *log.Logger) error {
+func startInteractiveSession(ctx context.Context, client *ssh.Client, logger *log.Logger) error {
 	logger.Println("Starting interactive SSH session...")
 	session, err := CreateSSHSession(client)
 	if err != nil {
@@ -44,13 +46,14 @@ func startInteractiveSession(client *ssh.Client, logger *log.Logger) error {
 	if err != nil {
 		return fmt.Errorf("failed to create stdin pipe for SSH session: %w", err)
 	}
-	session.Stdout = os.Stdout
-	session.Stderr = os.Stderr
+	tio, stop := localTerminalIO()
+	defer stop()
+	session.Stdout = tio.Stdout
+	session.Stderr = tio.Stderr
 
 	// Set up terminal if running in one
-	fd := int(os.Stdin.Fd())
-	if term.IsTerminal(fd) {
-		err = setupTerminal(session, fd, logger)
+	if tio.IsTerminal {
+		err = setupTerminal(session, int(os.Stdin.Fd()), logger)
 		if err != nil {
 			return fmt.Errorf("failed to setup terminal: %w", err)
 		}
@@ -63,12 +66,12 @@ func startInteractiveSession(client *ssh.Client, logger *log.Logger) error {
 	}
 
 	// Handle terminal resizing and escape sequences
-	return handleInteractiveSession(session, stdinPipe, fd, logger)
+	return handleInteractiveSession(ctx, session, stdinPipe, tio, logger)
 }
 
 // setupTerminal configures the terminal for interactive SSH session
 func setupTerminal(session *ssh.Session, fd int, logger *log.Logger) error {
-	termWidth, termHeight, err := term.GetSize(fd)
+	termWidth, termHeight, err := TerminalSize(fd)
 	if err != nil {
 		logger.Printf("Warning: Failed to get terminal size: %v. Using default %dx%d.", err, DefaultTerminalWidth, DefaultTerminalHeight)
 		termWidth = DefaultTerminalWidth
@@ -88,83 +91,6 @@ func setupTerminal(session *ssh.Session, fd int, logger *log.Logger) error {
 	return nil
 }
 
-// handleInteractiveSession manages the interactive SSH session with proper terminal handling
-func handleInteractiveSession(session *ssh.Session, stdinPipe io.WriteCloser, fd int, logger *log.Logger) error {
-	// Import GetGlobalTerminalState from main package - this needs to be accessible
-	// For now, create a simple terminal state manager
-	var terminalRestoreFn func() error
-
-	// Set up terminal in raw mode if we're in a terminal
-	if term.IsTerminal(fd) {
-		oldState, err := term.MakeRaw(fd)
-		if err != nil {
-			logger.Printf("Warning: Failed to set terminal to raw mode: %v", err)
-		} else {
-			terminalRestoreFn = func() error {
-				return term.Restore(fd, oldState)
-			}
-			// Ensure terminal is restored on exit
-			defer func() {
-				if terminalRestoreFn != nil {
-					if err := terminalRestoreFn(); err != nil {
-						logger.Printf("Warning: Failed to restore terminal: %v", err)
-					}
-				}
-			}()
-		}
-
-		// Show escape sequence info (would need T() function from i18n)
-		fmt.Fprint(os.Stderr, "Use ~. to terminate connection\n")
-	}
-
-	// Set up signal handling for graceful shutdown
-	done := make(chan bool, 1)
-	go handleInputWithTerminalState(stdinPipe, done, logger)
-
-	// Handle window resize signals if in terminal
-	if term.IsTerminal(fd) {
-		ctx, cancel := context.WithCancel(context.Background())
-		defer cancel()
-		go WatchWindowSize(fd, session, ctx, logger)
-	}
-
-	// Wait for session to complete
-	err := session.Wait()
-	done <- true // Signal input handler to stop
-
-	return err
-}
-
-// handleInputWithTerminalState handles stdin input
-func handleInputWithTerminalState(stdinPipe io.WriteCloser, done chan bool, logger *log.Logger) {
-	defer stdinPipe.Close()
-
-	// Create a buffered reader for stdin
-	input := make([]byte, 1024)
-
-	for {
-		select {
-		case <-done:
-			return
-		default:
-			n, err := os.Stdin.Read(input)
-			if err != nil {
-				if err != io.EOF {
-					logger.Printf("Error reading stdin: %v", err)
-				}
-				return
-			}
-
-			// Write to SSH session
-			_, writeErr := stdinPipe.Write(input[:n])
-			if writeErr != nil {
-				logger.Printf("Error writing to SSH session: %v", writeErr)
-				return
-			}
-		}
-	}
-}
-
 func promptUserViaTTY(prompt string, logger *log.Logger) (string, error) {
 	// Try secure TTY access first
 	result, err := security.PromptUserSecurely(prompt)
@@ -217,12 +143,21 @@ func ConnectToHost(
 	defer client.Close()
 
 	// Start interactive session using standardized helper
-	return startInteractiveSession(client, logger)
+	return startInteractiveSession(appCtx, client, logger)
 }
 
 // LoadPrivateKey loads an SSH private key from the given path.
 // It supports unencrypted keys and keys encrypted with a passphrase, prompting for it if needed.
 func LoadPrivateKey(path string, logger *log.Logger) (ssh.AuthMethod, error) {
+	signer, err := loadPrivateKeySigner(path, logger)
+	if err != nil {
+		return nil, err
+	}
+	return ssh.PublicKeys(signer), nil
+}
+
+// loadPrivateKeySigner does the work of LoadPrivateKey and returns the signer itself
+func loadPrivateKeySigner(path string, logger *log.Logger) (ssh.Signer, error) {
 	if path == "" {
 		return nil, errors.New("private key path is empty")
 	}
@@ -230,76 +165,165 @@ func LoadPrivateKey(path string, logger *log.Logger) (ssh.AuthMethod, error) {
 	if err != nil {
 		return nil, fmt.Errorf("reading key file %q failed: %w", path, err)
 	}
+	return parsePrivateKeySigner(keyBytes, path, logger)
+}
+
+// LoadPrivateKeyFrom reads an SSH private key from r, such as stdin fed by a
+// secret manager, so the key never has to be written to disk. name is used
+// in prompts and errors. A passphrase is asked for as for LoadPrivateKey,
+// through SSH_ASKPASS when that is set and stdin is not a terminal.
+func LoadPrivateKeyFrom(r io.Reader, name string, logger *log.Logger) (ssh.Signer, error) {
+	keyBytes, err := io.ReadAll(io.LimitReader(r, maxPrivateKeySize+1))
+	if err != nil {
+		return nil, fmt.Errorf("reading key from %s failed: %w", name, err)
+	}
+	defer clear(keyBytes)
+	if len(keyBytes) > maxPrivateKeySize {
+		return nil, fmt.Errorf("key from %s is larger than %d bytes", name, maxPrivateKeySize)
+	}
+	if len(bytes.TrimSpace(keyBytes)) == 0 {
+		return nil, fmt.Errorf("no key read from %s", name)
+	}
+	return parsePrivateKeySigner(keyBytes, name, logger)
+}
 
+// parsePrivateKeySigner parses keyBytes, asking for a passphrase if the key
+// is encrypted. With an agent set by UseKeyAgent, an encrypted key the agent
+// holds is used from there instead, and one decrypted here is added to it.
+// name identifies the key in prompts and errors.
+func parsePrivateKeySigner(keyBytes []byte, name string, logger *log.Logger) (ssh.Signer, error) {
 	signer, err := ssh.ParsePrivateKey(keyBytes)
 	if err == nil {
-		return ssh.PublicKeys(signer), nil
+		return signer, nil
 	}
 
 	var passphraseErr *ssh.PassphraseMissingError
 	if errors.As(err, &passphraseErr) {
-		logger.Printf("SSH key %s is passphrase protected.", path)
-		fmt.Printf("Enter passphrase for key %s: ", path)
-		password, errRead := security.ReadPasswordSecurely()
-		fmt.Println()
+		// A key added to the agent earlier needs no passphrase. Only newer
+		// key formats carry the public key needed to look it up.
+		agentSetting := keyAgent.Load()
+		if agentSetting != nil && passphraseErr.PublicKey != nil {
+			if signer := agentSetting.agentSigner(passphraseErr.PublicKey); signer != nil {
+				logger.Printf("Using key %s from the SSH agent", name)
+				return signer, nil
+			}
+		}
+
+		logger.Printf("SSH key %s is passphrase protected.", name)
+		password, errRead := readKeyPassphrase(fmt.Sprintf("Enter passphrase for key %s: ", name))
 		if errRead != nil {
 			return nil, fmt.Errorf("failed to read passphrase securely: %w", errRead)
 		}
-		signer, err = ssh.ParsePrivateKeyWithPassphrase(keyBytes, []byte(password))
+		rawKey, err := ssh.ParseRawPrivateKeyWithPassphrase(keyBytes, []byte(password))
 		if err != nil {
 			if strings.Contains(err.Error(), "incorrect passphrase") || strings.Contains(err.Error(), "decryption failed") {
-				return nil, fmt.Errorf("incorrect passphrase for key %q", path)
+				return nil, fmt.Errorf("incorrect passphrase for key %q", name)
 			}
-			return nil, fmt.Errorf("parsing key %q with passphrase failed: %w", path, err)
+			return nil, fmt.Errorf("parsing key %q with passphrase failed: %w", name, err)
+		}
+		signer, err = ssh.NewSignerFromKey(rawKey)
+		if err != nil {
+			return nil, fmt.Errorf("parsing key %q with passphrase failed: %w", name, err)
 		}
-		return ssh.PublicKeys(signer), nil
+		if agentSetting != nil {
+			agentSetting.add(rawKey, name, logger)
+		}
+		return signer, nil
 	}
 
-	return nil, fmt.Errorf("parsing private key %q failed: %w", path, err)
+	return nil, fmt.Errorf("parsing private key %q failed: %w", name, err)
 }
 
 // CreateKnownHostsCallback returns a ssh.HostKeyCallback that uses a known_hosts file.
 // It will prompt the user to add new host keys if the host is not found.
+// Keys are checked against ~/.ssh/known_hosts and the system-wide GlobalKnownHostsFile.
 func CreateKnownHostsCallback(currentUser *user.User, logger *log.Logger) (ssh.HostKeyCallback, error) {
-	if currentUser == nil || currentUser.HomeDir == "" {
-		home, err := os.UserHomeDir()
-		if err != nil {
-			logger.Printf("Warning: Cannot determine user home directory for known_hosts: %v. Host key checking may be impaired or prompt.", err)
-			return nil, fmt.Errorf("user home directory unknown, cannot reliably manage known_hosts: %w", err)
+	return CreateKnownHostsCallbackFiles(currentUser, "", []string{GlobalKnownHostsFile}, false, nil, logger)
+}
+
+// CreateKnownHostsCallbackFiles is like CreateKnownHostsCallback but verifies
+// against userKnownHostsPath (default ~/.ssh/known_hosts) plus any existing
+// globalKnownHostsPaths. Newly accepted keys are only appended to the user file,
+// and never when readOnly is set; the user file is then not created either.
+// A changed key for a host in acceptChanged is accepted instead of refused and
+// replaces the old entry in the user file.
+func CreateKnownHostsCallbackFiles(currentUser *user.User, userKnownHostsPath string, globalKnownHostsPaths []string, readOnly bool, acceptChanged []string, logger *log.Logger) (ssh.HostKeyCallback, error) {
+	knownHostsPath := userKnownHostsPath
+	if knownHostsPath == "" {
+		if currentUser == nil || currentUser.HomeDir == "" {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				logger.Printf("Warning: Cannot determine user home directory for known_hosts: %v. Host key checking may be impaired or prompt.", err)
+				return nil, fmt.Errorf("user home directory unknown, cannot reliably manage known_hosts: %w", err)
+			}
+			currentUser = &user.User{HomeDir: home}
+			logger.Printf("Warning: currentUser was nil or HomeDir empty. Deduced home as %s for known_hosts.", home)
 		}
-		currentUser = &user.User{HomeDir: home}
-		logger.Printf("Warning: currentUser was nil or HomeDir empty. Deduced home as %s for known_hosts.", home)
+		knownHostsPath = filepath.Join(currentUser.HomeDir, ".ssh", "known_hosts")
 	}
 
-	knownHostsPath := filepath.Join(currentUser.HomeDir, ".ssh", "known_hosts")
-
-	// Create known_hosts file securely to prevent race conditions
-	if err := security.CreateSecureKnownHostsFile(knownHostsPath); err != nil {
+	files := []string{knownHostsPath}
+	appendPath := knownHostsPath
+	if readOnly {
+		// Verify against the user file if present, but never create or modify it
+		appendPath = ""
+		if _, err := os.Stat(knownHostsPath); err != nil {
+			files = nil
+		}
+	} else if err := security.CreateSecureKnownHostsFile(knownHostsPath); err != nil {
+		// Create known_hosts file securely to prevent race conditions
 		logger.Printf("Unable to create secure known_hosts file %s: %v. Host key management will be impaired.", knownHostsPath, err)
 	}
 
-	hostKeyCallback, err := knownhosts.New(knownHostsPath)
+	// Global files are read-only and optional; skip any that don't exist
+	for _, path := range globalKnownHostsPaths {
+		if _, err := os.Stat(path); err == nil {
+			files = append(files, path)
+		}
+	}
+
+	hostKeyCallback, err := loadKnownHosts(files...)
 	if err != nil {
-		logger.Printf("Could not initialize known_hosts callback using %s: %v. Host key verification will prompt for every new host without persistence.", knownHostsPath, err)
+		logger.Printf("Could not initialize known_hosts callback using %v: %v. Host key verification will prompt for every new host without persistence.", files, err)
 		return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
 			return handleHostKey(hostname, remote, key, "", logger)
 		}, nil
 	}
 
 	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
-		err := hostKeyCallback(hostname, remote, key)
+		err := verifyKnownHostKey(hostKeyCallback, hostname, remote, key, logger)
 		if err == nil {
 			return nil
 		}
+		var revoked *knownhosts.RevokedError
+		if errors.As(err, &revoked) {
+			return rejectRevokedHostKey(hostname, revoked, logger)
+		}
+		// An untrusted certificate was checked as its plain key, which is
+		// what gets prompted for and saved
+		if cert, ok := key.(*ssh.Certificate); ok {
+			key = cert.Key
+		}
 		var keyErr *knownhosts.KeyError
 		if errors.As(err, &keyErr) {
-			return handleHostKey(hostname, remote, key, knownHostsPath, logger, keyErr)
+			if len(keyErr.Want) > 0 && hostInList(hostname, acceptChanged) {
+				return acceptChangedHostKey(hostname, remote, key, appendPath, keyErr, logger)
+			}
+			return handleHostKey(hostname, remote, key, appendPath, logger, keyErr)
 		}
 		logger.Printf("Unexpected error during host key verification for %s: %v", hostname, err)
 		return fmt.Errorf("unexpected error during host key verification: %w", err)
 	}, nil
 }
 
+// changedHostKeyBanner heads the warning for a changed host key, as in OpenSSH
+const changedHostKeyBanner = "@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@\n" +
+	"@    WARNING: REMOTE HOST IDENTIFICATION HAS CHANGED!     @\n" +
+	"@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@"
+
+// hostKeyPrompt asks the user whether to trust an unknown host key; tests replace it
+var hostKeyPrompt = promptUserViaTTY
+
 func handleHostKey(hostname string, remote net.Addr, key ssh.PublicKey, knownHostsPath string, logger *log.Logger, keyErr ...*knownhosts.KeyError) error {
 	var specificKeyError *knownhosts.KeyError
 	if len(keyErr) > 0 {
@@ -308,23 +332,33 @@ func handleHostKey(hostname string, remote net.Addr, key ssh.PublicKey, knownHos
 
 	if specificKeyError != nil && len(specificKeyError.Want) > 0 {
 		logger.Printf("WARNING: Remote host identification has changed for %s!", hostname)
-		fmt.Fprintf(os.Stderr, "\n@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@\n")
-		fmt.Fprintf(os.Stderr, "@    WARNING: REMOTE HOST IDENTIFICATION HAS CHANGED!     @\n")
-		fmt.Fprintf(os.Stderr, "@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@\n")
+		security.LogHostKeyVerification(hostname, "", "verification_failed", false)
+		if !firstChangedKeyWarning(hostname, key) {
+			// Already reported in this run; the returned error still fails the connection
+			return specificKeyError
+		}
+		if !stderrIsTerminal() {
+			// One line per host keeps scripted runs over many hosts readable
+			want := specificKeyError.Want[0]
+			fmt.Fprintf(os.Stderr, "%s host key for %s has changed (now %s %s, offending key in %s:%d); refusing to connect.\n",
+				platform.Colorize(platform.ColorRed, "WARNING:"), hostname, key.Type(), ssh.FingerprintSHA256(key), want.Filename, want.Line)
+			return specificKeyError
+		}
+		fmt.Fprint(os.Stderr, "\n"+platform.Colorize(platform.ColorRed, changedHostKeyBanner)+"\n")
 		fmt.Fprintf(os.Stderr, "IT IS POSSIBLE THAT SOMEONE IS DOING SOMETHING NASTY!\n")
 		fmt.Fprintf(os.Stderr, "Someone could be eavesdropping on you right now (man-in-the-middle attack)!\n")
 		fmt.Fprintf(os.Stderr, "It is also possible that a host key has just been changed.\n")
 		fmt.Fprintf(os.Stderr, "The fingerprint for the %s key sent by the remote host %s is:\n%s\n", key.Type(), remote.String(), ssh.FingerprintSHA256(key))
 		fmt.Fprintf(os.Stderr, "Please contact your system administrator.\n")
 		for _, kh := range specificKeyError.Want {
-			fmt.Fprintf(os.Stderr, "Offending ECDSA key in %s:%d\n", kh.Filename, kh.Line)
+			fmt.Fprintf(os.Stderr, "Offending %s key in %s:%d\n", kh.Key.Type(), kh.Filename, kh.Line)
 		}
 		return specificKeyError
 	} else {
 		fmt.Fprintf(os.Stderr, "The authenticity of host '%s (%s)' can't be established.\n", hostname, remote.String())
 		fmt.Fprintf(os.Stderr, "%s key fingerprint is %s.\n", key.Type(), ssh.FingerprintSHA256(key))
 
-		answer, readErr := promptUserViaTTY(fmt.Sprintf("Are you sure you want to continue connecting (yes/no/[fingerprint])? "), logger)
+		answer, readErr := hostKeyPrompt(fmt.Sprintf("Are you sure you want to continue connecting (yes/no/[fingerprint])? "), logger)
 		if readErr != nil {
 			return fmt.Errorf("failed to read user confirmation: %w", readErr)
 		}
@@ -337,7 +371,7 @@ func handleHostKey(hostname string, remote net.Addr, key ssh.PublicKey, knownHos
 			return appendKnownHost(knownHostsPath, hostname, remote, key, logger)
 		} else if strings.ToLower(answer) == "fingerprint" {
 			fmt.Fprintf(os.Stderr, "Re-displaying fingerprint for verification: %s\n", ssh.FingerprintSHA256(key))
-			answer, readErr = promptUserViaTTY("Are you sure you want to continue connecting (yes/no)? ", logger)
+			answer, readErr = hostKeyPrompt("Are you sure you want to continue connecting (yes/no)? ", logger)
 			if readErr != nil {
 				return fmt.Errorf("failed to read user re-confirmation: %w", readErr)
 			}
@@ -360,12 +394,6 @@ func appendKnownHost(knownHostsPath, hostname string, remote net.Addr, key ssh.P
 		return errors.New("cannot append known host: path is empty")
 	}
 
-	f, err := os.OpenFile(knownHostsPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
-	if err != nil {
-		return fmt.Errorf("failed to open %s to append new key: %w", knownHostsPath, err)
-	}
-	defer f.Close()
-
 	var addresses []string
 	normalizedRemoteAddr := knownhosts.Normalize(remote.String())
 	addresses = append(addresses, hostname)
@@ -382,7 +410,30 @@ func appendKnownHost(knownHostsPath, hostname string, remote net.Addr, key ssh.P
 		}
 	}
 
-	line := knownhosts.Line(addresses, key)
+	return appendKnownHostLine(knownHostsPath, knownhosts.Line(addresses, key), hostname, key, logger)
+}
+
+// appendKnownHostLine writes a preformatted known_hosts line to knownHostsPath
+func appendKnownHostLine(knownHostsPath, line, hostname string, key ssh.PublicKey, logger *log.Logger) error {
+	if knownHostsPath == "" {
+		return errors.New("cannot append known host: path is empty")
+	}
+
+	present, err := knownHostsHasLine(knownHostsPath, line)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", knownHostsPath, err)
+	}
+	if present {
+		logger.Printf("Host key for %s (%s) is already in %s; not adding it again.", hostname, key.Type(), knownHostsPath)
+		return nil
+	}
+
+	f, err := os.OpenFile(knownHostsPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open %s to append new key: %w", knownHostsPath, err)
+	}
+	defer f.Close()
+
 	if _, err := f.WriteString(line + "\n"); err != nil {
 		return fmt.Errorf("failed to write host key to %s: %w", knownHostsPath, err)
 	}
@@ -391,31 +442,14 @@ func appendKnownHost(knownHostsPath, hostname string, remote net.Addr, key ssh.P
 	return nil
 }
 
-// getSigWinch returns SIGWINCH on Unix platforms, nil on Windows
-func getSigWinch() os.Signal {
-	if runtime.GOOS == "windows" {
-		return nil
-	}
-	// This will only compile on Unix platforms
-	return syscall.Signal(0x1c) // SIGWINCH value on most Unix systems
-}
-
+// WatchWindowSize sends the terminal's size to session now and each time it
+// changes, until ctx is done or the session has gone away
 func WatchWindowSize(fd int, session *ssh.Session, ctx context.Context, logger *log.Logger) {
-	// Window resize monitoring is limited on some platforms
-	if runtime.GOOS == "windows" {
-		logger.Println("Window resize monitoring not supported on Windows")
-		return
-	}
-
-	sigCh := make(chan os.Signal, 1)
-	// Use reflection to access SIGWINCH on Unix platforms only
-	if sigWinch := getSigWinch(); sigWinch != nil {
-		signal.Notify(sigCh, sigWinch)
-	}
-	defer signal.Stop(sigCh)
+	resized, stop := watchWindowChanges()
+	defer stop()
 
 	if term.IsTerminal(fd) {
-		termWidth, termHeight, err := term.GetSize(fd)
+		termWidth, termHeight, err := TerminalSize(fd)
 		if err == nil && termWidth > 0 && termHeight > 0 {
 			if err := session.WindowChange(termHeight, termWidth); err != nil {
 				logger.Printf("watchWindowSize: Error sending initial window size: %v", err)
@@ -427,9 +461,9 @@ func WatchWindowSize(fd int, session *ssh.Session, ctx context.Context, logger *
 
 	for {
 		select {
-		case <-sigCh:
+		case <-resized:
 			if term.IsTerminal(fd) {
-				termWidth, termHeight, err := term.GetSize(fd)
+				termWidth, termHeight, err := TerminalSize(fd)
 				if err == nil && termWidth > 0 && termHeight > 0 {
 					if err := session.WindowChange(termHeight, termWidth); err != nil {
 						logger.Printf("watchWindowSize: Error sending window change: %v", err)
@@ -439,7 +473,7 @@ func WatchWindowSize(fd int, session *ssh.Session, ctx context.Context, logger *
 						}
 					}
 				} else if err != nil {
-					logger.Printf("watchWindowSize: Error getting terminal size on SIGWINCH: %v", err)
+					logger.Printf("watchWindowSize: Error getting terminal size on resize: %v", err)
 				}
 			}
 		case <-ctx.Done():
@@ -0,0 +1,94 @@
+package ssh
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestLoadPrivateKeyFrom(t *testing.T) {
+	logger := log.New(io.Discard, "", 0)
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := LoadPrivateKeyFrom(bytes.NewReader(pem.EncodeToMemory(block)), "(stdin)", logger)
+	if err != nil {
+		t.Fatalf("LoadPrivateKeyFrom() error = %v", err)
+	}
+	if !bytes.Equal(signer.PublicKey().Marshal(), want.Marshal()) {
+		t.Error("LoadPrivateKeyFrom() returned a different key")
+	}
+
+	if _, err := LoadPrivateKeyFrom(strings.NewReader("\n"), "(stdin)", logger); err == nil {
+		t.Error("LoadPrivateKeyFrom() accepted empty input")
+	}
+	if _, err := LoadPrivateKeyFrom(strings.NewReader("not a key"), "(stdin)", logger); err == nil {
+		t.Error("LoadPrivateKeyFrom() accepted garbage")
+	}
+
+	t.Run("passphrase from askpass", func(t *testing.T) {
+		if runtime.GOOS == "windows" {
+			t.Skip("askpass test uses a shell script")
+		}
+		block, err := ssh.MarshalPrivateKeyWithPassphrase(priv, "", []byte("s3cret"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		askpass := filepath.Join(t.TempDir(), "askpass")
+		if err := os.WriteFile(askpass, []byte("#!/bin/sh\necho s3cret\n"), 0700); err != nil {
+			t.Fatal(err)
+		}
+		t.Setenv("SSH_ASKPASS", askpass)
+		t.Setenv("SSH_ASKPASS_REQUIRE", "force")
+
+		signer, err := LoadPrivateKeyFrom(bytes.NewReader(pem.EncodeToMemory(block)), "(stdin)", logger)
+		if err != nil {
+			t.Fatalf("LoadPrivateKeyFrom() error = %v", err)
+		}
+		if !bytes.Equal(signer.PublicKey().Marshal(), want.Marshal()) {
+			t.Error("LoadPrivateKeyFrom() returned a different key")
+		}
+	})
+}
+
+func TestAskpassProgram(t *testing.T) {
+	tests := []struct {
+		askpass, require string
+		isTerminal       bool
+		want             string
+	}{
+		{"", "force", false, ""},
+		{"/bin/askpass", "", false, "/bin/askpass"},
+		{"/bin/askpass", "", true, ""},
+		{"/bin/askpass", "prefer", true, "/bin/askpass"},
+		{"/bin/askpass", "force", true, "/bin/askpass"},
+		{"/bin/askpass", "never", false, ""},
+	}
+	for _, tt := range tests {
+		t.Setenv("SSH_ASKPASS", tt.askpass)
+		t.Setenv("SSH_ASKPASS_REQUIRE", tt.require)
+		if got := askpassProgram(tt.isTerminal); got != tt.want {
+			t.Errorf("askpassProgram(%v) with SSH_ASKPASS=%q SSH_ASKPASS_REQUIRE=%q = %q, want %q", tt.isTerminal, tt.askpass, tt.require, got, tt.want)
+		}
+	}
+}
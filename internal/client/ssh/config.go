@@ -4,9 +4,12 @@ import (
 	"bufio"
 	"fmt"
 	"os"
-	"os/user"
-	"path/filepath"
+	"os/exec"
+	"path"
 	"strings"
+
+	"github.com/derekg/ts-ssh/internal/config"
+	"github.com/derekg/ts-ssh/internal/security"
 )
 
 // SSHConfigOptions holds SSH configuration options parsed from config file
@@ -15,11 +18,26 @@ type SSHConfigOptions struct {
 	IdentityFile    string
 	HostKeyChecking string
 	KnownHostsFile  string
+	ForceCommand    string // ts-ssh extension: remote command run instead of any given
+	RemoteCommand   string // Command run when none is given; "none" for no command
+}
+
+// MatchContext describes the connection that Host and Match blocks are evaluated against
+type MatchContext struct {
+	Host      string // Target host as given on the command line
+	User      string // Remote user, from the command line or the local user
+	LocalUser string // Local user running ts-ssh
+	AllowExec bool   // Permit "Match exec" to run local commands
 }
 
 // parseSSHConfig reads and parses an SSH config file for the specified host
-// This is a minimal implementation focused on our security needs
-func parseSSHConfig(configPath, hostname string) (*SSHConfigOptions, error) {
+// This is a minimal implementation focused on our security needs.
+//
+// Options before the first Host or Match line apply to every host. Host and
+// Match blocks are evaluated in order and, like OpenSSH, the first value
+// obtained for each option wins. "Match exec" only runs when match.AllowExec
+// is set; otherwise the condition is treated as not matching.
+func parseSSHConfig(configPath string, match MatchContext) (*SSHConfigOptions, error) {
 	if configPath == "" {
 		return nil, fmt.Errorf("no SSH config file specified")
 	}
@@ -31,11 +49,12 @@ func parseSSHConfig(configPath, hostname string) (*SSHConfigOptions, error) {
 	defer file.Close()
 
 	options := &SSHConfigOptions{}
-	inHostSection := false
-	matchesGlobal := true
+	inMatchingSection := true // Global section until the first Host/Match line
 
 	scanner := bufio.NewScanner(file)
+	lineNum := 0
 	for scanner.Scan() {
+		lineNum++
 		line := strings.TrimSpace(scanner.Text())
 
 		// Skip empty lines and comments
@@ -54,13 +73,19 @@ func parseSSHConfig(configPath, hostname string) (*SSHConfigOptions, error) {
 		switch key {
 		case "host":
 			// Check if this host section matches our target
-			hostPattern := value
-			inHostSection = (hostPattern == hostname || hostPattern == "*")
+			inMatchingSection = matchPatternList(parts[1:], match.Host)
+			continue
+		case "match":
+			matched, err := evaluateMatch(parts[1:], match)
+			if err != nil {
+				return nil, fmt.Errorf("%s line %d: %w", configPath, lineNum, err)
+			}
+			inMatchingSection = matched
 			continue
 		}
 
 		// Only process options if we're in a matching host section or global section
-		if !inHostSection && !matchesGlobal {
+		if !inMatchingSection {
 			continue
 		}
 
@@ -71,12 +96,9 @@ func parseSSHConfig(configPath, hostname string) (*SSHConfigOptions, error) {
 			}
 		case "identityfile":
 			if options.IdentityFile == "" {
-				// Expand ~ to home directory
-				if strings.HasPrefix(value, "~/") {
-					currentUser, err := user.Current()
-					if err == nil {
-						value = filepath.Join(currentUser.HomeDir, value[2:])
-					}
+				// Expand ~, ~user and environment variables
+				if expanded, err := config.ExpandPath(value); err == nil {
+					value = expanded
 				}
 				options.IdentityFile = value
 			}
@@ -86,15 +108,21 @@ func parseSSHConfig(configPath, hostname string) (*SSHConfigOptions, error) {
 			}
 		case "userknownhostsfile":
 			if options.KnownHostsFile == "" {
-				// Expand ~ to home directory
-				if strings.HasPrefix(value, "~/") {
-					currentUser, err := user.Current()
-					if err == nil {
-						value = filepath.Join(currentUser.HomeDir, value[2:])
-					}
+				// Expand ~, ~user and environment variables
+				if expanded, err := config.ExpandPath(value); err == nil {
+					value = expanded
 				}
 				options.KnownHostsFile = value
 			}
+		case "forcecommand":
+			if options.ForceCommand == "" {
+				// The command is the rest of the line, spaces included
+				options.ForceCommand = strings.TrimSpace(line[len(parts[0]):])
+			}
+		case "remotecommand":
+			if options.RemoteCommand == "" {
+				options.RemoteCommand = strings.TrimSpace(line[len(parts[0]):])
+			}
 		}
 	}
 
@@ -105,13 +133,94 @@ func parseSSHConfig(configPath, hostname string) (*SSHConfigOptions, error) {
 	return options, nil
 }
 
+// evaluateMatch reports whether every criterion of a Match line holds
+func evaluateMatch(args []string, match MatchContext) (bool, error) {
+	for i := 0; i < len(args); i++ {
+		criterion := strings.ToLower(args[i])
+
+		if criterion == "all" {
+			continue
+		}
+
+		if i+1 >= len(args) {
+			return false, fmt.Errorf("Match %s requires an argument", criterion)
+		}
+		i++
+		arg := args[i]
+
+		var matched bool
+		switch criterion {
+		case "host", "originalhost":
+			matched = matchPatternList(strings.Split(arg, ","), match.Host)
+		case "user":
+			matched = matchPatternList(strings.Split(arg, ","), match.User)
+		case "localuser":
+			matched = matchPatternList(strings.Split(arg, ","), match.LocalUser)
+		case "exec":
+			// Quoted commands may span several fields
+			command := arg
+			if strings.HasPrefix(command, "\"") {
+				for (len(command) < 2 || !strings.HasSuffix(command, "\"")) && i+1 < len(args) {
+					i++
+					command += " " + args[i]
+				}
+				command = strings.Trim(command, "\"")
+			}
+			matched = match.AllowExec && runMatchExec(command, match)
+		default:
+			return false, fmt.Errorf("unsupported Match criterion %q", criterion)
+		}
+
+		// Criteria are ANDed; stop evaluating (and never run exec) after a miss
+		if !matched {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// runMatchExec runs a "Match exec" command and reports whether it exited
+// successfully. Tokens are shell-quoted, so a host or user name can never be
+// run as part of the command (CVE-2023-51385 in OpenSSH).
+func runMatchExec(command string, match MatchContext) bool {
+	replacer := strings.NewReplacer(
+		"%h", security.QuoteShellArg(match.Host),
+		"%r", security.QuoteShellArg(match.User),
+		"%u", security.QuoteShellArg(match.LocalUser),
+		"%%", "%",
+	)
+	return exec.Command("sh", "-c", replacer.Replace(command)).Run() == nil
+}
+
+// matchPatternList reports whether value matches a list of ssh_config patterns.
+// Patterns support * and ? wildcards; a pattern prefixed with ! negates the
+// match and takes precedence over any positive match.
+func matchPatternList(patterns []string, value string) bool {
+	matched := false
+	for _, pattern := range patterns {
+		negate := strings.HasPrefix(pattern, "!")
+		pattern = strings.TrimPrefix(pattern, "!")
+		if ok, err := path.Match(strings.ToLower(pattern), strings.ToLower(value)); err == nil && ok {
+			if negate {
+				return false
+			}
+			matched = true
+		}
+	}
+	return matched
+}
+
 // ApplySSHConfigToConnection applies SSH config file options to connection parameters
-func ApplySSHConfigToConnection(configFile, hostname string, sshUser, sshKeyPath *string, insecureHostKey *bool) error {
+// Empty sshUser, sshKeyPath, knownHostsFile, forceCommand and remoteCommand
+// values are filled from the config; values set on the command line are left
+// untouched. A RemoteCommand of "none" is passed on for the caller to treat
+// as no command.
+func ApplySSHConfigToConnection(configFile string, match MatchContext, sshUser, sshKeyPath, knownHostsFile, forceCommand, remoteCommand *string, insecureHostKey *bool) error {
 	if configFile == "" {
 		return nil // No config file specified
 	}
 
-	options, err := parseSSHConfig(configFile, hostname)
+	options, err := parseSSHConfig(configFile, match)
 	if err != nil {
 		return err
 	}
@@ -125,6 +234,18 @@ func ApplySSHConfigToConnection(configFile, hostname string, sshUser, sshKeyPath
 		*sshKeyPath = options.IdentityFile
 	}
 
+	if *knownHostsFile == "" && options.KnownHostsFile != "" {
+		*knownHostsFile = options.KnownHostsFile
+	}
+
+	if *forceCommand == "" && options.ForceCommand != "" {
+		*forceCommand = options.ForceCommand
+	}
+
+	if *remoteCommand == "" && options.RemoteCommand != "" {
+		*remoteCommand = options.RemoteCommand
+	}
+
 	// Apply host key checking settings
 	if options.HostKeyChecking == "no" {
 		*insecureHostKey = true
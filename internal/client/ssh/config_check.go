@@ -0,0 +1,169 @@
+package ssh
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/derekg/ts-ssh/internal/config"
+	"github.com/derekg/ts-ssh/internal/security"
+)
+
+// ConfigProblem is one issue found by CheckSSHConfig. Line is 0 for problems
+// that concern the file as a whole.
+type ConfigProblem struct {
+	Line    int
+	Message string
+}
+
+func (p ConfigProblem) String() string {
+	if p.Line == 0 {
+		return p.Message
+	}
+	return fmt.Sprintf("line %d: %s", p.Line, p.Message)
+}
+
+// sshConfigKeywords lists the ssh_config(5) keywords. Anything else is
+// reported as unknown, which catches typos that OpenSSH would reject.
+var sshConfigKeywords = map[string]bool{
+	"addkeystoagent": true, "addressfamily": true, "batchmode": true,
+	"bindaddress": true, "bindinterface": true, "canonicaldomains": true,
+	"canonicalizefallbacklocal": true, "canonicalizehostname": true,
+	"canonicalizemaxdots": true, "canonicalizepermittedcnames": true,
+	"casignaturealgorithms": true, "certificatefile": true, "channeltimeout": true,
+	"checkhostip": true, "ciphers": true, "clearallforwardings": true,
+	"compression": true, "connectionattempts": true, "connecttimeout": true,
+	"controlmaster": true, "controlpath": true, "controlpersist": true,
+	"dynamicforward": true, "enableescapecommandline": true,
+	"enablesshkeysign": true, "escapechar": true, "exitonforwardfailure": true,
+	"fingerprinthash": true, "forcecommand": true, "forkafterauthentication": true,
+	"forwardagent": true, "forwardx11": true, "forwardx11timeout": true,
+	"forwardx11trusted": true, "gatewayports": true,
+	"globalknownhostsfile": true, "gssapiauthentication": true,
+	"gssapidelegatecredentials": true, "hashknownhosts": true, "host": true,
+	"hostbasedacceptedalgorithms": true, "hostbasedauthentication": true,
+	"hostkeyalgorithms": true, "hostkeyalias": true, "hostname": true,
+	"identitiesonly": true, "identityagent": true, "identityfile": true,
+	"ignoreunknown": true, "include": true, "ipqos": true,
+	"kbdinteractiveauthentication": true, "kbdinteractivedevices": true,
+	"kexalgorithms": true, "knownhostscommand": true, "localcommand": true,
+	"localforward": true, "loglevel": true, "logverbose": true, "macs": true,
+	"match": true, "nohostauthenticationforlocalhost": true,
+	"numberofpasswordprompts": true, "obscurekeystroketiming": true,
+	"passwordauthentication": true, "permitlocalcommand": true,
+	"permitremoteopen": true, "pkcs11provider": true, "port": true,
+	"preferredauthentications": true, "proxycommand": true, "proxyjump": true,
+	"proxyusefdpass": true, "pubkeyacceptedalgorithms": true,
+	"pubkeyauthentication": true, "rekeylimit": true, "remotecommand": true,
+	"remoteforward": true, "requesttty": true, "requiredrsasize": true,
+	"revokedhostkeys": true, "securitykeyprovider": true, "sendenv": true,
+	"serveralivecountmax": true, "serveraliveinterval": true, "sessiontype": true,
+	"setenv": true, "stdinnull": true, "streamlocalbindmask": true,
+	"streamlocalbindunlink": true, "stricthostkeychecking": true,
+	"syslogfacility": true, "tag": true, "tcpkeepalive": true, "tunnel": true,
+	"tunneldevice": true, "updatehostkeys": true, "user": true,
+	"userknownhostsfile": true, "verifyhostkeydns": true, "visualhostkey": true,
+	"xauthlocation": true,
+}
+
+// CheckSSHConfig validates an SSH config file without connecting anywhere,
+// in the spirit of "sshd -t". It reports syntax errors, unknown keywords,
+// Match criteria ts-ssh cannot evaluate, invalid values, IdentityFiles that
+// cannot be read or are readable by others, and a config file writable by
+// group or others. The error is non-nil only when the file cannot be read.
+func CheckSSHConfig(configPath string) ([]ConfigProblem, error) {
+	file, err := os.Open(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open SSH config file %s: %w", configPath, err)
+	}
+	defer file.Close()
+
+	var problems []ConfigProblem
+	if info, err := file.Stat(); err == nil && info.Mode().Perm()&0022 != 0 {
+		problems = append(problems, ConfigProblem{Message: fmt.Sprintf("insecure permissions %o: config file is writable by group or others", info.Mode().Perm())})
+	}
+
+	scanner := bufio.NewScanner(file)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.Fields(line)
+		key := strings.ToLower(parts[0])
+		report := func(format string, args ...interface{}) {
+			problems = append(problems, ConfigProblem{Line: lineNum, Message: fmt.Sprintf(format, args...)})
+		}
+
+		if !sshConfigKeywords[key] {
+			report("unknown directive %q", parts[0])
+			continue
+		}
+		if len(parts) < 2 {
+			report("%s requires an argument", parts[0])
+			continue
+		}
+		value := parts[1]
+
+		switch key {
+		case "match":
+			// Evaluate against an empty context only to surface syntax errors
+			if _, err := evaluateMatch(parts[1:], MatchContext{}); err != nil {
+				report("%v", err)
+			}
+		case "user":
+			if err := security.ValidateSSHUser(value); err != nil {
+				report("invalid User %q: %v", value, err)
+			}
+		case "stricthostkeychecking":
+			switch strings.ToLower(value) {
+			case "yes", "no", "ask", "accept-new", "off":
+			default:
+				report("invalid StrictHostKeyChecking value %q", value)
+			}
+		case "identityfile":
+			if problem := checkIdentityFile(value); problem != "" {
+				report("%s", problem)
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading SSH config file: %w", err)
+	}
+	return problems, nil
+}
+
+// checkIdentityFile describes why an IdentityFile is unusable, or returns ""
+func checkIdentityFile(keyPath string) string {
+	// Tokens such as %d or %u are expanded by OpenSSH at connect time
+	if strings.Contains(keyPath, "%") {
+		return ""
+	}
+	keyPath, err := config.ExpandPath(keyPath)
+	if err != nil {
+		return fmt.Sprintf("IdentityFile %v", err)
+	}
+
+	file, err := os.Open(keyPath)
+	if err != nil {
+		return fmt.Sprintf("IdentityFile %s is not readable: %v", keyPath, err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Sprintf("IdentityFile %s is not readable: %v", keyPath, err)
+	}
+	if info.IsDir() {
+		return fmt.Sprintf("IdentityFile %s is a directory", keyPath)
+	}
+	if info.Mode().Perm()&0044 != 0 {
+		return fmt.Sprintf("IdentityFile %s has insecure permissions %o (readable by group or others)", keyPath, info.Mode().Perm())
+	}
+	return ""
+}
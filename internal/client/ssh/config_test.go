@@ -0,0 +1,288 @@
+package ssh
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTestSSHConfig(t *testing.T, content string) string {
+	t.Helper()
+	configPath := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(configPath, []byte(content), 0600); err != nil {
+		t.Fatalf("Failed to write SSH config: %v", err)
+	}
+	return configPath
+}
+
+func TestParseSSHConfigMatchBlocks(t *testing.T) {
+	configPath := writeTestSSHConfig(t, `
+IdentityFile /keys/global
+
+Match host *.internal
+    User ops
+    StrictHostKeyChecking no
+
+Match user deploy
+    IdentityFile /keys/deploy
+
+Host *
+    User fallback
+    IdentityFile /keys/fallback
+`)
+
+	tests := []struct {
+		name         string
+		match        MatchContext
+		wantUser     string
+		wantIdentity string
+		wantChecking string
+	}{
+		{
+			name:         "match host",
+			match:        MatchContext{Host: "db.internal", User: "alice"},
+			wantUser:     "ops",
+			wantIdentity: "/keys/global",
+			wantChecking: "no",
+		},
+		{
+			name:         "match user",
+			match:        MatchContext{Host: "web", User: "deploy"},
+			wantUser:     "fallback",
+			wantIdentity: "/keys/global",
+		},
+		{
+			name:         "no match falls through to Host *",
+			match:        MatchContext{Host: "web", User: "alice"},
+			wantUser:     "fallback",
+			wantIdentity: "/keys/global",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options, err := parseSSHConfig(configPath, tt.match)
+			if err != nil {
+				t.Fatalf("parseSSHConfig() error = %v", err)
+			}
+			if options.User != tt.wantUser {
+				t.Errorf("User = %q, want %q", options.User, tt.wantUser)
+			}
+			if options.IdentityFile != tt.wantIdentity {
+				t.Errorf("IdentityFile = %q, want %q", options.IdentityFile, tt.wantIdentity)
+			}
+			if options.HostKeyChecking != tt.wantChecking {
+				t.Errorf("HostKeyChecking = %q, want %q", options.HostKeyChecking, tt.wantChecking)
+			}
+		})
+	}
+}
+
+func TestParseSSHConfigFirstMatchWins(t *testing.T) {
+	configPath := writeTestSSHConfig(t, `
+Host web !web-admin
+    User first
+
+Match user deploy host web*
+    User second
+    IdentityFile /keys/deploy
+`)
+
+	options, err := parseSSHConfig(configPath, MatchContext{Host: "web", User: "deploy"})
+	if err != nil {
+		t.Fatalf("parseSSHConfig() error = %v", err)
+	}
+	if options.User != "first" {
+		t.Errorf("User = %q, want first value %q", options.User, "first")
+	}
+	if options.IdentityFile != "/keys/deploy" {
+		t.Errorf("IdentityFile = %q, want %q", options.IdentityFile, "/keys/deploy")
+	}
+
+	// Negated patterns exclude a host even when another pattern matches
+	options, err = parseSSHConfig(configPath, MatchContext{Host: "web-admin", User: "alice"})
+	if err != nil {
+		t.Fatalf("parseSSHConfig() error = %v", err)
+	}
+	if options.User != "" {
+		t.Errorf("User = %q, want empty for negated host", options.User)
+	}
+}
+
+func TestParseSSHConfigForceCommand(t *testing.T) {
+	configPath := writeTestSSHConfig(t, `
+ForceCommand /usr/local/bin/report --host %h
+ForceCommand ignored
+`)
+
+	options, err := parseSSHConfig(configPath, MatchContext{Host: "web"})
+	if err != nil {
+		t.Fatalf("parseSSHConfig() error = %v", err)
+	}
+	if want := "/usr/local/bin/report --host %h"; options.ForceCommand != want {
+		t.Errorf("ForceCommand = %q, want %q", options.ForceCommand, want)
+	}
+
+	// The config fills the forced command only when none was given
+	forced := "uptime"
+	var sshUser, keyPath, knownHostsFile, remoteCommand string
+	var insecure bool
+	if err := ApplySSHConfigToConnection(configPath, MatchContext{Host: "web"}, &sshUser, &keyPath, &knownHostsFile, &forced, &remoteCommand, &insecure); err != nil {
+		t.Fatalf("ApplySSHConfigToConnection() error = %v", err)
+	}
+	if forced != "uptime" {
+		t.Errorf("forced command = %q, want the one given on the command line", forced)
+	}
+}
+
+func TestParseSSHConfigRemoteCommand(t *testing.T) {
+	configPath := writeTestSSHConfig(t, `
+Host dev
+    RemoteCommand tmux new -A -s main || exec bash -l
+Host *
+    RemoteCommand none
+`)
+
+	options, err := parseSSHConfig(configPath, MatchContext{Host: "dev"})
+	if err != nil {
+		t.Fatalf("parseSSHConfig() error = %v", err)
+	}
+	if want := "tmux new -A -s main || exec bash -l"; options.RemoteCommand != want {
+		t.Errorf("RemoteCommand = %q, want %q", options.RemoteCommand, want)
+	}
+
+	var sshUser, keyPath, knownHostsFile, forced, remoteCommand string
+	var insecure bool
+	if err := ApplySSHConfigToConnection(configPath, MatchContext{Host: "web"}, &sshUser, &keyPath, &knownHostsFile, &forced, &remoteCommand, &insecure); err != nil {
+		t.Fatalf("ApplySSHConfigToConnection() error = %v", err)
+	}
+	if remoteCommand != "none" {
+		t.Errorf("remote command = %q, want %q", remoteCommand, "none")
+	}
+
+	// A command given with -remote-command wins over the config
+	remoteCommand = "htop"
+	if err := ApplySSHConfigToConnection(configPath, MatchContext{Host: "dev"}, &sshUser, &keyPath, &knownHostsFile, &forced, &remoteCommand, &insecure); err != nil {
+		t.Fatalf("ApplySSHConfigToConnection() error = %v", err)
+	}
+	if remoteCommand != "htop" {
+		t.Errorf("remote command = %q, want the one given on the command line", remoteCommand)
+	}
+}
+
+func TestParseSSHConfigMatchExec(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "exec-ran")
+	configPath := writeTestSSHConfig(t, `
+Match exec "touch `+marker+`"
+    User fromexec
+`)
+
+	options, err := parseSSHConfig(configPath, MatchContext{Host: "web"})
+	if err != nil {
+		t.Fatalf("parseSSHConfig() error = %v", err)
+	}
+	if options.User != "" {
+		t.Errorf("Match exec should not match without AllowExec, got User %q", options.User)
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Fatal("Match exec command ran without AllowExec")
+	}
+
+	options, err = parseSSHConfig(configPath, MatchContext{Host: "web", AllowExec: true})
+	if err != nil {
+		t.Fatalf("parseSSHConfig() error = %v", err)
+	}
+	if options.User != "fromexec" {
+		t.Errorf("User = %q, want %q with AllowExec", options.User, "fromexec")
+	}
+	if _, err := os.Stat(marker); err != nil {
+		t.Errorf("Match exec command did not run with AllowExec: %v", err)
+	}
+}
+
+func TestMatchExecQuotesTokens(t *testing.T) {
+	dir := t.TempDir()
+	injected := filepath.Join(dir, "injected")
+	seen := filepath.Join(dir, "seen")
+	configPath := writeTestSSHConfig(t, `
+Match exec "printf %s %h > `+seen+`"
+    User fromexec
+`)
+
+	host := "x$(touch " + injected + ")"
+	if _, err := parseSSHConfig(configPath, MatchContext{Host: host, AllowExec: true}); err != nil {
+		t.Fatalf("parseSSHConfig() error = %v", err)
+	}
+	if _, err := os.Stat(injected); err == nil {
+		t.Fatal("a command in the host name ran")
+	}
+	got, err := os.ReadFile(seen)
+	if err != nil {
+		t.Fatalf("Match exec command did not run: %v", err)
+	}
+	if string(got) != host {
+		t.Errorf("%%h = %q, want the host name as one word", got)
+	}
+}
+
+func TestParseSSHConfigUnsupportedMatch(t *testing.T) {
+	configPath := writeTestSSHConfig(t, "Match canonical\n    User ops\n")
+	if _, err := parseSSHConfig(configPath, MatchContext{Host: "web"}); err == nil {
+		t.Error("parseSSHConfig() should reject unsupported Match criteria")
+	}
+}
+
+func TestCheckSSHConfig(t *testing.T) {
+	keyDir := t.TempDir()
+	goodKey := filepath.Join(keyDir, "id_ed25519")
+	looseKey := filepath.Join(keyDir, "id_loose")
+	if err := os.WriteFile(goodKey, []byte("key"), 0600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+	if err := os.WriteFile(looseKey, []byte("key"), 0644); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+
+	configPath := writeTestSSHConfig(t, `
+Host web
+    HostName web.internal
+    IdentityFile `+goodKey+`
+    Usr ops
+
+Host db
+    IdentityFile `+looseKey+`
+    IdentityFile `+filepath.Join(keyDir, "missing")+`
+    StrictHostKeyChecking maybe
+
+Match tagged prod
+Port
+`)
+
+	problems, err := CheckSSHConfig(configPath)
+	if err != nil {
+		t.Fatalf("CheckSSHConfig() error = %v", err)
+	}
+
+	want := map[int]string{
+		5:  `unknown directive "Usr"`,
+		8:  "insecure permissions",
+		9:  "is not readable",
+		10: "invalid StrictHostKeyChecking",
+		12: "unsupported Match criterion",
+		13: "Port requires an argument",
+	}
+	if len(problems) != len(want) {
+		t.Errorf("CheckSSHConfig() returned %d problems, want %d: %v", len(problems), len(want), problems)
+	}
+	for _, problem := range problems {
+		if !strings.Contains(problem.Message, want[problem.Line]) || want[problem.Line] == "" {
+			t.Errorf("unexpected problem %s", problem)
+		}
+	}
+
+	clean := writeTestSSHConfig(t, "Host *\n    User ops\n    IdentityFile "+goodKey+"\n")
+	if problems, err := CheckSSHConfig(clean); err != nil || len(problems) != 0 {
+		t.Errorf("CheckSSHConfig() on a clean config = %v, %v", problems, err)
+	}
+}
@@ -0,0 +1,44 @@
+//go:build !windows
+// +build !windows
+
+package ssh
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"golang.org/x/term"
+)
+
+// enableVirtualTerminal is only needed on Windows consoles; Unix terminals
+// always interpret escape sequences
+func enableVirtualTerminal() (restore func() error, err error) {
+	return func() error { return nil }, nil
+}
+
+// TerminalSize returns the size of the terminal on fd
+func TerminalSize(fd int) (width, height int, err error) {
+	return term.GetSize(fd)
+}
+
+// watchWindowChanges receives on the returned channel when the terminal is
+// resized, which Unix reports with SIGWINCH. Changes that arrive while one is
+// pending are coalesced. The returned function stops watching.
+func watchWindowChanges() (<-chan struct{}, func()) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGWINCH)
+	resized := make(chan struct{}, 1)
+	go func() {
+		for range sigCh {
+			select {
+			case resized <- struct{}{}:
+			default: // A change is already pending
+			}
+		}
+	}()
+	return resized, func() {
+		signal.Stop(sigCh)
+		close(sigCh)
+	}
+}
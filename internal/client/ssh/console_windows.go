@@ -0,0 +1,62 @@
+//go:build windows
+// +build windows
+
+package ssh
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/term"
+)
+
+// vtOutputMode returns console output mode with escape sequence processing
+// turned on, as Windows Terminal and ConPTY expect for a remote shell's
+// output. DISABLE_NEWLINE_AUTO_RETURN leaves line feeds alone, since the
+// remote pty already sends \r\n.
+func vtOutputMode(mode uint32) uint32 {
+	return mode | windows.ENABLE_PROCESSED_OUTPUT | windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING | windows.DISABLE_NEWLINE_AUTO_RETURN
+}
+
+// enableVirtualTerminal turns on escape sequence processing for the console
+// on stdout and returns a function restoring its previous mode. term.MakeRaw
+// already enables VT input; without VT output colors and cursor movement
+// would show up as raw text. When stdout is not a console it does nothing.
+func enableVirtualTerminal() (restore func() error, err error) {
+	handle := windows.Handle(os.Stdout.Fd())
+	var mode uint32
+	if err := windows.GetConsoleMode(handle, &mode); err != nil {
+		return func() error { return nil }, nil
+	}
+	if err := windows.SetConsoleMode(handle, vtOutputMode(mode)); err != nil {
+		// Consoles before Windows 10 1809 reject DISABLE_NEWLINE_AUTO_RETURN
+		if err := windows.SetConsoleMode(handle, vtOutputMode(mode)&^windows.DISABLE_NEWLINE_AUTO_RETURN); err != nil {
+			return nil, fmt.Errorf("console does not support virtual terminal sequences: %w", err)
+		}
+	}
+	return func() error { return windows.SetConsoleMode(handle, mode) }, nil
+}
+
+// TerminalSize returns the size of the console window. Only an output handle
+// has a screen buffer to measure, so when fd is the console's input, stdout
+// is asked instead.
+func TerminalSize(fd int) (width, height int, err error) {
+	if width, height, err = term.GetSize(int(os.Stdout.Fd())); err == nil {
+		return width, height, nil
+	}
+	return term.GetSize(fd)
+}
+
+// watchWindowChanges receives on the returned channel when the console window
+// is resized. Windows has no SIGWINCH, and its resize events are delivered
+// through console input that the session is already reading as a byte
+// stream, so the size is polled instead. The returned function stops
+// watching.
+func watchWindowChanges() (<-chan struct{}, func()) {
+	resized := make(chan struct{}, 1)
+	stop := make(chan struct{})
+	size := func() (int, int, error) { return TerminalSize(int(os.Stdin.Fd())) }
+	go pollWindowSize(stop, size, consoleResizePollInterval, resized)
+	return resized, func() { close(stop) }
+}
@@ -0,0 +1,57 @@
+//go:build windows
+// +build windows
+
+package ssh
+
+import (
+	"testing"
+
+	"golang.org/x/sys/windows"
+)
+
+func TestVTOutputMode(t *testing.T) {
+	base := uint32(windows.ENABLE_WRAP_AT_EOL_OUTPUT)
+	mode := vtOutputMode(base)
+	for _, flag := range []uint32{windows.ENABLE_PROCESSED_OUTPUT, windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING, windows.DISABLE_NEWLINE_AUTO_RETURN} {
+		if mode&flag == 0 {
+			t.Errorf("vtOutputMode(%#x) = %#x, missing %#x", base, mode, flag)
+		}
+	}
+	if mode&base != base {
+		t.Errorf("vtOutputMode(%#x) = %#x, dropped existing flags", base, mode)
+	}
+	if vtOutputMode(mode) != mode {
+		t.Error("vtOutputMode() is not idempotent")
+	}
+}
+
+func TestEnableVirtualTerminal(t *testing.T) {
+	// Under go test stdout is usually a pipe; then nothing changes. On a
+	// console the previous mode must come back.
+	var before uint32
+	isConsole := windows.GetConsoleMode(windows.Stdout, &before) == nil
+
+	restore, err := enableVirtualTerminal()
+	if err != nil {
+		t.Skipf("console without VT support: %v", err)
+	}
+	if isConsole {
+		var mode uint32
+		if err := windows.GetConsoleMode(windows.Stdout, &mode); err != nil {
+			t.Fatal(err)
+		}
+		if mode&windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING == 0 {
+			t.Errorf("console mode = %#x, want VT processing on", mode)
+		}
+	}
+	if err := restore(); err != nil {
+		t.Errorf("restore() error = %v", err)
+	}
+	if isConsole {
+		var after uint32
+		windows.GetConsoleMode(windows.Stdout, &after)
+		if after != before {
+			t.Errorf("console mode after restore = %#x, want %#x", after, before)
+		}
+	}
+}
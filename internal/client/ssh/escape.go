@@ -0,0 +1,98 @@
+package ssh
+
+import "io"
+
+// escapeHelp is printed for "~?"; the terminal is in raw mode, hence \r\n
+const escapeHelp = "~?\r\n" +
+	"Supported escape sequences:\r\n" +
+	" ~.   - terminate connection\r\n" +
+	" ~?   - this message\r\n" +
+	" ~~   - send the escape character by typing it twice\r\n" +
+	"(Note that escapes are only recognized immediately after newline.)\r\n"
+
+// EscapeReader passes interactive input through while watching for OpenSSH's
+// escape sequences at the start of a line: "~." calls onTerminate and ends the
+// input, "~?" writes a list of escapes to out, and "~~" sends a single "~". A
+// "~" followed by anything else is sent unchanged.
+type EscapeReader struct {
+	r           io.Reader
+	out         io.Writer
+	onTerminate func()
+	lineStart   bool
+	tilde       bool   // A line-start "~" is waiting for the next byte
+	carry       []byte // Output that didn't fit in the last Read
+	done        bool
+}
+
+// NewEscapeReader returns an EscapeReader over r. Input starts at the
+// beginning of a line, so "~." works as the very first thing typed. A nil
+// out discards the "~?" help.
+func NewEscapeReader(r io.Reader, out io.Writer, onTerminate func()) *EscapeReader {
+	if out == nil {
+		out = io.Discard
+	}
+	return &EscapeReader{r: r, out: out, onTerminate: onTerminate, lineStart: true}
+}
+
+func (e *EscapeReader) Read(p []byte) (int, error) {
+	if len(e.carry) > 0 {
+		n := copy(p, e.carry)
+		e.carry = e.carry[n:]
+		return n, nil
+	}
+	if e.done {
+		return 0, io.EOF
+	}
+
+	buf := make([]byte, len(p))
+	n, err := e.r.Read(buf)
+	out := make([]byte, 0, n+1)
+	for _, b := range buf[:n] {
+		if e.tilde {
+			e.tilde = false
+			switch b {
+			case '.':
+				e.done = true
+				e.onTerminate()
+				return e.flush(p, out), io.EOF
+			case '~':
+				out = append(out, '~')
+				e.lineStart = false
+				continue
+			case '?':
+				io.WriteString(e.out, escapeHelp)
+				e.lineStart = true
+				continue
+			default:
+				out = append(out, '~')
+			}
+		} else if e.lineStart && b == '~' {
+			e.tilde = true
+			e.lineStart = false
+			continue
+		}
+		out = append(out, b)
+		e.lineStart = b == '\r' || b == '\n'
+	}
+	if err != nil && e.tilde {
+		// Input ended right after a "~"; it was meant literally
+		e.tilde = false
+		out = append(out, '~')
+	}
+	if err == io.EOF {
+		e.done = true
+	}
+
+	m := e.flush(p, out)
+	if len(e.carry) > 0 {
+		return m, nil // Report EOF once the carried bytes are read
+	}
+	return m, err
+}
+
+// flush copies out into p and keeps whatever doesn't fit for the next Read
+func (e *EscapeReader) flush(p, out []byte) int {
+	n := copy(p, out)
+	e.carry = append(e.carry, out[n:]...)
+	return n
+}
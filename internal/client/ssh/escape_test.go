@@ -0,0 +1,64 @@
+package ssh
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestEscapeReader(t *testing.T) {
+	tests := []struct {
+		name       string
+		input      string
+		want       string
+		terminated bool
+	}{
+		{name: "plain input", input: "ls -l\n", want: "ls -l\n"},
+		{name: "terminate at start", input: "~.ignored", want: "", terminated: true},
+		{name: "terminate after newline", input: "ls\n~.exit\n", want: "ls\n", terminated: true},
+		{name: "terminate after carriage return", input: "ls\r~.", want: "ls\r", terminated: true},
+		{name: "tilde mid-line", input: "cd ~.\n", want: "cd ~.\n"},
+		{name: "literal tilde", input: "~~.\n", want: "~.\n"},
+		{name: "other escape passes through", input: "~/bin\n", want: "~/bin\n"},
+		{name: "trailing tilde", input: "ls\n~", want: "ls\n~"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			terminated := false
+			// One byte at a time, so escapes split across reads are covered
+			r := NewEscapeReader(iotest.OneByteReader(strings.NewReader(tt.input)), nil, func() { terminated = true })
+			got, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatalf("ReadAll() error = %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("EscapeReader output = %q, want %q", got, tt.want)
+			}
+			if terminated != tt.terminated {
+				t.Errorf("terminated = %v, want %v", terminated, tt.terminated)
+			}
+		})
+	}
+}
+
+func TestEscapeReaderSmallBuffer(t *testing.T) {
+	// A "~" held back from one read must not overflow a one-byte buffer in the next
+	r := NewEscapeReader(iotest.OneByteReader(strings.NewReader("~x~~y")), nil, func() {})
+	var got []byte
+	buf := make([]byte, 1)
+	for {
+		n, err := r.Read(buf)
+		got = append(got, buf[:n]...)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Read() error = %v", err)
+		}
+	}
+	if string(got) != "~x~~y" {
+		t.Errorf("EscapeReader output = %q, want %q", got, "~x~~y")
+	}
+}
@@ -0,0 +1,101 @@
+package ssh
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Randomart field size, as in OpenSSH's sshkey.c
+const (
+	randomartWidth  = 17
+	randomartHeight = 9
+)
+
+// randomartSymbols are drawn for 0..14 visits, then the start and end marks
+const randomartSymbols = " .o+=*BOX@%&#/^SE"
+
+// KeyDescription returns OpenSSH's short name and size for key, such as
+// "ED25519" and 256, or the wire type and 0 for types it doesn't size.
+func KeyDescription(key ssh.PublicKey) (string, int) {
+	cryptoKey, ok := key.(ssh.CryptoPublicKey)
+	if !ok {
+		return key.Type(), 0
+	}
+	switch k := cryptoKey.CryptoPublicKey().(type) {
+	case ed25519.PublicKey:
+		return "ED25519", 256
+	case *rsa.PublicKey:
+		return "RSA", k.N.BitLen()
+	case *ecdsa.PublicKey:
+		return "ECDSA", k.Curve.Params().BitSize
+	}
+	return key.Type(), 0
+}
+
+// Randomart draws the SHA256 fingerprint of key as OpenSSH's "drunken
+// bishop" picture, the one ssh-keygen -lv and VisualHostKey print.
+func Randomart(key ssh.PublicKey) string {
+	digest := sha256.Sum256(key.Marshal())
+
+	var field [randomartWidth][randomartHeight]int
+	last := len(randomartSymbols) - 1
+	startX, startY := randomartWidth/2, randomartHeight/2
+	x, y := startX, startY
+	for _, b := range digest {
+		// Each byte is four moves, low bits first: bit 0 is x, bit 1 is y
+		for i := 0; i < 4; i++ {
+			if b&1 != 0 {
+				x++
+			} else {
+				x--
+			}
+			if b&2 != 0 {
+				y++
+			} else {
+				y--
+			}
+			x = min(max(x, 0), randomartWidth-1)
+			y = min(max(y, 0), randomartHeight-1)
+			if field[x][y] < last-2 {
+				field[x][y]++
+			}
+			b >>= 2
+		}
+	}
+	field[startX][startY] = last - 1
+	field[x][y] = last
+
+	name, size := KeyDescription(key)
+	title := fmt.Sprintf("[%s %d]", name, size)
+	if size == 0 || len(title) > randomartWidth-2 {
+		title = fmt.Sprintf("[%s]", name)
+	}
+	if len(title) > randomartWidth {
+		title = title[:randomartWidth]
+	}
+
+	var b strings.Builder
+	b.WriteString(randomartBorder(title))
+	for y := 0; y < randomartHeight; y++ {
+		b.WriteByte('|')
+		for x := 0; x < randomartWidth; x++ {
+			b.WriteByte(randomartSymbols[min(field[x][y], last)])
+		}
+		b.WriteString("|\n")
+	}
+	b.WriteString(strings.TrimSuffix(randomartBorder("[SHA256]"), "\n"))
+	return b.String()
+}
+
+// randomartBorder returns a border line with label centred in it
+func randomartBorder(label string) string {
+	left := (randomartWidth - len(label)) / 2
+	right := randomartWidth - left - len(label)
+	return "+" + strings.Repeat("-", left) + label + strings.Repeat("-", right) + "+\n"
+}
@@ -0,0 +1,40 @@
+package ssh
+
+import (
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// fingerprintTestKey was generated with ssh-keygen -t ed25519; the expected
+// values are ssh-keygen -lv output for it.
+const fingerprintTestKey = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIGujtRWTPBSC1gH7lpu/gem1grLkA1MYAV9Xi6u0Z2NO"
+
+func TestRandomartMatchesOpenSSH(t *testing.T) {
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(fingerprintTestKey))
+	if err != nil {
+		t.Fatalf("ParseAuthorizedKey() error = %v", err)
+	}
+
+	if got, want := ssh.FingerprintSHA256(key), "SHA256:DZ6wEEYjK1EkMw24tfGzO+jBuzt1A0SZi1lBfwsD49k"; got != want {
+		t.Errorf("FingerprintSHA256() = %s, want %s", got, want)
+	}
+	if name, size := KeyDescription(key); name != "ED25519" || size != 256 {
+		t.Errorf("KeyDescription() = %s %d, want ED25519 256", name, size)
+	}
+
+	want := "+--[ED25519 256]--+\n" +
+		"|B=++@+           |\n" +
+		"|.=+==O           |\n" +
+		"|.o.O+.E o        |\n" +
+		"|..+ =. B =       |\n" +
+		"|     +. S .      |\n" +
+		"| .  o o          |\n" +
+		"|  oo o .         |\n" +
+		"|  ooo            |\n" +
+		"| .=+ .           |\n" +
+		"+----[SHA256]-----+"
+	if got := Randomart(key); got != want {
+		t.Errorf("Randomart() =\n%s\nwant\n%s", got, want)
+	}
+}
@@ -2,17 +2,21 @@ package ssh
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"os/user"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/crypto/ssh"
-	"tailscale.com/tsnet"
 
 	"github.com/derekg/ts-ssh/internal/config"
 	"github.com/derekg/ts-ssh/internal/crypto/pqc"
+	tserrors "github.com/derekg/ts-ssh/internal/errors"
 )
 
 // Constants needed by SSH package
@@ -29,12 +33,20 @@ var (
 // Timeout constants
 const (
 	DefaultSSHTimeout = 15 * time.Second
+	// How long a connected server has to send its side of the key exchange
+	DefaultHandshakeTimeout = 10 * time.Second
 )
 
+// Dialer opens network connections to SSH hosts. *tsnet.Server satisfies it.
+type Dialer interface {
+	Dial(ctx context.Context, network, address string) (net.Conn, error)
+}
+
 // SSHConnectionConfig holds all the parameters needed for SSH connection setup
 type SSHConnectionConfig struct {
 	User            string
 	KeyPath         string
+	Identity        ssh.Signer // Private key loaded elsewhere; replaces KeyPath
 	TargetHost      string
 	TargetPort      string
 	InsecureHostKey bool
@@ -42,6 +54,32 @@ type SSHConnectionConfig struct {
 	CurrentUser     *user.User
 	Logger          *log.Logger
 	PQCConfig       *pqc.Config // Post-quantum cryptography configuration
+	IdentitiesOnly  bool        // Only offer KeyPath, skip automatic key discovery
+	AuthThrottle    *AuthThrottle
+	// UserKnownHostsFile replaces ~/.ssh/known_hosts; GlobalKnownHostsFile is always consulted
+	UserKnownHostsFile string
+	ReadOnlyKnownHosts bool // Accepted host keys are never written to known_hosts
+	PasswordCache      *PasswordCache
+	// AcceptChangedFor lists hosts whose changed host key is accepted and
+	// replaces the old known_hosts entry instead of failing the connection
+	AcceptChangedFor []string
+	// DialAddress, when set, is dialled instead of TargetHost (e.g. to force
+	// an address family); host keys are still checked against TargetHost
+	DialAddress string
+	// HostKeyAlias, when set, is the name host keys are checked and recorded
+	// under in known_hosts instead of TargetHost, like ssh's HostKeyAlias
+	HostKeyAlias string
+	// NoKeyboardInteractive disables keyboard-interactive (OTP) authentication
+	NoKeyboardInteractive bool
+	// Banner receives the server's pre-authentication banner; nil discards it
+	Banner io.Writer
+	// HandshakeTimeout bounds the wait, once connected, for the server to get
+	// as far as presenting its host key; 0 uses DefaultHandshakeTimeout. A
+	// deadline on the context shortens it. Host key and password prompts
+	// come later and are not limited.
+	HandshakeTimeout time.Duration
+	// HandshakeRetries is how many times to redial after a handshake timeout
+	HandshakeRetries int
 }
 
 // createSSHAuthMethods creates authentication methods for SSH connection.
@@ -50,12 +88,17 @@ type SSHConnectionConfig struct {
 //
 // Parameters:
 //   - keyPath: path to SSH private key file (optional, if empty uses auto-discovery)
+//   - identity: already loaded private key, used instead of keyPath (may be nil)
 //   - sshUser: username for SSH connection
 //   - targetHost: hostname for password prompts
+//   - identitiesOnly: only offer keyPath, never discovered keys
+//   - keyboardInteractive: answer keyboard-interactive (OTP) challenges
+//   - slot: paces attempts and caps concurrent authentication (may be nil)
+//   - passwords: optional cache of a password entered for an earlier host (may be nil)
 //   - logger: logger instance for debug output
 //
 // Returns a slice of ssh.AuthMethod and any error that occurred.
-func createSSHAuthMethods(keyPath, sshUser, targetHost string, logger *log.Logger) ([]ssh.AuthMethod, error) {
+func createSSHAuthMethods(keyPath string, identity ssh.Signer, sshUser, targetHost string, identitiesOnly, keyboardInteractive bool, slot *authSlot, passwords *PasswordCache, logger *log.Logger) ([]ssh.AuthMethod, error) {
 	// Get current user for key discovery
 	currentUser, err := user.Current()
 	if err != nil && logger != nil {
@@ -63,7 +106,7 @@ func createSSHAuthMethods(keyPath, sshUser, targetHost string, logger *log.Logge
 	}
 
 	// Use the modern key discovery system
-	return createModernSSHAuthMethods(keyPath, sshUser, targetHost, currentUser, logger)
+	return createModernSSHAuthMethods(keyPath, identity, sshUser, targetHost, currentUser, identitiesOnly, keyboardInteractive, slot, passwords, logger)
 }
 
 // createSSHConfig creates an SSH client configuration from the provided parameters.
@@ -75,10 +118,11 @@ func createSSHAuthMethods(keyPath, sshUser, targetHost string, logger *log.Logge
 //   - Host key verification (secure or insecure mode)
 //   - Connection timeout settings
 //
+// The auth methods pace themselves through slot, which may be nil.
 // Returns a configured ssh.ClientConfig ready for connection establishment.
-func createSSHConfig(config SSHConnectionConfig) (*ssh.ClientConfig, error) {
+func createSSHConfig(config SSHConnectionConfig, slot *authSlot) (*ssh.ClientConfig, error) {
 	// Create authentication methods
-	authMethods, err := createSSHAuthMethods(config.KeyPath, config.User, config.TargetHost, config.Logger)
+	authMethods, err := createSSHAuthMethods(config.KeyPath, config.Identity, config.User, config.TargetHost, config.IdentitiesOnly, !config.NoKeyboardInteractive, slot, config.PasswordCache, config.Logger)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create auth methods: %w", err)
 	}
@@ -92,10 +136,11 @@ func createSSHConfig(config SSHConnectionConfig) (*ssh.ClientConfig, error) {
 		hostKeyCallback = ssh.InsecureIgnoreHostKey()
 	} else {
 		var err error
-		hostKeyCallback, err = CreateKnownHostsCallback(config.CurrentUser, config.Logger)
+		hostKeyCallback, err = CreateKnownHostsCallbackFiles(config.CurrentUser, config.UserKnownHostsFile, []string{GlobalKnownHostsFile}, config.ReadOnlyKnownHosts, config.AcceptChangedFor, config.Logger)
 		if err != nil {
 			return nil, fmt.Errorf("could not set up host key verification: %w", err)
 		}
+		hostKeyCallback = markHostKeyErrors(hostKeyCallback)
 	}
 
 	sshConfig := &ssh.ClientConfig{
@@ -117,6 +162,10 @@ func createSSHConfig(config SSHConnectionConfig) (*ssh.ClientConfig, error) {
 		},
 	}
 
+	if config.Banner != nil {
+		sshConfig.BannerCallback = BannerCallback(config.Banner)
+	}
+
 	// Apply PQC configuration if provided
 	if config.PQCConfig != nil {
 		pqc.ConfigureSSHConfig(sshConfig, config.PQCConfig)
@@ -128,20 +177,23 @@ func createSSHConfig(config SSHConnectionConfig) (*ssh.ClientConfig, error) {
 	return sshConfig, nil
 }
 
-// establishSSHConnection creates a complete SSH connection using tsnet.
+// EstablishSSHConnection creates a complete SSH connection using tsnet.
 // This function consolidates the connection establishment pattern used across
 // multiple files, providing a standardized way to connect to SSH hosts via Tailscale.
 //
 // The connection process includes:
 //  1. Creating SSH client configuration
 //  2. Establishing TCP connection via tsnet
-//  3. Performing SSH handshake
+//  3. Performing SSH handshake, redialing up to HandshakeRetries times if
+//     the server never answers (a half-open connection)
 //  4. Returning ready-to-use SSH client
 //
 // Returns an active ssh.Client that must be closed by the caller.
-func EstablishSSHConnection(srv *tsnet.Server, ctx context.Context, config SSHConnectionConfig) (*ssh.Client, error) {
-	// Create SSH configuration
-	sshConfig, err := createSSHConfig(config)
+func EstablishSSHConnection(dialer Dialer, ctx context.Context, config SSHConnectionConfig) (*ssh.Client, error) {
+	// Create SSH configuration; the auth methods take the throttle's slot
+	// only while authenticating, not during the dial or key exchange
+	slot := config.AuthThrottle.newAuthSlot(config.TargetHost)
+	sshConfig, err := createSSHConfig(config, slot)
 	if err != nil {
 		return nil, err
 	}
@@ -153,26 +205,141 @@ func EstablishSSHConnection(srv *tsnet.Server, ctx context.Context, config SSHCo
 		config.Logger.Printf("Dialing via tsnet...")
 	}
 
-	// Dial via tsnet
-	conn, err := srv.Dial(ctx, "tcp", sshTargetAddr)
-	if err != nil {
-		return nil, fmt.Errorf("tsnet dial failed")
+	dialAddr := sshTargetAddr
+	if config.DialAddress != "" {
+		dialAddr = net.JoinHostPort(config.DialAddress, config.TargetPort)
+		if config.Logger != nil {
+			config.Logger.Printf("Using address %s for %s", config.DialAddress, config.TargetHost)
+		}
 	}
 
-	// Establish SSH connection
-	sshConn, chans, reqs, err := ssh.NewClientConn(conn, sshTargetAddr, sshConfig)
-	if err != nil {
+	hostKeyAddr := sshTargetAddr
+	if config.HostKeyAlias != "" {
+		hostKeyAddr = net.JoinHostPort(config.HostKeyAlias, config.TargetPort)
+	}
+
+	handshakeTimeout := config.HandshakeTimeout
+	if handshakeTimeout <= 0 {
+		handshakeTimeout = DefaultHandshakeTimeout
+	}
+
+	for attempt := 0; ; attempt++ {
+		// The context's deadline, if sooner, also ends the wait for the server
+		attemptTimeout := handshakeTimeout
+		if deadline, ok := ctx.Deadline(); ok {
+			attemptTimeout = min(attemptTimeout, time.Until(deadline))
+		}
+
+		// Dial via tsnet
+		conn, err := dialer.Dial(ctx, "tcp", dialAddr)
+		if err != nil {
+			if isTimeout(err) {
+				return nil, tserrors.NewTimeoutError("dial", config.TargetHost, err)
+			}
+			return nil, tserrors.NewDialError(config.TargetHost, err)
+		}
+
+		// Watch the key exchange so a fallback to classical is logged, or refused
+		if pqcConfig := config.PQCConfig; pqcConfig != nil && pqcConfig.EnablePQC && pqcConfig.QuantumResistance >= pqc.QuantumResistanceHybrid {
+			conn = newKexObserver(conn, pqcKexCheck(pqcConfig, config.TargetHost, config.Logger))
+		}
+
+		// Establish SSH connection
+		sshConn, chans, reqs, stalled, err := clientHandshake(conn, hostKeyAddr, sshConfig, attemptTimeout)
+		slot.done(err == nil)
+		if err == nil {
+			client := ssh.NewClient(sshConn, chans, reqs)
+			if config.Logger != nil {
+				config.Logger.Printf("SSH connection established")
+			}
+			return client, nil
+		}
+		conn.Close()
+
+		if !stalled {
+			return nil, classifyHandshakeError(config, err)
+		}
+		if attempt < config.HandshakeRetries && ctx.Err() == nil {
+			if config.Logger != nil {
+				config.Logger.Printf("No SSH handshake from %s within %v; redialing", config.TargetHost, attemptTimeout)
+			}
+			continue
+		}
+		return nil, tserrors.NewTimeoutError("ssh_handshake", config.TargetHost,
+			fmt.Errorf("no SSH handshake within %v, the connection may be half-open: %w", attemptTimeout.Round(time.Millisecond), err))
+	}
+}
+
+// clientHandshake runs the SSH client handshake over conn. The server has
+// timeout to get as far as presenting its host key; a connection that stays
+// silent that long, as a half-open path does, is closed and fails with
+// stalled set. A timer closes it rather than a deadline, which a channel
+// through a jump host doesn't support. The timer stops once the host key
+// arrives, so prompts for the host key and credentials can take as long as
+// they need.
+func clientHandshake(conn net.Conn, addr string, sshConfig *ssh.ClientConfig, timeout time.Duration) (ssh.Conn, <-chan ssh.NewChannel, <-chan *ssh.Request, bool, error) {
+	var timedOut atomic.Bool
+	timer := time.AfterFunc(timeout, func() {
+		timedOut.Store(true)
 		conn.Close()
-		return nil, fmt.Errorf("SSH connection failed: %w", err)
+	})
+	defer timer.Stop()
+
+	attemptConfig := *sshConfig
+	attemptConfig.HostKeyCallback = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		if !timer.Stop() {
+			return fmt.Errorf("no host key within %v", timeout)
+		}
+		return sshConfig.HostKeyCallback(hostname, remote, key)
 	}
 
-	client := ssh.NewClient(sshConn, chans, reqs)
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, addr, &attemptConfig)
+	if err != nil {
+		return nil, nil, nil, timedOut.Load(), err
+	}
+	return sshConn, chans, reqs, false, nil
+}
 
-	if config.Logger != nil {
-		config.Logger.Printf("SSH connection established")
+// markHostKeyErrors wraps verify so its failures are reported as host key
+// errors, telling them apart from other handshake failures
+func markHostKeyErrors(verify ssh.HostKeyCallback) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		if err := verify(hostname, remote, key); err != nil {
+			return tserrors.NewHostKeyError(hostname, err)
+		}
+		return nil
+	}
+}
+
+// classifyHandshakeError returns err as a typed error from internal/errors
+// so callers can tell host key, authentication, timeout and other failures
+// apart with errors.As
+func classifyHandshakeError(config SSHConnectionConfig, err error) error {
+	var tsErr *tserrors.TSError
+	switch {
+	case errors.As(err, &tsErr):
+		return fmt.Errorf("SSH connection failed: %w", err)
+	case isTimeout(err):
+		return tserrors.NewTimeoutError("ssh_connect", config.TargetHost, err)
+	case isAuthFailure(err):
+		return tserrors.NewSSHAuthError(config.User, config.TargetHost, err)
+	default:
+		return tserrors.NewSSHConnectionError(config.TargetHost, err)
 	}
+}
+
+// isAuthFailure reports whether a handshake failed because the server
+// rejected every authentication method. golang.org/x/crypto/ssh has no error
+// type or sentinel for this, only the message, so the match lives here alone
+// and TestIsAuthFailure pins it against a real handshake.
+func isAuthFailure(err error) bool {
+	return strings.Contains(err.Error(), "ssh: unable to authenticate")
+}
 
-	return client, nil
+// isTimeout reports whether err is a deadline or network timeout
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout())
 }
 
 // CreateSSHSession creates an SSH session with standard configuration
@@ -187,3 +354,32 @@ func CreateSSHSession(client *ssh.Client) (*ssh.Session, error) {
 	}
 	return session, nil
 }
+
+// WaitSession waits for a started session to finish. If ctx is cancelled
+// first, the session is closed so a hung remote command cannot block forever,
+// and the context's error is returned.
+func WaitSession(ctx context.Context, session *ssh.Session) error {
+	return waitSession(ctx, session)
+}
+
+func waitSession(ctx context.Context, session interface {
+	Wait() error
+	Close() error
+}) error {
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			session.Close()
+		case <-done:
+		}
+	}()
+
+	err := session.Wait()
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
@@ -1,9 +1,24 @@
 package ssh
 
 import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io"
 	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	tserrors "github.com/derekg/ts-ssh/internal/errors"
 )
 
 func TestCreateSSHConfig(t *testing.T) {
@@ -41,7 +56,7 @@ func TestCreateSSHConfig(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			sshConfig, err := createSSHConfig(tt.config)
+			sshConfig, err := createSSHConfig(tt.config, nil)
 			if err != nil {
 				t.Errorf("createSSHConfig() error = %v", err)
 				return
@@ -97,7 +112,7 @@ func TestCreateSSHAuthMethods(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			authMethods, err := createSSHAuthMethods(tt.keyPath, tt.user, tt.targetHost, logger)
+			authMethods, err := createSSHAuthMethods(tt.keyPath, nil, tt.user, tt.targetHost, false, false, nil, nil, logger)
 			if err != nil {
 				t.Errorf("createSSHAuthMethods() error = %v", err)
 				return
@@ -165,3 +180,259 @@ func TestSSHConnectionConfig(t *testing.T) {
 		})
 	}
 }
+
+// failingDialer returns err from every Dial
+type failingDialer struct{ err error }
+
+func (d failingDialer) Dial(ctx context.Context, network, address string) (net.Conn, error) {
+	return nil, d.err
+}
+
+// tcpDialer dials directly, standing in for tsnet
+type tcpDialer struct{}
+
+func (tcpDialer) Dial(ctx context.Context, network, address string) (net.Conn, error) {
+	var d net.Dialer
+	return d.DialContext(ctx, network, address)
+}
+
+// halfOpenDialer connects, like a half-open path, to a peer that takes
+// whatever is sent but never answers. After the first dial it uses next,
+// when set.
+type halfOpenDialer struct {
+	dials *atomic.Int32
+	next  Dialer
+}
+
+func (d halfOpenDialer) Dial(ctx context.Context, network, address string) (net.Conn, error) {
+	if d.dials.Add(1) > 1 && d.next != nil {
+		return d.next.Dial(ctx, network, address)
+	}
+	client, server := net.Pipe()
+	go io.Copy(io.Discard, server)
+	return client, nil
+}
+
+func TestEstablishSSHConnectionHalfOpen(t *testing.T) {
+	origReadPassword := readPassword
+	readPassword = func() (string, error) { return "wrong", nil }
+	defer func() { readPassword = origReadPassword }()
+
+	addr := startPasswordSSHServer(t, "s3cret")
+	host, port, _ := net.SplitHostPort(addr)
+	config := SSHConnectionConfig{
+		User:                  "testuser",
+		TargetHost:            host,
+		TargetPort:            port,
+		InsecureHostKey:       true,
+		IdentitiesOnly:        true,
+		NoKeyboardInteractive: true,
+		HandshakeTimeout:      100 * time.Millisecond,
+		Logger:                log.New(io.Discard, "", 0),
+	}
+
+	// Without retries the stalled handshake is a timeout, well before the dial timeout
+	var dials atomic.Int32
+	start := time.Now()
+	_, err := EstablishSSHConnection(halfOpenDialer{dials: &dials}, context.Background(), config)
+	var tsErr *tserrors.TSError
+	if !errors.As(err, &tsErr) || tsErr.Code != tserrors.ErrCodeTimeout {
+		t.Fatalf("EstablishSSHConnection() error = %v, want a handshake timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("EstablishSSHConnection() took %v to give up", elapsed)
+	}
+	if dials.Load() != 1 {
+		t.Errorf("dialed %d times, want 1 without retries", dials.Load())
+	}
+
+	// A retry redials and reaches the server, which then rejects the password
+	dials.Store(0)
+	config.HandshakeRetries = 1
+	_, err = EstablishSSHConnection(halfOpenDialer{dials: &dials, next: tcpDialer{}}, context.Background(), config)
+	if !errors.As(err, &tsErr) || tsErr.Code != tserrors.ErrCodeSSHAuth {
+		t.Fatalf("EstablishSSHConnection() after a retry error = %v, want the server's authentication failure", err)
+	}
+	if dials.Load() != 2 {
+		t.Errorf("dialed %d times, want 2 with one retry", dials.Load())
+	}
+}
+
+func TestEstablishSSHConnectionHostKeyAlias(t *testing.T) {
+	origReadPassword := readPassword
+	readPassword = func() (string, error) { return "s3cret", nil }
+	defer func() { readPassword = origReadPassword }()
+
+	addr := startPasswordSSHServer(t, "s3cret")
+	host, port, _ := net.SplitHostPort(addr)
+
+	// known_hosts already has a different machine under the same name, as a
+	// tailnet host shadowed by one only a bastion can reach
+	_, directKey := generateTestKeyPair(t)
+	knownHostsPath := filepath.Join(t.TempDir(), "known_hosts")
+	if err := os.WriteFile(knownHostsPath, []byte(KnownHostsLine(addr, directKey)+"\n"), 0600); err != nil {
+		t.Fatalf("Failed to write known_hosts: %v", err)
+	}
+
+	config := SSHConnectionConfig{
+		User:                  "testuser",
+		TargetHost:            host,
+		TargetPort:            port,
+		UserKnownHostsFile:    knownHostsPath,
+		IdentitiesOnly:        true,
+		NoKeyboardInteractive: true,
+		Logger:                log.New(io.Discard, "", 0),
+	}
+
+	// Under its own name the key looks changed
+	if _, err := EstablishSSHConnection(tcpDialer{}, context.Background(), config); err == nil {
+		t.Fatal("EstablishSSHConnection() should fail against the other machine's key")
+	}
+
+	// Under the alias it is a new host, recorded in bracketed form
+	origPrompt := hostKeyPrompt
+	hostKeyPrompt = func(string, *log.Logger) (string, error) { return "yes", nil }
+	defer func() { hostKeyPrompt = origPrompt }()
+	config.HostKeyAlias = "bastion/" + host
+	client, err := EstablishSSHConnection(tcpDialer{}, context.Background(), config)
+	if err != nil {
+		t.Fatalf("EstablishSSHConnection() with an alias error = %v", err)
+	}
+	client.Close()
+
+	data, err := os.ReadFile(knownHostsPath)
+	if err != nil {
+		t.Fatalf("Failed to read known_hosts: %v", err)
+	}
+	wantEntry := "\n[bastion/" + host + "]:" + port + " "
+	if !strings.Contains(string(data), wantEntry) {
+		t.Errorf("known_hosts = %q, want an entry starting %q", data, wantEntry)
+	}
+	if knownhosts.Normalize(net.JoinHostPort(config.HostKeyAlias, port)) != "[bastion/"+host+"]:"+port {
+		t.Errorf("knownhosts.Normalize() doesn't bracket the alias")
+	}
+
+	// The recorded key is verified without prompting next time, and the
+	// direct entry is left as it was
+	hostKeyPrompt = func(string, *log.Logger) (string, error) { return "", errors.New("unexpected prompt") }
+	client, err = EstablishSSHConnection(tcpDialer{}, context.Background(), config)
+	if err != nil {
+		t.Fatalf("EstablishSSHConnection() with a recorded alias error = %v", err)
+	}
+	client.Close()
+	if !strings.HasPrefix(string(data), KnownHostsLine(addr, directKey)+"\n") {
+		t.Errorf("the direct known_hosts entry changed: %q", data)
+	}
+}
+
+func TestIsAuthFailure(t *testing.T) {
+	addr := startPasswordSSHServer(t, "s3cret")
+
+	// Handshake with x/crypto directly so an upgrade that rewords its
+	// message fails here rather than turning auth errors into generic ones
+	_, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            "testuser",
+		Auth:            []ssh.AuthMethod{ssh.Password("wrong")},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         5 * time.Second,
+	})
+	if err == nil {
+		t.Fatal("ssh.Dial() with a wrong password should fail")
+	}
+	if !isAuthFailure(err) {
+		t.Errorf("isAuthFailure(%q) = false, x/crypto's message may have changed", err)
+	}
+
+	if isAuthFailure(errors.New("ssh: handshake failed: EOF")) {
+		t.Error("isAuthFailure() matched a connection failure")
+	}
+}
+
+func TestEstablishSSHConnectionErrorTypes(t *testing.T) {
+	origReadPassword := readPassword
+	readPassword = func() (string, error) { return "wrong", nil }
+	defer func() { readPassword = origReadPassword }()
+
+	addr := startPasswordSSHServer(t, "s3cret")
+	host, port, _ := net.SplitHostPort(addr)
+
+	// A known_hosts file holding a different key for the server
+	_, otherPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	otherKey, err := ssh.NewSignerFromKey(otherPriv)
+	if err != nil {
+		t.Fatalf("Failed to create signer: %v", err)
+	}
+	knownHosts := filepath.Join(t.TempDir(), "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(addr)}, otherKey.PublicKey())
+	if err := os.WriteFile(knownHosts, []byte(line+"\n"), 0600); err != nil {
+		t.Fatalf("Failed to write known_hosts: %v", err)
+	}
+
+	base := SSHConnectionConfig{
+		User:                  "testuser",
+		TargetHost:            host,
+		TargetPort:            port,
+		IdentitiesOnly:        true,
+		NoKeyboardInteractive: true,
+		Logger:                log.New(io.Discard, "", 0),
+	}
+
+	tests := []struct {
+		name     string
+		dialer   Dialer
+		modify   func(*SSHConnectionConfig)
+		wantCode tserrors.ErrorCode
+	}{
+		{
+			name:     "dial failure",
+			dialer:   failingDialer{err: errors.New("connection refused")},
+			wantCode: tserrors.ErrCodeNetworking,
+		},
+		{
+			name:     "dial timeout",
+			dialer:   failingDialer{err: context.DeadlineExceeded},
+			wantCode: tserrors.ErrCodeTimeout,
+		},
+		{
+			name:     "authentication failure",
+			dialer:   tcpDialer{},
+			modify:   func(c *SSHConnectionConfig) { c.InsecureHostKey = true },
+			wantCode: tserrors.ErrCodeSSHAuth,
+		},
+		{
+			name:   "changed host key",
+			dialer: tcpDialer{},
+			modify: func(c *SSHConnectionConfig) {
+				c.UserKnownHostsFile = knownHosts
+				c.ReadOnlyKnownHosts = true
+			},
+			wantCode: tserrors.ErrCodeHostKeyVerification,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := base
+			if tt.modify != nil {
+				tt.modify(&config)
+			}
+
+			client, err := EstablishSSHConnection(tt.dialer, context.Background(), config)
+			if err == nil {
+				client.Close()
+				t.Fatal("EstablishSSHConnection() should fail")
+			}
+
+			var tsErr *tserrors.TSError
+			if !errors.As(err, &tsErr) {
+				t.Fatalf("error %v is not a *TSError", err)
+			}
+			if tsErr.Code != tt.wantCode {
+				t.Errorf("error code = %v, want %v (error: %v)", tsErr.Code, tt.wantCode, err)
+			}
+		})
+	}
+}
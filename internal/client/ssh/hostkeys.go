@@ -0,0 +1,384 @@
+package ssh
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"golang.org/x/term"
+
+	"github.com/derekg/ts-ssh/internal/platform"
+	"github.com/derekg/ts-ssh/internal/security"
+)
+
+// GlobalKnownHostsFile is the system-wide known_hosts file OpenSSH also consults
+const GlobalKnownHostsFile = "/etc/ssh/ssh_known_hosts"
+
+// errHostKeyScanned aborts the handshake once the host key has been captured
+var errHostKeyScanned = errors.New("host key scanned")
+
+// ScanHostKey performs an SSH handshake over conn only far enough to learn the
+// server's host key, like ssh-keyscan. No authentication is attempted and conn
+// is closed before returning, or as soon as ctx is done, so a host that
+// accepts the connection but never answers can't hold up a scan.
+func ScanHostKey(ctx context.Context, conn net.Conn, addr string) (ssh.PublicKey, error) {
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	var hostKey ssh.PublicKey
+	config := &ssh.ClientConfig{
+		User: "ts-ssh-keyscan",
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			hostKey = key
+			return errHostKeyScanned
+		},
+		Timeout: DefaultSSHTimeout,
+	}
+
+	_, _, _, err := ssh.NewClientConn(conn, addr, config)
+	if hostKey != nil {
+		return hostKey, nil
+	}
+	if err == nil {
+		err = errors.New("server did not present a host key")
+	}
+	return nil, fmt.Errorf("failed to scan host key for %s: %w", addr, err)
+}
+
+// KnownHostsLine formats key as a known_hosts entry for addr (host:port)
+func KnownHostsLine(addr string, key ssh.PublicKey) string {
+	return knownhosts.Line([]string{knownhosts.Normalize(addr)}, key)
+}
+
+// DefaultKnownHostsPath returns the user's ~/.ssh/known_hosts path
+func DefaultKnownHostsPath(currentUser *user.User) (string, error) {
+	if currentUser == nil || currentUser.HomeDir == "" {
+		return "", errors.New("user home directory unknown")
+	}
+	return filepath.Join(currentUser.HomeDir, ".ssh", "known_hosts"), nil
+}
+
+// knownHostsCache keeps the parsed known_hosts files of this process, so
+// connecting to many hosts parses a large file once. An entry is reused only
+// while every file has the size and modification time it was parsed at; any
+// write, including our own appends, causes the files to be read again.
+var knownHostsCache = struct {
+	sync.Mutex
+	entries map[string]knownHostsCacheEntry // Keyed by the joined file list
+}{entries: make(map[string]knownHostsCacheEntry)}
+
+type knownHostsCacheEntry struct {
+	stamp    string
+	callback ssh.HostKeyCallback
+}
+
+// loadKnownHosts is knownhosts.New with a per-process cache
+func loadKnownHosts(files ...string) (ssh.HostKeyCallback, error) {
+	key := strings.Join(files, "\x00")
+	var stamp strings.Builder
+	for _, path := range files {
+		info, err := os.Stat(path)
+		if err != nil {
+			// Let knownhosts.New report it
+			return knownhosts.New(files...)
+		}
+		fmt.Fprintf(&stamp, "%d:%d ", info.Size(), info.ModTime().UnixNano())
+	}
+
+	knownHostsCache.Lock()
+	defer knownHostsCache.Unlock()
+	if entry, ok := knownHostsCache.entries[key]; ok && entry.stamp == stamp.String() {
+		return entry.callback, nil
+	}
+	callback, err := knownhosts.New(files...)
+	if err != nil {
+		return nil, err
+	}
+	knownHostsCache.entries[key] = knownHostsCacheEntry{stamp: stamp.String(), callback: callback}
+	return callback, nil
+}
+
+// knownHostsHasLine reports whether knownHostsPath already contains line,
+// ignoring surrounding whitespace. A missing file contains nothing.
+func knownHostsHasLine(knownHostsPath, line string) (bool, error) {
+	f, err := os.Open(knownHostsPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	line = strings.TrimSpace(line)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if strings.TrimSpace(scanner.Text()) == line {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+// AddKnownHost appends key for addr (host:port) to knownHostsPath, creating the
+// file with secure permissions if needed.
+func AddKnownHost(knownHostsPath, addr string, key ssh.PublicKey, logger *log.Logger) error {
+	if err := security.CreateSecureKnownHostsFile(knownHostsPath); err != nil {
+		return fmt.Errorf("failed to prepare %s: %w", knownHostsPath, err)
+	}
+	return appendKnownHostLine(knownHostsPath, KnownHostsLine(addr, key), addr, key, logger)
+}
+
+// HostKeyEntry is a host key to add to known_hosts
+type HostKeyEntry struct {
+	Addr string // host:port
+	Key  ssh.PublicKey
+}
+
+// AddKnownHosts adds the entries for hosts not yet in knownHostsPath or the
+// global known_hosts in a single atomic rewrite, so a bulk import is never
+// left half-written, and returns how many were added. An entry whose host is
+// already known with a different or revoked key is not added, since
+// known_hosts would then accept either key and a man-in-the-middle would go
+// unnoticed; those entries are returned as conflicts for the caller to
+// report. The file is created with secure permissions if needed.
+func AddKnownHosts(knownHostsPath string, entries []HostKeyEntry, logger *log.Logger) (added int, conflicts []HostKeyEntry, err error) {
+	if err := security.CreateSecureKnownHostsFile(knownHostsPath); err != nil {
+		return 0, nil, fmt.Errorf("failed to prepare %s: %w", knownHostsPath, err)
+	}
+	data, err := os.ReadFile(knownHostsPath)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to read %s: %w", knownHostsPath, err)
+	}
+	files := []string{knownHostsPath}
+	if _, err := os.Stat(GlobalKnownHostsFile); err == nil {
+		files = append(files, GlobalKnownHostsFile)
+	}
+	verify, err := knownhosts.New(files...)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to read known hosts: %w", err)
+	}
+
+	present := make(map[string]bool)
+	var lines []string
+	for _, entry := range entries {
+		line := KnownHostsLine(entry.Addr, entry.Key)
+		if present[line] {
+			continue
+		}
+		known, err := knownHostKey(verify, entry)
+		if err != nil {
+			logger.Printf("Host key for %s (%s) conflicts with known_hosts: %v", entry.Addr, entry.Key.Type(), err)
+			conflicts = append(conflicts, entry)
+			continue
+		}
+		if known {
+			logger.Printf("Host key for %s (%s) is already known; not adding it again.", entry.Addr, entry.Key.Type())
+			continue
+		}
+		present[line] = true
+		lines = append(lines, line)
+	}
+	if len(lines) == 0 {
+		return 0, conflicts, nil
+	}
+
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		data = append(data, '\n')
+	}
+	data = append(data, strings.Join(lines, "\n")+"\n"...)
+
+	f, err := security.CreateSecureDownloadFileWithReplace(knownHostsPath)
+	if err != nil {
+		return 0, conflicts, err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return 0, conflicts, fmt.Errorf("failed to write %s: %w", knownHostsPath, err)
+	}
+	if err := security.CompleteAtomicReplacement(f); err != nil {
+		return 0, conflicts, fmt.Errorf("failed to write %s: %w", knownHostsPath, err)
+	}
+	logger.Printf("Added %d host keys to %s", len(lines), knownHostsPath)
+	return len(lines), conflicts, nil
+}
+
+// knownHostKey reports whether entry's key is already trusted for its host.
+// An error means the host is known with another key, or the key is revoked.
+func knownHostKey(verify ssh.HostKeyCallback, entry HostKeyEntry) (bool, error) {
+	// Only the host name is matched; the address just has to parse
+	remote := &net.TCPAddr{IP: net.IPv4zero}
+	err := verify(entry.Addr, remote, entry.Key)
+	var keyErr *knownhosts.KeyError
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.As(err, &keyErr) && len(keyErr.Want) == 0:
+		return false, nil
+	case errors.As(err, &keyErr):
+		want := keyErr.Want[0]
+		return false, fmt.Errorf("%s:%d has %s %s", want.Filename, want.Line, want.Key.Type(), ssh.FingerprintSHA256(want.Key))
+	default:
+		return false, err
+	}
+}
+
+// stderrIsTerminal reports whether warnings are read by a person; tests replace it
+var stderrIsTerminal = func() bool { return term.IsTerminal(int(os.Stderr.Fd())) }
+
+// changedKeysReported holds "host key-fingerprint" pairs already warned about,
+// so a run that connects to the same host repeatedly warns only once
+var changedKeysReported sync.Map
+
+// firstChangedKeyWarning reports whether the changed key for hostname has not
+// been warned about yet in this process
+func firstChangedKeyWarning(hostname string, key ssh.PublicKey) bool {
+	_, seen := changedKeysReported.LoadOrStore(hostname+" "+ssh.FingerprintSHA256(key), true)
+	return !seen
+}
+
+// verifyKnownHostKey checks key with check, a knownhosts callback, adding
+// what knownhosts leaves out for host certificates: a certificate is refused
+// when its CA or its key is marked @revoked, and one not signed by a
+// @cert-authority for the host, or not valid for it, is checked as its plain
+// key instead, as OpenSSH does.
+func verifyKnownHostKey(check ssh.HostKeyCallback, hostname string, remote net.Addr, key ssh.PublicKey, logger *log.Logger) error {
+	cert, ok := key.(*ssh.Certificate)
+	if !ok {
+		return check(hostname, remote, key)
+	}
+
+	// Plain keys are looked up in the revoked list first, so this only
+	// fails with a RevokedError for revoked keys. The certificate itself is
+	// looked up the same way when it isn't recognised as one.
+	for _, k := range []ssh.PublicKey{cert.SignatureKey, cert.Key, plainKey{cert}} {
+		var revoked *knownhosts.RevokedError
+		if err := check(hostname, remote, k); errors.As(err, &revoked) {
+			return revoked
+		}
+	}
+
+	err := check(hostname, remote, cert)
+	if err == nil {
+		logger.Printf("Host certificate for %s (serial %d) is signed by trusted CA %s", hostname, cert.Serial, ssh.FingerprintSHA256(cert.SignatureKey))
+		return nil
+	}
+	logger.Printf("Host certificate for %s not accepted (%v); checking its key instead", hostname, err)
+	return check(hostname, remote, cert.Key)
+}
+
+// plainKey hides that a certificate is one, so knownhosts matches its bytes
+// against @revoked lines like any other key's
+type plainKey struct {
+	ssh.PublicKey
+}
+
+// rejectRevokedHostKey refuses a connection whose host key, or the CA that
+// signed its certificate, is marked @revoked in known_hosts
+func rejectRevokedHostKey(hostname string, revoked *knownhosts.RevokedError, logger *log.Logger) error {
+	key := revoked.Revoked.Key
+	logger.Printf("WARNING: Host key for %s is revoked: %s %s", hostname, key.Type(), ssh.FingerprintSHA256(key))
+	security.LogHostKeyVerification(hostname, "", "revoked_key", false)
+
+	where := ""
+	if revoked.Revoked.Filename != "" {
+		where = fmt.Sprintf(" in %s:%d", revoked.Revoked.Filename, revoked.Revoked.Line)
+	}
+	fmt.Fprintf(os.Stderr, "%s %s key %s for '%s' is marked @revoked%s; refusing to connect.\n",
+		platform.Colorize(platform.ColorRed, "WARNING:"), key.Type(), ssh.FingerprintSHA256(key), hostname, where)
+	return fmt.Errorf("host key for %s is revoked: %w", hostname, revoked)
+}
+
+// hostInList reports whether hostname (host or host:port) names one of hosts.
+// Entries match without a port, or with exactly the port connected to.
+func hostInList(hostname string, hosts []string) bool {
+	host := hostname
+	if h, _, err := net.SplitHostPort(hostname); err == nil {
+		host = h
+	}
+	for _, entry := range hosts {
+		entry = strings.TrimSuffix(entry, ".")
+		if strings.EqualFold(entry, host) || strings.EqualFold(entry, hostname) {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptChangedHostKey trusts a changed key the user has allowed for this host.
+// The offending entries in knownHostsPath are replaced by the new key, so the
+// acceptance is one-time: later connections verify against the new key as
+// usual. Entries in other files, such as the global known_hosts, are left
+// alone. An empty knownHostsPath accepts the key without saving it.
+func acceptChangedHostKey(hostname string, remote net.Addr, key ssh.PublicKey, knownHostsPath string, keyErr *knownhosts.KeyError, logger *log.Logger) error {
+	logger.Printf("WARNING: Host key for %s has changed to %s %s; accepting it as allowed", hostname, key.Type(), ssh.FingerprintSHA256(key))
+	security.LogHostKeyVerification(hostname, "", "changed_key_accepted", true)
+	fmt.Fprintf(os.Stderr, "%s accepted changed %s host key for '%s' (%s).\n", platform.Colorize(platform.ColorYellow, "Warning:"), key.Type(), hostname, ssh.FingerprintSHA256(key))
+
+	if knownHostsPath == "" {
+		logger.Printf("Changed host key for %s accepted for this connection only; known_hosts is not updated", hostname)
+		return nil
+	}
+
+	var stale []int
+	for _, want := range keyErr.Want {
+		if want.Filename == knownHostsPath {
+			stale = append(stale, want.Line)
+		}
+	}
+	if err := removeKnownHostLines(knownHostsPath, stale); err != nil {
+		return fmt.Errorf("failed to remove the old host key for %s: %w", hostname, err)
+	}
+	return appendKnownHost(knownHostsPath, hostname, remote, key, logger)
+}
+
+// removeKnownHostLines rewrites knownHostsPath without the given 1-based lines
+func removeKnownHostLines(knownHostsPath string, lines []int) error {
+	if len(lines) == 0 {
+		return nil
+	}
+	drop := make(map[int]bool, len(lines))
+	for _, n := range lines {
+		drop[n] = true
+	}
+
+	data, err := os.ReadFile(knownHostsPath)
+	if err != nil {
+		return err
+	}
+	var kept bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		if !drop[n] {
+			kept.Write(scanner.Bytes())
+			kept.WriteByte('\n')
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	f, err := security.CreateSecureDownloadFileWithReplace(knownHostsPath)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(kept.Bytes()); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	return security.CompleteAtomicReplacement(f)
+}
@@ -0,0 +1,552 @@
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func TestScanHostKey(t *testing.T) {
+	_, clientPubKey := generateTestKeyPair(t)
+	serverAddr, cleanup := startMockSSHServer(t, clientPubKey)
+	defer cleanup()
+
+	conn, err := net.DialTimeout("tcp", serverAddr, 5*time.Second)
+	if err != nil {
+		t.Fatalf("Failed to connect to mock server: %v", err)
+	}
+
+	key, err := ScanHostKey(context.Background(), conn, serverAddr)
+	if err != nil {
+		t.Fatalf("ScanHostKey() error = %v", err)
+	}
+
+	line := KnownHostsLine(serverAddr, key)
+	if !strings.Contains(line, key.Type()) {
+		t.Errorf("KnownHostsLine() = %q, expected key type %s", line, key.Type())
+	}
+
+	t.Run("scanned_line_verifies_on_connect", func(t *testing.T) {
+		tempDir := t.TempDir()
+		knownHostsPath := filepath.Join(tempDir, ".ssh", "known_hosts")
+		logger := log.New(io.Discard, "", 0)
+
+		if err := AddKnownHost(knownHostsPath, serverAddr, key, logger); err != nil {
+			t.Fatalf("AddKnownHost() error = %v", err)
+		}
+
+		info, err := os.Stat(knownHostsPath)
+		if err != nil {
+			t.Fatalf("known_hosts not created: %v", err)
+		}
+		if info.Mode().Perm() != 0600 {
+			t.Errorf("known_hosts permissions = %o, want 0600", info.Mode().Perm())
+		}
+
+		callback, err := knownhosts.New(knownHostsPath)
+		if err != nil {
+			t.Fatalf("knownhosts.New() error = %v", err)
+		}
+
+		// A later connection to the same address must verify against the scanned entry
+		remote, err := net.ResolveTCPAddr("tcp", serverAddr)
+		if err != nil {
+			t.Fatalf("Failed to resolve server address: %v", err)
+		}
+		if err := callback(serverAddr, remote, key); err != nil {
+			t.Errorf("scanned host key did not verify: %v", err)
+		}
+
+		// A different key for the same host must not verify
+		_, otherKey := generateTestKeyPair(t)
+		if err := callback(serverAddr, remote, otherKey); err == nil {
+			t.Error("expected a mismatched host key to fail verification")
+		}
+	})
+}
+
+func TestScanHostKeySilentHost(t *testing.T) {
+	// The host accepts the connection but never sends its version
+	client, server := net.Pipe()
+	defer server.Close()
+	go io.Copy(io.Discard, server)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		_, err := ScanHostKey(ctx, client, "silent:22")
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("ScanHostKey() succeeded against a silent host")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ScanHostKey() did not return once its context was done")
+	}
+}
+
+func TestKnownHostsCallbackGlobalFile(t *testing.T) {
+	tempDir := t.TempDir()
+	userKnownHosts := filepath.Join(tempDir, "user_known_hosts")
+	globalKnownHosts := filepath.Join(tempDir, "ssh_known_hosts")
+	logger := log.New(io.Discard, "", 0)
+
+	const addr = "db.tailnet.example:22"
+	_, hostKey := generateTestKeyPair(t)
+	if err := os.WriteFile(globalKnownHosts, []byte(KnownHostsLine(addr, hostKey)+"\n"), 0644); err != nil {
+		t.Fatalf("Failed to write global known_hosts: %v", err)
+	}
+
+	callback, err := CreateKnownHostsCallbackFiles(nil, userKnownHosts, []string{globalKnownHosts, filepath.Join(tempDir, "missing")}, false, nil, logger)
+	if err != nil {
+		t.Fatalf("CreateKnownHostsCallbackFiles() error = %v", err)
+	}
+	before, err := os.ReadFile(userKnownHosts)
+	if err != nil {
+		t.Fatalf("user known_hosts not created: %v", err)
+	}
+
+	remote := &net.TCPAddr{IP: net.ParseIP("100.64.0.1"), Port: 22}
+	if err := callback(addr, remote, hostKey); err != nil {
+		t.Errorf("key only present in the global file did not verify: %v", err)
+	}
+
+	// A changed key is still rejected using the global entry
+	_, otherKey := generateTestKeyPair(t)
+	if err := callback(addr, remote, otherKey); err == nil {
+		t.Error("expected a mismatched host key to fail verification")
+	}
+
+	// Verification never writes to the user file
+	after, err := os.ReadFile(userKnownHosts)
+	if err != nil {
+		t.Fatalf("Failed to read user known_hosts: %v", err)
+	}
+	if string(after) != string(before) {
+		t.Errorf("user known_hosts modified: %q", after)
+	}
+}
+
+func TestKnownHostsCallbackReadOnly(t *testing.T) {
+	tempDir := t.TempDir()
+	knownHostsPath := filepath.Join(tempDir, "known_hosts")
+	logger := log.New(io.Discard, "", 0)
+
+	_, pinnedKey := generateTestKeyPair(t)
+	original := KnownHostsLine("pinned.example:22", pinnedKey) + "\n"
+	if err := os.WriteFile(knownHostsPath, []byte(original), 0600); err != nil {
+		t.Fatalf("Failed to write known_hosts: %v", err)
+	}
+
+	// Accept every unknown key as if the user answered "yes"
+	origPrompt := hostKeyPrompt
+	hostKeyPrompt = func(string, *log.Logger) (string, error) { return "yes", nil }
+	defer func() { hostKeyPrompt = origPrompt }()
+
+	callback, err := CreateKnownHostsCallbackFiles(nil, knownHostsPath, nil, true, nil, logger)
+	if err != nil {
+		t.Fatalf("CreateKnownHostsCallbackFiles() error = %v", err)
+	}
+
+	remote := &net.TCPAddr{IP: net.ParseIP("100.64.0.2"), Port: 22}
+	_, newKey := generateTestKeyPair(t)
+	if err := callback("new.example:22", remote, newKey); err != nil {
+		t.Fatalf("accepting a new key in read-only mode failed: %v", err)
+	}
+
+	// Pinned keys are still enforced
+	if err := callback("pinned.example:22", remote, pinnedKey); err != nil {
+		t.Errorf("pinned key did not verify: %v", err)
+	}
+	if err := callback("pinned.example:22", remote, newKey); err == nil {
+		t.Error("expected a mismatched pinned key to fail verification")
+	}
+
+	data, err := os.ReadFile(knownHostsPath)
+	if err != nil {
+		t.Fatalf("Failed to read known_hosts: %v", err)
+	}
+	if string(data) != original {
+		t.Errorf("known_hosts changed in read-only mode: %q", data)
+	}
+
+	// A missing user file is not created in read-only mode
+	missingPath := filepath.Join(tempDir, "absent", "known_hosts")
+	if _, err := CreateKnownHostsCallbackFiles(nil, missingPath, nil, true, nil, logger); err != nil {
+		t.Fatalf("CreateKnownHostsCallbackFiles() error = %v", err)
+	}
+	if _, err := os.Stat(missingPath); !os.IsNotExist(err) {
+		t.Errorf("read-only mode created %s", missingPath)
+	}
+}
+
+func TestKnownHostsCallbackAcceptChanged(t *testing.T) {
+	tempDir := t.TempDir()
+	knownHostsPath := filepath.Join(tempDir, "known_hosts")
+	var logged bytes.Buffer
+	logger := log.New(&logged, "", 0)
+
+	_, oldKey := generateTestKeyPair(t)
+	_, otherKey := generateTestKeyPair(t)
+	original := KnownHostsLine("rotated.example:22", oldKey) + "\n" + KnownHostsLine("other.example:22", otherKey) + "\n"
+	if err := os.WriteFile(knownHostsPath, []byte(original), 0600); err != nil {
+		t.Fatalf("Failed to write known_hosts: %v", err)
+	}
+
+	origTerminal := stderrIsTerminal
+	stderrIsTerminal = func() bool { return false }
+	defer func() { stderrIsTerminal = origTerminal }()
+
+	callback, err := CreateKnownHostsCallbackFiles(nil, knownHostsPath, nil, false, []string{"rotated.example"}, logger)
+	if err != nil {
+		t.Fatalf("CreateKnownHostsCallbackFiles() error = %v", err)
+	}
+
+	remote := &net.TCPAddr{IP: net.ParseIP("100.64.0.3"), Port: 22}
+	_, newKey := generateTestKeyPair(t)
+
+	// Hosts not in the allowlist still fail on a changed key
+	var keyErr *knownhosts.KeyError
+	if err := callback("other.example:22", remote, newKey); !errors.As(err, &keyErr) {
+		t.Errorf("changed key for a host not in the allowlist: error = %v, want a KeyError", err)
+	}
+
+	if err := callback("rotated.example:22", remote, newKey); err != nil {
+		t.Fatalf("allowlisted changed key was refused: %v", err)
+	}
+	if !strings.Contains(logged.String(), "rotated.example:22 has changed") {
+		t.Errorf("log = %q, want the accepted change recorded", logged.String())
+	}
+
+	// The new key replaced the old one, so the next connection verifies
+	// without the allowlist and the old key no longer does
+	plain, err := knownhosts.New(knownHostsPath)
+	if err != nil {
+		t.Fatalf("knownhosts.New() error = %v", err)
+	}
+	if err := plain("rotated.example:22", remote, newKey); err != nil {
+		t.Errorf("accepted key did not verify afterwards: %v", err)
+	}
+	if err := plain("rotated.example:22", remote, oldKey); err == nil {
+		t.Error("old key still verifies after the change was accepted")
+	}
+	if err := plain("other.example:22", remote, otherKey); err != nil {
+		t.Errorf("unrelated entry lost: %v", err)
+	}
+}
+
+// signHostCert returns a host certificate for key signed by ca, valid for principals
+func signHostCert(t *testing.T, ca ssh.Signer, key ssh.PublicKey, principals ...string) *ssh.Certificate {
+	t.Helper()
+	cert := &ssh.Certificate{
+		Key:             key,
+		Serial:          42,
+		CertType:        ssh.HostCert,
+		ValidPrincipals: principals,
+		ValidBefore:     ssh.CertTimeInfinity,
+	}
+	if err := cert.SignCert(rand.Reader, ca); err != nil {
+		t.Fatalf("SignCert() error = %v", err)
+	}
+	return cert
+}
+
+func newTestSigner(t *testing.T) ssh.Signer {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() error = %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatalf("NewSignerFromKey() error = %v", err)
+	}
+	return signer
+}
+
+func TestKnownHostsCallbackMarkers(t *testing.T) {
+	ca := newTestSigner(t)
+	revokedCA := newTestSigner(t)
+	otherCA := newTestSigner(t)
+	_, hostKey := generateTestKeyPair(t)
+	_, revokedKey := generateTestKeyPair(t)
+	_, knownKey := generateTestKeyPair(t)
+	revokedCert := signHostCert(t, ca, hostKey, "web1.corp.example")
+
+	knownHostsPath := filepath.Join(t.TempDir(), "known_hosts")
+	lines := []string{
+		"@cert-authority *.corp.example " + strings.TrimSpace(string(ssh.MarshalAuthorizedKey(ca.PublicKey()))),
+		"@cert-authority *.corp.example " + strings.TrimSpace(string(ssh.MarshalAuthorizedKey(revokedCA.PublicKey()))),
+		"@revoked * " + strings.TrimSpace(string(ssh.MarshalAuthorizedKey(revokedCA.PublicKey()))),
+		"@revoked * " + strings.TrimSpace(string(ssh.MarshalAuthorizedKey(revokedKey))),
+		"@revoked * " + strings.TrimSpace(string(ssh.MarshalAuthorizedKey(revokedCert))),
+		KnownHostsLine("legacy.corp.example:22", knownKey),
+	}
+	if err := os.WriteFile(knownHostsPath, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		t.Fatalf("Failed to write known_hosts: %v", err)
+	}
+
+	// Nothing here should fall through to asking the user
+	origPrompt := hostKeyPrompt
+	hostKeyPrompt = func(string, *log.Logger) (string, error) { return "", errors.New("unexpected prompt") }
+	defer func() { hostKeyPrompt = origPrompt }()
+
+	callback, err := CreateKnownHostsCallbackFiles(nil, knownHostsPath, nil, true, nil, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatalf("CreateKnownHostsCallbackFiles() error = %v", err)
+	}
+	remote := &net.TCPAddr{IP: net.ParseIP("100.64.0.4"), Port: 22}
+
+	// A certificate from a trusted CA needs no known_hosts entry of its own
+	if err := callback("web1.corp.example:22", remote, signHostCert(t, ca, hostKey, "web1.corp.example")); err != nil {
+		t.Errorf("CA-signed host certificate was refused: %v", err)
+	}
+
+	// An untrusted or mismatched certificate falls back to its plain key
+	if err := callback("legacy.corp.example:22", remote, signHostCert(t, otherCA, knownKey, "legacy.corp.example")); err != nil {
+		t.Errorf("certificate of a known key from another CA was refused: %v", err)
+	}
+	if err := callback("legacy.corp.example:22", remote, signHostCert(t, ca, knownKey, "somewhere.else")); err != nil {
+		t.Errorf("certificate of a known key for another principal was refused: %v", err)
+	}
+
+	revokedTests := []struct {
+		name string
+		key  ssh.PublicKey
+	}{
+		{"revoked plain key", revokedKey},
+		{"certificate from a revoked CA", signHostCert(t, revokedCA, hostKey, "web1.corp.example")},
+		{"certificate of a revoked key", signHostCert(t, ca, revokedKey, "web1.corp.example")},
+		{"revoked certificate", revokedCert},
+	}
+	for _, tt := range revokedTests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := callback("web1.corp.example:22", remote, tt.key)
+			var revoked *knownhosts.RevokedError
+			if !errors.As(err, &revoked) || !strings.Contains(err.Error(), "revoked") {
+				t.Errorf("callback() error = %v, want a revoked key refusal", err)
+			}
+		})
+	}
+}
+
+func TestHostInList(t *testing.T) {
+	hosts := []string{"web1", "DB.example.com.", "cache:2222"}
+	tests := []struct {
+		hostname string
+		want     bool
+	}{
+		{"web1:22", true},
+		{"db.example.com:22", true},
+		{"cache:2222", true},
+		{"cache:22", false},
+		{"web10:22", false},
+	}
+	for _, tt := range tests {
+		if got := hostInList(tt.hostname, hosts); got != tt.want {
+			t.Errorf("hostInList(%q) = %v, want %v", tt.hostname, got, tt.want)
+		}
+	}
+}
+
+func TestAddKnownHostSkipsDuplicates(t *testing.T) {
+	knownHostsPath := filepath.Join(t.TempDir(), "known_hosts")
+	logger := log.New(io.Discard, "", 0)
+	_, key := generateTestKeyPair(t)
+	_, otherKey := generateTestKeyPair(t)
+
+	for i := 0; i < 3; i++ {
+		if err := AddKnownHost(knownHostsPath, "web1:22", key, logger); err != nil {
+			t.Fatalf("AddKnownHost() error = %v", err)
+		}
+	}
+	// A different key for the same host is a new entry
+	if err := AddKnownHost(knownHostsPath, "web1:22", otherKey, logger); err != nil {
+		t.Fatalf("AddKnownHost() error = %v", err)
+	}
+
+	data, err := os.ReadFile(knownHostsPath)
+	if err != nil {
+		t.Fatalf("Failed to read known_hosts: %v", err)
+	}
+	for _, k := range []ssh.PublicKey{key, otherKey} {
+		if n := strings.Count(string(data), KnownHostsLine("web1:22", k)+"\n"); n != 1 {
+			t.Errorf("known_hosts has %d entries for a %s key, want 1:\n%s", n, k.Type(), data)
+		}
+	}
+}
+
+func TestAddKnownHostsBulk(t *testing.T) {
+	knownHostsPath := filepath.Join(t.TempDir(), ".ssh", "known_hosts")
+	logger := log.New(io.Discard, "", 0)
+	_, key1 := generateTestKeyPair(t)
+	_, key2 := generateTestKeyPair(t)
+
+	if err := AddKnownHost(knownHostsPath, "web1:22", key1, logger); err != nil {
+		t.Fatalf("AddKnownHost() error = %v", err)
+	}
+	before, err := os.ReadFile(knownHostsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries := []HostKeyEntry{
+		{Addr: "web1:22", Key: key1}, // Already present
+		{Addr: "web2:22", Key: key2},
+		{Addr: "web3:2222", Key: key2},
+		{Addr: "web2:22", Key: key2}, // Repeated in the same batch
+	}
+	added, conflicts, err := AddKnownHosts(knownHostsPath, entries, logger)
+	if err != nil {
+		t.Fatalf("AddKnownHosts() error = %v", err)
+	}
+	if len(conflicts) != 0 {
+		t.Errorf("AddKnownHosts() conflicts = %v, want none", conflicts)
+	}
+	if added != 2 {
+		t.Errorf("AddKnownHosts() added %d, want 2", added)
+	}
+
+	data, err := os.ReadFile(knownHostsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := string(before) + KnownHostsLine("web2:22", key2) + "\n" + KnownHostsLine("web3:2222", key2) + "\n"
+	if string(data) != want {
+		t.Errorf("known_hosts =\n%s\nwant\n%s", data, want)
+	}
+	if info, err := os.Stat(knownHostsPath); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("known_hosts mode = %v, %v, want 0600", info.Mode().Perm(), err)
+	}
+
+	// Nothing new leaves the file untouched
+	if added, _, err := AddKnownHosts(knownHostsPath, entries, logger); err != nil || added != 0 {
+		t.Errorf("AddKnownHosts() again = %d, %v, want nothing added", added, err)
+	}
+}
+
+func TestAddKnownHostsRefusesChangedKey(t *testing.T) {
+	knownHostsPath := filepath.Join(t.TempDir(), "known_hosts")
+	logger := log.New(io.Discard, "", 0)
+	_, trusted := generateTestKeyPair(t)
+	_, scanned := generateTestKeyPair(t)
+
+	if err := AddKnownHost(knownHostsPath, "web1:22", trusted, logger); err != nil {
+		t.Fatalf("AddKnownHost() error = %v", err)
+	}
+	before, err := os.ReadFile(knownHostsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries := []HostKeyEntry{
+		{Addr: "web1:22", Key: scanned}, // Same host, different key
+		{Addr: "web2:22", Key: scanned},
+	}
+	added, conflicts, err := AddKnownHosts(knownHostsPath, entries, logger)
+	if err != nil {
+		t.Fatalf("AddKnownHosts() error = %v", err)
+	}
+	if added != 1 || len(conflicts) != 1 || conflicts[0].Addr != "web1:22" {
+		t.Fatalf("AddKnownHosts() = %d, %v; want web2 added and web1 reported", added, conflicts)
+	}
+
+	data, err := os.ReadFile(knownHostsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := string(before) + KnownHostsLine("web2:22", scanned) + "\n"; string(data) != want {
+		t.Errorf("known_hosts =\n%s\nwant\n%s", data, want)
+	}
+
+	// The trusted key alone still verifies web1
+	verify, err := knownhosts.New(knownHostsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verify("web1:22", &net.TCPAddr{IP: net.IPv4(100, 64, 0, 1), Port: 22}, scanned); err == nil {
+		t.Error("the scanned key for web1 is trusted")
+	}
+}
+
+func TestLoadKnownHostsNoticesChanges(t *testing.T) {
+	knownHostsPath := filepath.Join(t.TempDir(), "known_hosts")
+	logger := log.New(io.Discard, "", 0)
+	_, key := generateTestKeyPair(t)
+	remote := &net.TCPAddr{IP: net.ParseIP("100.64.0.4"), Port: 22}
+
+	if err := os.WriteFile(knownHostsPath, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	before, err := loadKnownHosts(knownHostsPath)
+	if err != nil {
+		t.Fatalf("loadKnownHosts() error = %v", err)
+	}
+	if err := before("web1:22", remote, key); err == nil {
+		t.Fatal("unknown host verified")
+	}
+
+	// The cached callback is not reused once the file has been written to
+	if err := AddKnownHost(knownHostsPath, "web1:22", key, logger); err != nil {
+		t.Fatalf("AddKnownHost() error = %v", err)
+	}
+	after, err := loadKnownHosts(knownHostsPath)
+	if err != nil {
+		t.Fatalf("loadKnownHosts() error = %v", err)
+	}
+	if err := after("web1:22", remote, key); err != nil {
+		t.Errorf("added host did not verify: %v", err)
+	}
+}
+
+// BenchmarkLoadKnownHosts verifies against a known_hosts file with thousands
+// of hashed entries, as each connection of a fleet run does
+func BenchmarkLoadKnownHosts(b *testing.B) {
+	knownHostsPath := filepath.Join(b.TempDir(), "known_hosts")
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		b.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(priv.Public())
+	if err != nil {
+		b.Fatal(err)
+	}
+	var data strings.Builder
+	for i := 0; i < 5000; i++ {
+		host := knownhosts.HashHostname(fmt.Sprintf("host%d.tailnet.example", i))
+		data.WriteString(knownhosts.Line([]string{host}, key) + "\n")
+	}
+	if err := os.WriteFile(knownHostsPath, []byte(data.String()), 0600); err != nil {
+		b.Fatal(err)
+	}
+	remote := &net.TCPAddr{IP: net.ParseIP("100.64.0.5"), Port: 22}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		callback, err := loadKnownHosts(knownHostsPath)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if err := callback("host4999.tailnet.example:22", remote, key); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package ssh
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// ParseHTTPProxyURL parses an http://[user:password@]host:port proxy URL
+// for NewHTTPConnectDialer. The port defaults to 80.
+func ParseHTTPProxyURL(proxy string) (*url.URL, error) {
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy %q: %w", proxy, err)
+	}
+	if u.Scheme != "http" || u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid proxy %q: must be an http:// URL", u.Redacted())
+	}
+	if u.Path != "" && u.Path != "/" {
+		return nil, fmt.Errorf("invalid proxy %q: must not have a path", u.Redacted())
+	}
+	if u.Port() == "" {
+		u.Host = net.JoinHostPort(u.Hostname(), "80")
+	}
+	return u, nil
+}
+
+// httpConnectDialer tunnels connections through an HTTP proxy with the
+// CONNECT method, reaching the proxy itself through another Dialer
+type httpConnectDialer struct {
+	base  Dialer
+	proxy *url.URL
+}
+
+// NewHTTPConnectDialer returns a Dialer that connects to proxy through base
+// (normally tsnet) and asks it to CONNECT to each address, for hosts that
+// are only reachable from the proxy. Credentials in proxy are sent with
+// Basic authentication.
+func NewHTTPConnectDialer(base Dialer, proxy *url.URL) Dialer {
+	return httpConnectDialer{base: base, proxy: proxy}
+}
+
+func (d httpConnectDialer) Dial(ctx context.Context, network, address string) (net.Conn, error) {
+	conn, err := d.base.Dial(ctx, network, d.proxy.Host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach HTTP proxy %s: %w", d.proxy.Host, err)
+	}
+
+	// The handshake has to respect ctx; the tunnel itself has no deadline
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	r, err := httpConnect(conn, address, d.proxy.User)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+
+	if r.Buffered() > 0 {
+		// The proxy may send the server's first bytes with its response
+		return &bufferedConn{Conn: conn, r: r}, nil
+	}
+	return conn, nil
+}
+
+// httpConnect sends a CONNECT request for address over conn and checks the
+// proxy's response. The returned reader holds anything the proxy sent after
+// the response headers.
+func httpConnect(conn net.Conn, address string, user *url.Userinfo) (*bufio.Reader, error) {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: make(http.Header),
+	}
+	if user != nil {
+		password, _ := user.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err := req.Write(conn); err != nil {
+		return nil, fmt.Errorf("failed to send CONNECT to HTTP proxy: %w", err)
+	}
+
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read HTTP proxy response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		// Any error page is dropped with the connection
+		return nil, fmt.Errorf("HTTP proxy refused CONNECT to %s: %s", address, resp.Status)
+	}
+	return r, nil
+}
+
+// bufferedConn reads through r first, so bytes read ahead aren't lost
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
@@ -0,0 +1,116 @@
+package ssh
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startConnectProxy runs a one-shot HTTP proxy that records the CONNECT
+// request and replies with response. With a 200 response it then writes
+// greeting in the same packet, as a proxy forwarding an SSH server would.
+func startConnectProxy(t *testing.T, response, greeting string) (addr string, requests <-chan *http.Request) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	reqs := make(chan *http.Request, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		req, err := http.ReadRequest(bufio.NewReader(conn))
+		if err != nil {
+			close(reqs)
+			return
+		}
+		reqs <- req
+		io.WriteString(conn, response+greeting)
+		io.Copy(io.Discard, conn)
+	}()
+	return listener.Addr().String(), reqs
+}
+
+func TestHTTPConnectDialer(t *testing.T) {
+	proxyAddr, requests := startConnectProxy(t, "HTTP/1.1 200 Connection established\r\n\r\n", "SSH-2.0-OpenSSH_9.6\r\n")
+	proxy, err := ParseHTTPProxyURL("http://squid:s3cret@" + proxyAddr)
+	if err != nil {
+		t.Fatalf("ParseHTTPProxyURL() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := NewHTTPConnectDialer(tcpDialer{}, proxy).Dial(ctx, "tcp", "db.internal:22")
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer conn.Close()
+
+	req := <-requests
+	if req.Method != http.MethodConnect || req.RequestURI != "db.internal:22" || req.Host != "db.internal:22" {
+		t.Errorf("request = %s %s (Host %s), want CONNECT db.internal:22", req.Method, req.RequestURI, req.Host)
+	}
+	// "squid:s3cret" in Basic encoding
+	if got := req.Header.Get("Proxy-Authorization"); got != "Basic c3F1aWQ6czNjcmV0" {
+		t.Errorf("Proxy-Authorization = %q", got)
+	}
+
+	// Bytes the proxy sent along with its response reach the SSH client
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || line != "SSH-2.0-OpenSSH_9.6\r\n" {
+		t.Errorf("tunnelled data = %q, %v", line, err)
+	}
+}
+
+func TestHTTPConnectDialerRefused(t *testing.T) {
+	proxyAddr, _ := startConnectProxy(t, "HTTP/1.1 403 Forbidden\r\nContent-Length: 6\r\n\r\ndenied", "")
+	proxy, err := ParseHTTPProxyURL("http://" + proxyAddr)
+	if err != nil {
+		t.Fatalf("ParseHTTPProxyURL() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = NewHTTPConnectDialer(tcpDialer{}, proxy).Dial(ctx, "tcp", "db.internal:22")
+	if err == nil || !strings.Contains(err.Error(), "403 Forbidden") {
+		t.Errorf("Dial() error = %v, want the proxy's refusal", err)
+	}
+}
+
+func TestParseHTTPProxyURL(t *testing.T) {
+	tests := []struct {
+		proxy   string
+		want    string
+		wantErr bool
+	}{
+		{proxy: "http://proxy:3128", want: "proxy:3128"},
+		{proxy: "http://proxy", want: "proxy:80"},
+		{proxy: "http://[fd7a:115c:a1e0::1]:3128/", want: "[fd7a:115c:a1e0::1]:3128"},
+		{proxy: "https://proxy:3128", wantErr: true},
+		{proxy: "socks5://proxy:1080", wantErr: true},
+		{proxy: "http://proxy:3128/path", wantErr: true},
+		{proxy: "proxy:3128", wantErr: true},
+	}
+	for _, tt := range tests {
+		u, err := ParseHTTPProxyURL(tt.proxy)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseHTTPProxyURL(%q) = %v, want an error", tt.proxy, u)
+			}
+			continue
+		}
+		if err != nil || u.Host != tt.want {
+			t.Errorf("ParseHTTPProxyURL(%q) = %v, %v, want host %s", tt.proxy, u, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package ssh
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// IdleTimer calls onIdle once when no activity has been recorded for the
+// timeout. Reads and writes through the wrappers from Reader and Writer
+// count as activity. A nil *IdleTimer disables the timeout, so callers can
+// wrap their streams unconditionally.
+type IdleTimer struct {
+	timeout time.Duration
+	timer   *time.Timer
+
+	mu      sync.Mutex
+	expired bool
+}
+
+// NewIdleTimer starts an idle timer, or returns nil when timeout is zero
+func NewIdleTimer(timeout time.Duration, onIdle func()) *IdleTimer {
+	if timeout <= 0 {
+		return nil
+	}
+	t := &IdleTimer{timeout: timeout}
+	t.timer = time.AfterFunc(timeout, func() {
+		t.mu.Lock()
+		t.expired = true
+		t.mu.Unlock()
+		onIdle()
+	})
+	return t
+}
+
+// Touch records activity and restarts the countdown, unless it already expired
+func (t *IdleTimer) Touch() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.expired {
+		t.timer.Reset(t.timeout)
+	}
+}
+
+// Stop cancels the timer without calling onIdle
+func (t *IdleTimer) Stop() {
+	if t != nil {
+		t.timer.Stop()
+	}
+}
+
+// Expired reports whether onIdle was called
+func (t *IdleTimer) Expired() bool {
+	if t == nil {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.expired
+}
+
+// Reader returns r with every successful read counted as activity
+func (t *IdleTimer) Reader(r io.Reader) io.Reader {
+	if t == nil || r == nil {
+		return r
+	}
+	return idleReader{r: r, timer: t}
+}
+
+// Writer returns w with every write counted as activity
+func (t *IdleTimer) Writer(w io.Writer) io.Writer {
+	if t == nil || w == nil {
+		return w
+	}
+	return idleWriter{w: w, timer: t}
+}
+
+type idleReader struct {
+	r     io.Reader
+	timer *IdleTimer
+}
+
+func (r idleReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.timer.Touch()
+	}
+	return n, err
+}
+
+type idleWriter struct {
+	w     io.Writer
+	timer *IdleTimer
+}
+
+func (w idleWriter) Write(p []byte) (int, error) {
+	if len(p) > 0 {
+		w.timer.Touch()
+	}
+	return w.w.Write(p)
+}
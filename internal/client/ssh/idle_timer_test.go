@@ -0,0 +1,63 @@
+package ssh
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIdleTimerClosesAfterInactivity(t *testing.T) {
+	closed := make(chan struct{})
+	timer := NewIdleTimer(50*time.Millisecond, func() { close(closed) })
+	defer timer.Stop()
+
+	// Activity on either stream keeps the session open
+	out := timer.Writer(io.Discard)
+	in := timer.Reader(strings.NewReader(strings.Repeat("x", 10)))
+	buf := make([]byte, 1)
+	for i := 0; i < 5; i++ {
+		time.Sleep(20 * time.Millisecond)
+		if i%2 == 0 {
+			out.Write([]byte("output"))
+		} else {
+			in.Read(buf)
+		}
+	}
+	select {
+	case <-closed:
+		t.Fatal("session closed while it was active")
+	default:
+	}
+	if timer.Expired() {
+		t.Fatal("Expired() = true while active")
+	}
+
+	// Then nothing happens, and the session is closed
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("session was not closed after the idle timeout")
+	}
+	if !timer.Expired() {
+		t.Error("Expired() = false after the idle timeout")
+	}
+}
+
+func TestIdleTimerDisabled(t *testing.T) {
+	timer := NewIdleTimer(0, func() { t.Error("disabled timer fired") })
+	if timer != nil {
+		t.Fatal("NewIdleTimer(0) should return nil")
+	}
+
+	// A nil timer passes streams through untouched
+	r := strings.NewReader("data")
+	if timer.Reader(r) != io.Reader(r) {
+		t.Error("nil timer should not wrap readers")
+	}
+	timer.Touch()
+	timer.Stop()
+	if timer.Expired() {
+		t.Error("nil timer should never expire")
+	}
+}
@@ -0,0 +1,183 @@
+package ssh
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// sessionControl is the part of *ssh.Session an interactive session drives,
+// so tests can substitute a fake
+type sessionControl interface {
+	WindowChange(h, w int) error
+	Wait() error
+	Close() error
+}
+
+// terminalIO is the local end of an interactive session. localTerminalIO
+// builds it from the process's own terminal; tests use pipes instead, so
+// nothing touches the real terminal or the saved terminal state.
+type terminalIO struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// IsTerminal enables raw mode, the escape sequences and resize forwarding
+	IsTerminal bool
+	// MakeRaw puts the terminal in raw mode and returns a function restoring it
+	MakeRaw func() (restore func(), err error)
+	// Size reports the local window size; Resized receives on each change
+	Size    func() (width, height int, err error)
+	Resized <-chan struct{}
+}
+
+// localTerminalIO returns the process's stdin, stdout and stderr as a
+// terminalIO. The returned function stops watching for window changes.
+func localTerminalIO() (terminalIO, func()) {
+	fd := int(os.Stdin.Fd())
+	tio := terminalIO{
+		Stdin:      os.Stdin,
+		Stdout:     os.Stdout,
+		Stderr:     os.Stderr,
+		IsTerminal: term.IsTerminal(fd),
+	}
+	if !tio.IsTerminal {
+		return tio, func() {}
+	}
+
+	// The raw state lives in the package registry so a signal handler can
+	// restore it too
+	tio.MakeRaw = func() (func(), error) {
+		if err := MakeTerminalRaw(fd); err != nil {
+			return nil, err
+		}
+		stop := RestoreTerminalOnSignal()
+		return func() {
+			RestoreTerminal()
+			stop()
+		}, nil
+	}
+	tio.Size = func() (int, int, error) { return TerminalSize(fd) }
+
+	resized, stop := watchWindowChanges()
+	tio.Resized = resized
+	return tio, stop
+}
+
+// consoleResizePollInterval is how often the window size is checked where
+// resizes aren't signalled
+const consoleResizePollInterval = 250 * time.Millisecond
+
+// pollWindowSize checks size every interval until stop is closed and
+// receives on resized when it differs from the last size seen. A change
+// already pending absorbs later ones.
+func pollWindowSize(stop <-chan struct{}, size func() (int, int, error), interval time.Duration, resized chan<- struct{}) {
+	lastWidth, lastHeight, _ := size()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			width, height, err := size()
+			if err != nil || (width == lastWidth && height == lastHeight) {
+				continue
+			}
+			lastWidth, lastHeight = width, height
+			select {
+			case resized <- struct{}{}:
+			default: // A change is already pending
+			}
+		}
+	}
+}
+
+// handleInteractiveSession runs a started shell session until it exits,
+// copying tio.Stdin to stdinPipe. At the end of input the remote stdin is
+// closed, which sends EOF; the session then runs until the remote side
+// exits. On a terminal the input is raw, ~. closes the session, ~? lists
+// the escapes and window changes are forwarded. Cancelling ctx closes the
+// session.
+func handleInteractiveSession(ctx context.Context, session sessionControl, stdinPipe io.WriteCloser, tio terminalIO, logger *log.Logger) error {
+	if tio.Stderr == nil {
+		tio.Stderr = io.Discard
+	}
+
+	in := tio.Stdin
+	var terminated atomic.Bool
+	if tio.IsTerminal {
+		if tio.MakeRaw != nil {
+			if restore, err := tio.MakeRaw(); err != nil {
+				logger.Printf("Warning: Failed to set terminal to raw mode: %v", err)
+			} else {
+				defer restore()
+			}
+		}
+		fmt.Fprint(tio.Stderr, "Use ~. to terminate connection, ~? for help\r\n")
+
+		if in != nil {
+			in = NewEscapeReader(in, tio.Stderr, func() {
+				terminated.Store(true)
+				fmt.Fprint(tio.Stderr, "Connection closed.\r\n")
+				session.Close()
+			})
+		}
+
+		if tio.Resized != nil && tio.Size != nil {
+			watchCtx, cancel := context.WithCancel(ctx)
+			defer cancel()
+			go forwardWindowChanges(watchCtx, session, tio.Resized, tio.Size, logger)
+		}
+	}
+
+	go func() {
+		if in != nil {
+			if _, err := io.Copy(stdinPipe, in); err != nil {
+				logger.Printf("Error reading stdin: %v", err)
+			}
+		}
+		if err := stdinPipe.Close(); err != nil {
+			logger.Printf("Error sending EOF: %v", err)
+		}
+	}()
+
+	err := waitSession(ctx, session)
+	if terminated.Load() {
+		return nil
+	}
+	return err
+}
+
+// forwardWindowChanges sends the local window size to session each time
+// resized receives, until ctx is done or the session has gone away
+func forwardWindowChanges(ctx context.Context, session sessionControl, resized <-chan struct{}, size func() (int, int, error), logger *log.Logger) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-resized:
+			width, height, err := size()
+			if err != nil {
+				logger.Printf("Error getting terminal size: %v", err)
+				continue
+			}
+			if width <= 0 || height <= 0 {
+				continue
+			}
+			if err := session.WindowChange(height, width); err != nil {
+				logger.Printf("Error sending window change: %v", err)
+				if strings.Contains(err.Error(), "EOF") || strings.Contains(err.Error(), "closed") {
+					return
+				}
+			}
+		}
+	}
+}
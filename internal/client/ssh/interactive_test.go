@@ -0,0 +1,234 @@
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSession stands in for a started *ssh.Session. Wait returns once the
+// remote side exits or the session is closed.
+type fakeSession struct {
+	mu            sync.Mutex
+	windowChanges [][2]int // height, width
+	closeOnce     sync.Once
+	closed        chan struct{}
+	exited        chan struct{}
+}
+
+func newFakeSession() *fakeSession {
+	return &fakeSession{closed: make(chan struct{}), exited: make(chan struct{})}
+}
+
+func (s *fakeSession) WindowChange(h, w int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.windowChanges = append(s.windowChanges, [2]int{h, w})
+	return nil
+}
+
+func (s *fakeSession) Wait() error {
+	select {
+	case <-s.exited:
+		return nil
+	case <-s.closed:
+		return errors.New("session closed without exit status")
+	}
+}
+
+func (s *fakeSession) Close() error {
+	s.closeOnce.Do(func() { close(s.closed) })
+	return nil
+}
+
+// fakeStdinPipe records what reaches the remote stdin and when EOF is sent
+type fakeStdinPipe struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+	eof chan struct{}
+}
+
+func (p *fakeStdinPipe) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.buf.Write(b)
+}
+
+func (p *fakeStdinPipe) Close() error {
+	close(p.eof)
+	return nil
+}
+
+// received waits for EOF and returns everything written before it
+func (p *fakeStdinPipe) received(t *testing.T) string {
+	t.Helper()
+	select {
+	case <-p.eof:
+	case <-time.After(5 * time.Second):
+		t.Fatal("remote stdin never got EOF")
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.buf.String()
+}
+
+// runInteractive runs handleInteractiveSession in the background
+func runInteractive(session *fakeSession, stdin *fakeStdinPipe, tio terminalIO) <-chan error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- handleInteractiveSession(context.Background(), session, stdin, tio, log.New(io.Discard, "", 0))
+	}()
+	return errCh
+}
+
+func waitResult(t *testing.T, errCh <-chan error) error {
+	t.Helper()
+	select {
+	case err := <-errCh:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("handleInteractiveSession() did not return")
+		return nil
+	}
+}
+
+func TestInteractiveSessionPassthrough(t *testing.T) {
+	session := newFakeSession()
+	stdin := &fakeStdinPipe{eof: make(chan struct{})}
+	var stderr bytes.Buffer
+
+	// Without a terminal "~." is ordinary input
+	errCh := runInteractive(session, stdin, terminalIO{Stdin: strings.NewReader("echo hi\n~.\n"), Stderr: &stderr})
+
+	if got := stdin.received(t); got != "echo hi\n~.\n" {
+		t.Errorf("remote stdin = %q, want input unchanged", got)
+	}
+
+	// EOF on input doesn't end the session; the remote side does
+	select {
+	case err := <-errCh:
+		t.Fatalf("handleInteractiveSession() returned %v before the remote shell exited", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(session.exited)
+	if err := waitResult(t, errCh); err != nil {
+		t.Errorf("handleInteractiveSession() error = %v", err)
+	}
+	if stderr.Len() != 0 {
+		t.Errorf("stderr = %q, want nothing without a terminal", stderr.String())
+	}
+}
+
+func TestInteractiveSessionEscapeTerminates(t *testing.T) {
+	session := newFakeSession()
+	stdin := &fakeStdinPipe{eof: make(chan struct{})}
+	var stderr bytes.Buffer
+	rawMode, restored := false, false
+	tio := terminalIO{
+		Stdin:      strings.NewReader("ls\r~.rm -rf /\r"),
+		Stderr:     &stderr,
+		IsTerminal: true,
+		MakeRaw: func() (func(), error) {
+			rawMode = true
+			return func() { restored = true }, nil
+		},
+	}
+
+	// The shell never exits on its own; ~. has to close the session
+	if err := waitResult(t, runInteractive(session, stdin, tio)); err != nil {
+		t.Errorf("handleInteractiveSession() error = %v, want nil after ~.", err)
+	}
+	if got := stdin.received(t); got != "ls\r" {
+		t.Errorf("remote stdin = %q, want input up to the escape", got)
+	}
+	if !rawMode || !restored {
+		t.Errorf("raw mode set = %v, restored = %v", rawMode, restored)
+	}
+	if !strings.Contains(stderr.String(), "Connection closed.") {
+		t.Errorf("stderr = %q, want a closed notice", stderr.String())
+	}
+}
+
+func TestInteractiveSessionEscapeHelp(t *testing.T) {
+	session := newFakeSession()
+	stdin := &fakeStdinPipe{eof: make(chan struct{})}
+	var stderr bytes.Buffer
+	tio := terminalIO{Stdin: strings.NewReader("~?~~x\r~."), Stderr: &stderr, IsTerminal: true}
+
+	if err := waitResult(t, runInteractive(session, stdin, tio)); err != nil {
+		t.Errorf("handleInteractiveSession() error = %v", err)
+	}
+	if !strings.Contains(stderr.String(), "Supported escape sequences:") {
+		t.Errorf("stderr = %q, want the escape help", stderr.String())
+	}
+	// ~? is not sent, and the help leaves the cursor at a new line for ~~
+	if got := stdin.received(t); got != "~x\r" {
+		t.Errorf("remote stdin = %q, want %q", got, "~x\r")
+	}
+}
+
+func TestInteractiveSessionWindowChanges(t *testing.T) {
+	session := newFakeSession()
+	stdin := &fakeStdinPipe{eof: make(chan struct{})}
+	inR, inW := io.Pipe()
+	defer inW.Close()
+	resized := make(chan struct{})
+	tio := terminalIO{
+		Stdin:      inR,
+		IsTerminal: true,
+		Size:       func() (int, int, error) { return 120, 40, nil },
+		Resized:    resized,
+	}
+
+	errCh := runInteractive(session, stdin, tio)
+	resized <- struct{}{}
+	resized <- struct{}{} // Unbuffered, so the first change has been handled
+
+	session.mu.Lock()
+	changes := append([][2]int(nil), session.windowChanges...)
+	session.mu.Unlock()
+	if len(changes) < 1 || changes[0] != [2]int{40, 120} {
+		t.Errorf("window changes = %v, want 40 rows by 120 columns", changes)
+	}
+
+	close(session.exited)
+	if err := waitResult(t, errCh); err != nil {
+		t.Errorf("handleInteractiveSession() error = %v", err)
+	}
+}
+
+func TestPollWindowSize(t *testing.T) {
+	var mu sync.Mutex
+	width, height := 80, 24
+	size := func() (int, int, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		return width, height, nil
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	resized := make(chan struct{}, 1)
+	go pollWindowSize(stop, size, time.Millisecond, resized)
+
+	// An unchanged size is not reported
+	select {
+	case <-resized:
+		t.Fatal("resize reported without a change")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	mu.Lock()
+	width = 120
+	mu.Unlock()
+	select {
+	case <-resized:
+	case <-time.After(5 * time.Second):
+		t.Fatal("resize not reported")
+	}
+}
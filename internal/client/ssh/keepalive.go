@@ -0,0 +1,66 @@
+package ssh
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// keepAliveRequest is the global request OpenSSH sends for ServerAliveInterval
+const keepAliveRequest = "keepalive@openssh.com"
+
+// DefaultKeepAliveCountMax is how many keepalives in a row may go unanswered
+// before the connection is given up, as OpenSSH's ServerAliveCountMax
+const DefaultKeepAliveCountMax = 3
+
+// keepAliveConn is the part of *ssh.Client keepalives use, so tests can
+// substitute a fake
+type keepAliveConn interface {
+	SendRequest(name string, wantReply bool, payload []byte) (bool, []byte, error)
+	Close() error
+}
+
+// KeepAlive sends a keepalive over conn every interval until ctx is done, so
+// idle forwards aren't dropped by a NAT. Any reply, even a refusal, shows the
+// server is still there. When countMax intervals (DefaultKeepAliveCountMax
+// if 0) pass without one, conn is closed so whatever waits on it sees the
+// connection lost instead of hanging. It returns ctx's error, or why the
+// connection ended.
+func KeepAlive(ctx context.Context, conn keepAliveConn, interval time.Duration, countMax int, logger *log.Logger) error {
+	if countMax <= 0 {
+		countMax = DefaultKeepAliveCountMax
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	replies := make(chan error, 1)
+	pending, missed := false, 0
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-replies:
+			pending = false
+			if err != nil {
+				return fmt.Errorf("keepalive failed: %w", err)
+			}
+			missed = 0
+		case <-ticker.C:
+			if pending {
+				missed++
+				if missed >= countMax {
+					logger.Printf("No keepalive reply for %v; closing the connection", interval*time.Duration(countMax))
+					conn.Close()
+					return fmt.Errorf("server stopped answering keepalives for %v", interval*time.Duration(countMax))
+				}
+				continue
+			}
+			pending = true
+			go func() {
+				_, _, err := conn.SendRequest(keepAliveRequest, true, nil)
+				replies <- err
+			}()
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package ssh
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeKeepAliveConn counts keepalives; a silent one never answers until closed
+type fakeKeepAliveConn struct {
+	mu       sync.Mutex
+	requests []string
+	silent   bool
+	closed   chan struct{}
+	once     sync.Once
+}
+
+func newFakeKeepAliveConn(silent bool) *fakeKeepAliveConn {
+	return &fakeKeepAliveConn{silent: silent, closed: make(chan struct{})}
+}
+
+func (c *fakeKeepAliveConn) SendRequest(name string, wantReply bool, payload []byte) (bool, []byte, error) {
+	c.mu.Lock()
+	c.requests = append(c.requests, name)
+	c.mu.Unlock()
+	if c.silent {
+		<-c.closed
+		return false, nil, io.EOF
+	}
+	// Servers refuse requests they don't know; that still counts as alive
+	return false, nil, nil
+}
+
+func (c *fakeKeepAliveConn) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return nil
+}
+
+func (c *fakeKeepAliveConn) sent() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.requests...)
+}
+
+func TestKeepAliveDuringForwarding(t *testing.T) {
+	conn := newFakeKeepAliveConn(false)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- KeepAlive(ctx, conn, 5*time.Millisecond, 2, log.New(io.Discard, "", 0)) }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(conn.sent()) < 3 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("KeepAlive() error = %v, want context.Canceled", err)
+	}
+
+	sent := conn.sent()
+	if len(sent) < 3 {
+		t.Fatalf("sent %d keepalives, want at least 3", len(sent))
+	}
+	for _, name := range sent {
+		if name != "keepalive@openssh.com" {
+			t.Errorf("sent request %q, want keepalive@openssh.com", name)
+		}
+	}
+	select {
+	case <-conn.closed:
+		t.Error("KeepAlive() closed a connection that was answering")
+	default:
+	}
+}
+
+func TestKeepAliveClosesUnansweredConnection(t *testing.T) {
+	conn := newFakeKeepAliveConn(true)
+	done := make(chan error, 1)
+	go func() {
+		done <- KeepAlive(context.Background(), conn, 5*time.Millisecond, 2, log.New(io.Discard, "", 0))
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("KeepAlive() = nil, want an error for a silent server")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("KeepAlive() did not give up on a silent server")
+	}
+	select {
+	case <-conn.closed:
+	default:
+		t.Error("connection left open after missed keepalives")
+	}
+	// Only one keepalive is outstanding at a time
+	if n := len(conn.sent()); n != 1 {
+		t.Errorf("sent %d keepalives to a silent server, want 1", n)
+	}
+}
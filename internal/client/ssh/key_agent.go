@@ -0,0 +1,138 @@
+package ssh
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// AddKeysToAgent is the -add-keys-to-agent setting, after OpenSSH's option
+// of the same name
+type AddKeysToAgent struct {
+	Enabled  bool          // Add keys once their passphrase is entered
+	Confirm  bool          // Have the agent confirm each use of an added key
+	Lifetime time.Duration // Drop added keys from the agent after this long; 0 keeps them
+}
+
+// ParseAddKeysToAgent parses "no", "yes", "confirm", a lifetime such as
+// "1h" or "3600" (seconds), or "yes" or "confirm" followed by a lifetime
+func ParseAddKeysToAgent(s string) (AddKeysToAgent, error) {
+	fields := strings.Fields(strings.ToLower(s))
+	if len(fields) == 0 || (len(fields) == 1 && fields[0] == "no") {
+		return AddKeysToAgent{}, nil
+	}
+
+	opts := AddKeysToAgent{Enabled: true}
+	switch fields[0] {
+	case "yes":
+		fields = fields[1:]
+	case "confirm":
+		opts.Confirm = true
+		fields = fields[1:]
+	}
+	switch len(fields) {
+	case 0:
+		return opts, nil
+	case 1:
+		lifetime, err := parseAgentLifetime(fields[0])
+		if err != nil {
+			return AddKeysToAgent{}, fmt.Errorf("invalid -add-keys-to-agent %q: %w", s, err)
+		}
+		opts.Lifetime = lifetime
+		return opts, nil
+	}
+	return AddKeysToAgent{}, fmt.Errorf("invalid -add-keys-to-agent %q: use no, yes, confirm or a lifetime", s)
+}
+
+// parseAgentLifetime parses a Go duration or a number of seconds
+func parseAgentLifetime(s string) (time.Duration, error) {
+	lifetime, err := time.ParseDuration(s)
+	if err != nil {
+		secs, serr := strconv.ParseUint(s, 10, 32)
+		if serr != nil {
+			return 0, fmt.Errorf("bad lifetime %q", s)
+		}
+		lifetime = time.Duration(secs) * time.Second
+	}
+	if lifetime < time.Second || lifetime.Seconds() > math.MaxUint32 {
+		return 0, fmt.Errorf("lifetime %q out of range", s)
+	}
+	return lifetime, nil
+}
+
+// keyAgentSetting is the agent set by UseKeyAgent and what to do with it
+type keyAgentSetting struct {
+	agent agent.Agent
+	opts  AddKeysToAgent
+}
+
+// keyAgent is set once at startup; until then no agent is used
+var keyAgent atomic.Pointer[keyAgentSetting]
+
+// UseKeyAgent makes loading a passphrase-protected key take it from a when
+// a holds it, so the passphrase isn't asked for again, and add it to a once
+// its passphrase has been entered. A nil a, or opts that aren't Enabled,
+// turn this off.
+func UseKeyAgent(a agent.Agent, opts AddKeysToAgent) {
+	if a == nil || !opts.Enabled {
+		keyAgent.Store(nil)
+		return
+	}
+	keyAgent.Store(&keyAgentSetting{agent: a, opts: opts})
+}
+
+// DialKeyAgent connects to the agent listening on SSH_AUTH_SOCK. The
+// returned connection must be closed once no more keys will be loaded.
+func DialKeyAgent() (agent.ExtendedAgent, net.Conn, error) {
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if sock == "" {
+		return nil, nil, errors.New("no SSH agent: SSH_AUTH_SOCK is not set")
+	}
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect to the SSH agent: %w", err)
+	}
+	return agent.NewClient(conn), conn, nil
+}
+
+// agentSigner returns the agent's signer for pub, or nil when the agent
+// doesn't hold it
+func (s *keyAgentSetting) agentSigner(pub ssh.PublicKey) ssh.Signer {
+	signers, err := s.agent.Signers()
+	if err != nil {
+		return nil
+	}
+	for _, signer := range signers {
+		if bytes.Equal(signer.PublicKey().Marshal(), pub.Marshal()) {
+			return signer
+		}
+	}
+	return nil
+}
+
+// add gives the decrypted key to the agent; a failure only costs a prompt
+// next time, so it is logged rather than returned
+func (s *keyAgentSetting) add(rawKey interface{}, name string, logger *log.Logger) {
+	err := s.agent.Add(agent.AddedKey{
+		PrivateKey:       rawKey,
+		Comment:          name,
+		LifetimeSecs:     uint32(s.opts.Lifetime / time.Second),
+		ConfirmBeforeUse: s.opts.Confirm,
+	})
+	if err != nil {
+		logger.Printf("Could not add key %s to the SSH agent: %v", name, err)
+		return
+	}
+	logger.Printf("Added key %s to the SSH agent", name)
+}
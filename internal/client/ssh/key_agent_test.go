@@ -0,0 +1,133 @@
+package ssh
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// recordingAgent is an in-memory agent that records how keys were added
+type recordingAgent struct {
+	agent.Agent
+	added []agent.AddedKey
+}
+
+func (a *recordingAgent) Add(key agent.AddedKey) error {
+	a.added = append(a.added, key)
+	return a.Agent.Add(key)
+}
+
+func TestAddKeysToAgentAfterLoad(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("askpass test uses a shell script")
+	}
+	logger := log.New(io.Discard, "", 0)
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKeyWithPassphrase(priv, "", []byte("s3cret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM := pem.EncodeToMemory(block)
+
+	askpass := func(script string) {
+		path := filepath.Join(t.TempDir(), "askpass")
+		if err := os.WriteFile(path, []byte(script), 0700); err != nil {
+			t.Fatal(err)
+		}
+		t.Setenv("SSH_ASKPASS", path)
+		t.Setenv("SSH_ASKPASS_REQUIRE", "force")
+	}
+
+	fake := &recordingAgent{Agent: agent.NewKeyring()}
+	UseKeyAgent(fake, AddKeysToAgent{Enabled: true, Confirm: true, Lifetime: time.Hour})
+	defer UseKeyAgent(nil, AddKeysToAgent{})
+
+	// The first load asks for the passphrase and adds the key
+	askpass("#!/bin/sh\necho s3cret\n")
+	if _, err := LoadPrivateKeyFrom(bytes.NewReader(keyPEM), "deploy-key", logger); err != nil {
+		t.Fatalf("LoadPrivateKeyFrom() error = %v", err)
+	}
+	if len(fake.added) != 1 {
+		t.Fatalf("agent got %d keys, want 1", len(fake.added))
+	}
+	added := fake.added[0]
+	if added.Comment != "deploy-key" || !added.ConfirmBeforeUse || added.LifetimeSecs != 3600 {
+		t.Errorf("added key comment %q, confirm %v, lifetime %d", added.Comment, added.ConfirmBeforeUse, added.LifetimeSecs)
+	}
+	keys, err := fake.List()
+	if err != nil || len(keys) != 1 || !bytes.Equal(keys[0].Marshal(), want.Marshal()) {
+		t.Fatalf("agent keys = %v, %v, want the loaded key", keys, err)
+	}
+
+	// The next load takes it from the agent without asking again
+	askpass("#!/bin/sh\nexit 1\n")
+	signer, err := LoadPrivateKeyFrom(bytes.NewReader(keyPEM), "deploy-key", logger)
+	if err != nil {
+		t.Fatalf("second LoadPrivateKeyFrom() error = %v, want the agent's key", err)
+	}
+	if !bytes.Equal(signer.PublicKey().Marshal(), want.Marshal()) {
+		t.Error("second load returned a different key")
+	}
+	if len(fake.added) != 1 {
+		t.Errorf("agent got %d keys, want the key added once", len(fake.added))
+	}
+
+	// Without the setting the agent is left alone
+	UseKeyAgent(fake, AddKeysToAgent{})
+	askpass("#!/bin/sh\necho s3cret\n")
+	if _, err := LoadPrivateKeyFrom(bytes.NewReader(keyPEM), "deploy-key", logger); err != nil {
+		t.Fatalf("LoadPrivateKeyFrom() error = %v", err)
+	}
+	if len(fake.added) != 1 {
+		t.Errorf("agent got a key with -add-keys-to-agent off")
+	}
+}
+
+func TestParseAddKeysToAgent(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    AddKeysToAgent
+		wantErr bool
+	}{
+		{in: "no", want: AddKeysToAgent{}},
+		{in: "", want: AddKeysToAgent{}},
+		{in: "yes", want: AddKeysToAgent{Enabled: true}},
+		{in: "confirm", want: AddKeysToAgent{Enabled: true, Confirm: true}},
+		{in: "1h", want: AddKeysToAgent{Enabled: true, Lifetime: time.Hour}},
+		{in: "600", want: AddKeysToAgent{Enabled: true, Lifetime: 10 * time.Minute}},
+		{in: "confirm 30m", want: AddKeysToAgent{Enabled: true, Confirm: true, Lifetime: 30 * time.Minute}},
+		{in: "yes 2h", want: AddKeysToAgent{Enabled: true, Lifetime: 2 * time.Hour}},
+		{in: "ask", wantErr: true},
+		{in: "confirm soon", wantErr: true},
+		{in: "0", wantErr: true},
+		{in: "yes 1h extra", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseAddKeysToAgent(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseAddKeysToAgent(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseAddKeysToAgent(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
@@ -96,6 +96,15 @@ func GetDefaultSSHKeyPath(currentUser *user.User, logger *log.Logger) string {
 // LoadBestPrivateKey attempts to load SSH keys in order of preference
 // This function tries multiple key types automatically rather than relying on a single path
 func LoadBestPrivateKey(homeDir string, logger *log.Logger) (keyPath string, authMethod ssh.AuthMethod, err error) {
+	keyPath, signer, err := loadBestPrivateKeySigner(homeDir, logger)
+	if err != nil {
+		return "", nil, err
+	}
+	return keyPath, ssh.PublicKeys(signer), nil
+}
+
+// loadBestPrivateKeySigner does the work of LoadBestPrivateKey and returns the signer itself
+func loadBestPrivateKeySigner(homeDir string, logger *log.Logger) (string, ssh.Signer, error) {
 	if homeDir == "" {
 		return "", nil, fmt.Errorf("home directory is required for key discovery")
 	}
@@ -104,7 +113,7 @@ func LoadBestPrivateKey(homeDir string, logger *log.Logger) (keyPath string, aut
 
 	// Try each key type in order of preference
 	for _, keyType := range ModernKeyTypes {
-		keyPath = filepath.Join(sshDir, keyType)
+		keyPath := filepath.Join(sshDir, keyType)
 
 		// Check if key exists
 		if _, err := os.Stat(keyPath); err != nil {
@@ -112,10 +121,10 @@ func LoadBestPrivateKey(homeDir string, logger *log.Logger) (keyPath string, aut
 		}
 
 		// Try to load the key
-		authMethod, loadErr := LoadPrivateKey(keyPath, logger)
+		signer, loadErr := loadPrivateKeySigner(keyPath, logger)
 		if loadErr == nil {
 			logSafe(logger, "Successfully loaded SSH key: %s (type: %s)", keyPath, keyType)
-			return keyPath, authMethod, nil
+			return keyPath, signer, nil
 		} else {
 			logSafe(logger, "Failed to load %s key at %s: %v", keyType, keyPath, loadErr)
 		}
@@ -124,16 +133,86 @@ func LoadBestPrivateKey(homeDir string, logger *log.Logger) (keyPath string, aut
 	return "", nil, fmt.Errorf("no usable SSH private keys found in %s (searched: %v)", sshDir, ModernKeyTypes)
 }
 
+// readPassword reads a password without echo; tests replace it
+var readPassword = security.ReadPasswordSecurely
+
+// promptInput reads an echoed answer from the secure TTY; tests replace it
+var promptInput = security.PromptUserSecurely
+
+// keyboardInteractiveChallenge answers keyboard-interactive challenges such as
+// OTP prompts from the secure TTY. Answers the server marks as secret are
+// read without echo. The server's name, instruction and questions are shown
+// without control characters, as banners are.
+func keyboardInteractiveChallenge(sshUser, targetHost string, slot *authSlot) ssh.KeyboardInteractiveChallenge {
+	return func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+		name, instruction = stripControl(name), stripControl(instruction)
+		if len(questions) == 0 {
+			// Informational round (e.g. a banner); nothing to answer
+			if instruction != "" {
+				fmt.Fprintln(os.Stderr, instruction)
+			}
+			return nil, nil
+		}
+
+		// Other connections may authenticate while the user answers
+		slot.release()
+		if name != "" {
+			fmt.Fprintln(os.Stderr, name)
+		}
+		if instruction != "" {
+			fmt.Fprintln(os.Stderr, instruction)
+		}
+
+		answers := make([]string, len(questions))
+		for i, question := range questions {
+			question = stripControl(question)
+			if question == "" {
+				question = fmt.Sprintf("Response for %s@%s: ", sshUser, targetHost)
+			}
+
+			var answer string
+			var err error
+			if i < len(echos) && echos[i] {
+				answer, err = promptInput(question)
+			} else {
+				fmt.Fprint(os.Stderr, question)
+				answer, err = readPassword()
+				fmt.Fprintln(os.Stderr)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to read keyboard-interactive response securely: %w", err)
+			}
+			answers[i] = answer
+		}
+		slot.attempt()
+		return answers, nil
+	}
+}
+
 // createModernSSHAuthMethods creates authentication methods with automatic key discovery
-// This is an enhanced version of createSSHAuthMethods that prioritizes modern key types
-func createModernSSHAuthMethods(keyPath, sshUser, targetHost string, currentUser *user.User, logger *log.Logger) ([]ssh.AuthMethod, error) {
+// This is an enhanced version of createSSHAuthMethods that prioritizes modern key types.
+// An identity loaded elsewhere (e.g. from stdin) is offered instead of keyPath
+// and discovered keys.
+// When identitiesOnly is set, only the explicitly specified key is offered (like
+// OpenSSH's IdentitiesOnly), so servers with low MaxAuthTries don't reject us.
+// Every attempt goes through slot (which may be nil) to space out retries and
+// cap concurrent authentication; prompts happen outside it.
+// When passwords is non-nil a previously entered password is tried first, and
+// a newly entered one is cached for the next host. keyboardInteractive adds
+// keyboard-interactive auth (e.g. OTP prompts) between keys and password, as
+// OpenSSH orders them.
+func createModernSSHAuthMethods(keyPath string, identity ssh.Signer, sshUser, targetHost string, currentUser *user.User, identitiesOnly, keyboardInteractive bool, slot *authSlot, passwords *PasswordCache, logger *log.Logger) ([]ssh.AuthMethod, error) {
 	var authMethods []ssh.AuthMethod
+	var signers []ssh.Signer
 
-	// If a specific key path is provided, try it first
-	if keyPath != "" {
-		keyAuth, err := LoadPrivateKey(keyPath, logger)
+	// If a specific key is provided, try it first
+	if identity != nil {
+		signers = append(signers, identity)
+		logSafe(logger, "Using supplied identity (%s)", identity.PublicKey().Type())
+	} else if keyPath != "" {
+		signer, err := loadPrivateKeySigner(keyPath, logger)
 		if err == nil {
-			authMethods = append(authMethods, keyAuth)
+			signers = append(signers, signer)
 			logSafe(logger, "Using specified key: %s", keyPath)
 		} else {
 			logSafe(logger, "Specified key failed to load: %v", err)
@@ -142,29 +221,59 @@ func createModernSSHAuthMethods(keyPath, sshUser, targetHost string, currentUser
 	}
 
 	// If no specific key provided or if it failed, try automatic discovery
-	if len(authMethods) == 0 && currentUser != nil {
-		discoveredKeyPath, keyAuth, err := LoadBestPrivateKey(currentUser.HomeDir, logger)
-		if err == nil && keyAuth != nil {
-			authMethods = append(authMethods, keyAuth)
+	if len(signers) == 0 && identitiesOnly {
+		logSafe(logger, "IdentitiesOnly set, skipping automatic key discovery")
+	} else if len(signers) == 0 && currentUser != nil {
+		discoveredKeyPath, signer, err := loadBestPrivateKeySigner(currentUser.HomeDir, logger)
+		if err == nil && signer != nil {
+			signers = append(signers, signer)
 			logSafe(logger, "Using discovered key: %s", discoveredKeyPath)
 		} else {
 			logSafe(logger, "Key discovery failed: %v", err)
 		}
 	}
 
+	if len(signers) > 0 {
+		authMethods = append(authMethods, ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+			slot.attempt()
+			return signers, nil
+		}))
+	}
+
+	if keyboardInteractive {
+		authMethods = append(authMethods, ssh.KeyboardInteractive(keyboardInteractiveChallenge(sshUser, targetHost, slot)))
+	}
+
 	// Add password authentication as fallback using secure TTY
-	authMethods = append(authMethods, ssh.PasswordCallback(func() (string, error) {
+	triedCached := false
+	passwordAuth := ssh.PasswordCallback(func() (string, error) {
+		if !triedCached {
+			triedCached = true
+			if password, ok := passwords.Get(); ok {
+				logSafe(logger, "Using cached password for %s@%s", sshUser, targetHost)
+				slot.attempt()
+				return password, nil
+			}
+		}
+		slot.release()
 		fmt.Printf("Enter password for %s@%s: ", sshUser, targetHost)
-		password, err := security.ReadPasswordSecurely()
+		password, err := readPassword()
 		fmt.Println()
 		if err != nil {
 			return "", fmt.Errorf("failed to read password securely: %w", err)
 		}
+		passwords.Set(password)
+		slot.attempt()
 		return password, nil
-	}))
+	})
+	if passwords != nil {
+		// Allow a prompt after a cached password from another host is rejected
+		passwordAuth = ssh.RetryableAuthMethod(passwordAuth, 2)
+	}
+	authMethods = append(authMethods, passwordAuth)
 
-	logSafe(logger, "Created %d authentication methods (key-based: %d, password: 1)",
-		len(authMethods), len(authMethods)-1)
+	logSafe(logger, "Created %d authentication methods (key-based: %d, keyboard-interactive: %t, password: 1)",
+		len(authMethods), len(signers), keyboardInteractive)
 
 	return authMethods, nil
 }
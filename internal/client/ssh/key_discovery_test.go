@@ -1,11 +1,13 @@
 package ssh
 
 import (
+	"errors"
 	"io"
 	"log"
 	"os"
 	"os/user"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -205,3 +207,135 @@ func TestLegacyCompatibility(t *testing.T) {
 		t.Errorf("Expected to find RSA key %s in legacy setup, got %s", rsaPath, result)
 	}
 }
+
+// TestIdentitiesOnlySkipsDiscovery ensures discovered keys are not offered when IdentitiesOnly is set
+func TestIdentitiesOnlySkipsDiscovery(t *testing.T) {
+	tempHome, err := os.MkdirTemp("", "ssh-identities-only-test-*")
+	if err != nil {
+		t.Fatalf("Failed to create temp home: %v", err)
+	}
+	defer os.RemoveAll(tempHome)
+
+	sshDir := filepath.Join(tempHome, ".ssh")
+	if err := os.MkdirAll(sshDir, 0700); err != nil {
+		t.Fatalf("Failed to create .ssh dir: %v", err)
+	}
+
+	privKey, _ := generateTestKeyPair(t)
+	if err := writePrivateKeyToFile(privKey, filepath.Join(sshDir, "id_rsa")); err != nil {
+		t.Fatalf("Failed to write discoverable key: %v", err)
+	}
+
+	logger := log.New(io.Discard, "", 0)
+	currentUser := &user.User{HomeDir: tempHome}
+
+	// Without IdentitiesOnly the discovered key is offered before the password fallback
+	methods, err := createModernSSHAuthMethods("", nil, "testuser", "testhost", currentUser, false, false, nil, nil, logger)
+	if err != nil {
+		t.Fatalf("createModernSSHAuthMethods() error = %v", err)
+	}
+	if len(methods) != 2 {
+		t.Errorf("Expected discovered key + password (2 methods), got %d", len(methods))
+	}
+
+	// With IdentitiesOnly only the password fallback remains
+	methods, err = createModernSSHAuthMethods("", nil, "testuser", "testhost", currentUser, true, false, nil, nil, logger)
+	if err != nil {
+		t.Fatalf("createModernSSHAuthMethods() error = %v", err)
+	}
+	if len(methods) != 1 {
+		t.Errorf("Expected only password auth with IdentitiesOnly, got %d methods", len(methods))
+	}
+}
+
+func TestKeyboardInteractiveChallenge(t *testing.T) {
+	origPrompt, origRead := promptInput, readPassword
+	defer func() { promptInput, readPassword = origPrompt, origRead }()
+
+	var prompted, secret []string
+	promptInput = func(prompt string) (string, error) {
+		prompted = append(prompted, prompt)
+		return "alice", nil
+	}
+	readPassword = func() (string, error) {
+		secret = append(secret, "read")
+		return "123456", nil
+	}
+
+	challenge := keyboardInteractiveChallenge("alice", "vpn-gw", nil)
+
+	// Echoed questions use the prompt, hidden ones are read without echo
+	answers, err := challenge("", "Two-factor login", []string{"Username: ", "Verification code: "}, []bool{true, false})
+	if err != nil {
+		t.Fatalf("challenge() error = %v", err)
+	}
+	if len(answers) != 2 || answers[0] != "alice" || answers[1] != "123456" {
+		t.Errorf("challenge() answers = %q, want [alice 123456]", answers)
+	}
+	if len(prompted) != 1 || prompted[0] != "Username: " || len(secret) != 1 {
+		t.Errorf("prompted %q and read %d secrets, want one of each", prompted, len(secret))
+	}
+
+	// An informational round without questions needs no answers
+	answers, err = challenge("", "Welcome", nil, nil)
+	if err != nil || len(answers) != 0 {
+		t.Errorf("challenge() with no questions = %q, %v", answers, err)
+	}
+
+	readPassword = func() (string, error) { return "", errors.New("no tty") }
+	if _, err := challenge("", "", []string{"OTP: "}, []bool{false}); err == nil {
+		t.Error("challenge() should fail when the response cannot be read")
+	}
+}
+
+func TestKeyboardInteractiveChallengeStripsControlCharacters(t *testing.T) {
+	origPrompt, origRead, origStderr := promptInput, readPassword, os.Stderr
+	defer func() { promptInput, readPassword, os.Stderr = origPrompt, origRead, origStderr }()
+
+	var prompted []string
+	promptInput = func(prompt string) (string, error) {
+		prompted = append(prompted, prompt)
+		return "alice", nil
+	}
+	readPassword = func() (string, error) { return "123456", nil }
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Failed to create pipe: %v", err)
+	}
+	os.Stderr = w
+
+	challenge := keyboardInteractiveChallenge("alice", "vpn-gw", nil)
+	_, err = challenge("Login\x1b]0;pwned\x07", "Enter\tyour code\x1b[2J\n",
+		[]string{"User\x1b[8m: ", "Code\x00\x1b[1A: "}, []bool{true, false})
+	w.Close()
+	os.Stderr = origStderr
+	if err != nil {
+		t.Fatalf("challenge() error = %v", err)
+	}
+	shown, _ := io.ReadAll(r)
+
+	if strings.ContainsRune(string(shown), 0x1b) || strings.ContainsRune(string(shown), 0) {
+		t.Errorf("challenge wrote control characters to stderr: %q", shown)
+	}
+	for _, want := range []string{"Login]0;pwned\n", "Enter\tyour code[2J\n", "Code[1A: "} {
+		if !strings.Contains(string(shown), want) {
+			t.Errorf("stderr = %q, want it to contain %q", shown, want)
+		}
+	}
+	if len(prompted) != 1 || prompted[0] != "User[8m: " {
+		t.Errorf("prompted %q, want the question without control characters", prompted)
+	}
+}
+
+func TestKeyboardInteractiveAuthMethod(t *testing.T) {
+	logger := log.New(io.Discard, "", 0)
+
+	methods, err := createModernSSHAuthMethods("", nil, "testuser", "testhost", nil, true, true, nil, nil, logger)
+	if err != nil {
+		t.Fatalf("createModernSSHAuthMethods() error = %v", err)
+	}
+	if len(methods) != 2 {
+		t.Errorf("Expected keyboard-interactive + password (2 methods), got %d", len(methods))
+	}
+}
@@ -124,6 +124,22 @@ type MockSSHServer struct {
 
 // startMockSSHServer starts a mock SSH server that accepts the given public key
 func startMockSSHServer(t *testing.T, authorizedKey ssh.PublicKey) (string, func()) {
+	return listenMockSSHServer(t, &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			// Check if the provided key matches our authorized key
+			if string(key.Marshal()) == string(authorizedKey.Marshal()) {
+				return &ssh.Permissions{}, nil
+			}
+			return nil, fmt.Errorf("authentication failed")
+		},
+	})
+}
+
+// listenMockSSHServer starts a mock SSH server that authenticates clients
+// with config, under a freshly generated host key
+func listenMockSSHServer(t *testing.T, config *ssh.ServerConfig) (string, func()) {
+	t.Helper()
+
 	// Generate server host key
 	serverPrivKey, err := rsa.GenerateKey(rand.Reader, 2048)
 	if err != nil {
@@ -134,17 +150,6 @@ func startMockSSHServer(t *testing.T, authorizedKey ssh.PublicKey) (string, func
 	if err != nil {
 		t.Fatalf("Failed to create server signer: %v", err)
 	}
-
-	// Create SSH server config
-	config := &ssh.ServerConfig{
-		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
-			// Check if the provided key matches our authorized key
-			if string(key.Marshal()) == string(authorizedKey.Marshal()) {
-				return &ssh.Permissions{}, nil
-			}
-			return nil, fmt.Errorf("authentication failed")
-		},
-	}
 	config.AddHostKey(serverKey)
 
 	// Start listening
@@ -246,7 +251,7 @@ func testSSHConnection(t *testing.T, serverAddr, keyPath string, expectSuccess b
 	}
 
 	// Create SSH config
-	sshConfig, err := createSSHConfig(config)
+	sshConfig, err := createSSHConfig(config, nil)
 	if err != nil {
 		t.Fatalf("Failed to create SSH config: %v", err)
 	}
@@ -326,7 +331,7 @@ func TestCreateSSHAuthMethodsMock(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			logger := createTestLogger()
 
-			authMethods, err := createSSHAuthMethods(tt.keyPath, tt.user, tt.targetHost, logger)
+			authMethods, err := createSSHAuthMethods(tt.keyPath, nil, tt.user, tt.targetHost, false, false, nil, nil, logger)
 
 			if tt.expectError && err == nil {
 				t.Error("Expected error but got none")
@@ -0,0 +1,194 @@
+package ssh
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+)
+
+// OSC52Mode is the -osc52 setting: what happens to OSC 52 clipboard
+// sequences in remote output
+type OSC52Mode string
+
+const (
+	OSC52Pass OSC52Mode = "pass" // Sequences reach the local terminal unmodified
+	OSC52Copy OSC52Mode = "copy" // Passed through and copied to the system clipboard
+	OSC52Off  OSC52Mode = "off"  // Dropped, so the remote can't set the clipboard
+)
+
+// ParseOSC52Mode parses a -osc52 value
+func ParseOSC52Mode(s string) (OSC52Mode, error) {
+	switch mode := OSC52Mode(s); mode {
+	case OSC52Pass, OSC52Copy, OSC52Off:
+		return mode, nil
+	}
+	return "", fmt.Errorf("invalid -osc52 mode %q: use pass, copy or off", s)
+}
+
+// osc52Start begins an OSC 52 sequence: ESC ] 52 ; selection ; base64 data,
+// ended by BEL or ESC \
+var osc52Start = []byte("\x1b]52;")
+
+// maxOSC52Size bounds a sequence held while waiting for its terminator.
+// Anything longer is passed on as ordinary output, or in OSC52Off mode
+// dropped up to its terminator without being held.
+const maxOSC52Size = 1 << 20
+
+// OSC52Writer passes output through to w while picking out OSC 52 clipboard
+// sequences, which may be split across writes. Each complete sequence that
+// sets the clipboard is decoded and given to onCopy; queries ("?") are not.
+// In OSC52Off mode the sequences are dropped instead of written, whatever
+// their length.
+type OSC52Writer struct {
+	w          io.Writer
+	mode       OSC52Mode
+	onCopy     func(data []byte)
+	pending    []byte // A possible sequence waiting for more output
+	discarding bool   // Inside an overlong sequence being dropped
+}
+
+// NewOSC52Writer returns an OSC52Writer over w. onCopy may be nil.
+func NewOSC52Writer(w io.Writer, mode OSC52Mode, onCopy func(data []byte)) *OSC52Writer {
+	return &OSC52Writer{w: w, mode: mode, onCopy: onCopy}
+}
+
+func (o *OSC52Writer) Write(p []byte) (int, error) {
+	data := p
+	if len(o.pending) > 0 {
+		data = append(o.pending, p...)
+		o.pending = nil
+	}
+
+	for len(data) > 0 {
+		if o.discarding {
+			end, termLen := osc52End(data)
+			if end < 0 {
+				// A trailing ESC may be the first half of ESC \
+				if data[len(data)-1] == '\x1b' {
+					o.hold(data[len(data)-1:])
+				}
+				break
+			}
+			o.discarding = false
+			data = data[end+termLen:]
+			continue
+		}
+
+		start := bytes.Index(data, osc52Start)
+		if start < 0 {
+			// Hold back a trailing partial start such as a lone ESC
+			keep := partialPrefixLen(data, osc52Start)
+			if err := o.emit(data[:len(data)-keep]); err != nil {
+				return 0, err
+			}
+			o.hold(data[len(data)-keep:])
+			break
+		}
+		if err := o.emit(data[:start]); err != nil {
+			return 0, err
+		}
+		data = data[start:]
+
+		end, termLen := osc52End(data[len(osc52Start):])
+		if end < 0 {
+			if len(data) > maxOSC52Size {
+				if o.mode == OSC52Off {
+					// Drop the rest too, so its terminator and data can't
+					// reach the terminal as a sequence after all
+					o.discarding = true
+					data = data[len(osc52Start):]
+					continue
+				}
+				// Not a clipboard update anyone would send; let it through
+				if err := o.emit(data); err != nil {
+					return 0, err
+				}
+				break
+			}
+			o.hold(data)
+			break
+		}
+		seqLen := len(osc52Start) + end + termLen
+		o.handle(data[len(osc52Start) : len(osc52Start)+end])
+		if o.mode != OSC52Off {
+			if err := o.emit(data[:seqLen]); err != nil {
+				return 0, err
+			}
+		}
+		data = data[seqLen:]
+	}
+	return len(p), nil
+}
+
+// Flush writes out anything held back waiting for the rest of a sequence.
+// In OSC52Off mode an unfinished sequence is dropped rather than written.
+func (o *OSC52Writer) Flush() error {
+	pending := o.pending
+	o.pending = nil
+	if o.mode == OSC52Off && (o.discarding || bytes.HasPrefix(pending, osc52Start)) {
+		o.discarding = false
+		return nil
+	}
+	return o.emit(pending)
+}
+
+func (o *OSC52Writer) emit(b []byte) error {
+	if len(b) == 0 {
+		return nil
+	}
+	_, err := o.w.Write(b)
+	return err
+}
+
+// hold keeps a copy of b, which may alias the caller's buffer
+func (o *OSC52Writer) hold(b []byte) {
+	o.pending = append([]byte(nil), b...)
+}
+
+// handle decodes the "selection;data" body of a sequence for onCopy
+func (o *OSC52Writer) handle(body []byte) {
+	if o.onCopy == nil || o.mode != OSC52Copy {
+		return
+	}
+	sep := bytes.IndexByte(body, ';')
+	if sep < 0 {
+		return
+	}
+	encoded := string(body[sep+1:])
+	if encoded == "?" {
+		return
+	}
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		if decoded, err = base64.RawStdEncoding.DecodeString(encoded); err != nil {
+			return
+		}
+	}
+	o.onCopy(decoded)
+}
+
+// osc52End finds the BEL or ESC \ ending a sequence body, returning the
+// body's length and the terminator's, or -1 when it hasn't arrived yet
+func osc52End(body []byte) (end, termLen int) {
+	for i, b := range body {
+		switch {
+		case b == '\a':
+			return i, 1
+		case b == '\x1b' && i+1 < len(body) && body[i+1] == '\\':
+			return i, 2
+		}
+	}
+	return -1, 0
+}
+
+// partialPrefixLen returns the length of the longest suffix of data that
+// is a proper prefix of marker
+func partialPrefixLen(data, marker []byte) int {
+	for n := len(marker) - 1; n > 0; n-- {
+		if len(data) >= n && bytes.Equal(data[len(data)-n:], marker[:n]) {
+			return n
+		}
+	}
+	return 0
+}
@@ -0,0 +1,126 @@
+package ssh
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestOSC52WriterDetectsSequences(t *testing.T) {
+	const (
+		bel = "\x1b]52;c;aGVsbG8=\a"   // "hello", BEL-terminated
+		st  = "\x1b]52;;d29ybGQ\x1b\\" // "world", unpadded, ST-terminated
+		qry = "\x1b]52;c;?\a"          // A query, not a copy
+		osc = "\x1b]0;window title\a"  // Another OSC, left alone
+		out = "ls\r\n" + bel + "$ " + osc + st + qry + "\x1b[0m"
+	)
+
+	tests := []struct {
+		name   string
+		chunks []string
+	}{
+		{"one write", []string{out}},
+		{"split in the start", []string{"ls\r\n\x1b", "]5", "2;c;aGVsbG8=\a$ " + osc + st + qry + "\x1b[0m"}},
+		{"split in the data", []string{"ls\r\n\x1b]52;c;aGVs", "bG8=\a$ " + osc + "\x1b]52;;d29ybGQ\x1b", "\\" + qry + "\x1b[0m"}},
+		{"byte at a time", strings.Split(out, "")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			var copied []string
+			w := NewOSC52Writer(&buf, OSC52Copy, func(data []byte) { copied = append(copied, string(data)) })
+			for _, chunk := range tt.chunks {
+				if n, err := w.Write([]byte(chunk)); err != nil || n != len(chunk) {
+					t.Fatalf("Write() = %d, %v", n, err)
+				}
+			}
+			if err := w.Flush(); err != nil {
+				t.Fatal(err)
+			}
+
+			// The stream reaches the terminal unmodified
+			if buf.String() != out {
+				t.Errorf("output = %q, want %q", buf.String(), out)
+			}
+			if strings.Join(copied, ",") != "hello,world" {
+				t.Errorf("copied = %q, want hello and world", copied)
+			}
+		})
+	}
+}
+
+func TestOSC52WriterOff(t *testing.T) {
+	var buf bytes.Buffer
+	called := false
+	w := NewOSC52Writer(&buf, OSC52Off, func([]byte) { called = true })
+	w.Write([]byte("a\x1b]52;c;aGk=\ab\x1b]52;c;aGk="))
+	w.Write([]byte("\x1b\\c"))
+	if buf.String() != "abc" {
+		t.Errorf("output = %q, want the sequences dropped", buf.String())
+	}
+	if called {
+		t.Error("onCopy called in off mode")
+	}
+}
+
+func TestOSC52WriterOffDropsOverlongSequences(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewOSC52Writer(&buf, OSC52Off, nil)
+	chunk := bytes.Repeat([]byte("A"), maxOSC52Size/2)
+	w.Write([]byte("a\x1b]52;c;"))
+	for i := 0; i < 3; i++ {
+		w.Write(chunk)
+	}
+	// The terminator is split across writes
+	w.Write([]byte("AAAA\x1b"))
+	w.Write([]byte("\\b"))
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "ab" {
+		t.Errorf("output = %d bytes starting %q, want the overlong sequence dropped", buf.Len(), buf.String()[:min(buf.Len(), 8)])
+	}
+
+	// A sequence still unfinished when output ends is dropped too
+	buf.Reset()
+	w.Write([]byte("c\x1b]52;c;aGk="))
+	w.Flush()
+	if buf.String() != "c" {
+		t.Errorf("output = %q, want the unfinished sequence dropped", buf.String())
+	}
+}
+
+func TestOSC52WriterHoldsOnlyPossibleSequences(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewOSC52Writer(&buf, OSC52Copy, nil)
+
+	// A partial start is held back until the next write decides it
+	w.Write([]byte("prompt$ \x1b]5"))
+	if buf.String() != "prompt$ " {
+		t.Errorf("output = %q, want the partial start held", buf.String())
+	}
+	w.Write([]byte("x"))
+	if buf.String() != "prompt$ \x1b]5x" {
+		t.Errorf("output = %q, want it released once it isn't OSC 52", buf.String())
+	}
+
+	// An unterminated sequence past the size limit is passed on
+	buf.Reset()
+	long := "\x1b]52;c;" + strings.Repeat("A", maxOSC52Size)
+	w.Write([]byte(long))
+	if buf.Len() != len(long) {
+		t.Errorf("wrote %d bytes, want the oversized sequence passed on", buf.Len())
+	}
+}
+
+func TestParseOSC52Mode(t *testing.T) {
+	for _, s := range []string{"pass", "copy", "off"} {
+		if mode, err := ParseOSC52Mode(s); err != nil || string(mode) != s {
+			t.Errorf("ParseOSC52Mode(%q) = %q, %v", s, mode, err)
+		}
+	}
+	if _, err := ParseOSC52Mode("on"); err == nil {
+		t.Error("ParseOSC52Mode() accepted an unknown mode")
+	}
+}
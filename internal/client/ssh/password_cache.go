@@ -0,0 +1,66 @@
+package ssh
+
+import (
+	"sync"
+)
+
+// PasswordCache remembers a password entered during one invocation so that
+// later connections to other hosts can reuse it instead of prompting again.
+// Callers should Clear the cache once their connections are established.
+// The cache keeps its own copy in a byte slice that Clear overwrites; the
+// strings handed to the SSH library cannot be wiped and stay in memory until
+// the garbage collector reclaims them. A nil cache disables caching.
+type PasswordCache struct {
+	mu       sync.Mutex
+	password []byte
+}
+
+// NewPasswordCache returns an empty password cache
+func NewPasswordCache() *PasswordCache {
+	return &PasswordCache{}
+}
+
+// Get returns the cached password, if any. It is safe to call on a nil cache.
+func (c *PasswordCache) Get() (string, bool) {
+	if c == nil {
+		return "", false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.password == nil {
+		return "", false
+	}
+	return string(c.password), true
+}
+
+// Set replaces the cached password, wiping the previous one. It is a no-op
+// on a nil cache.
+func (c *PasswordCache) Set(password string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.wipe()
+	c.password = append(make([]byte, 0, len(password)), password...)
+}
+
+// Clear overwrites the cached password with zeros and forgets it, so later
+// connections prompt again. It is safe to call on a nil cache and more than
+// once.
+func (c *PasswordCache) Clear() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.wipe()
+}
+
+// wipe zeroes and drops the cached bytes; the caller holds c.mu
+func (c *PasswordCache) wipe() {
+	for i := range c.password {
+		c.password[i] = 0
+	}
+	c.password = nil
+}
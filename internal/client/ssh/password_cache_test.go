@@ -0,0 +1,169 @@
+package ssh
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// startPasswordSSHServer starts a mock SSH server that only accepts password
+func startPasswordSSHServer(t *testing.T, password string) string {
+	t.Helper()
+	addr, cleanup := listenMockSSHServer(t, &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if string(pass) == password {
+				return &ssh.Permissions{}, nil
+			}
+			return nil, fmt.Errorf("authentication failed")
+		},
+	})
+	t.Cleanup(cleanup)
+	return addr
+}
+
+// dialWithPasswordCache connects to addr using only password authentication
+func dialWithPasswordCache(t *testing.T, addr string, passwords *PasswordCache) error {
+	t.Helper()
+
+	logger := log.New(io.Discard, "", 0)
+	authMethods, err := createModernSSHAuthMethods("", nil, "testuser", addr, nil, true, false, nil, passwords, logger)
+	if err != nil {
+		t.Fatalf("createModernSSHAuthMethods() error = %v", err)
+	}
+
+	client, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            "testuser",
+		Auth:            authMethods,
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         5 * time.Second,
+	})
+	if err != nil {
+		return err
+	}
+	return client.Close()
+}
+
+func TestPasswordCacheReusedForSecondHost(t *testing.T) {
+	first := startPasswordSSHServer(t, "s3cret")
+	second := startPasswordSSHServer(t, "s3cret")
+
+	prompts := 0
+	origReadPassword := readPassword
+	readPassword = func() (string, error) {
+		prompts++
+		return "s3cret", nil
+	}
+	defer func() { readPassword = origReadPassword }()
+
+	passwords := NewPasswordCache()
+	defer passwords.Clear()
+
+	if err := dialWithPasswordCache(t, first, passwords); err != nil {
+		t.Fatalf("first host: %v", err)
+	}
+	if err := dialWithPasswordCache(t, second, passwords); err != nil {
+		t.Fatalf("second host: %v", err)
+	}
+	if prompts != 1 {
+		t.Errorf("prompted %d times, want 1 with the cached password reused", prompts)
+	}
+
+	// A host with a different password falls back to prompting
+	third := startPasswordSSHServer(t, "other")
+	readPassword = func() (string, error) {
+		prompts++
+		return "other", nil
+	}
+	if err := dialWithPasswordCache(t, third, passwords); err != nil {
+		t.Fatalf("third host: %v", err)
+	}
+	if prompts != 2 {
+		t.Errorf("prompted %d times, want 2 after the cached password was rejected", prompts)
+	}
+	if got, _ := passwords.Get(); got != "other" {
+		t.Errorf("cached password = %q, want the newly entered one", got)
+	}
+}
+
+func TestPasswordCacheClear(t *testing.T) {
+	passwords := NewPasswordCache()
+	passwords.Set("s3cret")
+	buf := passwords.password
+	passwords.Clear()
+
+	if _, ok := passwords.Get(); ok {
+		t.Error("Get() after Clear should report no password")
+	}
+	for i, b := range buf {
+		if b != 0 {
+			t.Fatalf("byte %d of the cleared password = %q, want it zeroed", i, b)
+		}
+	}
+
+	// Replacing the password wipes the old one too
+	passwords.Set("first")
+	buf = passwords.password
+	passwords.Set("second")
+	if string(buf) != "\x00\x00\x00\x00\x00" {
+		t.Errorf("replaced password buffer = %q, want it zeroed", buf)
+	}
+	if got, _ := passwords.Get(); got != "second" {
+		t.Errorf("Get() = %q, want %q", got, "second")
+	}
+	passwords.Clear()
+
+	// An empty password is still a cached password
+	passwords.Set("")
+	if _, ok := passwords.Get(); !ok {
+		t.Error("Get() should report an empty password as set")
+	}
+
+	// A nil cache disables caching
+	var disabled *PasswordCache
+	disabled.Set("s3cret")
+	if _, ok := disabled.Get(); ok {
+		t.Error("nil cache should never return a password")
+	}
+	disabled.Clear()
+}
+
+func TestPasswordNeverLogged(t *testing.T) {
+	addr := startPasswordSSHServer(t, "s3cret-pw")
+
+	origReadPassword := readPassword
+	readPassword = func() (string, error) { return "s3cret-pw", nil }
+	defer func() { readPassword = origReadPassword }()
+
+	var logs bytes.Buffer
+	logger := log.New(&logs, "", 0)
+	passwords := NewPasswordCache()
+	defer passwords.Clear()
+
+	authMethods, err := createModernSSHAuthMethods("", nil, "testuser", addr, nil, true, true, nil, passwords, logger)
+	if err != nil {
+		t.Fatalf("createModernSSHAuthMethods() error = %v", err)
+	}
+	client, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            "testuser",
+		Auth:            authMethods,
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         5 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	client.Close()
+
+	if logs.Len() == 0 {
+		t.Fatal("expected verbose output from authentication")
+	}
+	if strings.Contains(logs.String(), "s3cret-pw") {
+		t.Errorf("password appeared in log output: %q", logs.String())
+	}
+}
@@ -0,0 +1,105 @@
+package ssh
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"time"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/types/key"
+
+	"github.com/derekg/ts-ssh/internal/security"
+)
+
+// PeerCacheFile is the name of the peer list cache in the tsnet state directory
+const PeerCacheFile = "peers.json"
+
+// peerCache is the on-disk form of a cached tailnet peer list. It records
+// the control server it came from, so switching -control-url never serves
+// another tailnet's peers.
+type peerCache struct {
+	ControlURL     string       `json:"control_url"`
+	SavedAt        time.Time    `json:"saved_at"`
+	MagicDNSSuffix string       `json:"magic_dns_suffix,omitempty"`
+	Peers          []cachedPeer `json:"peers"`
+}
+
+type cachedPeer struct {
+	PublicKey    key.NodePublic `json:"public_key"`
+	HostName     string         `json:"host_name"`
+	DNSName      string         `json:"dns_name"`
+	OS           string         `json:"os,omitempty"`
+	TailscaleIPs []netip.Addr   `json:"tailscale_ips"`
+	Online       bool           `json:"online"`
+}
+
+// SavePeerCache writes the peers of status to path with mode 0600, replacing
+// any earlier cache atomically.
+func SavePeerCache(path, controlURL string, status *ipnstate.Status, now time.Time) error {
+	cache := peerCache{
+		ControlURL:     controlURL,
+		SavedAt:        now.UTC(),
+		MagicDNSSuffix: magicDNSSuffix(status),
+		Peers:          make([]cachedPeer, 0, len(status.Peer)),
+	}
+	for _, peer := range status.Peer {
+		cache.Peers = append(cache.Peers, cachedPeer{
+			PublicKey:    peer.PublicKey,
+			HostName:     peer.HostName,
+			DNSName:      peer.DNSName,
+			OS:           peer.OS,
+			TailscaleIPs: peer.TailscaleIPs,
+			Online:       peer.Online,
+		})
+	}
+
+	file, err := security.CreateSecureDownloadFileWithReplace(path)
+	if err != nil {
+		return fmt.Errorf("failed to create peer cache: %w", err)
+	}
+	if err := json.NewEncoder(file).Encode(cache); err != nil {
+		security.CompleteAtomicReplacement(file)
+		return fmt.Errorf("failed to write peer cache %s: %w", path, err)
+	}
+	return security.CompleteAtomicReplacement(file)
+}
+
+// LoadPeerCache returns the peer list cached at path as a status, or nil
+// when there is no cache, it is older than ttl, or it was saved for a
+// different control server.
+func LoadPeerCache(path, controlURL string, ttl time.Duration, now time.Time) (*ipnstate.Status, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read peer cache %s: %w", path, err)
+	}
+
+	var cache peerCache
+	if err := json.Unmarshal(data, &cache); err != nil {
+		return nil, fmt.Errorf("failed to parse peer cache %s: %w", path, err)
+	}
+	if cache.ControlURL != controlURL || now.Sub(cache.SavedAt) > ttl || now.Before(cache.SavedAt) {
+		return nil, nil
+	}
+
+	status := &ipnstate.Status{
+		MagicDNSSuffix: cache.MagicDNSSuffix,
+		Peer:           make(map[key.NodePublic]*ipnstate.PeerStatus, len(cache.Peers)),
+	}
+	for _, peer := range cache.Peers {
+		status.Peer[peer.PublicKey] = &ipnstate.PeerStatus{
+			PublicKey:    peer.PublicKey,
+			HostName:     peer.HostName,
+			DNSName:      peer.DNSName,
+			OS:           peer.OS,
+			TailscaleIPs: peer.TailscaleIPs,
+			Online:       peer.Online,
+		}
+	}
+	return status, nil
+}
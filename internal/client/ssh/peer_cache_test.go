@@ -0,0 +1,99 @@
+package ssh
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/types/key"
+)
+
+func TestPeerCacheRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), PeerCacheFile)
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	status := &ipnstate.Status{
+		MagicDNSSuffix: "tail1234.ts.net",
+		Peer: map[key.NodePublic]*ipnstate.PeerStatus{
+			key.NewNode().Public(): {
+				HostName:     "web1",
+				DNSName:      "web1.tail1234.ts.net.",
+				OS:           "linux",
+				TailscaleIPs: []netip.Addr{netip.MustParseAddr("100.64.0.1")},
+				Online:       true,
+			},
+		},
+	}
+
+	if err := SavePeerCache(path, "https://control.example", status, now); err != nil {
+		t.Fatalf("SavePeerCache() error = %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if mode := info.Mode().Perm(); mode != 0600 {
+		t.Errorf("peer cache mode = %o, want 600", mode)
+	}
+
+	cached, err := LoadPeerCache(path, "https://control.example", time.Minute, now.Add(30*time.Second))
+	if err != nil || cached == nil {
+		t.Fatalf("LoadPeerCache() = %v, %v", cached, err)
+	}
+	peer, err := FindPeer(cached, "web1.tail1234.ts.net")
+	if err != nil {
+		t.Fatalf("FindPeer() in the cached status: %v", err)
+	}
+	if !peer.Online || peer.OS != "linux" || len(peer.TailscaleIPs) != 1 || peer.TailscaleIPs[0].String() != "100.64.0.1" {
+		t.Errorf("cached peer = %+v", peer)
+	}
+}
+
+func TestPeerCacheInvalidation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), PeerCacheFile)
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	status := &ipnstate.Status{
+		Peer: map[key.NodePublic]*ipnstate.PeerStatus{
+			key.NewNode().Public(): {HostName: "web1"},
+		},
+	}
+
+	// A missing cache is not an error
+	if cached, err := LoadPeerCache(path, "", time.Minute, now); cached != nil || err != nil {
+		t.Errorf("LoadPeerCache() without a cache = %v, %v", cached, err)
+	}
+
+	if err := SavePeerCache(path, "https://a.example", status, now); err != nil {
+		t.Fatalf("SavePeerCache() error = %v", err)
+	}
+	tests := []struct {
+		name       string
+		controlURL string
+		at         time.Time
+	}{
+		{"expired", "https://a.example", now.Add(2 * time.Minute)},
+		{"other control server", "https://b.example", now},
+		{"saved in the future", "https://a.example", now.Add(-time.Minute)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cached, err := LoadPeerCache(path, tt.controlURL, time.Minute, tt.at)
+			if err != nil {
+				t.Fatalf("LoadPeerCache() error = %v", err)
+			}
+			if cached != nil {
+				t.Error("LoadPeerCache() returned a stale cache")
+			}
+		})
+	}
+
+	// A corrupt cache is reported so -v can say why it was ignored
+	if err := os.WriteFile(path, []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadPeerCache(path, "https://a.example", time.Minute, now); err == nil {
+		t.Error("LoadPeerCache() accepted a corrupt cache")
+	}
+}
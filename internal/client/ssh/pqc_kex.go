@@ -0,0 +1,195 @@
+package ssh
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/derekg/ts-ssh/internal/crypto/pqc"
+	"github.com/derekg/ts-ssh/internal/security"
+)
+
+// msgKexInit is the SSH_MSG_KEXINIT message number
+const msgKexInit = 20
+
+// maxKexInitBytes bounds how much of each direction is buffered while
+// looking for KEXINIT; anything longer isn't a real handshake.
+const maxKexInitBytes = 64 * 1024
+
+// kexObserver wraps a connection and reads the key exchange algorithms from
+// the client's and server's plaintext KEXINIT messages. Once both are seen,
+// onKex is told what they offered; an error it returns fails the handshake
+// before authentication starts. x/crypto/ssh doesn't report the negotiated
+// key exchange, so this is the only way to see a fallback to classical.
+type kexObserver struct {
+	net.Conn
+	onKex func(client, server []string) error
+
+	mu                     sync.Mutex
+	readBuf, writeBuf      []byte
+	client, server         []string
+	clientDone, serverDone bool
+	decided                bool
+	err                    error
+}
+
+func newKexObserver(conn net.Conn, onKex func(client, server []string) error) *kexObserver {
+	return &kexObserver{Conn: conn, onKex: onKex}
+}
+
+func (o *kexObserver) Read(p []byte) (int, error) {
+	n, err := o.Conn.Read(p)
+	if n > 0 {
+		if kexErr := o.observe(p[:n], false); kexErr != nil {
+			return 0, kexErr
+		}
+	}
+	return n, err
+}
+
+func (o *kexObserver) Write(p []byte) (int, error) {
+	if err := o.observe(p, true); err != nil {
+		return 0, err
+	}
+	return o.Conn.Write(p)
+}
+
+// observe buffers data in one direction until its KEXINIT has been parsed
+func (o *kexObserver) observe(p []byte, outgoing bool) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.decided {
+		return o.err
+	}
+
+	buf, algos, done := &o.readBuf, &o.server, &o.serverDone
+	if outgoing {
+		buf, algos, done = &o.writeBuf, &o.client, &o.clientDone
+	}
+	if !*done {
+		*buf = append(*buf, p...)
+		*algos, *done = parseKexInitAlgorithms(*buf)
+		if !*done && len(*buf) > maxKexInitBytes {
+			*done = true
+		}
+		if *done {
+			*buf = nil
+		}
+	}
+
+	if o.clientDone && o.serverDone {
+		o.decided = true
+		// An unparseable KEXINIT is left for x/crypto/ssh to reject
+		if o.client != nil && o.server != nil {
+			o.err = o.onKex(o.client, o.server)
+		}
+	}
+	return o.err
+}
+
+// parseKexInitAlgorithms skips the SSH version exchange at the start of buf
+// and returns the key exchange name-list of the KEXINIT packet that follows.
+// done reports whether buf held enough to decide; algos is nil when the
+// first packet isn't a well-formed KEXINIT.
+func parseKexInitAlgorithms(buf []byte) (algos []string, done bool) {
+	// Lines before the binary protocol end with a version line "SSH-..."
+	for {
+		i := bytes.IndexByte(buf, '\n')
+		if i < 0 {
+			return nil, false
+		}
+		line := buf[:i]
+		buf = buf[i+1:]
+		if bytes.HasPrefix(line, []byte("SSH-")) {
+			break
+		}
+	}
+
+	// uint32 packet_length, byte padding_length, payload, padding
+	if len(buf) < 5 {
+		return nil, false
+	}
+	packetLen := int(binary.BigEndian.Uint32(buf))
+	if packetLen < 1 || packetLen > maxKexInitBytes {
+		return nil, true
+	}
+	if len(buf) < 4+packetLen {
+		return nil, false
+	}
+	paddingLen := int(buf[4])
+	if paddingLen+1 > packetLen {
+		return nil, true
+	}
+	payload := buf[5 : 4+packetLen-paddingLen]
+
+	// byte SSH_MSG_KEXINIT, byte[16] cookie, name-list kex_algorithms
+	if len(payload) < 21 || payload[0] != msgKexInit {
+		return nil, true
+	}
+	listLen := int(binary.BigEndian.Uint32(payload[17:]))
+	if listLen > len(payload)-21 {
+		return nil, true
+	}
+	list := string(payload[21 : 21+listLen])
+	if list == "" {
+		return []string{}, true
+	}
+	return strings.Split(list, ","), true
+}
+
+// negotiatedKex returns the key exchange the handshake will use: the
+// client's first preference the server also offers, or "".
+func negotiatedKex(client, server []string) string {
+	for _, c := range client {
+		for _, s := range server {
+			if c == s {
+				return c
+			}
+		}
+	}
+	return ""
+}
+
+// pqcKexCheck returns the onKex callback for cfg. When the handshake is
+// about to settle on a classical key exchange it logs and audits the
+// downgrade at the hybrid level and refuses the connection at the strict
+// level.
+func pqcKexCheck(cfg *pqc.Config, host string, logger *log.Logger) func(client, server []string) error {
+	return func(client, server []string) error {
+		kex := negotiatedKex(client, server)
+		if kex == "" {
+			return nil // No common algorithm; the handshake reports it
+		}
+		if pqc.IsPQCKeyExchange(kex) {
+			if logger != nil {
+				logger.Printf("PQC: using post-quantum key exchange %s", kex)
+			}
+			return nil
+		}
+
+		var serverPQC []string
+		for _, algo := range server {
+			if pqc.IsPQCKeyExchange(algo) {
+				serverPQC = append(serverPQC, algo)
+			}
+		}
+		reason := "server offers no post-quantum key exchange"
+		if len(serverPQC) > 0 {
+			reason = fmt.Sprintf("server offers %s, which this client cannot use", strings.Join(serverPQC, ","))
+		}
+
+		if cfg.QuantumResistance >= pqc.QuantumResistanceStrict {
+			security.LogPQCDowngrade(host, kex, reason, false)
+			return fmt.Errorf("refusing classical key exchange %s with %s: %s and post-quantum key exchange is required", kex, host, reason)
+		}
+		if logger != nil {
+			logger.Printf("PQC: falling back to classical key exchange %s with %s: %s", kex, host, reason)
+		}
+		security.LogPQCDowngrade(host, kex, reason, true)
+		return nil
+	}
+}
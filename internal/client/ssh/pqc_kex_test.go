@@ -0,0 +1,143 @@
+package ssh
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/derekg/ts-ssh/internal/crypto/pqc"
+	"github.com/derekg/ts-ssh/internal/security"
+)
+
+// kexInitStream returns a version line followed by a KEXINIT packet offering kex
+func kexInitStream(kex string) []byte {
+	payload := []byte{msgKexInit}
+	payload = append(payload, make([]byte, 16)...) // cookie
+	payload = binary.BigEndian.AppendUint32(payload, uint32(len(kex)))
+	payload = append(payload, kex...)
+
+	padding := 4
+	packet := binary.BigEndian.AppendUint32(nil, uint32(1+len(payload)+padding))
+	packet = append(packet, byte(padding))
+	packet = append(packet, payload...)
+	packet = append(packet, make([]byte, padding)...)
+	return append([]byte("SSH-2.0-Test\r\n"), packet...)
+}
+
+func TestParseKexInitAlgorithms(t *testing.T) {
+	stream := kexInitStream("sntrup761x25519-sha512@openssh.com,curve25519-sha256")
+
+	algos, done := parseKexInitAlgorithms(stream)
+	if !done || len(algos) != 2 || algos[0] != "sntrup761x25519-sha512@openssh.com" || algos[1] != "curve25519-sha256" {
+		t.Errorf("parseKexInitAlgorithms() = %q, %v", algos, done)
+	}
+
+	// A partial packet needs more data
+	if _, done := parseKexInitAlgorithms(stream[:len(stream)-3]); done {
+		t.Error("parseKexInitAlgorithms() finished on a truncated packet")
+	}
+
+	// Banner lines before the version line are skipped
+	banner := append([]byte("Welcome\r\n"), stream...)
+	if algos, done := parseKexInitAlgorithms(banner); !done || len(algos) != 2 {
+		t.Errorf("parseKexInitAlgorithms() with banner = %q, %v", algos, done)
+	}
+}
+
+func TestPQCDowngradeAudited(t *testing.T) {
+	auditLog := filepath.Join(t.TempDir(), "audit.log")
+	t.Setenv("TS_SSH_SECURITY_AUDIT", "1")
+	t.Setenv("TS_SSH_AUDIT_LOG", auditLog)
+	if err := security.InitSecurityLogger(); err != nil {
+		t.Fatalf("InitSecurityLogger() error = %v", err)
+	}
+	defer security.CloseSecurityLogger()
+
+	prompts := 0
+	origReadPassword := readPassword
+	readPassword = func() (string, error) {
+		prompts++
+		return "s3cret", nil
+	}
+	defer func() { readPassword = origReadPassword }()
+
+	// The x/crypto/ssh test server only offers classical key exchanges
+	addr := startPasswordSSHServer(t, "s3cret")
+	host, port, _ := net.SplitHostPort(addr)
+	config := SSHConnectionConfig{
+		User:                  "testuser",
+		TargetHost:            host,
+		TargetPort:            port,
+		InsecureHostKey:       true,
+		IdentitiesOnly:        true,
+		NoKeyboardInteractive: true,
+		Logger:                log.New(io.Discard, "", 0),
+	}
+
+	// Hybrid falls back to classical and records the downgrade
+	config.PQCConfig, _ = pqc.ConfigForLevel(int(pqc.QuantumResistanceHybrid))
+	client, err := EstablishSSHConnection(tcpDialer{}, context.Background(), config)
+	if err != nil {
+		t.Fatalf("EstablishSSHConnection() at hybrid level error = %v", err)
+	}
+	client.Close()
+
+	// Strict refuses before any credentials are asked for
+	prompts = 0
+	config.PQCConfig, _ = pqc.ConfigForLevel(int(pqc.QuantumResistanceStrict))
+	if client, err := EstablishSSHConnection(tcpDialer{}, context.Background(), config); err == nil {
+		client.Close()
+		t.Fatal("EstablishSSHConnection() at strict level should refuse a classical key exchange")
+	} else if !strings.Contains(err.Error(), "post-quantum key exchange is required") {
+		t.Errorf("EstablishSSHConnection() error = %v, want a PQC refusal", err)
+	}
+	if prompts != 0 {
+		t.Errorf("prompted for a password %d times before refusing", prompts)
+	}
+
+	data, err := os.ReadFile(auditLog)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	for _, action := range []string{"pqc_downgrade_allowed", "pqc_downgrade_refused"} {
+		if !strings.Contains(string(data), `"action":"`+action+`"`) {
+			t.Errorf("audit log has no %s event:\n%s", action, data)
+		}
+	}
+}
+
+func TestUnwritableAuditLogAllowsConnection(t *testing.T) {
+	// A directory can't be opened as the audit log
+	t.Setenv("TS_SSH_SECURITY_AUDIT", "1")
+	t.Setenv("TS_SSH_AUDIT_LOG", t.TempDir())
+	if err := security.InitSecurityLogger(); err == nil {
+		t.Error("InitSecurityLogger() accepted a directory as the audit log")
+	}
+	defer security.CloseSecurityLogger()
+
+	origReadPassword := readPassword
+	readPassword = func() (string, error) { return "s3cret", nil }
+	defer func() { readPassword = origReadPassword }()
+
+	addr := startPasswordSSHServer(t, "s3cret")
+	host, port, _ := net.SplitHostPort(addr)
+	client, err := EstablishSSHConnection(tcpDialer{}, context.Background(), SSHConnectionConfig{
+		User:                  "testuser",
+		TargetHost:            host,
+		TargetPort:            port,
+		InsecureHostKey:       true,
+		IdentitiesOnly:        true,
+		NoKeyboardInteractive: true,
+		Logger:                log.New(io.Discard, "", 0),
+	})
+	if err != nil {
+		t.Fatalf("EstablishSSHConnection() with an unwritable audit log error = %v", err)
+	}
+	client.Close()
+}
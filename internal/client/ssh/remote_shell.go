@@ -0,0 +1,90 @@
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// shellProbeCommand prints the login shell and OS name. It only uses syntax
+// that sh, bash, zsh, fish, csh and busybox ash all accept; on Windows the
+// unexpanded $SHELL and the failed uname identify cmd.exe.
+const shellProbeCommand = `echo "$SHELL"; uname -s`
+
+// RemoteShell describes the login shell sshd runs remote commands with
+type RemoteShell struct {
+	Path string // Login shell, e.g. /bin/bash; empty when unknown
+	Name string // Base name of Path, or "cmd" for Windows cmd.exe
+	OS   string // uname -s output, empty when uname is unavailable
+}
+
+// POSIX reports whether the shell parses commands with sh syntax
+func (r RemoteShell) POSIX() bool {
+	switch r.Name {
+	case "sh", "bash", "zsh", "ksh", "mksh", "dash", "ash", "busybox":
+		return true
+	}
+	return false
+}
+
+// String returns the shell name and OS, e.g. "bash (Linux)"
+func (r RemoteShell) String() string {
+	name := r.Name
+	if name == "" {
+		name = "unknown shell"
+	}
+	if r.OS == "" {
+		return name
+	}
+	return fmt.Sprintf("%s (%s)", name, r.OS)
+}
+
+// ParseShellProbe interprets the output of shellProbeCommand
+func ParseShellProbe(output string) RemoteShell {
+	lines := strings.Split(strings.ReplaceAll(output, "\r\n", "\n"), "\n")
+	var shell RemoteShell
+
+	first := strings.TrimSpace(lines[0])
+	switch {
+	case strings.Contains(first, "$SHELL"):
+		// cmd.exe echoes the line, quotes and all, and runs no uname
+		shell.Name = "cmd"
+		return shell
+	case first != "":
+		shell.Path = first
+		shell.Name = strings.TrimPrefix(path.Base(first), "-")
+	}
+
+	if len(lines) > 1 {
+		osName := strings.TrimSpace(lines[1])
+		if osName != "" && !strings.ContainsAny(osName, " :") {
+			shell.OS = osName
+		}
+	}
+	return shell
+}
+
+// ProbeRemoteShell runs a short command on the host to find out which shell
+// remote commands are given to and which OS it runs
+func ProbeRemoteShell(ctx context.Context, client *ssh.Client) (RemoteShell, error) {
+	session, err := CreateSSHSession(client)
+	if err != nil {
+		return RemoteShell{}, err
+	}
+	defer session.Close()
+
+	// uname fails on Windows, so the exit status is ignored
+	var stdout bytes.Buffer
+	session.Stdout = &stdout
+	if err := session.Start(shellProbeCommand); err != nil {
+		return RemoteShell{}, fmt.Errorf("failed to start shell probe: %w", err)
+	}
+	if err := WaitSession(ctx, session); err != nil && ctx.Err() != nil {
+		return RemoteShell{}, err
+	}
+	return ParseShellProbe(stdout.String()), nil
+}
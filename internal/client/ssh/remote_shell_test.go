@@ -0,0 +1,37 @@
+package ssh
+
+import "testing"
+
+func TestParseShellProbe(t *testing.T) {
+	tests := []struct {
+		name      string
+		output    string
+		wantName  string
+		wantOS    string
+		wantPOSIX bool
+	}{
+		{name: "bash on linux", output: "/bin/bash\nLinux\n", wantName: "bash", wantOS: "Linux", wantPOSIX: true},
+		{name: "zsh on macOS", output: "/bin/zsh\nDarwin\n", wantName: "zsh", wantOS: "Darwin", wantPOSIX: true},
+		{name: "fish", output: "/usr/bin/fish\nLinux\n", wantName: "fish", wantOS: "Linux", wantPOSIX: false},
+		{name: "tcsh on FreeBSD", output: "/bin/tcsh\r\nFreeBSD\r\n", wantName: "tcsh", wantOS: "FreeBSD", wantPOSIX: false},
+		{name: "busybox ash", output: "/bin/ash\nLinux\n", wantName: "ash", wantOS: "Linux", wantPOSIX: true},
+		{name: "windows cmd", output: "\"$SHELL\"\r\n", wantName: "cmd", wantOS: "", wantPOSIX: false},
+		{name: "no SHELL and no uname", output: "\n", wantName: "", wantOS: "", wantPOSIX: false},
+		{name: "powershell", output: "\nuname : The term 'uname' is not recognized\n", wantName: "", wantOS: "", wantPOSIX: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			shell := ParseShellProbe(tt.output)
+			if shell.Name != tt.wantName {
+				t.Errorf("Name = %q, want %q", shell.Name, tt.wantName)
+			}
+			if shell.OS != tt.wantOS {
+				t.Errorf("OS = %q, want %q", shell.OS, tt.wantOS)
+			}
+			if shell.POSIX() != tt.wantPOSIX {
+				t.Errorf("POSIX() = %v, want %v", shell.POSIX(), tt.wantPOSIX)
+			}
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package ssh
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/derekg/ts-ssh/internal/security"
+)
+
+// ParseTarget parses [user@]host[:port] and returns user, host, port
+func ParseTarget(target, defaultUser, defaultPort string) (user, host, port string, err error) {
+	user = defaultUser
+	host = target
+	port = defaultPort
+
+	// Extract user if present
+	if strings.Contains(host, "@") {
+		parts := strings.SplitN(host, "@", 2)
+		user = parts[0]
+		host = parts[1]
+	}
+
+	// Extract port if present
+	if strings.Contains(host, ":") {
+		// Handle IPv6 addresses [::1]:port
+		if strings.HasPrefix(host, "[") {
+			endBracket := strings.Index(host, "]")
+			if endBracket == -1 {
+				return "", "", "", fmt.Errorf("invalid IPv6 address format")
+			}
+			if len(host) > endBracket+1 && host[endBracket+1] == ':' {
+				port = host[endBracket+2:]
+				host = host[1:endBracket]
+			} else {
+				host = host[1:endBracket]
+			}
+		} else {
+			parts := strings.Split(host, ":")
+			if len(parts) == 2 {
+				host = parts[0]
+				port = parts[1]
+			}
+		}
+	}
+
+	// "WEB1" and "web1." name the same tailnet peer as "web1"
+	host = NormalizeHostname(host, "")
+	if host == "" {
+		return "", "", "", fmt.Errorf("hostname cannot be empty")
+	}
+
+	return user, host, port, nil
+}
+
+// ValidateTarget checks an SSH user, host and port given on the command
+// line or in a -J list
+func ValidateTarget(sshUser, host, port string) error {
+	if err := security.ValidateSSHUser(sshUser); err != nil {
+		return fmt.Errorf("invalid SSH user: %w", err)
+	}
+	if err := security.ValidateHostname(host); err != nil {
+		return fmt.Errorf("invalid hostname: %w", err)
+	}
+	if err := security.ValidatePort(port); err != nil {
+		return fmt.Errorf("invalid port: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,240 @@
+package ssh
+
+import (
+	"testing"
+)
+
+func TestParseTarget(t *testing.T) {
+	tests := []struct {
+		name        string
+		target      string
+		defaultUser string
+		defaultPort string
+		wantUser    string
+		wantHost    string
+		wantPort    string
+		wantErr     bool
+	}{
+		{
+			name:        "hostname only",
+			target:      "myhost",
+			defaultUser: "testuser",
+			defaultPort: "22",
+			wantUser:    "testuser",
+			wantHost:    "myhost",
+			wantPort:    "22",
+		},
+		{
+			name:        "hostname is normalized",
+			target:      "MyHost.",
+			defaultUser: "testuser",
+			defaultPort: "22",
+			wantUser:    "testuser",
+			wantHost:    "myhost",
+			wantPort:    "22",
+		},
+		{
+			name:        "user@hostname",
+			target:      "alice@myhost",
+			defaultUser: "testuser",
+			defaultPort: "22",
+			wantUser:    "alice",
+			wantHost:    "myhost",
+			wantPort:    "22",
+		},
+		{
+			name:        "hostname:port",
+			target:      "myhost:2222",
+			defaultUser: "testuser",
+			defaultPort: "22",
+			wantUser:    "testuser",
+			wantHost:    "myhost",
+			wantPort:    "2222",
+		},
+		{
+			name:        "user@hostname:port",
+			target:      "alice@myhost:2222",
+			defaultUser: "testuser",
+			defaultPort: "22",
+			wantUser:    "alice",
+			wantHost:    "myhost",
+			wantPort:    "2222",
+		},
+		{
+			name:        "ipv4 address",
+			target:      "192.168.1.1",
+			defaultUser: "testuser",
+			defaultPort: "22",
+			wantUser:    "testuser",
+			wantHost:    "192.168.1.1",
+			wantPort:    "22",
+		},
+		{
+			name:        "ipv4:port",
+			target:      "192.168.1.1:2222",
+			defaultUser: "testuser",
+			defaultPort: "22",
+			wantUser:    "testuser",
+			wantHost:    "192.168.1.1",
+			wantPort:    "2222",
+		},
+		{
+			name:        "ipv6 address",
+			target:      "[::1]",
+			defaultUser: "testuser",
+			defaultPort: "22",
+			wantUser:    "testuser",
+			wantHost:    "::1",
+			wantPort:    "22",
+		},
+		{
+			name:        "ipv6:port",
+			target:      "[::1]:2222",
+			defaultUser: "testuser",
+			defaultPort: "22",
+			wantUser:    "testuser",
+			wantHost:    "::1",
+			wantPort:    "2222",
+		},
+		{
+			name:        "empty target",
+			target:      "",
+			defaultUser: "testuser",
+			defaultPort: "22",
+			wantErr:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, host, port, err := ParseTarget(tt.target, tt.defaultUser, tt.defaultPort)
+
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ParseTarget() expected error, got nil")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Errorf("ParseTarget() unexpected error: %v", err)
+				return
+			}
+
+			if user != tt.wantUser {
+				t.Errorf("ParseTarget() user = %v, want %v", user, tt.wantUser)
+			}
+			if host != tt.wantHost {
+				t.Errorf("ParseTarget() host = %v, want %v", host, tt.wantHost)
+			}
+			if port != tt.wantPort {
+				t.Errorf("ParseTarget() port = %v, want %v", port, tt.wantPort)
+			}
+		})
+	}
+}
+
+func TestParseTargetEdgeCases(t *testing.T) {
+	tests := []struct {
+		name        string
+		target      string
+		defaultUser string
+		defaultPort string
+		wantUser    string
+		wantHost    string
+		wantPort    string
+		wantErr     bool
+	}{
+		{
+			name:        "complex username with hyphen",
+			target:      "deploy-user@myhost:2222",
+			defaultUser: "testuser",
+			defaultPort: "22",
+			wantUser:    "deploy-user",
+			wantHost:    "myhost",
+			wantPort:    "2222",
+		},
+		{
+			name:        "hostname with hyphens",
+			target:      "my-awesome-host",
+			defaultUser: "testuser",
+			defaultPort: "22",
+			wantUser:    "testuser",
+			wantHost:    "my-awesome-host",
+			wantPort:    "22",
+		},
+		{
+			name:        "FQDN",
+			target:      "server.example.com",
+			defaultUser: "testuser",
+			defaultPort: "22",
+			wantUser:    "testuser",
+			wantHost:    "server.example.com",
+			wantPort:    "22",
+		},
+		{
+			name:        "FQDN with user and port",
+			target:      "admin@server.example.com:8022",
+			defaultUser: "testuser",
+			defaultPort: "22",
+			wantUser:    "admin",
+			wantHost:    "server.example.com",
+			wantPort:    "8022",
+		},
+		{
+			name:        "IPv6 without brackets or port",
+			target:      "2001:db8::1",
+			defaultUser: "testuser",
+			defaultPort: "22",
+			wantUser:    "testuser",
+			wantHost:    "2001:db8::1",
+			wantPort:    "22",
+		},
+		{
+			name:        "localhost",
+			target:      "localhost",
+			defaultUser: "testuser",
+			defaultPort: "22",
+			wantUser:    "testuser",
+			wantHost:    "localhost",
+			wantPort:    "22",
+		},
+		{
+			name:        "localhost with port",
+			target:      "localhost:2222",
+			defaultUser: "testuser",
+			defaultPort: "22",
+			wantUser:    "testuser",
+			wantHost:    "localhost",
+			wantPort:    "2222",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, host, port, err := ParseTarget(tt.target, tt.defaultUser, tt.defaultPort)
+
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ParseTarget() expected error, got nil")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Errorf("ParseTarget() unexpected error: %v", err)
+				return
+			}
+
+			if user != tt.wantUser {
+				t.Errorf("ParseTarget() user = %v, want %v", user, tt.wantUser)
+			}
+			if host != tt.wantHost {
+				t.Errorf("ParseTarget() host = %v, want %v", host, tt.wantHost)
+			}
+			if port != tt.wantPort {
+				t.Errorf("ParseTarget() port = %v, want %v", port, tt.wantPort)
+			}
+		})
+	}
+}
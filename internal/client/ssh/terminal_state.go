@@ -0,0 +1,98 @@
+package ssh
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"golang.org/x/term"
+)
+
+// terminalState is the process-wide record of a terminal put into raw mode.
+// Only one terminal is ever raw at a time, so a single registry lets the
+// session's defer and a signal handler share the same restore, whichever
+// runs first.
+type terminalState struct {
+	mu      sync.Mutex
+	restore func() error
+}
+
+var savedTerminal terminalState
+
+// MakeTerminalRaw puts fd into raw mode and registers its previous state so
+// RestoreTerminal can undo it. On a Windows console it also turns on escape
+// sequence processing for output, which a remote shell relies on.
+func MakeTerminalRaw(fd int) error {
+	oldState, err := term.MakeRaw(fd)
+	if err != nil {
+		return err
+	}
+	restoreOutput, err := enableVirtualTerminal()
+	if err != nil {
+		term.Restore(fd, oldState)
+		return err
+	}
+	savedTerminal.save(func() error {
+		restoreOutput()
+		return term.Restore(fd, oldState)
+	})
+	return nil
+}
+
+// RestoreTerminal restores the terminal registered by MakeTerminalRaw. It is
+// safe to call from a defer, a signal handler or both: only the first call
+// restores, later calls do nothing.
+func RestoreTerminal() error {
+	return savedTerminal.take()
+}
+
+// RestoreTerminalOnSignal restores the terminal and exits when SIGINT, SIGTERM
+// or SIGHUP arrives, so a killed session doesn't leave the terminal raw. The
+// returned function removes the handler.
+func RestoreTerminalOnSignal() (stop func()) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	done := make(chan struct{})
+
+	go func() {
+		select {
+		case sig := <-sigCh:
+			RestoreTerminal()
+			code := 1
+			if s, ok := sig.(syscall.Signal); ok {
+				code = 128 + int(s)
+			}
+			os.Exit(code)
+		case <-done:
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(sigCh)
+			close(done)
+		})
+	}
+}
+
+// save registers restore, replacing any earlier registration
+func (t *terminalState) save(restore func() error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.restore = restore
+}
+
+// take runs and clears the registered restore function, if any
+func (t *terminalState) take() error {
+	t.mu.Lock()
+	restore := t.restore
+	t.restore = nil
+	t.mu.Unlock()
+
+	if restore == nil {
+		return nil
+	}
+	return restore()
+}
@@ -0,0 +1,69 @@
+package ssh
+
+import (
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestRestoreTerminalIdempotent(t *testing.T) {
+	calls := 0
+	savedTerminal.save(func() error {
+		calls++
+		return nil
+	})
+
+	if err := RestoreTerminal(); err != nil {
+		t.Fatalf("first RestoreTerminal() error = %v", err)
+	}
+	if err := RestoreTerminal(); err != nil {
+		t.Fatalf("second RestoreTerminal() error = %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("terminal restored %d times, want 1", calls)
+	}
+}
+
+func TestRestoreTerminalConcurrent(t *testing.T) {
+	var mu sync.Mutex
+	calls := 0
+	restoreErr := errors.New("restore failed")
+	savedTerminal.save(func() error {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		return restoreErr
+	})
+
+	// A signal handler and the session's defer may race to restore
+	var wg sync.WaitGroup
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- RestoreTerminal()
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	failures := 0
+	for err := range errs {
+		if err != nil {
+			failures++
+		}
+	}
+	if calls != 1 || failures != 1 {
+		t.Errorf("restore ran %d times with %d errors, want exactly once", calls, failures)
+	}
+}
+
+func TestRestoreTerminalWithoutState(t *testing.T) {
+	if err := RestoreTerminal(); err != nil {
+		t.Errorf("RestoreTerminal() with nothing saved error = %v", err)
+	}
+	stop := RestoreTerminalOnSignal()
+	stop()
+	stop()
+}
@@ -0,0 +1,166 @@
+package ssh
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/derekg/ts-ssh/internal/config"
+)
+
+// maxAuthBackoff caps the wait between repeated failed attempts to one host
+const maxAuthBackoff = 30 * time.Second
+
+// AuthThrottle spaces out authentication attempts against the same host,
+// backing off exponentially while they keep failing, and caps how many
+// connections may be authenticating at once. Rapid retries across many hosts
+// can otherwise trip fail2ban-style lockouts on shared infrastructure. One
+// throttle should be shared by every connection a process makes.
+type AuthThrottle struct {
+	delay time.Duration
+	sem   chan struct{}
+
+	mu       sync.Mutex
+	next     map[string]time.Time // Earliest next attempt per host
+	failures map[string]int       // Attempts per host since one succeeded
+}
+
+// NewAuthThrottle creates a throttle that waits at least delay (plus up to 50%
+// random jitter) between attempts to the same host, doubling the wait after
+// each further failed attempt up to maxAuthBackoff, and lets at most
+// maxConcurrent connections authenticate at a time. A non-positive
+// maxConcurrent falls back to config.MaxConcurrentConnections.
+func NewAuthThrottle(delay time.Duration, maxConcurrent int) *AuthThrottle {
+	if maxConcurrent <= 0 {
+		maxConcurrent = config.MaxConcurrentConnections
+	}
+	return &AuthThrottle{
+		delay:    delay,
+		sem:      make(chan struct{}, maxConcurrent),
+		next:     make(map[string]time.Time),
+		failures: make(map[string]int),
+	}
+}
+
+// Wait blocks until the next authentication attempt against host is allowed
+// and records the attempt. Every attempt counts as failed until Succeeded is
+// called for host. It is safe to call on a nil throttle.
+func (t *AuthThrottle) Wait(host string) {
+	if t == nil || t.delay <= 0 {
+		return
+	}
+
+	t.mu.Lock()
+	now := time.Now()
+	at := t.next[host]
+	if at.Before(now) {
+		at = now
+	}
+	// Reserve the slot before sleeping so concurrent callers queue up behind it
+	t.failures[host]++
+	t.next[host] = at.Add(t.backoff(t.failures[host]))
+	t.mu.Unlock()
+
+	time.Sleep(time.Until(at))
+}
+
+// Succeeded resets the backoff for host once it has accepted an attempt.
+// It is safe to call on a nil throttle.
+func (t *AuthThrottle) Succeeded(host string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.failures, host)
+}
+
+// Acquire takes a slot in the authentication phase, blocking while the cap is reached.
+func (t *AuthThrottle) Acquire() {
+	if t == nil {
+		return
+	}
+	t.sem <- struct{}{}
+}
+
+// Release returns a slot taken by Acquire.
+func (t *AuthThrottle) Release() {
+	if t == nil {
+		return
+	}
+	<-t.sem
+}
+
+// backoff returns the wait after the given number of unsuccessful attempts:
+// delay doubled for each attempt after the first, capped at maxAuthBackoff
+// (or delay if that is longer), plus jitter
+func (t *AuthThrottle) backoff(failures int) time.Duration {
+	limit := max(t.delay, maxAuthBackoff)
+	wait := t.delay
+	for i := 1; i < failures && wait < limit; i++ {
+		wait *= 2
+	}
+	wait = min(wait, limit)
+	return wait + jitter(wait)
+}
+
+// jitter returns a random duration of up to half of d
+func jitter(d time.Duration) time.Duration {
+	if d < 2 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d / 2)))
+}
+
+// authSlot is one connection's place under an AuthThrottle's concurrency
+// cap. The auth methods take it before each attempt and hand it back while
+// the user is prompted, so a slow answer doesn't hold up other hosts; the
+// key exchange happens before the first attempt and never holds it. The
+// methods run one at a time on the handshake's goroutine, so it needs no lock.
+type authSlot struct {
+	throttle *AuthThrottle
+	host     string
+	held     bool
+}
+
+// newAuthSlot returns a slot for a connection to host. A nil throttle gives
+// a nil slot, whose methods do nothing.
+func (t *AuthThrottle) newAuthSlot(host string) *authSlot {
+	if t == nil {
+		return nil
+	}
+	return &authSlot{throttle: t, host: host}
+}
+
+// attempt waits for the next attempt against the host to be allowed and
+// takes the slot for it. The slot is given up while waiting.
+func (s *authSlot) attempt() {
+	if s == nil {
+		return
+	}
+	s.release()
+	s.throttle.Wait(s.host)
+	s.throttle.Acquire()
+	s.held = true
+}
+
+// release hands the slot back, before a prompt or once the handshake is over
+func (s *authSlot) release() {
+	if s == nil || !s.held {
+		return
+	}
+	s.throttle.Release()
+	s.held = false
+}
+
+// done releases the slot once the handshake is over and, if it succeeded,
+// resets the host's backoff
+func (s *authSlot) done(succeeded bool) {
+	if s == nil {
+		return
+	}
+	s.release()
+	if succeeded {
+		s.throttle.Succeeded(s.host)
+	}
+}
@@ -0,0 +1,158 @@
+package ssh
+
+import (
+	"io"
+	"log"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestAuthThrottleSpacesAttempts(t *testing.T) {
+	delay := 50 * time.Millisecond
+	throttle := NewAuthThrottle(delay, 1)
+
+	start := time.Now()
+	throttle.Wait("host1") // first attempt is immediate
+	if elapsed := time.Since(start); elapsed >= delay {
+		t.Errorf("first attempt waited %v, expected no delay", elapsed)
+	}
+
+	throttle.Wait("host1")
+	throttle.Wait("host1")
+	if elapsed := time.Since(start); elapsed < 2*delay {
+		t.Errorf("three attempts took %v, expected at least %v", elapsed, 2*delay)
+	}
+
+	// Other hosts have their own schedule
+	before := time.Now()
+	throttle.Wait("host2")
+	if elapsed := time.Since(before); elapsed >= delay {
+		t.Errorf("first attempt to another host waited %v, expected no delay", elapsed)
+	}
+}
+
+func TestAuthThrottleBacksOff(t *testing.T) {
+	delay := 20 * time.Millisecond
+	throttle := NewAuthThrottle(delay, 1)
+
+	for failures, want := range map[int]time.Duration{1: delay, 2: 2 * delay, 4: 8 * delay, 100: maxAuthBackoff} {
+		if got := throttle.backoff(failures); got < want || got >= want+want/2 {
+			t.Errorf("backoff(%d) = %v, want %v plus at most 50%% jitter", failures, got, want)
+		}
+	}
+
+	// Attempts that keep failing wait longer each time
+	start := time.Now()
+	for range 4 {
+		throttle.Wait("host")
+	}
+	if elapsed := time.Since(start); elapsed < 7*delay {
+		t.Errorf("four failing attempts took %v, expected at least %v", elapsed, 7*delay)
+	}
+
+	// A success brings the spacing back to delay
+	throttle.Succeeded("host")
+	throttle.Wait("host")
+	before := time.Now()
+	throttle.Wait("host")
+	if elapsed := time.Since(before); elapsed >= 2*delay {
+		t.Errorf("attempt after a success waited %v, expected about %v", elapsed, delay)
+	}
+}
+
+func TestAuthThrottleConcurrencyCap(t *testing.T) {
+	throttle := NewAuthThrottle(0, 1)
+	throttle.Acquire()
+
+	acquired := make(chan struct{})
+	go func() {
+		throttle.Acquire()
+		close(acquired)
+		throttle.Release()
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("second Acquire should block while the cap is reached")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	throttle.Release()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("second Acquire did not proceed after Release")
+	}
+}
+
+func TestAuthSlotReleasedDuringPrompt(t *testing.T) {
+	addr := startPasswordSSHServer(t, "s3cret")
+	throttle := NewAuthThrottle(0, 1)
+
+	prompting, answer := make(chan struct{}), make(chan struct{})
+	origReadPassword := readPassword
+	readPassword = func() (string, error) {
+		close(prompting)
+		<-answer
+		return "s3cret", nil
+	}
+	defer func() { readPassword = origReadPassword }()
+
+	slot := throttle.newAuthSlot(addr)
+	authMethods, err := createModernSSHAuthMethods("", nil, "testuser", addr, nil, true, false, slot, nil, log.New(io.Discard, "", 0))
+	if err != nil {
+		t.Fatalf("createModernSSHAuthMethods() error = %v", err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		client, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+			User:            "testuser",
+			Auth:            authMethods,
+			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+			Timeout:         5 * time.Second,
+		})
+		slot.done(err == nil)
+		if err == nil {
+			client.Close()
+		}
+		done <- err
+	}()
+
+	// While the user types, another connection can authenticate
+	<-prompting
+	acquired := make(chan struct{})
+	go func() {
+		throttle.Acquire()
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("the slot was held during the password prompt")
+	}
+	throttle.Release()
+
+	close(answer)
+	if err := <-done; err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	// The slot is handed back once the handshake is over
+	throttle.Acquire()
+	throttle.Release()
+}
+
+func TestAuthThrottleNil(t *testing.T) {
+	var throttle *AuthThrottle
+	// A nil throttle must be a no-op so callers don't need to check
+	throttle.Wait("host")
+	throttle.Acquire()
+	throttle.Release()
+	throttle.Succeeded("host")
+
+	var slot *authSlot
+	slot.attempt()
+	slot.release()
+	slot.done(true)
+}
@@ -0,0 +1,62 @@
+package ssh
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// ConnectionTokens holds the values substituted for OpenSSH-style % tokens
+// in paths such as a control-master socket path.
+type ConnectionTokens struct {
+	LocalHost string // %l: local host name
+	Host      string // %h: remote host name
+	Port      string // %p: remote port
+	User      string // %r: remote user
+	LocalUser string // %u: local user
+}
+
+// ConnectionHash returns the %C token, the hex SHA-1 of %l%h%p%r as OpenSSH
+// computes it. It is stable for a given connection and short enough for a
+// Unix socket path.
+func (t ConnectionTokens) ConnectionHash() string {
+	sum := sha1.Sum([]byte(t.LocalHost + t.Host + t.Port + t.User))
+	return hex.EncodeToString(sum[:])
+}
+
+// ExpandTokens replaces %C, %h, %l, %p, %r, %u and %% in s. Like OpenSSH it
+// rejects any other token, and a lone % at the end, rather than leaving
+// them in place.
+func ExpandTokens(s string, tokens ConnectionTokens) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+1 >= len(s) {
+			return "", fmt.Errorf("%q ends with an incomplete %% token", s)
+		}
+		i++
+		switch s[i] {
+		case '%':
+			b.WriteByte('%')
+		case 'C':
+			b.WriteString(tokens.ConnectionHash())
+		case 'h':
+			b.WriteString(tokens.Host)
+		case 'l':
+			b.WriteString(tokens.LocalHost)
+		case 'p':
+			b.WriteString(tokens.Port)
+		case 'r':
+			b.WriteString(tokens.User)
+		case 'u':
+			b.WriteString(tokens.LocalUser)
+		default:
+			return "", fmt.Errorf("unknown token %%%c in %q", s[i], s)
+		}
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,67 @@
+package ssh
+
+import "testing"
+
+func TestExpandTokens(t *testing.T) {
+	tokens := ConnectionTokens{
+		LocalHost: "laptop",
+		Host:      "web1",
+		Port:      "22",
+		User:      "alice",
+		LocalUser: "bob",
+	}
+
+	tests := []struct {
+		path    string
+		want    string
+		wantErr bool
+	}{
+		{path: "~/.ssh/cm-%C", want: "~/.ssh/cm-ce7054ae2383dffe01b970a37490ed730d77d231"},
+		{path: "%h", want: "web1"},
+		{path: "%l", want: "laptop"},
+		{path: "%p", want: "22"},
+		{path: "%r", want: "alice"},
+		{path: "%u", want: "bob"},
+		{path: "/tmp/%r@%h:%p", want: "/tmp/alice@web1:22"},
+		{path: "100%%", want: "100%"},
+		{path: "no tokens", want: "no tokens"},
+		{path: "%x", wantErr: true},
+		{path: "trailing %", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, err := ExpandTokens(tt.path, tokens)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ExpandTokens() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ExpandTokens() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConnectionHashStable(t *testing.T) {
+	tokens := ConnectionTokens{LocalHost: "laptop", Host: "web1", Port: "22", User: "alice", LocalUser: "bob"}
+
+	// The same SHA-1 of %l%h%p%r that OpenSSH uses, so sockets can be shared
+	if got, want := tokens.ConnectionHash(), "ce7054ae2383dffe01b970a37490ed730d77d231"; got != want {
+		t.Errorf("ConnectionHash() = %s, want %s", got, want)
+	}
+	if tokens.ConnectionHash() != tokens.ConnectionHash() {
+		t.Error("ConnectionHash() is not stable")
+	}
+
+	// The local user is not part of the hash; every other field is
+	other := tokens
+	other.LocalUser = "carol"
+	if other.ConnectionHash() != tokens.ConnectionHash() {
+		t.Error("ConnectionHash() changed with the local user")
+	}
+	other = tokens
+	other.Port = "2222"
+	if other.ConnectionHash() == tokens.ConnectionHash() {
+		t.Error("ConnectionHash() did not change with the port")
+	}
+}
@@ -0,0 +1,35 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+)
+
+// ExpandPath expands a leading ~ or ~user to a home directory and $VAR or
+// ${VAR} to environment values, as a shell would for an unquoted path.
+// Paths given through flags like -i=~/key or a quoted "$HOME/key" reach
+// ts-ssh unexpanded.
+func ExpandPath(path string) (string, error) {
+	if strings.HasPrefix(path, "~") {
+		name, rest, _ := strings.Cut(path[1:], "/")
+		var home string
+		if name == "" {
+			h, err := os.UserHomeDir()
+			if err != nil {
+				return "", fmt.Errorf("cannot expand %s: %w", path, err)
+			}
+			home = h
+		} else {
+			u, err := user.Lookup(name)
+			if err != nil {
+				return "", fmt.Errorf("cannot expand %s: %w", path, err)
+			}
+			home = u.HomeDir
+		}
+		path = filepath.Join(home, rest)
+	}
+	return os.ExpandEnv(path), nil
+}
@@ -0,0 +1,48 @@
+package config
+
+import (
+	"os/user"
+	"path/filepath"
+	"testing"
+)
+
+func TestExpandPath(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", "/custom/config")
+
+	current, err := user.Current()
+	if err != nil {
+		t.Skipf("cannot look up current user: %v", err)
+	}
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: "~", want: home},
+		{path: "~/.ssh/id_ed25519", want: filepath.Join(home, ".ssh", "id_ed25519")},
+		{path: "~" + current.Username + "/.ssh/known_hosts", want: filepath.Join(current.HomeDir, ".ssh", "known_hosts")},
+		{path: "$XDG_CONFIG_HOME/ts-ssh", want: "/custom/config/ts-ssh"},
+		{path: "${XDG_CONFIG_HOME}/ts-ssh", want: "/custom/config/ts-ssh"},
+		{path: "/etc/ssh/ssh_config", want: "/etc/ssh/ssh_config"},
+		{path: "relative/key", want: "relative/key"},
+		{path: "", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, err := ExpandPath(tt.path)
+			if err != nil {
+				t.Fatalf("ExpandPath(%q) error = %v", tt.path, err)
+			}
+			if got != tt.want {
+				t.Errorf("ExpandPath(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+
+	if _, err := ExpandPath("~no-such-user-ts-ssh/key"); err == nil {
+		t.Error("ExpandPath() with an unknown user should fail")
+	}
+}
@@ -1,6 +1,8 @@
 package pqc
 
 import (
+	"fmt"
+
 	"golang.org/x/crypto/ssh"
 )
 
@@ -49,6 +51,21 @@ func DefaultConfig() *Config {
 	}
 }
 
+// ConfigForLevel returns the default configuration at a quantum resistance
+// level given as a number (0 none, 1 hybrid, 2 strict), or nil for level 0.
+func ConfigForLevel(level int) (*Config, error) {
+	switch QuantumResistanceLevel(level) {
+	case QuantumResistanceNone:
+		return nil, nil
+	case QuantumResistanceHybrid, QuantumResistanceStrict:
+		config := DefaultConfig()
+		config.QuantumResistance = QuantumResistanceLevel(level)
+		return config, nil
+	default:
+		return nil, fmt.Errorf("invalid PQC level %d (want 0, 1 or 2)", level)
+	}
+}
+
 // Algorithm represents a cryptographic algorithm with metadata
 type Algorithm struct {
 	Name             string
@@ -1,9 +1,11 @@
 package errors
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"strings"
 )
 
 // ErrorCode represents different types of errors in ts-ssh
@@ -25,6 +27,7 @@ const (
 	ErrCodeTerminal
 	ErrCodeTmux
 	ErrCodeSCP
+	ErrCodeTimeout
 )
 
 // TSError represents a structured error with operation context and error code
@@ -33,6 +36,7 @@ type TSError struct {
 	Code    ErrorCode // Error classification
 	Err     error     // Underlying error
 	Context string    // Additional context (optional)
+	Host    string    // Host the operation was for (optional)
 	Fatal   bool      // Whether this error should cause program exit
 }
 
@@ -59,6 +63,68 @@ func (e *TSError) GetCode() ErrorCode {
 	return e.Code
 }
 
+// CodeOf returns the code of the first TSError in err's chain, or
+// ErrCodeUnknown when there is none
+func CodeOf(err error) ErrorCode {
+	var tsErr *TSError
+	if errors.As(err, &tsErr) {
+		return tsErr.Code
+	}
+	return ErrCodeUnknown
+}
+
+// HostOf returns the host of the first TSError in err's chain that names
+// one, or "" when none does
+func HostOf(err error) string {
+	for err != nil {
+		var tsErr *TSError
+		if !errors.As(err, &tsErr) {
+			return ""
+		}
+		if tsErr.Host != "" {
+			return tsErr.Host
+		}
+		err = tsErr.Err
+	}
+	return ""
+}
+
+// Class names the code for machine-readable output, e.g. "ssh_auth"
+func (c ErrorCode) Class() string {
+	switch c {
+	case ErrCodeTargetParsing:
+		return "target_parsing"
+	case ErrCodeSSHConnection:
+		return "ssh_connection"
+	case ErrCodeSSHAuth:
+		return "ssh_auth"
+	case ErrCodeTsnetInit:
+		return "tsnet_init"
+	case ErrCodeHostKeyVerification:
+		return "host_key_verification"
+	case ErrCodeFileOperation:
+		return "file_operation"
+	case ErrCodeSecurityValidation:
+		return "security_validation"
+	case ErrCodeUserInput:
+		return "user_input"
+	case ErrCodeConfiguration:
+		return "configuration"
+	case ErrCodeNetworking:
+		return "networking"
+	case ErrCodeTerminal:
+		return "terminal"
+	case ErrCodeTmux:
+		return "tmux"
+	case ErrCodeSCP:
+		return "scp"
+	case ErrCodeTimeout:
+		return "timeout"
+	default:
+		return "unknown"
+	}
+}
+
 // ErrorHandler provides standardized error handling across the application
 type ErrorHandler struct {
 	logger *log.Logger
@@ -129,36 +195,7 @@ func (eh *ErrorHandler) HandleWithExit(err error) {
 
 // codeToString converts error codes to readable strings
 func (eh *ErrorHandler) codeToString(code ErrorCode) string {
-	switch code {
-	case ErrCodeTargetParsing:
-		return "TARGET_PARSING"
-	case ErrCodeSSHConnection:
-		return "SSH_CONNECTION"
-	case ErrCodeSSHAuth:
-		return "SSH_AUTH"
-	case ErrCodeTsnetInit:
-		return "TSNET_INIT"
-	case ErrCodeHostKeyVerification:
-		return "HOST_KEY_VERIFICATION"
-	case ErrCodeFileOperation:
-		return "FILE_OPERATION"
-	case ErrCodeSecurityValidation:
-		return "SECURITY_VALIDATION"
-	case ErrCodeUserInput:
-		return "USER_INPUT"
-	case ErrCodeConfiguration:
-		return "CONFIGURATION"
-	case ErrCodeNetworking:
-		return "NETWORKING"
-	case ErrCodeTerminal:
-		return "TERMINAL"
-	case ErrCodeTmux:
-		return "TMUX"
-	case ErrCodeSCP:
-		return "SCP"
-	default:
-		return "UNKNOWN"
-	}
+	return strings.ToUpper(code.Class())
 }
 
 // Helper functions for creating common error types
@@ -181,6 +218,40 @@ func NewSSHConnectionError(host string, err error) *TSError {
 		Code:    ErrCodeSSHConnection,
 		Err:     err,
 		Context: fmt.Sprintf("host: %s", host),
+		Host:    host,
+	}
+}
+
+// NewDialError creates an error for a failed network connection to host
+func NewDialError(host string, err error) *TSError {
+	return &TSError{
+		Op:      "dial",
+		Code:    ErrCodeNetworking,
+		Err:     err,
+		Context: fmt.Sprintf("host: %s", host),
+		Host:    host,
+	}
+}
+
+// NewTimeoutError creates an error for an operation on host that timed out
+func NewTimeoutError(operation, host string, err error) *TSError {
+	return &TSError{
+		Op:      operation,
+		Code:    ErrCodeTimeout,
+		Err:     err,
+		Context: fmt.Sprintf("host: %s", host),
+		Host:    host,
+	}
+}
+
+// NewHostKeyError creates an error for a host key that failed verification
+func NewHostKeyError(host string, err error) *TSError {
+	return &TSError{
+		Op:      "host_key_verification",
+		Code:    ErrCodeHostKeyVerification,
+		Err:     err,
+		Context: fmt.Sprintf("host: %s", host),
+		Host:    host,
 	}
 }
 
@@ -191,6 +262,7 @@ func NewSSHAuthError(user, host string, err error) *TSError {
 		Code:    ErrCodeSSHAuth,
 		Err:     err,
 		Context: fmt.Sprintf("user: %s, host: %s", user, host),
+		Host:    host,
 	}
 }
 
@@ -2,6 +2,7 @@ package errors
 
 import (
 	"errors"
+	"fmt"
 	"log"
 	"os"
 	"strings"
@@ -25,6 +26,7 @@ func TestErrorCode(t *testing.T) {
 		ErrCodeTerminal,
 		ErrCodeTmux,
 		ErrCodeSCP,
+		ErrCodeTimeout,
 	}
 
 	// Verify all codes are unique
@@ -206,6 +208,7 @@ func TestErrorHandlerCodeToString(t *testing.T) {
 		{ErrCodeTerminal, "TERMINAL"},
 		{ErrCodeTmux, "TMUX"},
 		{ErrCodeSCP, "SCP"},
+		{ErrCodeTimeout, "TIMEOUT"},
 		{ErrCodeUnknown, "UNKNOWN"},
 		{ErrorCode(999), "UNKNOWN"}, // Unknown code
 	}
@@ -318,6 +321,33 @@ func TestErrorHelperFunctions(t *testing.T) {
 			wantOp:    "scp_operation",
 			wantFatal: false,
 		},
+		{
+			name: "NewDialError",
+			createFn: func() *TSError {
+				return NewDialError("server1", errors.New("connection refused"))
+			},
+			wantCode:  ErrCodeNetworking,
+			wantOp:    "dial",
+			wantFatal: false,
+		},
+		{
+			name: "NewTimeoutError",
+			createFn: func() *TSError {
+				return NewTimeoutError("ssh_connect", "server1", errors.New("i/o timeout"))
+			},
+			wantCode:  ErrCodeTimeout,
+			wantOp:    "ssh_connect",
+			wantFatal: false,
+		},
+		{
+			name: "NewHostKeyError",
+			createFn: func() *TSError {
+				return NewHostKeyError("server1", errors.New("key mismatch"))
+			},
+			wantCode:  ErrCodeHostKeyVerification,
+			wantOp:    "host_key_verification",
+			wantFatal: false,
+		},
 	}
 
 	for _, tt := range tests {
@@ -403,3 +433,41 @@ func TestTSErrorInterfaceCompliance(t *testing.T) {
 		t.Error("Error interface not properly implemented")
 	}
 }
+
+// TestCodeOf tests finding the code of a wrapped TSError
+func TestCodeOf(t *testing.T) {
+	wrapped := fmt.Errorf("failed to connect: %w", NewSSHAuthError("user", "host", errors.New("denied")))
+	if got := CodeOf(wrapped); got != ErrCodeSSHAuth {
+		t.Errorf("CodeOf(wrapped auth error) = %v, want %v", got, ErrCodeSSHAuth)
+	}
+	if got := CodeOf(errors.New("plain")); got != ErrCodeUnknown {
+		t.Errorf("CodeOf(plain error) = %v, want %v", got, ErrCodeUnknown)
+	}
+	if got := CodeOf(nil); got != ErrCodeUnknown {
+		t.Errorf("CodeOf(nil) = %v, want %v", got, ErrCodeUnknown)
+	}
+}
+
+func TestHostOf(t *testing.T) {
+	wrapped := fmt.Errorf("failed to connect: %w", NewSSHAuthError("user", "web1", errors.New("denied")))
+	if got := HostOf(wrapped); got != "web1" {
+		t.Errorf("HostOf(wrapped auth error) = %q, want %q", got, "web1")
+	}
+	// An outer error without a host defers to the one it wraps
+	nested := &TSError{Op: "connect", Err: NewDialError("web2", errors.New("refused"))}
+	if got := HostOf(nested); got != "web2" {
+		t.Errorf("HostOf(nested) = %q, want %q", got, "web2")
+	}
+	if got := HostOf(errors.New("plain")); got != "" {
+		t.Errorf("HostOf(plain error) = %q, want empty", got)
+	}
+}
+
+func TestErrorCodeClass(t *testing.T) {
+	if got := ErrCodeSSHAuth.Class(); got != "ssh_auth" {
+		t.Errorf("ErrCodeSSHAuth.Class() = %q", got)
+	}
+	if got := ErrorCode(999).Class(); got != "unknown" {
+		t.Errorf("ErrorCode(999).Class() = %q", got)
+	}
+}
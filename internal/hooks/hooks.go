@@ -0,0 +1,52 @@
+// Package hooks runs the local commands ts-ssh starts around a connection:
+// -pre-connect, -post-connect and -local-command.
+package hooks
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+
+	sshclient "github.com/derekg/ts-ssh/internal/client/ssh"
+	"github.com/derekg/ts-ssh/internal/security"
+)
+
+// Run runs a -pre-connect or -post-connect command locally, with the
+// connection's user, host and port in TS_SSH_USER, TS_SSH_HOST and
+// TS_SSH_PORT. The command is split on spaces and run without a shell; its
+// output goes to stderr so it can't mix with a remote command's stdout.
+func Run(name, command, sshUser, host, port string, logger *log.Logger) error {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return nil
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Env = append(os.Environ(), "TS_SSH_USER="+sshUser, "TS_SSH_HOST="+host, "TS_SSH_PORT="+port)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	logger.Printf("Running %s hook: %s", name, command)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s hook %q failed: %w", name, command, err)
+	}
+	return nil
+}
+
+// RunLocalCommand runs -local-command like OpenSSH's LocalCommand: locally,
+// once connected, with the connection's % tokens expanded. It is run as a
+// hook, so the command is split on spaces rather than given to a shell.
+func RunLocalCommand(command string, tokens sshclient.ConnectionTokens, logger *log.Logger) error {
+	if command == "" {
+		return nil
+	}
+	expanded, err := sshclient.ExpandTokens(command, tokens)
+	if err != nil {
+		return fmt.Errorf("invalid local command: %w", err)
+	}
+	if err := security.ValidateCommand(expanded); err != nil {
+		return fmt.Errorf("invalid local command: %w", err)
+	}
+	return Run("local-command", expanded, tokens.User, tokens.Host, tokens.Port, logger)
+}
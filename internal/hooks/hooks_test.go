@@ -0,0 +1,73 @@
+package hooks
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	sshclient "github.com/derekg/ts-ssh/internal/client/ssh"
+)
+
+func TestRunOrderAndEnv(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "hooks.log")
+	script := filepath.Join(dir, "hook.sh")
+	body := "#!/bin/sh\necho \"$1 $TS_SSH_USER@$TS_SSH_HOST:$TS_SSH_PORT\" >> " + logPath + "\n"
+	if err := os.WriteFile(script, []byte(body), 0700); err != nil {
+		t.Fatal(err)
+	}
+	logger := log.New(io.Discard, "", 0)
+
+	for _, name := range []string{"pre-connect", "post-connect"} {
+		if err := Run(name, script+" "+name, "alice", "web1", "2222", logger); err != nil {
+			t.Fatalf("Run(%s) error = %v", name, err)
+		}
+	}
+
+	data, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "pre-connect alice@web1:2222\npost-connect alice@web1:2222\n"
+	if string(data) != want {
+		t.Errorf("hook log = %q, want %q", data, want)
+	}
+
+	// No hook configured is a no-op
+	if err := Run("pre-connect", "", "alice", "web1", "22", logger); err != nil {
+		t.Errorf("Run() with no command error = %v", err)
+	}
+}
+
+func TestRunLocalCommand(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "local.log")
+	script := filepath.Join(dir, "notify.sh")
+	body := "#!/bin/sh\necho \"$*\" >> " + logPath + "\n"
+	if err := os.WriteFile(script, []byte(body), 0700); err != nil {
+		t.Fatal(err)
+	}
+	logger := log.New(io.Discard, "", 0)
+	tokens := sshclient.ConnectionTokens{Host: "web1", Port: "2222", User: "alice", LocalUser: "bob"}
+
+	if err := RunLocalCommand(script+" connected %r@%h:%p as %u 100%%", tokens, logger); err != nil {
+		t.Fatalf("RunLocalCommand() error = %v", err)
+	}
+	data, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "connected alice@web1:2222 as bob 100%\n"; string(data) != want {
+		t.Errorf("local command got %q, want %q", data, want)
+	}
+
+	if err := RunLocalCommand(script+" %x", tokens, logger); err == nil {
+		t.Error("RunLocalCommand() accepted an unknown token")
+	}
+	// Not permitted or not configured is a no-op
+	if err := RunLocalCommand("", tokens, logger); err != nil {
+		t.Errorf("RunLocalCommand() with no command error = %v", err)
+	}
+}
@@ -0,0 +1,46 @@
+package platform
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+// CopyToClipboard puts data on the system clipboard with pbcopy on macOS,
+// clip on Windows and wl-copy, xclip or xsel elsewhere
+func CopyToClipboard(data []byte) error {
+	cmd, err := clipboardCommand()
+	if err != nil {
+		return err
+	}
+	cmd.Stdin = bytes.NewReader(data)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to copy to the clipboard with %s: %w", cmd.Path, err)
+	}
+	return nil
+}
+
+// clipboardCommand returns the command that reads the new clipboard
+// contents from stdin
+func clipboardCommand() (*exec.Cmd, error) {
+	switch runtime.GOOS {
+	case "darwin":
+		return exec.Command("pbcopy"), nil
+	case "windows":
+		return exec.Command("clip"), nil
+	}
+
+	candidates := [][]string{{"xclip", "-selection", "clipboard"}, {"xsel", "--clipboard", "--input"}}
+	if os.Getenv("WAYLAND_DISPLAY") != "" {
+		candidates = append([][]string{{"wl-copy"}}, candidates...)
+	}
+	for _, c := range candidates {
+		if path, err := exec.LookPath(c[0]); err == nil {
+			return exec.Command(path, c[1:]...), nil
+		}
+	}
+	return nil, errors.New("no clipboard command found (install wl-copy, xclip or xsel)")
+}
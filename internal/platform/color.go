@@ -0,0 +1,63 @@
+package platform
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+)
+
+// ColorMode is the -color setting: whether warnings on stderr use ANSI colors
+type ColorMode string
+
+const (
+	ColorAuto   ColorMode = "auto"   // Color when stderr is a terminal and NO_COLOR is unset
+	ColorAlways ColorMode = "always" // Color even when redirected or with NO_COLOR
+	ColorNever  ColorMode = "never"  // Plain text
+)
+
+// ANSI SGR sequences used for warnings
+const (
+	ColorRed    = "\x1b[1;31m"
+	ColorYellow = "\x1b[1;33m"
+	colorReset  = "\x1b[0m"
+)
+
+// stderrColor is the process-wide decision made by SetStderrColor
+var stderrColor atomic.Bool
+
+// ParseColorMode parses a -color value
+func ParseColorMode(s string) (ColorMode, error) {
+	switch mode := ColorMode(s); mode {
+	case ColorAuto, ColorAlways, ColorNever:
+		return mode, nil
+	}
+	return "", fmt.Errorf("invalid color mode %q: use auto, always or never", s)
+}
+
+// UseColor decides whether output to a stream should be colored. In auto
+// mode that takes a terminal, NO_COLOR (https://no-color.org) unset or empty,
+// and a TERM other than "dumb"; always and never override all of these.
+func UseColor(mode ColorMode, isTerminal bool) bool {
+	switch mode {
+	case ColorAlways:
+		return true
+	case ColorNever:
+		return false
+	}
+	return isTerminal && os.Getenv("NO_COLOR") == "" && os.Getenv("TERM") != "dumb"
+}
+
+// SetStderrColor records whether Colorize colors stderr output. It is set
+// once from -color at startup; until then output is plain.
+func SetStderrColor(enabled bool) {
+	stderrColor.Store(enabled)
+}
+
+// Colorize wraps s in color for printing to stderr, or returns it unchanged
+// when color is off
+func Colorize(color, s string) string {
+	if !stderrColor.Load() || s == "" {
+		return s
+	}
+	return color + s + colorReset
+}
@@ -0,0 +1,55 @@
+package platform
+
+import "testing"
+
+func TestUseColor(t *testing.T) {
+	tests := []struct {
+		name       string
+		mode       ColorMode
+		isTerminal bool
+		noColor    string
+		term       string
+		want       bool
+	}{
+		{"auto on a terminal", ColorAuto, true, "", "xterm-256color", true},
+		{"auto redirected", ColorAuto, false, "", "xterm-256color", false},
+		{"auto with NO_COLOR", ColorAuto, true, "1", "xterm-256color", false},
+		{"auto on a dumb terminal", ColorAuto, true, "", "dumb", false},
+		{"never on a terminal", ColorNever, true, "", "xterm-256color", false},
+		{"always redirected", ColorAlways, false, "", "xterm-256color", true},
+		{"always with NO_COLOR", ColorAlways, true, "1", "xterm-256color", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("NO_COLOR", tt.noColor)
+			t.Setenv("TERM", tt.term)
+			if got := UseColor(tt.mode, tt.isTerminal); got != tt.want {
+				t.Errorf("UseColor(%q, %v) = %v, want %v", tt.mode, tt.isTerminal, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseColorMode(t *testing.T) {
+	for _, s := range []string{"auto", "always", "never"} {
+		if mode, err := ParseColorMode(s); err != nil || string(mode) != s {
+			t.Errorf("ParseColorMode(%q) = %q, %v", s, mode, err)
+		}
+	}
+	if _, err := ParseColorMode("yes"); err == nil {
+		t.Error("ParseColorMode() accepted an unknown mode")
+	}
+}
+
+func TestColorize(t *testing.T) {
+	defer SetStderrColor(false)
+
+	SetStderrColor(false)
+	if got := Colorize(ColorRed, "WARNING"); got != "WARNING" {
+		t.Errorf("Colorize() with color off = %q, want plain text", got)
+	}
+	SetStderrColor(true)
+	if got := Colorize(ColorRed, "WARNING"); got != "\x1b[1;31mWARNING\x1b[0m" {
+		t.Errorf("Colorize() with color on = %q", got)
+	}
+}
@@ -2,10 +2,13 @@ package security
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 )
 
@@ -37,61 +40,116 @@ type SecurityLogger struct {
 	enabled bool
 	logFile *os.File
 	logger  *log.Logger
+	syslog  syslogWriter // System log output, nil unless TS_SSH_AUDIT_SYSLOG=1
 }
 
+// syslogWriter is the part of a system log connection the audit logger
+// uses. On Unix it is a log/syslog writer, on Windows the Event Log.
+type syslogWriter interface {
+	Info(m string) error
+	Warning(m string) error
+	Err(m string) error
+	Close() error
+}
+
+// openSyslog connects to the system log; tests replace it with a fake
+var openSyslog = openSystemLog
+
+// auditFallback receives events when no configured output can be opened
+var auditFallback io.Writer = os.Stderr
+
 // Global security logger instance
 var securityLogger *SecurityLogger
 
-// InitSecurityLogger initializes the security audit logging system
+// InitSecurityLogger initializes the security audit logging system.
+// TS_SSH_SECURITY_AUDIT enables it with a log file, TS_SSH_AUDIT_LOG or
+// ~/.ts-ssh-security.log. TS_SSH_AUDIT_SYSLOG=1 sends events to syslog (the
+// Event Log on Windows) instead; a file is then only written when
+// TS_SSH_AUDIT_LOG is also set.
+//
+// An output that can't be opened, such as an unwritable TS_SSH_AUDIT_LOG,
+// doesn't disable auditing: the other output is used, or stderr if there is
+// none, and an AUDIT_FALLBACK event records why. The returned error then
+// describes the failure, but the logger works and connections can proceed.
 func InitSecurityLogger() error {
 	// Check if security logging is enabled via environment variable
-	enabled := os.Getenv("TS_SSH_SECURITY_AUDIT") != ""
+	useSyslog := os.Getenv("TS_SSH_AUDIT_SYSLOG") == "1"
+	enabled := os.Getenv("TS_SSH_SECURITY_AUDIT") != "" || useSyslog
 	if !enabled {
 		securityLogger = &SecurityLogger{enabled: false}
 		return nil
 	}
 
+	sl := &SecurityLogger{enabled: true}
+	var outputs []string
+	var failures []error
+	if useSyslog {
+		w, err := openSyslog()
+		if err != nil {
+			failures = append(failures, fmt.Errorf("failed to open system log for security audit: %w", err))
+		} else {
+			sl.syslog = w
+			outputs = append(outputs, "system log")
+		}
+	}
+
 	// Determine log file path
 	logPath := os.Getenv("TS_SSH_AUDIT_LOG")
-	if logPath == "" {
+	if logPath == "" && !useSyslog {
 		// Default to user's home directory
 		homeDir, err := os.UserHomeDir()
 		if err != nil {
-			return fmt.Errorf("failed to determine home directory for security log: %w", err)
+			failures = append(failures, fmt.Errorf("failed to determine home directory for security log: %w", err))
+		} else {
+			logPath = filepath.Join(homeDir, ".ts-ssh-security.log")
 		}
-		logPath = filepath.Join(homeDir, ".ts-ssh-security.log")
 	}
 
-	// Create secure log file with appropriate permissions
-	logFile, err := CreateSecureFileForAppend(logPath, 0600)
-	if err != nil {
-		return fmt.Errorf("failed to create security audit log: %w", err)
+	if logPath != "" {
+		// Create secure log file with appropriate permissions
+		logFile, err := CreateSecureFileForAppend(logPath, 0600)
+		if err != nil {
+			failures = append(failures, fmt.Errorf("failed to create security audit log: %w", err))
+		} else {
+			sl.logFile = logFile
+			sl.logger = log.New(logFile, "", 0) // No default prefix, we'll format our own
+			outputs = append(outputs, "log file: "+logPath)
+		}
 	}
 
-	// Create logger instance
-	logger := log.New(logFile, "", 0) // No default prefix, we'll format our own
-
-	securityLogger = &SecurityLogger{
-		enabled: true,
-		logFile: logFile,
-		logger:  logger,
+	if len(outputs) == 0 {
+		sl.logger = log.New(auditFallback, "", 0)
+		outputs = append(outputs, "stderr")
 	}
 
+	securityLogger = sl
+
 	// Log initialization
 	securityLogger.logSecurityEvent(SecurityEvent{
 		EventType: "AUDIT_INIT",
 		Severity:  "INFO",
 		Action:    "security_audit_logging_initialized",
-		Details:   fmt.Sprintf("Security audit logging enabled, log file: %s", logPath),
+		Details:   fmt.Sprintf("Security audit logging enabled, %s", strings.Join(outputs, ", ")),
 		Success:   true,
 	})
 
-	return nil
+	if len(failures) == 0 {
+		return nil
+	}
+	err := errors.Join(failures...)
+	securityLogger.logSecurityEvent(SecurityEvent{
+		EventType: "AUDIT_FALLBACK",
+		Severity:  "WARNING",
+		Action:    "security_audit_output_unavailable",
+		Details:   fmt.Sprintf("%v; logging to %s instead", err, strings.Join(outputs, ", ")),
+		Success:   false,
+	})
+	return fmt.Errorf("%w; security audit logging to %s instead", err, strings.Join(outputs, ", "))
 }
 
 // CloseSecurityLogger safely closes the security logger
 func CloseSecurityLogger() {
-	if securityLogger != nil && securityLogger.enabled && securityLogger.logFile != nil {
+	if securityLogger != nil && securityLogger.enabled && (securityLogger.logFile != nil || securityLogger.syslog != nil) {
 		securityLogger.logSecurityEvent(SecurityEvent{
 			EventType: "AUDIT_CLOSE",
 			Severity:  "INFO",
@@ -99,13 +157,18 @@ func CloseSecurityLogger() {
 			Details:   "Security audit logging session ended",
 			Success:   true,
 		})
-		securityLogger.logFile.Close()
+		if securityLogger.logFile != nil {
+			securityLogger.logFile.Close()
+		}
+		if securityLogger.syslog != nil {
+			securityLogger.syslog.Close()
+		}
 	}
 }
 
 // logSecurityEvent logs a security event to the audit log
 func (sl *SecurityLogger) logSecurityEvent(event SecurityEvent) {
-	if !sl.enabled || sl.logger == nil {
+	if !sl.enabled || (sl.logger == nil && sl.syslog == nil) {
 		return
 	}
 
@@ -123,17 +186,36 @@ func (sl *SecurityLogger) logSecurityEvent(event SecurityEvent) {
 	eventJSON, err := json.Marshal(event)
 	if err != nil {
 		// Fallback to simple text logging if JSON fails
-		sl.logger.Printf("[SECURITY] %s %s %s: %s - %s",
+		sl.write(event.Severity, fmt.Sprintf("[SECURITY] %s %s %s: %s - %s",
 			event.Timestamp.Format(time.RFC3339),
 			event.Severity,
 			event.EventType,
 			event.Action,
-			event.Details)
+			event.Details))
 		return
 	}
 
 	// Log structured JSON event
-	sl.logger.Printf("%s", string(eventJSON))
+	sl.write(event.Severity, string(eventJSON))
+}
+
+// write sends one serialized event to each configured output. In the
+// system log HIGH events are errors and WARNING events warnings.
+func (sl *SecurityLogger) write(severity, line string) {
+	if sl.logger != nil {
+		sl.logger.Printf("%s", line)
+	}
+	if sl.syslog == nil {
+		return
+	}
+	switch severity {
+	case "HIGH", "CRITICAL":
+		sl.syslog.Err(line)
+	case "WARNING":
+		sl.syslog.Warning(line)
+	default:
+		sl.syslog.Info(line)
+	}
 }
 
 // LogInsecureModeUsage logs usage of insecure host key verification mode
@@ -206,6 +288,8 @@ func LogHostKeyVerification(host, user string, action string, success bool) {
 	severity := "INFO"
 	if !success {
 		severity = "HIGH"
+	} else if action == "changed_key_accepted" {
+		severity = "WARNING"
 	}
 
 	details := fmt.Sprintf("Host key verification for %s", host)
@@ -214,10 +298,14 @@ func LogHostKeyVerification(host, user string, action string, success bool) {
 		details += " - verified against known_hosts"
 	case "new_host_accepted":
 		details += " - new host key accepted by user"
+	case "changed_key_accepted":
+		details += " - changed host key accepted from the allowlist"
 	case "new_host_rejected":
 		details += " - new host key rejected by user"
 	case "verification_failed":
 		details += " - verification failed"
+	case "revoked_key":
+		details += " - key or its certificate authority is marked @revoked"
 	}
 
 	securityLogger.logSecurityEvent(SecurityEvent{
@@ -298,3 +386,65 @@ func LogTTYSecurityValidation(operation string, success bool, details string) {
 		Success:   success,
 	})
 }
+
+// LogCommandPolicyDecision logs whether a command policy allowed a remote command
+func LogCommandPolicyDecision(host, user, command string, allowed bool, details string) {
+	if securityLogger == nil {
+		return
+	}
+
+	severity := "INFO"
+	action := "command_allowed"
+	if !allowed {
+		severity = "WARNING"
+		action = "command_denied"
+	}
+
+	securityLogger.logSecurityEvent(SecurityEvent{
+		EventType: "COMMAND_POLICY",
+		Severity:  severity,
+		User:      user,
+		Host:      host,
+		Action:    action,
+		Details:   fmt.Sprintf("Command policy: %q - %s", command, details),
+		Success:   allowed,
+	})
+}
+
+// LogForcedCommand logs a forced command replacing the command the user asked for
+func LogForcedCommand(target, attempted, forced string) {
+	if securityLogger == nil {
+		return
+	}
+
+	securityLogger.logSecurityEvent(SecurityEvent{
+		EventType: "COMMAND_POLICY",
+		Severity:  "WARNING",
+		Host:      target,
+		Action:    "command_forced",
+		Details:   fmt.Sprintf("Forced command %q replaced %q", forced, attempted),
+		Success:   true,
+	})
+}
+
+// LogPQCDowngrade logs a connection that settled, or would have settled, on a
+// classical key exchange although post-quantum key exchange was requested
+func LogPQCDowngrade(host, kex, reason string, allowed bool) {
+	if securityLogger == nil {
+		return
+	}
+
+	action := "pqc_downgrade_allowed"
+	if !allowed {
+		action = "pqc_downgrade_refused"
+	}
+
+	securityLogger.logSecurityEvent(SecurityEvent{
+		EventType: "PQC_DOWNGRADE",
+		Severity:  "WARNING",
+		Host:      host,
+		Action:    action,
+		Details:   fmt.Sprintf("Classical key exchange %s: %s", kex, reason),
+		Success:   allowed,
+	})
+}
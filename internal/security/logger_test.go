@@ -0,0 +1,157 @@
+package security
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeSyslog records messages by priority in place of a system log connection
+type fakeSyslog struct {
+	messages map[string][]string
+	closed   bool
+}
+
+func (f *fakeSyslog) record(priority, m string) error {
+	f.messages[priority] = append(f.messages[priority], m)
+	return nil
+}
+
+func (f *fakeSyslog) Info(m string) error    { return f.record("info", m) }
+func (f *fakeSyslog) Warning(m string) error { return f.record("warning", m) }
+func (f *fakeSyslog) Err(m string) error     { return f.record("err", m) }
+func (f *fakeSyslog) Close() error           { f.closed = true; return nil }
+
+func useFakeSyslog(t *testing.T) *fakeSyslog {
+	fake := &fakeSyslog{messages: map[string][]string{}}
+	orig := openSyslog
+	openSyslog = func() (syslogWriter, error) { return fake, nil }
+	t.Cleanup(func() { openSyslog = orig })
+	return fake
+}
+
+func TestSecurityLoggerSyslog(t *testing.T) {
+	fake := useFakeSyslog(t)
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("TS_SSH_SECURITY_AUDIT", "")
+	t.Setenv("TS_SSH_AUDIT_LOG", "")
+	t.Setenv("TS_SSH_AUDIT_SYSLOG", "1")
+
+	if err := InitSecurityLogger(); err != nil {
+		t.Fatalf("InitSecurityLogger() error = %v", err)
+	}
+	LogInsecureModeUsage("web1", "alice", true, true)      // HIGH
+	LogPQCDowngrade("web1", "curve25519-sha256", "", true) // WARNING
+	CloseSecurityLogger()
+
+	if !fake.closed {
+		t.Error("CloseSecurityLogger() did not close the system log")
+	}
+	if len(fake.messages["info"]) != 2 || len(fake.messages["warning"]) != 1 || len(fake.messages["err"]) != 1 {
+		t.Fatalf("syslog messages = %v", fake.messages)
+	}
+
+	// Events use the same JSON serialization as the log file
+	var event SecurityEvent
+	if err := json.Unmarshal([]byte(fake.messages["warning"][0]), &event); err != nil {
+		t.Fatalf("syslog message is not a JSON event: %v", err)
+	}
+	if event.EventType != "PQC_DOWNGRADE" || event.Host != "web1" {
+		t.Errorf("syslog event = %+v", event)
+	}
+
+	// Syslog alone doesn't create the default log file
+	if _, err := os.Stat(filepath.Join(home, ".ts-ssh-security.log")); !os.IsNotExist(err) {
+		t.Errorf("default audit log was created with syslog output: %v", err)
+	}
+}
+
+func TestSecurityLoggerSyslogAndFile(t *testing.T) {
+	fake := useFakeSyslog(t)
+	logPath := filepath.Join(t.TempDir(), "audit.log")
+	t.Setenv("TS_SSH_AUDIT_LOG", logPath)
+	t.Setenv("TS_SSH_AUDIT_SYSLOG", "1")
+
+	if err := InitSecurityLogger(); err != nil {
+		t.Fatalf("InitSecurityLogger() error = %v", err)
+	}
+	LogForcedCommand("web1", "bash", "uptime")
+	CloseSecurityLogger()
+
+	data, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if len(fake.messages["warning"]) != 1 || !json.Valid([]byte(fake.messages["warning"][0])) {
+		t.Errorf("syslog messages = %v", fake.messages)
+	}
+	if want := fake.messages["warning"][0] + "\n"; !strings.Contains(string(data), want) {
+		t.Errorf("log file doesn't have the syslog event:\n%s", data)
+	}
+}
+
+func TestSecurityLoggerFallback(t *testing.T) {
+	var fallback bytes.Buffer
+	orig := auditFallback
+	auditFallback = &fallback
+	t.Cleanup(func() { auditFallback = orig })
+
+	// A regular file where a directory should be can't hold the log
+	notDir := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(notDir, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TS_SSH_SECURITY_AUDIT", "1")
+	t.Setenv("TS_SSH_AUDIT_LOG", filepath.Join(notDir, "audit.log"))
+	t.Setenv("TS_SSH_AUDIT_SYSLOG", "")
+
+	err := InitSecurityLogger()
+	if err == nil || !strings.Contains(err.Error(), "stderr") {
+		t.Errorf("InitSecurityLogger() error = %v, want one naming the stderr fallback", err)
+	}
+	LogHostKeyVerification("web1", "alice", "known_host", true)
+	CloseSecurityLogger()
+
+	var types []string
+	for _, line := range strings.Split(strings.TrimSpace(fallback.String()), "\n") {
+		var event SecurityEvent
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatalf("fallback line %q is not a JSON event: %v", line, err)
+		}
+		types = append(types, event.EventType)
+	}
+	if want := "AUDIT_INIT AUDIT_FALLBACK HOST_KEY_VERIFICATION"; strings.Join(types, " ") != want {
+		t.Errorf("fallback events = %v, want %s", types, want)
+	}
+}
+
+func TestSecurityLoggerSyslogUnavailable(t *testing.T) {
+	orig := openSyslog
+	openSyslog = func() (syslogWriter, error) { return nil, errors.New("no syslog daemon") }
+	t.Cleanup(func() { openSyslog = orig })
+	logPath := filepath.Join(t.TempDir(), "audit.log")
+	t.Setenv("TS_SSH_AUDIT_LOG", logPath)
+	t.Setenv("TS_SSH_AUDIT_SYSLOG", "1")
+
+	// The log file still gets every event, including why syslog is missing
+	if err := InitSecurityLogger(); err == nil {
+		t.Error("InitSecurityLogger() reported no error for a missing system log")
+	}
+	LogForcedCommand("web1", "bash", "uptime")
+	CloseSecurityLogger()
+
+	data, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	for _, want := range []string{`"event_type":"AUDIT_FALLBACK"`, "no syslog daemon", `"event_type":"COMMAND_POLICY"`} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("log file has no %s:\n%s", want, data)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package security
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// CommandPolicy restricts which remote commands may be run. It is loaded
+// from a policy file with one rule per line:
+//
+//	# comment
+//	allow uptime
+//	allow systemctl status *
+//	deny *passwd*
+//
+// Patterns match the whole command; * matches any run of characters and ?
+// a single character. A command matching any deny rule is refused, and a
+// command must match at least one allow rule to run.
+type CommandPolicy struct {
+	path  string
+	rules []commandRule
+}
+
+type commandRule struct {
+	allow   bool
+	pattern string
+	line    int
+	re      *regexp.Regexp
+}
+
+// LoadCommandPolicy reads a command policy file. The file must not be
+// writable by group or others, since anyone who can edit it can lift the
+// restrictions.
+func LoadCommandPolicy(path string) (*CommandPolicy, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open command policy %s: %w", path, err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat command policy %s: %w", path, err)
+	}
+	if info.Mode().Perm()&0022 != 0 {
+		return nil, fmt.Errorf("command policy %s is writable by group or others (mode %o)", path, info.Mode().Perm())
+	}
+
+	policy := &CommandPolicy{path: path}
+	scanner := bufio.NewScanner(file)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		action, pattern, _ := strings.Cut(line, " ")
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			return nil, fmt.Errorf("%s line %d: %s requires a pattern", path, lineNum, action)
+		}
+
+		var allow bool
+		switch strings.ToLower(action) {
+		case "allow":
+			allow = true
+		case "deny":
+		default:
+			return nil, fmt.Errorf("%s line %d: unknown action %q (want allow or deny)", path, lineNum, action)
+		}
+
+		policy.rules = append(policy.rules, commandRule{
+			allow:   allow,
+			pattern: pattern,
+			line:    lineNum,
+			re:      compileCommandPattern(pattern),
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading command policy %s: %w", path, err)
+	}
+
+	return policy, nil
+}
+
+// compileCommandPattern turns a * and ? wildcard pattern into an anchored regexp
+func compileCommandPattern(pattern string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("^")
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
+
+// policyShellChars are the characters a POSIX shell gives a meaning to:
+// operators, redirections, expansions, globs, quotes and line breaks. The
+// remote shell runs the command line as it is, so any of them could run or
+// reach something other than what the rules matched.
+const policyShellChars = ";&|<>`$()\\'\"*?[]{}~!#\n\r"
+
+// Check reports whether command may run. A command containing any shell
+// metacharacter is refused outright, so operators cannot chain a denied
+// command onto an allowed one and quoting or globs cannot slip a denied
+// word past the patterns.
+func (p *CommandPolicy) Check(command string) error {
+	if err := ValidateCommand(command); err != nil {
+		return fmt.Errorf("command refused: %w", err)
+	}
+	if i := strings.IndexAny(command, policyShellChars); i >= 0 {
+		return fmt.Errorf("command refused: shell metacharacter %q is not allowed under a command policy", command[i])
+	}
+
+	var allowedBy *commandRule
+	for i := range p.rules {
+		rule := &p.rules[i]
+		if !rule.re.MatchString(command) {
+			continue
+		}
+		if !rule.allow {
+			return fmt.Errorf("command refused by %s line %d (deny %s)", p.path, rule.line, rule.pattern)
+		}
+		if allowedBy == nil {
+			allowedBy = rule
+		}
+	}
+
+	if allowedBy == nil {
+		return fmt.Errorf("command refused: not allowed by %s", p.path)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package security
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTestPolicy(t *testing.T, content string, mode os.FileMode) string {
+	t.Helper()
+	policyPath := filepath.Join(t.TempDir(), "policy")
+	if err := os.WriteFile(policyPath, []byte(content), mode); err != nil {
+		t.Fatalf("Failed to write policy: %v", err)
+	}
+	if err := os.Chmod(policyPath, mode); err != nil {
+		t.Fatalf("Failed to chmod policy: %v", err)
+	}
+	return policyPath
+}
+
+func TestCommandPolicyCheck(t *testing.T) {
+	policyPath := writeTestPolicy(t, `
+# Read-only operations for the on-call team
+allow uptime
+allow systemctl status *
+allow cat /var/log/*
+deny cat /var/log/auth*
+`, 0600)
+
+	policy, err := LoadCommandPolicy(policyPath)
+	if err != nil {
+		t.Fatalf("LoadCommandPolicy() error = %v", err)
+	}
+
+	tests := []struct {
+		command   string
+		wantAllow bool
+		wantErr   string
+	}{
+		{command: "uptime", wantAllow: true},
+		{command: "systemctl status nginx", wantAllow: true},
+		{command: "cat /var/log/syslog", wantAllow: true},
+		{command: "cat /var/log/auth.log", wantErr: "line 6"},
+		{command: "uptime -p", wantErr: "not allowed"},
+		{command: "systemctl restart nginx", wantErr: "not allowed"},
+		{command: "uptime; rm -rf /", wantErr: "dangerous pattern"},
+		{command: "systemctl status nginx && reboot", wantErr: "dangerous pattern"},
+		{command: "systemctl status x & rm -rf ~", wantErr: "metacharacter"},
+		{command: "systemctl status x > /etc/motd", wantErr: "metacharacter"},
+		{command: "cat /var/log/syslog < /etc/shadow", wantErr: "metacharacter"},
+		{command: "systemctl status x\nrm -rf /", wantErr: "metacharacter"},
+		{command: "systemctl status $HOME", wantErr: "metacharacter"},
+		{command: "cat /var/log/a*", wantErr: "metacharacter"},
+		{command: "cat /var/log/au''th.log", wantErr: "metacharacter"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			err := policy.Check(tt.command)
+			if tt.wantAllow {
+				if err != nil {
+					t.Errorf("Check() error = %v, want allowed", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("Check() allowed a command that should be refused")
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Check() error = %v, want it to mention %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadCommandPolicyErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		mode    os.FileMode
+	}{
+		{name: "unknown action", content: "permit uptime\n", mode: 0600},
+		{name: "missing pattern", content: "allow\n", mode: 0600},
+		{name: "group writable", content: "allow uptime\n", mode: 0620},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := LoadCommandPolicy(writeTestPolicy(t, tt.content, tt.mode)); err == nil {
+				t.Error("LoadCommandPolicy() should fail")
+			}
+		})
+	}
+}
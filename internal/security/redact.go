@@ -0,0 +1,50 @@
+package security
+
+import (
+	"io"
+	"regexp"
+)
+
+// secretPatterns match secrets that could end up in verbose or tsnet logs,
+// each with the replacement that masks it
+var secretPatterns = []struct {
+	re   *regexp.Regexp
+	repl string
+}{
+	// PEM private keys, including OpenSSH's format
+	{regexp.MustCompile(`(?s)-----BEGIN [A-Z0-9 ]*PRIVATE KEY-----.*?(-----END [A-Z0-9 ]*PRIVATE KEY-----|$)`), "[REDACTED PRIVATE KEY]"},
+	// Tailscale auth and API keys
+	{regexp.MustCompile(`tskey-[A-Za-z0-9-]+`), "tskey-[REDACTED]"},
+	// The token in a Tailscale login URL, e.g. https://login.tailscale.com/a/0123abcd
+	{regexp.MustCompile(`(https://[^\s/]+/a/)[A-Za-z0-9]+`), "${1}[REDACTED]"},
+	// password=..., PGPASSWORD=..., passphrase: ..., token=... and similar
+	{regexp.MustCompile(`(?i)([A-Za-z0-9_-]*(?:password|passphrase|passwd|secret|token|authkey))(\s*[=:]\s*)[^\s,;&]+`), "${1}${2}[REDACTED]"},
+}
+
+// RedactSecrets masks private keys, Tailscale auth keys, login URL tokens
+// and password-like key=value pairs in s
+func RedactSecrets(s string) string {
+	for _, p := range secretPatterns {
+		s = p.re.ReplaceAllString(s, p.repl)
+	}
+	return s
+}
+
+// redactingWriter applies RedactSecrets to everything written through it
+type redactingWriter struct {
+	w io.Writer
+}
+
+// NewRedactingWriter returns a writer that masks secrets before writing to
+// w. Each Write is redacted on its own, which suits log.Logger since it
+// writes every message with a single call.
+func NewRedactingWriter(w io.Writer) io.Writer {
+	return redactingWriter{w: w}
+}
+
+func (r redactingWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(r.w, RedactSecrets(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,11 @@
+//go:build !windows
+// +build !windows
+
+package security
+
+import "log/syslog"
+
+// openSystemLog connects to the local syslog daemon under the auth facility
+func openSystemLog() (syslogWriter, error) {
+	return syslog.New(syslog.LOG_AUTH|syslog.LOG_INFO, "ts-ssh")
+}
@@ -0,0 +1,28 @@
+//go:build windows
+// +build windows
+
+package security
+
+import "golang.org/x/sys/windows/svc/eventlog"
+
+// eventLogID is the event ID used for every audit event
+const eventLogID = 1
+
+// eventLogWriter adapts the Windows Event Log to syslogWriter
+type eventLogWriter struct {
+	log *eventlog.Log
+}
+
+// openSystemLog opens the Application event log with "ts-ssh" as the source
+func openSystemLog() (syslogWriter, error) {
+	l, err := eventlog.Open("ts-ssh")
+	if err != nil {
+		return nil, err
+	}
+	return eventLogWriter{log: l}, nil
+}
+
+func (w eventLogWriter) Info(m string) error    { return w.log.Info(eventLogID, m) }
+func (w eventLogWriter) Warning(m string) error { return w.log.Warning(eventLogID, m) }
+func (w eventLogWriter) Err(m string) error     { return w.log.Error(eventLogID, m) }
+func (w eventLogWriter) Close() error           { return w.log.Close() }
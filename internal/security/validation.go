@@ -240,6 +240,20 @@ func (iv *InputValidator) SanitizeShellArg(arg string) string {
 	return strconv.Quote(arg)
 }
 
+// shellSafeArg matches arguments no POSIX shell treats specially
+var shellSafeArg = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// QuoteShellArg quotes arg for a POSIX shell so it arrives as one word,
+// exactly as given. Unlike SanitizeShellArg's double quotes, single quotes
+// also stop $ and ` expansion. Arguments without special characters are
+// returned unchanged, so ordinary commands read the same in logs.
+func (iv *InputValidator) QuoteShellArg(arg string) string {
+	if shellSafeArg.MatchString(arg) {
+		return arg
+	}
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
 // ValidateWindowName validates tmux window names with appropriate restrictions
 func (iv *InputValidator) ValidateWindowName(windowName string) error {
 	if windowName == "" {
@@ -325,6 +339,10 @@ func SanitizeShellArg(arg string) string {
 	return DefaultValidator.SanitizeShellArg(arg)
 }
 
+func QuoteShellArg(arg string) string {
+	return DefaultValidator.QuoteShellArg(arg)
+}
+
 // ValidateWindowName validates tmux window names with appropriate restrictions
 func ValidateWindowName(windowName string) error {
 	if windowName == "" {
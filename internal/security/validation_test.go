@@ -296,6 +296,33 @@ func TestSanitizeShellArg(t *testing.T) {
 	}
 }
 
+func TestQuoteShellArg(t *testing.T) {
+	validator := NewInputValidator()
+
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"simple_arg", "test", "test"},
+		{"flag_and_path", "--dir=/tmp/a.b", "--dir=/tmp/a.b"},
+		{"arg_with_space", "hello world", "'hello world'"},
+		{"arg_with_single_quote", "don't", `'don'\''t'`},
+		{"empty_arg", "", "''"},
+		{"arg_with_dollar", "test$USER", "'test$USER'"},
+		{"arg_with_backticks", "test`whoami`", "'test`whoami`'"},
+		{"arg_with_glob", "*.go", "'*.go'"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := validator.QuoteShellArg(tt.input); result != tt.expected {
+				t.Errorf("QuoteShellArg(%s) = %s, expected %s", tt.input, result, tt.expected)
+			}
+		})
+	}
+}
+
 func TestValidateEnvironmentVariable(t *testing.T) {
 	validator := NewInputValidator()
 
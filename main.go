@@ -1,34 +1,33 @@
 package main
 
 import (
-	"context"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
-	"net"
 	"os"
 	osuser "os/user"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/term"
-	"tailscale.com/tsnet"
 
-	"github.com/derekg/ts-ssh/internal/client/scp"
+	"github.com/derekg/ts-ssh/internal/client/forward"
 	sshclient "github.com/derekg/ts-ssh/internal/client/ssh"
+	"github.com/derekg/ts-ssh/internal/config"
+	"github.com/derekg/ts-ssh/internal/crypto/pqc"
+	"github.com/derekg/ts-ssh/internal/platform"
 	"github.com/derekg/ts-ssh/internal/security"
+	"github.com/derekg/ts-ssh/tsssh"
 )
 
-// version is set at build time via -ldflags
-var version = "dev"
-
 func main() {
 	// Initialize security audit logging
 	if err := security.InitSecurityLogger(); err != nil {
-		fmt.Fprintf(os.Stderr, "Warning: Failed to initialize security audit logging: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Warning: Security audit logging: %v\n", err)
 	}
 	defer security.CloseSecurityLogger()
 	security.SetVersion(version)
@@ -39,626 +38,635 @@ func main() {
 		sshPort        = flag.String("p", "22", "SSH port")
 		keyPath        = flag.String("i", defaultKeyPath(), "SSH private key path")
 		tsnetDir       = flag.String("tsnet-dir", defaultTsnetDir(), "Tailscale state directory")
-		controlURL     = flag.String("control-url", "", "Tailscale control server URL")
 		verbose        = flag.Bool("v", false, "Verbose output")
 		insecure       = flag.Bool("insecure", false, "Skip host key verification (insecure)")
-		scpMode        = flag.Bool("scp", false, "SCP mode: ts-ssh -scp source dest")
+		scpMode        = flag.Bool("scp", false, "SCP mode: ts-ssh -scp source... dest")
 		showVersion    = flag.Bool("version", false, "Show version")
 		disablePTY     = flag.Bool("T", false, "Disable pseudo-terminal allocation")
-		dynamicForward = flag.String("D", "", "SOCKS5 dynamic port forwarding on [bind_address:]port")
+		noCommand      = flag.Bool("N", false, "Don't run a command or shell; keep -L and -D forwards open until interrupted")
+		requirePTY     = flag.Bool("t", false, "Run a remote command on a pseudo-terminal too, and fail instead of continuing without one when the server refuses it")
+		identitiesOnly = flag.Bool("identities-only", false, "Only use the key given with -i, skip automatic key discovery")
+		identityStdin  = flag.Bool("identity-from-stdin", false, "Read the private key from stdin instead of -i, never writing it to disk (passphrase via SSH_ASKPASS)")
+		addKeysAgent   = flag.String("add-keys-to-agent", "no", "Add keys to the SSH agent once their passphrase is entered and use them from it afterwards: no, yes, confirm, a lifetime (e.g. 1h) or \"confirm 1h\"")
+		noKbdInteract  = flag.Bool("no-keyboard-interactive", false, "Disable keyboard-interactive (OTP/2FA) authentication")
+		noBanner       = flag.Bool("no-banner", false, "Don't print the banner some servers send before authentication")
+		cachePassword  = flag.Bool("cache-password", false, "Try the password typed for one -J hop on the later hops and the destination before prompting again")
+		authDelay      = flag.Duration("auth-delay", 0, "Minimum delay between authentication attempts to a host, doubling while they keep failing (e.g. 500ms)")
+		idleTimeout    = flag.Duration("idle-timeout", 0, "Close the interactive session after this long without input or output (e.g. 15m)")
+		keepAlive      = flag.Duration("keepalive", 0, "While -L/-D forwards or -N are active, send an SSH keepalive this often so NATs don't drop idle tunnels (e.g. 30s)")
+		keepAliveMax   = flag.Int("keepalive-count-max", sshclient.DefaultKeepAliveCountMax, "Unanswered -keepalive messages after which the connection is dropped")
+		execTimeout    = flag.Duration("exec-timeout", 0, "Stop the remote command after this long and exit with status 8 (e.g. 30s)")
+		scanKeys       = flag.Bool("scan-keys", false, "Print host keys in known_hosts format: ts-ssh -scan-keys host[,host...]")
+		fingerprint    = flag.Bool("fingerprint", false, "Print a host key's SHA256 fingerprint and randomart without saving it: ts-ssh -fingerprint host[:port]")
+		checkHostsMode = flag.Bool("check", false, "Check in parallel whether hosts are reachable and accept the key without prompting: ts-ssh -check host[,host...]")
+		addScanned     = flag.Bool("add", false, "With -scan-keys, append scanned keys to ~/.ssh/known_hosts (or -user-known-hosts-file)")
+		sshConfigFile  = flag.String("F", "", "OpenSSH config file to read User, IdentityFile and StrictHostKeyChecking from")
+		allowMatchExec = flag.Bool("allow-match-exec", false, "Allow \"Match exec\" blocks in the -F config to run local commands")
+		tsnetLogFile   = flag.String("tsnet-log-file", "", "Write Tailscale (tsnet) logs to this file instead of the console")
+		tsnetVerbose   = flag.Bool("tsnet-verbose", false, "Show Tailscale (tsnet) logs on stderr, independent of -v")
+		knownHostsFile = flag.String("user-known-hosts-file", "", "known_hosts file to use instead of ~/.ssh/known_hosts (/etc/ssh/ssh_known_hosts is also checked)")
+		noHostKeyAdd   = flag.Bool("no-host-key-append", false, "Never write accepted host keys to known_hosts (read-only)")
+		acceptChanged  = flag.String("accept-changed-for", "", "Accept a changed host key for these hosts (host[,host...]) and replace the old known_hosts entry")
+		dumpConfig     = flag.Bool("dump-config", false, "Print the effective settings for the target (like ssh -G) and exit")
+		compress       = flag.Bool("C", false, "Request compression (accepted for ssh compatibility; transfers stay uncompressed)")
+		ipv4Only       = flag.Bool("4", false, "Connect using the host's IPv4 tailnet address only")
+		ipv6Only       = flag.Bool("6", false, "Connect using the host's IPv6 tailnet address only")
+		commandPolicy  = flag.String("command-policy", "", "Only run remote commands allowed by this policy file (interactive shells are refused)")
+		resolveHost    = flag.Bool("resolve", false, "Print a host's tailnet addresses, DNS name and online status: ts-ssh -resolve host")
+		jsonOutput     = flag.Bool("json", false, "With -resolve, -fingerprint or -version, print JSON")
+		refreshPeers   = flag.Bool("refresh", false, "With -resolve, ignore the cached peer list and ask the tailnet")
+		configCheck    = flag.Bool("config-check", false, "Validate the -F config file and exit without connecting")
+		ptySizeSpec    = flag.String("pty-size", "", "Fixed pseudo-terminal size COLSxROWS, ignoring local resizes (e.g. 120x40)")
+		preserve       = flag.Bool("preserve", false, "With -scp, keep modification times and modes of copied files (like scp -p)")
+		manifestPath   = flag.String("manifest", "", "With -scp, write a JSON record of each file's size, SHA-256 and result to this file")
+		outputTemplate = flag.String("output", "", "With -scp downloads, local path template used instead of a destination: {host}, {path}, {basename}, {date}")
+		controlProxy   = flag.String("control-proxy", "", "HTTP(S) proxy URL for reaching the Tailscale control plane (default: HTTPS_PROXY)")
+		jumpSpec       = flag.String("J", "", "Connect through these jump hosts in order, for SSH and -scp: [user@]host[:port][,...]")
+		jumpTimeout    = flag.Duration("jump-timeout", DefaultJumpTimeout, "Give up on a -J jump host that hasn't presented its host key within this long of being dialed; prompts don't count and the destination has its own timeout (0 for no limit)")
+		httpProxy      = flag.String("proxy", "", "Reach the SSH host through this HTTP CONNECT proxy on the tailnet (http://[user:pass@]host:port)")
+		openBrowser    = flag.Bool("open-browser", false, "Open the Tailscale login URL in the default browser (it is still printed)")
+		pqcLevel       = flag.Int("pqc-level", 0, "Post-quantum key exchange: 0 off, 1 prefer and log a classical fallback, 2 require")
+		forceCommand   = flag.String("force-command", "", "Always run this remote command, ignoring any command given (also ForceCommand in -F config)")
+		remoteCommand  = flag.String("remote-command", "", "Run this command on connect when none is given (also RemoteCommand in -F config); with -t it runs on a terminal, so one ending in a shell keeps the session")
+		preConnect     = flag.String("pre-connect", "", "Local command to run before connecting; the connection is not made if it fails")
+		postConnect    = flag.String("post-connect", "", "Local command to run once the SSH connection is established")
+		localCommand   = flag.String("local-command", "", "Local command to run after connecting, with %h, %p, %r, %l, %u and %C expanded (needs -permit-local-command)")
+		permitLocalCmd = flag.Bool("permit-local-command", false, "Allow -local-command to run, like OpenSSH's PermitLocalCommand")
+		skipIf         = flag.String("skip-if", "", "Run this remote command first and skip the given command where it succeeds (e.g. \"test -f /done\")")
+		pidFile        = flag.String("write-pid", "", "Write the process ID to this file (mode 0600) once connected and forwarding; it is removed on exit")
+		showSummary    = flag.Bool("summary", false, "When the session ends, print its duration, bytes sent and received, and exit status (also with -v)")
+		escapeChar     = flag.String("e", "~", "Escape character for interactive sessions: ~, or none to send ~ through unchanged")
+		osc52Flag      = flag.String("osc52", "pass", "OSC 52 clipboard sequences from the remote: pass to the terminal, copy (also to the system clipboard) or off")
+		colorMode      = flag.String("color", "auto", "Color warnings: auto (stderr is a terminal and NO_COLOR is unset), always or never")
+		errorFormat    = flag.String("error-format", "text", "Print a failure to connect, authenticate or transfer as text or json ({error, class, host, code})")
 	)
 
+	var controlURLs stringList
+	flag.Var(&controlURLs, "control-url", "Tailscale control server URL (repeatable; later ones are tried in order if the first can't be reached)")
+	var dynamicForwards stringList
+	flag.Var(&dynamicForwards, "D", "SOCKS5 dynamic port forwarding on `[bind_address:]port` (repeatable)")
+	var localForwards stringList
+	flag.Var(&localForwards, "L", "Forward local `[bind_address:]port:host:hostport` to host:hostport through the SSH host (repeatable)")
 	flag.Usage = usage
 	flag.Parse()
 
-	if *showVersion {
-		fmt.Println(version)
-		os.Exit(0)
+	if *errorFormat != "text" && *errorFormat != "json" {
+		os.Exit(reportError(os.Stderr, "text", &usageError{fmt.Errorf("invalid -error-format %q: use text or json", *errorFormat)}, ""))
 	}
-
-	// Setup logger
-	logger := log.New(io.Discard, "", 0)
-	if *verbose {
-		logger = log.New(os.Stderr, "", log.LstdFlags)
+	// fail reports err as -error-format asks and exits with its status;
+	// operand is the [user@]host[:port] it concerns, if any. failUsage does
+	// the same for invalid options and settings, which exit with ExitUsage.
+	fail := func(err error, operand string) {
+		os.Exit(reportError(os.Stderr, *errorFormat, err, operand))
+	}
+	failUsage := func(err error, operand string) {
+		fail(&usageError{err}, operand)
 	}
 
-	args := flag.Args()
+	mode, err := platform.ParseColorMode(*colorMode)
+	if err != nil {
+		failUsage(err, "")
+	}
+	platform.SetStderrColor(platform.UseColor(mode, term.IsTerminal(int(os.Stderr.Fd()))))
 
-	// SCP mode: ts-ssh -scp source dest
-	if *scpMode {
-		if len(args) != 2 {
-			fmt.Fprintf(os.Stderr, "Error: SCP mode requires exactly 2 arguments (source dest)\n")
-			os.Exit(1)
-		}
-		if err := runSCP(args[0], args[1], *sshUser, *keyPath, *tsnetDir, *controlURL, *insecure, *verbose, logger); err != nil {
-			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-			os.Exit(1)
+	if *showVersion {
+		if err := printVersion(os.Stdout, *jsonOutput); err != nil {
+			failUsage(err, "")
 		}
-		return
+		os.Exit(0)
 	}
 
-	// SSH mode: ts-ssh [user@]host[:port] [command...]
-	if len(args) < 1 {
-		fmt.Fprintf(os.Stderr, "Error: target hostname required\n\n")
-		flag.Usage()
-		os.Exit(1)
+	// Options given explicitly on the command line take precedence over -F
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	// Expand ~ and $VAR in path flags the shell left alone (-i=~/key, quoted paths)
+	for _, p := range []*string{keyPath, tsnetDir, sshConfigFile, knownHostsFile, tsnetLogFile, commandPolicy, manifestPath} {
+		expanded, err := config.ExpandPath(*p)
+		if err != nil {
+			failUsage(err, "")
+		}
+		*p = expanded
 	}
 
-	target := args[0]
-	var remoteCmd []string
-	if len(args) > 1 {
-		remoteCmd = args[1:]
+	// Setup logger; secrets such as keys and login tokens are masked
+	logger := log.New(io.Discard, "", 0)
+	if *verbose {
+		logger = log.New(security.NewRedactingWriter(os.Stderr), "", log.LstdFlags)
 	}
 
-	if err := runSSH(target, remoteCmd, *sshUser, *sshPort, *keyPath, *tsnetDir, *controlURL, *insecure, *disablePTY, *dynamicForward, *verbose, logger); err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+	// Dedicated tsnet log stream, kept apart from the SSH logs
+	var tsnetLog *log.Logger
+	if *tsnetLogFile != "" {
+		f, err := security.CreateSecureFileForAppend(*tsnetLogFile, 0600)
+		if err != nil {
+			failUsage(fmt.Errorf("failed to open tsnet log file: %w", err), "")
+		}
+		defer f.Close()
+		tsnetLog = log.New(security.NewRedactingWriter(f), "", log.LstdFlags)
+	} else if *tsnetVerbose {
+		tsnetLog = log.New(security.NewRedactingWriter(os.Stderr), "tsnet: ", log.LstdFlags)
 	}
-}
 
-func usage() {
-	fmt.Fprintf(os.Stderr, "Usage: %s [options] [user@]host[:port] [command...]\n", os.Args[0])
-	fmt.Fprintf(os.Stderr, "       %s -scp source dest\n\n", os.Args[0])
-	fmt.Fprintf(os.Stderr, "SSH over Tailscale without requiring a full Tailscale daemon\n\n")
-	fmt.Fprintf(os.Stderr, "Options:\n")
-	flag.PrintDefaults()
-	fmt.Fprintf(os.Stderr, "\nExamples:\n")
-	fmt.Fprintf(os.Stderr, "  %s hostname                    # Interactive SSH\n", os.Args[0])
-	fmt.Fprintf(os.Stderr, "  %s user@hostname uptime        # Execute command\n", os.Args[0])
-	fmt.Fprintf(os.Stderr, "  %s hostname:2222               # Custom port\n", os.Args[0])
-	fmt.Fprintf(os.Stderr, "  %s -scp file.txt host:/tmp/    # Copy file\n", os.Args[0])
-	fmt.Fprintf(os.Stderr, "  %s -v hostname                 # Verbose mode\n", os.Args[0])
-}
+	args := flag.Args()
 
-// runSSH handles the SSH connection
-func runSSH(target string, remoteCmd []string, defaultUser, defaultPort, keyPath, tsnetDir, controlURL string, insecure, disablePTY bool, dynamicForward string, verbose bool, logger *log.Logger) error {
-	// Parse target: [user@]host[:port]
-	sshUser, host, port, err := parseSSHTarget(target, defaultUser, defaultPort)
-	if err != nil {
-		return err
+	for _, controlURL := range controlURLs {
+		if err := validateControlURL(controlURL); err != nil {
+			failUsage(err, "")
+		}
 	}
-
-	// Validate inputs
-	if err := security.ValidateSSHUser(sshUser); err != nil {
-		return fmt.Errorf("invalid SSH user: %w", err)
+	proxyURL, err := parseControlProxy(*controlProxy)
+	if err != nil {
+		failUsage(err, "")
 	}
-	if err := security.ValidateHostname(host); err != nil {
-		return fmt.Errorf("invalid hostname: %w", err)
+	tailnet := tailnetOptions{
+		dir:          *tsnetDir,
+		controlURLs:  controlURLs,
+		controlProxy: proxyURL,
+		log:          tsnetLog,
+		openBrowser:  *openBrowser,
 	}
-	if err := security.ValidatePort(port); err != nil {
-		return fmt.Errorf("invalid port: %w", err)
+
+	if _, err := pqc.ConfigForLevel(*pqcLevel); err != nil {
+		failUsage(err, "")
 	}
 
-	// Initialize tsnet
-	srv, ctx, err := initTailscale(tsnetDir, controlURL, verbose, logger)
-	if err != nil {
-		return fmt.Errorf("failed to initialize Tailscale: %w", err)
+	// x/crypto/ssh only implements the "none" compression method, so there
+	// is no zlib@openssh.com to negotiate; say so instead of failing scripts
+	// that pass -C out of habit
+	if *compress && *verbose {
+		logger.Printf("Compression requested with -C, but this client only supports uncompressed connections")
 	}
 
-	// Establish SSH connection
-	client, err := connectSSH(srv, ctx, sshUser, host, port, keyPath, insecure, verbose, logger)
-	if err != nil {
-		return fmt.Errorf("failed to connect via SSH: %w", err)
+	var addressFamily string
+	switch {
+	case *ipv4Only && *ipv6Only:
+		failUsage(errors.New("-4 and -6 are mutually exclusive"), "")
+	case (*ipv4Only || *ipv6Only) && *httpProxy != "":
+		// The proxy resolves the host, not the tailnet
+		failUsage(errors.New("-4 and -6 can't be used with -proxy"), "")
+	case *ipv4Only:
+		addressFamily = sshclient.AddressFamilyIPv4
+	case *ipv6Only:
+		addressFamily = sshclient.AddressFamilyIPv6
 	}
-	defer client.Close()
 
-	// Setup dynamic port forwarding if requested
-	if dynamicForward != "" {
-		if err := setupDynamicForward(client, dynamicForward, verbose, logger); err != nil {
-			return fmt.Errorf("failed to setup dynamic forwarding: %w", err)
+	if *httpProxy != "" {
+		if _, err := sshclient.ParseHTTPProxyURL(*httpProxy); err != nil {
+			failUsage(err, "")
 		}
 	}
 
-	// Execute command or start interactive session
-	if len(remoteCmd) > 0 {
-		return execRemoteCommand(client, remoteCmd, logger)
+	for _, spec := range localForwards {
+		if _, err := forward.ParseLocal(spec); err != nil {
+			failUsage(err, "")
+		}
 	}
 
-	return interactiveSession(client, disablePTY, logger)
-}
-
-// runSCP handles SCP file transfer
-func runSCP(source, dest, defaultUser, keyPath, tsnetDir, controlURL string, insecure, verbose bool, logger *log.Logger) error {
-	// Determine which is local and which is remote
-	srcHost, srcPath, srcIsRemote := parseSCPArg(source)
-	dstHost, dstPath, dstIsRemote := parseSCPArg(dest)
-
-	// Exactly one must be remote
-	if srcIsRemote == dstIsRemote {
-		return fmt.Errorf("exactly one of source or destination must be remote (host:path)")
+	acceptChangedFor, err := parseHostList(*acceptChanged)
+	if err != nil {
+		failUsage(fmt.Errorf("invalid -accept-changed-for: %w", err), "")
 	}
 
-	var targetHost, remotePath, localPath, sshUser string
-	var upload bool
-
-	if srcIsRemote {
-		// Download: remote -> local
-		targetHost = srcHost
-		remotePath = srcPath
-		localPath = dstPath
-		upload = false
-	} else {
-		// Upload: local -> remote
-		targetHost = dstHost
-		remotePath = dstPath
-		localPath = srcPath
-		upload = true
+	jumpHosts, err := parseJumpHosts(*jumpSpec)
+	if err != nil {
+		failUsage(fmt.Errorf("invalid -J: %w", err), "")
 	}
 
-	// Parse target host for user@host[:port]
-	sshUser, host, port, err := parseSSHTarget(targetHost, defaultUser, "22")
+	agentOpts, err := sshclient.ParseAddKeysToAgent(*addKeysAgent)
 	if err != nil {
-		return err
+		failUsage(err, "")
 	}
 
-	// Validate inputs
-	if err := security.ValidateSSHUser(sshUser); err != nil {
-		return fmt.Errorf("invalid SSH user: %w", err)
-	}
-	if err := security.ValidateHostname(host); err != nil {
-		return fmt.Errorf("invalid hostname: %w", err)
+	// A key piped in by a secret manager is only ever held in memory
+	var identity ssh.Signer
+	if *identityStdin {
+		if term.IsTerminal(int(os.Stdin.Fd())) {
+			failUsage(errors.New("-identity-from-stdin needs the private key piped to stdin"), "")
+		}
+		identity, err = sshclient.LoadPrivateKeyFrom(os.Stdin, "(stdin)", logger)
+		if err != nil {
+			failUsage(fmt.Errorf("-identity-from-stdin: %w", err), "")
+		}
 	}
 
-	// Initialize tsnet
-	srv, ctx, err := initTailscale(tsnetDir, controlURL, verbose, logger)
-	if err != nil {
-		return fmt.Errorf("failed to initialize Tailscale: %w", err)
+	// Config check mode: ts-ssh -F file -config-check
+	if *configCheck {
+		if *sshConfigFile == "" {
+			failUsage(errors.New("-config-check requires a config file given with -F"), "")
+		}
+		if err := runConfigCheck(*sshConfigFile, os.Stdout); err != nil {
+			failUsage(err, "")
+		}
+		return
 	}
 
-	// Get current user for SCP client
-	currentUser, err := osuser.Current()
-	if err != nil {
-		currentUser = &osuser.User{Username: sshUser}
+	// Resolve mode: ts-ssh -resolve host
+	if *resolveHost {
+		if len(args) != 1 {
+			failUsage(errors.New("-resolve requires exactly one host"), "")
+		}
+		if err := runResolve(args[0], tailnet, *jsonOutput, *refreshPeers, *verbose, logger); err != nil {
+			fail(err, args[0])
+		}
+		return
 	}
 
-	// Perform SCP operation
-	addr := host + ":" + port
-	if err := scp.HandleCliScp(srv, ctx, logger, sshUser, keyPath, insecure, currentUser,
-		localPath, remotePath, addr, upload, verbose); err != nil {
-		return fmt.Errorf("SCP failed: %w", err)
-	}
+	// One throttle paces and caps authentication for every host and jump
+	// host this process connects to
+	authThrottle := sshclient.NewAuthThrottle(*authDelay, 0)
 
-	if verbose {
-		logger.Println("SCP transfer completed successfully")
+	// -F can still change the user, key and host key settings, so they are
+	// read when a mode is about to connect
+	connectOpts := func() connectOptions {
+		var passwords *sshclient.PasswordCache
+		if *cachePassword {
+			passwords = sshclient.NewPasswordCache()
+		}
+		return connectOptions{
+			defaultUser:           *sshUser,
+			defaultPort:           *sshPort,
+			keyPath:               *keyPath,
+			identity:              identity,
+			knownHostsFile:        *knownHostsFile,
+			readOnlyKnownHosts:    *noHostKeyAdd,
+			acceptChangedFor:      acceptChangedFor,
+			insecure:              *insecure,
+			identitiesOnly:        *identitiesOnly,
+			noKeyboardInteractive: *noKbdInteract,
+			authThrottle:          authThrottle,
+			pqcLevel:              *pqcLevel,
+			addKeysToAgent:        agentOpts,
+			passwordCache:         passwords,
+			jumpHosts:             jumpHosts,
+			jumpTimeout:           *jumpTimeout,
+			addressFamily:         addressFamily,
+			httpProxy:             *httpProxy,
+			noBanner:              *noBanner,
+			tailnet:               tailnet,
+			verbose:               *verbose,
+			logger:                logger,
+		}
 	}
-	return nil
-}
 
-// parseSSHTarget parses [user@]host[:port] and returns user, host, port
-func parseSSHTarget(target, defaultUser, defaultPort string) (user, host, port string, err error) {
-	user = defaultUser
-	host = target
-	port = defaultPort
-
-	// Extract user if present
-	if strings.Contains(host, "@") {
-		parts := strings.SplitN(host, "@", 2)
-		user = parts[0]
-		host = parts[1]
-	}
-
-	// Extract port if present
-	if strings.Contains(host, ":") {
-		// Handle IPv6 addresses [::1]:port
-		if strings.HasPrefix(host, "[") {
-			endBracket := strings.Index(host, "]")
-			if endBracket == -1 {
-				return "", "", "", fmt.Errorf("invalid IPv6 address format")
+	// SCP mode: ts-ssh -scp source... dest
+	if *scpMode {
+		scpArgs := args
+		if *outputTemplate != "" {
+			if _, err := expandOutputTemplate(*outputTemplate, "host", "file", time.Now()); err != nil {
+				failUsage(err, "")
 			}
-			if len(host) > endBracket+1 && host[endBracket+1] == ':' {
-				port = host[endBracket+2:]
-				host = host[1:endBracket]
-			} else {
-				host = host[1:endBracket]
+			if strings.Contains(*outputTemplate, ":") {
+				failUsage(errors.New("-output must be a local path"), "")
+			}
+			// The template takes the place of the local destination
+			scpArgs = append(append([]string(nil), args...), *outputTemplate)
+		}
+		if len(scpArgs) < 2 {
+			failUsage(errors.New("SCP mode requires at least 2 arguments (source... dest)"), "")
+		}
+		if *commandPolicy != "" {
+			// scp runs its own remote command and can overwrite any file
+			failUsage(errors.New("SCP is not available when a command policy is in effect"), "")
+		}
+		transfer, err := parseSCPArgs(scpArgs)
+		if err != nil {
+			failUsage(err, transfer.host)
+		}
+		if *outputTemplate != "" {
+			if transfer.upload {
+				failUsage(errors.New("-output only applies to downloads"), transfer.host)
 			}
-		} else {
-			parts := strings.Split(host, ":")
-			if len(parts) == 2 {
-				host = parts[0]
-				port = parts[1]
+			transfer.output, transfer.dest = transfer.dest, ""
+		}
+		transfer.preserve = *preserve
+		transfer.manifest = *manifestPath
+		if *sshConfigFile != "" {
+			// scp runs its own remote command, so RemoteCommand doesn't apply
+			if err := applySSHConfig(*sshConfigFile, transfer.host, *allowMatchExec, explicit, sshUser, keyPath, knownHostsFile, forceCommand, new(string), insecure); err != nil {
+				failUsage(err, transfer.host)
 			}
 		}
+		if *forceCommand != "" {
+			// scp would run its own remote command instead of the forced one
+			failUsage(errors.New("SCP is not available when a forced command is in effect"), transfer.host)
+		}
+		if err := runSCP(transfer, connectOpts()); err != nil {
+			fail(err, transfer.host)
+		}
+		return
 	}
 
-	if host == "" {
-		return "", "", "", fmt.Errorf("hostname cannot be empty")
+	// Key scan mode: ts-ssh -scan-keys host[,host...]
+	if *scanKeys {
+		if len(args) != 1 {
+			failUsage(errors.New("-scan-keys requires a comma-separated host list"), "")
+		}
+		if err := runScanKeys(strings.Split(args[0], ","), *sshPort, *knownHostsFile, tailnet, *addScanned, *verbose, logger); err != nil {
+			fail(err, "")
+		}
+		return
 	}
 
-	return user, host, port, nil
-}
-
-// parseSCPArg parses SCP argument (either local path or host:path)
-func parseSCPArg(arg string) (host, path string, isRemote bool) {
-	// Check if it contains : (remote path)
-	// But not C:\ on Windows
-	if idx := strings.Index(arg, ":"); idx > 0 && idx < len(arg)-1 {
-		// Make sure it's not a Windows drive letter
-		if idx == 1 && len(arg) > 2 {
-			// Could be C:\path on Windows, treat as local
-			return "", arg, false
-		}
-		host = arg[:idx]
-		path = arg[idx+1:]
-		return host, path, true
-	}
-	return "", arg, false
-}
-
-// initTailscale initializes tsnet and returns server and context
-func initTailscale(tsnetDir, controlURL string, verbose bool, logger *log.Logger) (*tsnet.Server, context.Context, error) {
-	// Ensure directory exists
-	if err := os.MkdirAll(tsnetDir, 0700); err != nil {
-		return nil, nil, fmt.Errorf("failed to create tsnet directory: %w", err)
-	}
-
-	srv := &tsnet.Server{
-		Dir:        tsnetDir,
-		Hostname:   ClientName,
-		ControlURL: controlURL,
-	}
-
-	// Configure logging
-	if verbose {
-		srv.Logf = logger.Printf
-		srv.UserLogf = logger.Printf
-	} else {
-		// Silent mode - only show auth URLs
-		srv.Logf = func(string, ...interface{}) {}
-		srv.UserLogf = func(format string, args ...interface{}) {
-			msg := fmt.Sprintf(format, args...)
-			if strings.Contains(msg, "https://") {
-				fmt.Fprintf(os.Stderr, "\nTo authenticate, visit:\n%s\n\n", extractURL(msg))
-			}
+	// Reachability check mode: ts-ssh -check host[,host...]
+	if *checkHostsMode {
+		if len(args) != 1 {
+			failUsage(errors.New("-check requires a comma-separated host list"), "")
+		}
+		hosts, err := parseHostList(args[0])
+		if err == nil && len(hosts) == 0 {
+			err = errors.New("-check requires at least one host")
 		}
+		if err == nil {
+			err = runCheckHosts(hosts, connectOpts())
+		}
+		if err != nil {
+			fail(err, "")
+		}
+		return
 	}
 
-	ctx := context.Background()
-
-	if !verbose {
-		fmt.Fprintf(os.Stderr, "Connecting to Tailscale...\n")
+	// Fingerprint mode: ts-ssh -fingerprint host[:port]
+	if *fingerprint {
+		if len(args) != 1 {
+			failUsage(errors.New("-fingerprint requires exactly one host"), "")
+		}
+		if err := runFingerprint(args[0], *sshPort, tailnet, *jsonOutput, *verbose, logger); err != nil {
+			fail(err, args[0])
+		}
+		return
 	}
 
-	status, err := srv.Up(ctx)
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to bring up Tailscale: %w", err)
+	// SSH mode: ts-ssh [user@]host[:port] [command...]
+	if len(args) < 1 {
+		code := reportError(os.Stderr, *errorFormat, &usageError{errors.New("target hostname required")}, "")
+		if *errorFormat == "text" {
+			fmt.Fprintln(os.Stderr)
+			flag.Usage()
+		}
+		os.Exit(code)
 	}
 
-	// Show auth URL if needed
-	if status != nil && status.AuthURL != "" {
-		fmt.Fprintf(os.Stderr, "\nTo authenticate, visit:\n%s\n\n", status.AuthURL)
+	target := args[0]
+	var remoteCmd []string
+	if len(args) > 1 {
+		remoteCmd = args[1:]
 	}
 
-	return srv, ctx, nil
-}
-
-// connectSSH establishes SSH connection
-func connectSSH(srv *tsnet.Server, ctx context.Context, user, host, port, keyPath string, insecure, verbose bool, logger *log.Logger) (*ssh.Client, error) {
-	currentUser, err := osuser.Current()
-	if err != nil {
-		currentUser = &osuser.User{Username: user}
+	if *disablePTY && *requirePTY {
+		failUsage(errors.New("-t and -T cannot be used together"), target)
 	}
 
-	config := sshclient.SSHConnectionConfig{
-		User:            user,
-		KeyPath:         keyPath,
-		TargetHost:      host,
-		TargetPort:      port,
-		InsecureHostKey: insecure,
-		Verbose:         verbose,
-		CurrentUser:     currentUser,
-		Logger:          logger,
+	for _, hook := range []struct{ name, command string }{{"pre-connect", *preConnect}, {"post-connect", *postConnect}} {
+		if hook.command == "" {
+			continue
+		}
+		if err := security.ValidateCommand(hook.command); err != nil {
+			failUsage(fmt.Errorf("invalid -%s command: %w", hook.name, err), target)
+		}
 	}
 
-	return sshclient.EstablishSSHConnection(srv, ctx, config)
-}
-
-// execRemoteCommand executes a remote command
-func execRemoteCommand(client *ssh.Client, cmd []string, logger *log.Logger) error {
-	logger.Printf("Executing remote command: %v\n", cmd)
-
-	session, err := client.NewSession()
-	if err != nil {
-		return fmt.Errorf("failed to create session: %w", err)
+	// As with OpenSSH, LocalCommand only runs when explicitly permitted
+	if *localCommand != "" && !*permitLocalCmd {
+		fmt.Fprintf(os.Stderr, "%s -local-command is ignored without -permit-local-command\n", platform.Colorize(platform.ColorYellow, "Warning:"))
+		*localCommand = ""
 	}
-	defer session.Close()
-
-	session.Stdout = os.Stdout
-	session.Stderr = os.Stderr
-	session.Stdin = os.Stdin
-
-	cmdStr := strings.Join(cmd, " ")
-	if err := session.Run(cmdStr); err != nil {
-		if exitErr, ok := err.(*ssh.ExitError); ok {
-			os.Exit(exitErr.ExitStatus())
+	if *localCommand != "" {
+		if _, err := sshclient.ExpandTokens(*localCommand, sshclient.ConnectionTokens{}); err != nil {
+			failUsage(fmt.Errorf("invalid -local-command: %w", err), target)
+		}
+		if err := security.ValidateCommand(*localCommand); err != nil {
+			failUsage(fmt.Errorf("invalid -local-command: %w", err), target)
 		}
-		return fmt.Errorf("remote command failed: %w", err)
 	}
 
-	return nil
-}
-
-// interactiveSession starts an interactive SSH session
-func interactiveSession(client *ssh.Client, disablePTY bool, logger *log.Logger) error {
-	session, err := client.NewSession()
-	if err != nil {
-		return fmt.Errorf("failed to create session: %w", err)
+	if *keepAlive < 0 {
+		failUsage(errors.New("-keepalive must not be negative"), target)
 	}
-	defer session.Close()
-
-	// Setup I/O
-	stdinPipe, err := session.StdinPipe()
+	if *keepAliveMax < 1 {
+		failUsage(errors.New("-keepalive-count-max must be at least 1"), target)
+	}
+	osc52, err := sshclient.ParseOSC52Mode(*osc52Flag)
 	if err != nil {
-		return fmt.Errorf("failed to setup stdin: %w", err)
+		failUsage(err, target)
+	}
+	if *escapeChar != "~" && *escapeChar != "none" {
+		failUsage(fmt.Errorf("-e must be ~ or none, got %q", *escapeChar), target)
 	}
-	session.Stdout = os.Stdout
-	session.Stderr = os.Stderr
 
-	// Setup PTY if we're in a terminal and PTY is not disabled
-	fd := int(os.Stdin.Fd())
-	if !disablePTY && term.IsTerminal(fd) {
-		// Get terminal size
-		width, height, err := term.GetSize(fd)
+	var ptySize tsssh.PTYSize
+	if *ptySizeSpec != "" {
+		size, err := tsssh.ParsePTYSize(*ptySizeSpec)
 		if err != nil {
-			width, height = 80, 24
+			failUsage(err, target)
 		}
+		ptySize = size
+	}
 
-		termType := os.Getenv("TERM")
-		if termType == "" {
-			termType = "xterm-256color"
+	if *sshConfigFile != "" {
+		if err := applySSHConfig(*sshConfigFile, target, *allowMatchExec, explicit, sshUser, keyPath, knownHostsFile, forceCommand, remoteCommand, insecure); err != nil {
+			failUsage(err, target)
 		}
-
-		if err := session.RequestPty(termType, height, width, ssh.TerminalModes{}); err != nil {
-			return fmt.Errorf("failed to request PTY: %w", err)
+	}
+	// As with OpenSSH, "none" clears a RemoteCommand from the config
+	if *remoteCommand != "" && *remoteCommand != "none" {
+		if len(remoteCmd) > 0 {
+			failUsage(errors.New("can't run both a command-line command and -remote-command (RemoteCommand)"), target)
 		}
+		remoteCmd = []string{*remoteCommand}
+	}
+	remoteCmd = applyForceCommand(*forceCommand, remoteCmd, target, logger)
+	if *noCommand && len(remoteCmd) > 0 {
+		failUsage(errors.New("-N can't be used with a remote command or -force-command"), target)
+	}
+	if err := checkSkipIf(*skipIf, *forceCommand, remoteCmd); err != nil {
+		failUsage(err, target)
+	}
 
-		// Put terminal in raw mode
-		oldState, err := term.MakeRaw(fd)
+	var policy *security.CommandPolicy
+	if *commandPolicy != "" {
+		p, err := security.LoadCommandPolicy(*commandPolicy)
 		if err != nil {
-			logger.Printf("Warning: failed to set raw mode: %v\n", err)
-		} else {
-			defer term.Restore(fd, oldState)
+			failUsage(err, target)
 		}
+		policy = p
 	}
 
-	// Start shell
-	if err := session.Shell(); err != nil {
-		return fmt.Errorf("failed to start shell: %w", err)
+	if *dumpConfig {
+		if err := dumpEffectiveConfig(os.Stdout, target, *sshUser, *sshPort, *keyPath, *knownHostsFile, *insecure, *noHostKeyAdd, *identitiesOnly); err != nil {
+			failUsage(err, target)
+		}
+		return
 	}
 
-	// Copy stdin to session
-	go func() {
-		io.Copy(stdinPipe, os.Stdin)
-		stdinPipe.Close()
-	}()
-
-	// Wait for session to finish
-	return session.Wait()
-}
-
-// Helper functions for defaults
-func currentUsername() string {
-	if u, err := osuser.Current(); err == nil {
-		return u.Username
+	session := sessionOptions{
+		remoteCmd:         remoteCmd,
+		policy:            policy,
+		skipIf:            *skipIf,
+		noCommand:         *noCommand,
+		disablePTY:        *disablePTY,
+		requirePTY:        *requirePTY,
+		ptySize:           ptySize,
+		idleTimeout:       *idleTimeout,
+		execTimeout:       *execTimeout,
+		osc52:             osc52,
+		noEscapes:         *escapeChar == "none",
+		dynamicForwards:   dynamicForwards,
+		localForwards:     localForwards,
+		keepAlive:         *keepAlive,
+		keepAliveCountMax: *keepAliveMax,
+		preConnect:        *preConnect,
+		postConnect:       *postConnect,
+		localCommand:      *localCommand,
+		pidFile:           *pidFile,
+		summary:           *showSummary || *verbose,
+	}
+	if err := runSSH(target, connectOpts(), session); err != nil {
+		fail(err, target)
 	}
-	return "root"
 }
 
-func defaultKeyPath() string {
-	if u, err := osuser.Current(); err == nil {
-		return filepath.Join(u.HomeDir, ".ssh", "id_rsa")
-	}
-	return "~/.ssh/id_rsa"
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [options] [user@]host[:port] [command...]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s -scp source... dest\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s -scan-keys host[,host...]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s -check host[,host...]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s -resolve [-json] [-refresh] host\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s -fingerprint [-json] host[:port]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s -F config -config-check\n\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "SSH over Tailscale without requiring a full Tailscale daemon\n\n")
+	fmt.Fprintf(os.Stderr, "Options:\n")
+	flag.PrintDefaults()
+	fmt.Fprintf(os.Stderr, "\nExamples:\n")
+	fmt.Fprintf(os.Stderr, "  %s hostname                    # Interactive SSH\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  %s user@hostname uptime        # Execute command\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  %s hostname:2222               # Custom port\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  %s -scp file.txt host:/tmp/    # Copy file\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  %s -v hostname                 # Verbose mode\n", os.Args[0])
 }
 
-func defaultTsnetDir() string {
-	if u, err := osuser.Current(); err == nil {
-		return filepath.Join(u.HomeDir, ".config", ClientName)
-	}
-	return "~/.config/" + ClientName
+// connectOptions are the command-line settings for reaching and
+// authenticating to a host, shared by SSH sessions and -scp
+type connectOptions struct {
+	defaultUser string // User when the target doesn't name one
+	defaultPort string // Port when the target doesn't name one
+	keyPath     string
+	identity    ssh.Signer // Key from -identity-from-stdin; replaces keyPath
+
+	knownHostsFile        string
+	readOnlyKnownHosts    bool     // -no-host-key-append
+	acceptChangedFor      []string // Hosts whose changed key replaces the old one
+	insecure              bool     // Skip host key verification
+	identitiesOnly        bool
+	noKeyboardInteractive bool
+	authThrottle          *sshclient.AuthThrottle // Shared by every connection, from -auth-delay
+	pqcLevel              int
+	addKeysToAgent        sshclient.AddKeysToAgent // -add-keys-to-agent; identity is never added
+	// passwordCache lets a password typed for one -J hop be tried on the
+	// later hops and the destination; nil prompts for each
+	passwordCache *sshclient.PasswordCache
+
+	jumpHosts     []string      // -J hops, in order
+	jumpTimeout   time.Duration // Bounds connecting each of jumpHosts
+	addressFamily string        // "4", "6" or "" for either
+	httpProxy     string
+	noBanner      bool
+
+	tailnet tailnetOptions
+
+	verbose bool
+	logger  *log.Logger
 }
 
-func extractURL(msg string) string {
-	if idx := strings.Index(msg, "https://"); idx != -1 {
-		url := msg[idx:]
-		if endIdx := strings.IndexAny(url, " \n\r\t"); endIdx != -1 {
-			url = url[:endIdx]
-		}
-		return url
+// clientConfig returns the tsssh settings for connecting as sshUser
+// through dialer. Mode-specific settings are left for the caller.
+func (o connectOptions) clientConfig(sshUser string, dialer tsssh.Dialer) tsssh.Config {
+	return tsssh.Config{
+		User:                  sshUser,
+		KeyPath:               o.keyPath,
+		Identity:              o.identity,
+		IdentitiesOnly:        o.identitiesOnly,
+		NoKeyboardInteractive: o.noKeyboardInteractive,
+		InsecureHostKey:       o.insecure,
+		UserKnownHostsFile:    o.knownHostsFile,
+		ReadOnlyKnownHosts:    o.readOnlyKnownHosts,
+		AcceptChangedFor:      o.acceptChangedFor,
+		AddressFamily:         o.addressFamily,
+		HTTPProxy:             o.httpProxy,
+		PQCLevel:              o.pqcLevel,
+		AuthThrottle:          o.authThrottle,
+		PasswordCache:         o.passwordCache,
+		HandshakeRetries:      HandshakeRetries,
+		Banner:                bannerWriter(o.noBanner),
+		Dialer:                dialer,
+		Logger:                o.logger,
 	}
-	return msg
 }
 
-// setupDynamicForward sets up SOCKS5 dynamic port forwarding
-func setupDynamicForward(client *ssh.Client, forwardSpec string, verbose bool, logger *log.Logger) error {
-	// Parse bind address and port from forwardSpec.
-	// Format: "port" or "bind_address:port" or "[ipv6]:port"
-	bindAddr := "localhost"
-	port := forwardSpec
-
-	if strings.Contains(forwardSpec, ":") {
-		host, p, err := net.SplitHostPort(forwardSpec)
-		if err != nil {
-			return fmt.Errorf("invalid dynamic forward specification: %s", forwardSpec)
+// parseJumpHosts splits and validates a -J list of [user@]host[:port]
+func parseJumpHosts(spec string) ([]string, error) {
+	var jumps []string
+	for _, jump := range strings.Split(spec, ",") {
+		jump = strings.TrimSpace(jump)
+		if jump == "" {
+			continue
 		}
-		bindAddr = host
-		port = p
-	}
-
-	// Validate port
-	if err := security.ValidatePort(port); err != nil {
-		return fmt.Errorf("invalid port for dynamic forwarding: %w", err)
-	}
-
-	// Validate bind address for security
-	// Allow localhost, 127.0.0.1, ::1, and empty (defaults to all interfaces)
-	// Warn on binding to non-localhost addresses as they expose the proxy to network
-	if bindAddr != "" && bindAddr != "localhost" && bindAddr != "127.0.0.1" && bindAddr != "::1" {
-		// Parse to check if it's a valid IP
-		ip := net.ParseIP(bindAddr)
-		if ip == nil && bindAddr != "0.0.0.0" && bindAddr != "::" {
-			return fmt.Errorf("invalid bind address: %s", bindAddr)
+		sshUser, host, port, err := sshclient.ParseTarget(jump, currentUsername(), DefaultSshPort)
+		if err == nil {
+			err = sshclient.ValidateTarget(sshUser, host, port)
 		}
-		if verbose {
-			logger.Printf("Warning: Binding SOCKS5 proxy to %s exposes it to the network\n", bindAddr)
+		if err != nil {
+			return nil, fmt.Errorf("jump host %q: %w", jump, err)
 		}
+		jumps = append(jumps, jump)
 	}
+	return jumps, nil
+}
 
-	listenAddr := net.JoinHostPort(bindAddr, port)
+// stringList is a flag that can be given more than once, like ssh's -D
+type stringList []string
 
-	// Start listening on local port
-	listener, err := net.Listen("tcp", listenAddr)
-	if err != nil {
-		return fmt.Errorf("failed to listen on %s: %w", listenAddr, err)
-	}
-
-	if verbose {
-		logger.Printf("SOCKS5 dynamic forwarding listening on %s\n", listenAddr)
-	}
-
-	// Handle incoming SOCKS5 connections in background
-	go func() {
-		defer listener.Close()
-		for {
-			localConn, err := listener.Accept()
-			if err != nil {
-				if errors.Is(err, net.ErrClosed) {
-					return
-				}
-				if verbose {
-					logger.Printf("Error accepting SOCKS5 connection: %v\n", err)
-				}
-				return
-			}
-			go handleSOCKS5(client, localConn, verbose, logger)
-		}
-	}()
+func (l *stringList) String() string { return strings.Join(*l, ",") }
 
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
 	return nil
 }
 
-// handleSOCKS5 handles a SOCKS5 connection
-func handleSOCKS5(client *ssh.Client, localConn net.Conn, verbose bool, logger *log.Logger) {
-	defer localConn.Close()
-
-	// Read greeting: VER NMETHODS
-	greeting := make([]byte, 2)
-	if _, err := io.ReadFull(localConn, greeting); err != nil {
-		if verbose {
-			logger.Printf("SOCKS5 handshake failed: %v\n", err)
+// parseHostList splits a comma-separated host list, skipping empty entries
+func parseHostList(spec string) ([]string, error) {
+	var hosts []string
+	for _, host := range strings.Split(spec, ",") {
+		host = strings.TrimSpace(host)
+		if host == "" {
+			continue
 		}
-		return
-	}
-	if greeting[0] != 0x05 {
-		if verbose {
-			logger.Printf("Not SOCKS5 protocol: version=%d\n", greeting[0])
+		if err := security.ValidateHostname(host); err != nil {
+			return nil, err
 		}
-		return
-	}
-	// Drain the methods list (we always respond with "no auth")
-	methods := make([]byte, greeting[1])
-	if _, err := io.ReadFull(localConn, methods); err != nil {
-		return
-	}
-
-	// Reply: no authentication required
-	if _, err := localConn.Write([]byte{0x05, 0x00}); err != nil {
-		if verbose {
-			logger.Printf("Failed to send auth response: %v\n", err)
-		}
-		return
-	}
-
-	// Read request header: VER CMD RSV ATYP
-	reqHeader := make([]byte, 4)
-	if _, err := io.ReadFull(localConn, reqHeader); err != nil {
-		if verbose {
-			logger.Printf("Failed to read connection request: %v\n", err)
-		}
-		return
-	}
-	if reqHeader[0] != 0x05 || reqHeader[1] != 0x01 {
-		if verbose {
-			logger.Printf("Invalid SOCKS5 request: version=%d, cmd=%d\n", reqHeader[0], reqHeader[1])
-		}
-		localConn.Write([]byte{0x05, 0x07, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00})
-		return
-	}
-
-	// Parse address by type
-	portBytes := make([]byte, 2)
-	var host string
-
-	switch reqHeader[3] {
-	case 0x01: // IPv4
-		addr := make([]byte, 4)
-		if _, err := io.ReadFull(localConn, addr); err != nil {
-			return
-		}
-		if _, err := io.ReadFull(localConn, portBytes); err != nil {
-			return
-		}
-		host = net.IP(addr).String()
-	case 0x03: // Domain name
-		lenBuf := make([]byte, 1)
-		if _, err := io.ReadFull(localConn, lenBuf); err != nil {
-			return
-		}
-		domain := make([]byte, lenBuf[0])
-		if _, err := io.ReadFull(localConn, domain); err != nil {
-			return
-		}
-		if _, err := io.ReadFull(localConn, portBytes); err != nil {
-			return
-		}
-		host = string(domain)
-	case 0x04: // IPv6
-		addr := make([]byte, 16)
-		if _, err := io.ReadFull(localConn, addr); err != nil {
-			return
-		}
-		if _, err := io.ReadFull(localConn, portBytes); err != nil {
-			return
-		}
-		host = net.IP(addr).String()
-	default:
-		if verbose {
-			logger.Printf("Unsupported address type: %d\n", reqHeader[3])
-		}
-		localConn.Write([]byte{0x05, 0x08, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00})
-		return
+		hosts = append(hosts, host)
 	}
+	return hosts, nil
+}
 
-	port := uint16(portBytes[0])<<8 | uint16(portBytes[1])
-	targetAddr := net.JoinHostPort(host, fmt.Sprintf("%d", port))
-	if verbose {
-		logger.Printf("SOCKS5 forwarding to: %s\n", targetAddr)
+// Helper functions for defaults
+func currentUsername() string {
+	if u, err := osuser.Current(); err == nil {
+		return u.Username
 	}
+	return "root"
+}
 
-	// Dial through SSH
-	remoteConn, err := client.Dial("tcp", targetAddr)
-	if err != nil {
-		if verbose {
-			logger.Printf("Failed to dial %s: %v\n", targetAddr, err)
-		}
-		localConn.Write([]byte{0x05, 0x05, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00})
-		return
+func defaultKeyPath() string {
+	if u, err := osuser.Current(); err == nil {
+		return filepath.Join(u.HomeDir, ".ssh", "id_rsa")
 	}
-	defer remoteConn.Close()
+	return "~/.ssh/id_rsa"
+}
 
-	// Send success response
-	if _, err := localConn.Write([]byte{0x05, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}); err != nil {
-		if verbose {
-			logger.Printf("Failed to send success response: %v\n", err)
-		}
-		return
+func defaultTsnetDir() string {
+	if u, err := osuser.Current(); err == nil {
+		return filepath.Join(u.HomeDir, ".config", ClientName)
 	}
-
-	// Bidirectional copy: one direction in goroutine, one in current goroutine
-	done := make(chan struct{}, 1)
-	go func() {
-		io.Copy(remoteConn, localConn)
-		done <- struct{}{}
-	}()
-	io.Copy(localConn, remoteConn)
-	<-done
+	return "~/.config/" + ClientName
 }
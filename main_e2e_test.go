@@ -12,6 +12,8 @@ import (
 	"time"
 
 	"golang.org/x/crypto/ssh"
+
+	sshclient "github.com/derekg/ts-ssh/internal/client/ssh"
 )
 
 // TestE2ESSHConnectionFlow tests the complete SSH connection flow
@@ -23,7 +25,7 @@ func TestE2ESSHConnectionFlow(t *testing.T) {
 
 	t.Run("SSH connection with mock server", func(t *testing.T) {
 		// This would require setting up a mock SSH server
-		// For now, we test the parseSSHTarget integration with security validation
+		// For now, we test the sshclient.ParseTarget integration with security validation
 		tests := []struct {
 			name      string
 			target    string
@@ -49,13 +51,13 @@ func TestE2ESSHConnectionFlow(t *testing.T) {
 
 		for _, tt := range tests {
 			t.Run(tt.name, func(t *testing.T) {
-				user, host, port, err := parseSSHTarget(tt.target, tt.user, tt.port)
+				user, host, port, err := sshclient.ParseTarget(tt.target, tt.user, tt.port)
 				if (err != nil) != tt.shouldErr {
-					t.Errorf("parseSSHTarget() error = %v, shouldErr %v", err, tt.shouldErr)
+					t.Errorf("sshclient.ParseTarget() error = %v, shouldErr %v", err, tt.shouldErr)
 				}
 				if err == nil {
 					if user == "" || host == "" || port == "" {
-						t.Errorf("parseSSHTarget() returned empty values: user=%v, host=%v, port=%v", user, host, port)
+						t.Errorf("sshclient.ParseTarget() returned empty values: user=%v, host=%v, port=%v", user, host, port)
 					}
 				}
 			})
@@ -104,8 +106,16 @@ func TestE2ESCPFlow(t *testing.T) {
 
 		for _, tt := range tests {
 			t.Run(tt.name, func(t *testing.T) {
-				srcHost, srcPath, srcIsRemote := parseSCPArg(tt.source)
-				dstHost, dstPath, dstIsRemote := parseSCPArg(tt.dest)
+				src, err := parseSCPArg(tt.source)
+				if err != nil {
+					t.Fatalf("parseSCPArg(%q) error = %v", tt.source, err)
+				}
+				dst, err := parseSCPArg(tt.dest)
+				if err != nil {
+					t.Fatalf("parseSCPArg(%q) error = %v", tt.dest, err)
+				}
+				srcHost, srcPath, srcIsRemote := src.target(), src.Path, src.Remote
+				dstHost, dstPath, dstIsRemote := dst.target(), dst.Path, dst.Remote
 
 				// Both remote or both local should be an error
 				if srcIsRemote == dstIsRemote {
@@ -184,12 +194,12 @@ func TestE2ESecurityValidation(t *testing.T) {
 		}
 
 		for _, tt := range validTargets {
-			_, parsedHost, _, err := parseSSHTarget(tt.target, "user", "22")
+			_, parsedHost, _, err := sshclient.ParseTarget(tt.target, "user", "22")
 			if err != nil {
-				t.Errorf("parseSSHTarget(%q) unexpected error: %v", tt.target, err)
+				t.Errorf("sshclient.ParseTarget(%q) unexpected error: %v", tt.target, err)
 			}
 			if parsedHost != tt.host {
-				t.Errorf("parseSSHTarget(%q) host = %v, want %v", tt.target, parsedHost, tt.host)
+				t.Errorf("sshclient.ParseTarget(%q) host = %v, want %v", tt.target, parsedHost, tt.host)
 			}
 		}
 	})
@@ -199,12 +209,12 @@ func TestE2ESecurityValidation(t *testing.T) {
 
 		for _, port := range validPorts {
 			target := fmt.Sprintf("user@host:%s", port)
-			_, _, parsedPort, err := parseSSHTarget(target, "user", "22")
+			_, _, parsedPort, err := sshclient.ParseTarget(target, "user", "22")
 			if err != nil {
-				t.Errorf("parseSSHTarget with port %s unexpected error: %v", port, err)
+				t.Errorf("sshclient.ParseTarget with port %s unexpected error: %v", port, err)
 			}
 			if parsedPort != port {
-				t.Errorf("parseSSHTarget port = %v, want %v", parsedPort, port)
+				t.Errorf("sshclient.ParseTarget port = %v, want %v", parsedPort, port)
 			}
 		}
 	})
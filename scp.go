@@ -0,0 +1,477 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	sshclient "github.com/derekg/ts-ssh/internal/client/ssh"
+	"github.com/derekg/ts-ssh/internal/security"
+	"github.com/derekg/ts-ssh/tsssh"
+)
+
+// runSCP handles SCP file transfer
+func runSCP(transfer *scpTransfer, opts connectOptions) error {
+	logger := opts.logger
+
+	// Parse target host for user@host[:port]
+	sshUser, host, port, err := sshclient.ParseTarget(transfer.host, opts.defaultUser, opts.defaultPort)
+	if err != nil {
+		return err
+	}
+
+	// Validate inputs
+	if err := sshclient.ValidateTarget(sshUser, host, port); err != nil {
+		return err
+	}
+
+	// Initialize tsnet
+	srv, ctx, err := initTailscale(opts.tailnet, opts.verbose, logger)
+	if err != nil {
+		return fmt.Errorf("failed to initialize Tailscale: %w", err)
+	}
+
+	defer useKeyAgent(opts.addKeysToAgent)()
+	clientConfig := opts.clientConfig(sshUser, srv)
+	defer opts.passwordCache.Clear()
+	clientConfig.Preserve = transfer.preserve
+	clientConfig.JumpHosts, clientConfig.JumpTimeout = opts.jumpHosts, opts.jumpTimeout
+	client := tsssh.New(clientConfig)
+	if err := client.Connect(ctx, host, port); err != nil {
+		return fmt.Errorf("failed to connect via SSH: %w", err)
+	}
+	defer client.Close()
+	// Every hop and the destination are authenticated by now
+	opts.passwordCache.Clear()
+
+	// Perform SCP operation
+	sources := transfer.sources
+	if !transfer.upload {
+		if sources, err = expandRemoteSources(ctx, client, sources); err != nil {
+			return err
+		}
+	}
+
+	manifest := transferManifest{Direction: "download", Started: time.Now().UTC()}
+	if transfer.upload {
+		manifest.Direction = "upload"
+	}
+
+	failed := 0
+	written := make(map[string]bool) // Local paths -output has named so far
+	for _, source := range sources {
+		var err error
+		var localPath, remotePath string
+		var stream *streamDigest // Set for stdin and stdout, which leave no file to hash
+		switch {
+		case transfer.upload && source == stdioPath:
+			localPath, remotePath, stream = source, transfer.dest, newStreamDigest()
+			err = client.UploadFrom(ctx, io.TeeReader(os.Stdin, stream), remotePath)
+		case transfer.upload:
+			localPath, remotePath = source, uploadDestination(source, transfer.dest, len(sources) > 1)
+			err = client.Upload(ctx, localPath, remotePath)
+		case transfer.output != "":
+			remotePath = source
+			localPath, err = createOutputPath(transfer.output, host, source, manifest.Started.Local())
+			if err == nil && written[localPath] {
+				err = fmt.Errorf("-output names %s for more than one file; add {basename} or {path}", localPath)
+			} else if err == nil {
+				written[localPath] = true
+				err = client.Download(ctx, remotePath, localPath)
+			}
+		case transfer.dest == stdioPath:
+			// Several sources are written one after another, like cat
+			localPath, remotePath, stream = stdioPath, source, newStreamDigest()
+			err = client.DownloadTo(ctx, remotePath, io.MultiWriter(os.Stdout, stream))
+		default:
+			remotePath = source
+			if localPath, err = downloadDestination(source, transfer.dest, len(sources) > 1); err == nil {
+				err = client.Download(ctx, remotePath, localPath)
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", source, err)
+			failed++
+		}
+		if transfer.manifest != "" {
+			manifest.Files = append(manifest.Files, newTransferRecord(net.JoinHostPort(host, port), localPath, remotePath, stream, err))
+		}
+	}
+
+	if transfer.manifest != "" {
+		if err := writeTransferManifest(transfer.manifest, &manifest); err != nil {
+			return err
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("SCP failed for %d of %d files", failed, len(sources))
+	}
+
+	if opts.verbose {
+		logger.Println("SCP transfer completed successfully")
+	}
+	return nil
+}
+
+// stdioPath as an -scp source uploads stdin and as the destination writes
+// downloads to stdout, like "-" for cat or tar
+const stdioPath = "-"
+
+// scpTransfer is a parsed -scp command line: every source is local and the
+// destination remote (upload), or every source is remote on one host and the
+// destination local (download).
+type scpTransfer struct {
+	host     string   // [user@]host[:port] of the remote side
+	sources  []string // Local paths for uploads, remote paths for downloads
+	dest     string   // Remote path for uploads, local path for downloads
+	upload   bool
+	preserve bool   // Keep modification times and modes, like scp -p
+	manifest string // Path to write a transferManifest to, if set
+	output   string // -output template naming each downloaded file, instead of dest
+}
+
+// parseSCPArgs parses "source... dest". Local sources containing glob
+// characters are expanded here; remote globs are expanded on the host later.
+// A "-" source uploads stdin and a "-" destination downloads to stdout.
+func parseSCPArgs(args []string) (*scpTransfer, error) {
+	if len(args) < 2 {
+		return nil, errors.New("SCP needs at least one source and a destination")
+	}
+
+	dest, err := parseSCPArg(args[len(args)-1])
+	if err != nil {
+		return nil, err
+	}
+	destIsRemote := dest.Remote
+	transfer := &scpTransfer{dest: dest.Path, upload: destIsRemote, host: dest.target()}
+
+	for _, arg := range args[:len(args)-1] {
+		src, err := parseSCPArg(arg)
+		if err != nil {
+			return nil, err
+		}
+		host, srcPath, isRemote := src.target(), src.Path, src.Remote
+		switch {
+		case arg == stdioPath && destIsRemote && len(args) > 2:
+			return nil, errors.New("stdin (-) must be the only source")
+		case arg == stdioPath && destIsRemote && (transfer.dest == "" || strings.HasSuffix(transfer.dest, "/")):
+			return nil, errors.New("uploading stdin (-) needs a remote file name, not a directory")
+		case arg == stdioPath && destIsRemote:
+			transfer.sources = append(transfer.sources, stdioPath)
+		case isRemote && destIsRemote, !isRemote && !destIsRemote:
+			return nil, fmt.Errorf("exactly one of source or destination must be remote (host:path): %s", arg)
+		case isRemote && transfer.host != "" && host != transfer.host:
+			return nil, fmt.Errorf("all remote sources must be on the same host (%s and %s)", transfer.host, host)
+		case isRemote:
+			transfer.host = host
+			transfer.sources = append(transfer.sources, srcPath)
+		default:
+			matches, err := expandLocalGlob(srcPath)
+			if err != nil {
+				return nil, err
+			}
+			transfer.sources = append(transfer.sources, matches...)
+		}
+	}
+
+	return transfer, nil
+}
+
+// expandLocalGlob expands pattern if it contains glob characters
+func expandLocalGlob(pattern string) ([]string, error) {
+	if !strings.ContainsAny(pattern, "*?[") {
+		return []string{pattern}, nil
+	}
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern %s: %w", pattern, err)
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("%s: no matches", pattern)
+	}
+	return matches, nil
+}
+
+// expandRemoteSources expands remote paths containing glob characters on the host
+func expandRemoteSources(ctx context.Context, client *tsssh.Client, sources []string) ([]string, error) {
+	var expanded []string
+	for _, source := range sources {
+		if !strings.ContainsAny(source, "*?[") {
+			expanded = append(expanded, source)
+			continue
+		}
+		matches, err := client.Glob(ctx, source)
+		if err != nil {
+			return nil, err
+		}
+		expanded = append(expanded, matches...)
+	}
+	return expanded, nil
+}
+
+// transferManifest is the -manifest record of an SCP transfer
+type transferManifest struct {
+	Direction string           `json:"direction"` // "upload" or "download"
+	Started   time.Time        `json:"started"`
+	Files     []transferRecord `json:"files"`
+}
+
+// transferRecord is one file of a transferManifest. Size and SHA256 describe
+// the local copy and are only set when the transfer succeeded.
+type transferRecord struct {
+	Host       string `json:"host"`
+	LocalPath  string `json:"local_path"`
+	RemotePath string `json:"remote_path"`
+	Size       int64  `json:"size,omitempty"`
+	SHA256     string `json:"sha256,omitempty"`
+	Result     string `json:"result"` // "ok" or the error
+}
+
+// newTransferRecord describes the transfer of localPath, hashing it when
+// transferErr is nil. For stdin and stdout, stream has the size and hash.
+func newTransferRecord(host, localPath, remotePath string, stream *streamDigest, transferErr error) transferRecord {
+	record := transferRecord{Host: host, LocalPath: localPath, RemotePath: remotePath, Result: "ok"}
+	switch {
+	case transferErr != nil:
+	case stream != nil:
+		record.Size, record.SHA256 = stream.size, hex.EncodeToString(stream.hash.Sum(nil))
+	default:
+		transferErr = hashLocalFile(localPath, &record)
+	}
+	if transferErr != nil {
+		record.Size, record.SHA256 = 0, ""
+		record.Result = transferErr.Error()
+	}
+	return record
+}
+
+// streamDigest counts and hashes the bytes of a stdin or stdout transfer
+type streamDigest struct {
+	hash hash.Hash
+	size int64
+}
+
+func newStreamDigest() *streamDigest {
+	return &streamDigest{hash: sha256.New()}
+}
+
+func (d *streamDigest) Write(p []byte) (int, error) {
+	d.hash.Write(p)
+	d.size += int64(len(p))
+	return len(p), nil
+}
+
+// hashLocalFile fills record's size and SHA-256 from the file at path
+func hashLocalFile(path string, record *transferRecord) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to hash %s: %w", path, err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return fmt.Errorf("failed to hash %s: %w", path, err)
+	}
+	record.Size = n
+	record.SHA256 = hex.EncodeToString(h.Sum(nil))
+	return nil
+}
+
+// writeTransferManifest writes manifest to path as JSON with mode 0600,
+// replacing any earlier file atomically
+func writeTransferManifest(path string, manifest *transferManifest) error {
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode transfer manifest: %w", err)
+	}
+
+	file, err := security.CreateSecureDownloadFileWithReplace(path)
+	if err != nil {
+		return fmt.Errorf("failed to create transfer manifest: %w", err)
+	}
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return fmt.Errorf("failed to write transfer manifest %s: %w", path, err)
+	}
+	if err := security.CompleteAtomicReplacement(file); err != nil {
+		return fmt.Errorf("failed to write transfer manifest %s: %w", path, err)
+	}
+	return nil
+}
+
+// outputToken matches a {token} in an -output template
+var outputToken = regexp.MustCompile(`\{[^{}]*\}`)
+
+// expandOutputTemplate returns the local path for remotePath downloaded from
+// host, with {host} replaced by the host name, {path} by the remote path
+// without its leading "/", {basename} by its last element and {date} by
+// now's date as YYYY-MM-DD. A remote ".." can't climb out of the template.
+func expandOutputTemplate(template, host, remotePath string, now time.Time) (string, error) {
+	clean := strings.TrimPrefix(path.Clean("/"+remotePath), "/")
+	if clean == "" {
+		return "", fmt.Errorf("no file name in remote path %q", remotePath)
+	}
+
+	var unknown string
+	expanded := outputToken.ReplaceAllStringFunc(template, func(token string) string {
+		switch token {
+		case "{host}":
+			return host
+		case "{path}":
+			return filepath.FromSlash(clean)
+		case "{basename}":
+			return path.Base(clean)
+		case "{date}":
+			return now.Format("2006-01-02")
+		}
+		unknown = token
+		return token
+	})
+	if unknown != "" {
+		return "", fmt.Errorf("unknown -output token %s (use {host}, {path}, {basename} or {date})", unknown)
+	}
+	return filepath.Clean(expanded), nil
+}
+
+// createOutputPath expands template for remotePath and creates the local
+// directories the result needs
+func createOutputPath(template, host, remotePath string, now time.Time) (string, error) {
+	localPath, err := expandOutputTemplate(template, host, remotePath, now)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(localPath), DefaultDirPermissions); err != nil {
+		return "", fmt.Errorf("failed to create directory for %s: %w", localPath, err)
+	}
+	return localPath, nil
+}
+
+// uploadDestination returns the remote path for localPath. With several
+// sources, or a destination ending in "/", dest is a directory.
+func uploadDestination(localPath, dest string, multiple bool) string {
+	if multiple || strings.HasSuffix(dest, "/") {
+		return path.Join(dest, filepath.Base(localPath))
+	}
+	return dest
+}
+
+// downloadDestination returns the local path for remotePath. With several
+// sources dest must be an existing directory; a single file is also placed
+// inside dest when dest is a directory.
+func downloadDestination(remotePath, dest string, multiple bool) (string, error) {
+	info, err := os.Stat(dest)
+	isDir := err == nil && info.IsDir()
+	if multiple && !isDir {
+		return "", fmt.Errorf("destination %s must be a directory when copying several files", dest)
+	}
+	if isDir {
+		return filepath.Join(dest, path.Base(remotePath)), nil
+	}
+	return dest, nil
+}
+
+// windowsDrivePath matches paths starting with a drive letter, like C:\ or E:/
+var windowsDrivePath = regexp.MustCompile(`^[A-Za-z]:[\\/]`)
+
+// scpPortPrefix matches a port given between host and path, as in host:2222:/tmp
+var scpPortPrefix = regexp.MustCompile(`^([0-9]{1,5}):(.+)$`)
+
+// scpOperand is one scp source or destination: a local path, or a path on
+// [user@]host[:port]
+type scpOperand struct {
+	User   string // Empty when not given
+	Host   string // Without brackets, also for IPv6
+	Port   string // Empty when not given
+	Path   string
+	Remote bool
+}
+
+// target returns the [user@]host[:port] form sshclient.ParseTarget reads,
+// or "" for a local path
+func (o scpOperand) target() string {
+	if !o.Remote {
+		return ""
+	}
+	host := o.Host
+	if o.Port != "" {
+		host = net.JoinHostPort(host, o.Port)
+	} else if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if o.User != "" {
+		return o.User + "@" + host
+	}
+	return host
+}
+
+// parseSCPArg splits an scp argument into a local path or a remote
+// [user@]host[:port]:path. IPv6 hosts go in brackets ([fd7a::1]:/tmp), and a
+// port may follow the host (host:2222:/tmp). Like scp, it treats as local
+// anything with a slash before the first colon, Windows drive and UNC
+// paths, and a host with nothing after the colon.
+func parseSCPArg(arg string) (scpOperand, error) {
+	local := scpOperand{Path: arg}
+
+	// Drive letter and UNC paths are local even though they contain a colon
+	// or no host; only the first colon separates host from path, so
+	// user@host:C:/dir is a remote path on a Windows host
+	if windowsDrivePath.MatchString(arg) || strings.HasPrefix(arg, `\\`) {
+		return local, nil
+	}
+
+	var hostPart, rest string
+	if open := strings.Index(arg, "["); open >= 0 && open == strings.Index(arg, "@")+1 && !strings.ContainsAny(arg[:open], `/\:`) {
+		end := strings.Index(arg, "]")
+		if end < 0 {
+			return scpOperand{}, fmt.Errorf("invalid IPv6 address in %q: missing ]", arg)
+		}
+		if end+1 >= len(arg) || arg[end+1] != ':' {
+			return local, nil
+		}
+		hostPart, rest = arg[:end+1], arg[end+2:]
+	} else {
+		idx := strings.Index(arg, ":")
+		// As with scp, a slash before the colon means a local path
+		if idx <= 0 || strings.ContainsAny(arg[:idx], `/\`) {
+			return local, nil
+		}
+		hostPart, rest = arg[:idx], arg[idx+1:]
+	}
+	if rest == "" {
+		return local, nil
+	}
+
+	op := scpOperand{Path: rest, Remote: true}
+	if m := scpPortPrefix.FindStringSubmatch(rest); m != nil {
+		if err := security.ValidatePort(m[1]); err != nil {
+			return scpOperand{}, fmt.Errorf("invalid port in %q: %w", arg, err)
+		}
+		op.Port, op.Path = m[1], m[2]
+	}
+	if at := strings.Index(hostPart, "@"); at >= 0 {
+		op.User, hostPart = hostPart[:at], hostPart[at+1:]
+		if op.User == "" {
+			return scpOperand{}, fmt.Errorf("empty user in %q", arg)
+		}
+	}
+	op.Host = strings.TrimSuffix(strings.TrimPrefix(hostPart, "["), "]")
+	if op.Host == "" {
+		return scpOperand{}, fmt.Errorf("empty host in %q", arg)
+	}
+	return op, nil
+}
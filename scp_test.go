@@ -0,0 +1,526 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	sshclient "github.com/derekg/ts-ssh/internal/client/ssh"
+)
+
+func TestParseSCPArg(t *testing.T) {
+	tests := []struct {
+		name     string
+		arg      string
+		wantHost string
+		wantPath string
+		isRemote bool
+	}{
+		{
+			name:     "local path",
+			arg:      "/tmp/file.txt",
+			wantHost: "",
+			wantPath: "/tmp/file.txt",
+			isRemote: false,
+		},
+		{
+			name:     "relative path",
+			arg:      "file.txt",
+			wantHost: "",
+			wantPath: "file.txt",
+			isRemote: false,
+		},
+		{
+			name:     "remote path",
+			arg:      "host:/tmp/file.txt",
+			wantHost: "host",
+			wantPath: "/tmp/file.txt",
+			isRemote: true,
+		},
+		{
+			name:     "remote with user",
+			arg:      "user@host:/tmp/file.txt",
+			wantHost: "user@host",
+			wantPath: "/tmp/file.txt",
+			isRemote: true,
+		},
+		{
+			name:     "windows drive letter",
+			arg:      "C:\\Users\\test\\file.txt",
+			wantHost: "",
+			wantPath: "C:\\Users\\test\\file.txt",
+			isRemote: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			op, err := parseSCPArg(tt.arg)
+			if err != nil {
+				t.Fatalf("parseSCPArg() error = %v", err)
+			}
+			host, path, isRemote := op.target(), op.Path, op.Remote
+			if host != tt.wantHost {
+				t.Errorf("parseSCPArg() host = %v, want %v", host, tt.wantHost)
+			}
+			if path != tt.wantPath {
+				t.Errorf("parseSCPArg() path = %v, want %v", path, tt.wantPath)
+			}
+			if isRemote != tt.isRemote {
+				t.Errorf("parseSCPArg() isRemote = %v, want %v", isRemote, tt.isRemote)
+			}
+		})
+	}
+}
+
+func TestParseSCPArgEdgeCases(t *testing.T) {
+	tests := []struct {
+		name     string
+		arg      string
+		wantHost string
+		wantPath string
+		isRemote bool
+	}{
+		{
+			name:     "remote with port notation",
+			arg:      "host:2222:/tmp/file.txt",
+			wantHost: "host:2222",
+			wantPath: "/tmp/file.txt",
+			isRemote: true,
+		},
+		{
+			name:     "user@host with port notation",
+			arg:      "user@host:2222:/tmp/file.txt",
+			wantHost: "user@host:2222",
+			wantPath: "/tmp/file.txt",
+			isRemote: true,
+		},
+		{
+			name:     "path with spaces",
+			arg:      "/tmp/my file.txt",
+			wantHost: "",
+			wantPath: "/tmp/my file.txt",
+			isRemote: false,
+		},
+		{
+			name:     "remote path with spaces",
+			arg:      "host:/tmp/my file.txt",
+			wantHost: "host",
+			wantPath: "/tmp/my file.txt",
+			isRemote: true,
+		},
+		{
+			name:     "empty path",
+			arg:      "",
+			wantHost: "",
+			wantPath: "",
+			isRemote: false,
+		},
+		{
+			name:     "just colon",
+			arg:      ":",
+			wantHost: "",
+			wantPath: ":",
+			isRemote: false,
+		},
+		{
+			name:     "D drive windows",
+			arg:      "D:\\data\\file.txt",
+			wantHost: "",
+			wantPath: "D:\\data\\file.txt",
+			isRemote: false,
+		},
+		{
+			name:     "E drive root",
+			arg:      "E:\\data",
+			wantHost: "",
+			wantPath: "E:\\data",
+			isRemote: false,
+		},
+		{
+			name:     "drive with forward slash",
+			arg:      "c:/data/file.txt",
+			wantHost: "",
+			wantPath: "c:/data/file.txt",
+			isRemote: false,
+		},
+		{
+			name:     "UNC share",
+			arg:      "\\\\server\\share\\file.txt",
+			wantHost: "",
+			wantPath: "\\\\server\\share\\file.txt",
+			isRemote: false,
+		},
+		{
+			name:     "remote windows drive path",
+			arg:      "user@host:C:/weird",
+			wantHost: "user@host",
+			wantPath: "C:/weird",
+			isRemote: true,
+		},
+		{
+			name:     "single letter host",
+			arg:      "h:/tmp/file.txt",
+			wantHost: "",
+			wantPath: "h:/tmp/file.txt",
+			isRemote: false,
+		},
+		{
+			name:     "single letter host with relative path",
+			arg:      "h:file.txt",
+			wantHost: "h",
+			wantPath: "file.txt",
+			isRemote: true,
+		},
+		{
+			name:     "colon after slash is local",
+			arg:      "./logs/10:30.txt",
+			wantHost: "",
+			wantPath: "./logs/10:30.txt",
+			isRemote: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			op, err := parseSCPArg(tt.arg)
+			if err != nil {
+				t.Fatalf("parseSCPArg() error = %v", err)
+			}
+			host, path, isRemote := op.target(), op.Path, op.Remote
+			if host != tt.wantHost {
+				t.Errorf("parseSCPArg() host = %v, want %v", host, tt.wantHost)
+			}
+			if path != tt.wantPath {
+				t.Errorf("parseSCPArg() path = %v, want %v", path, tt.wantPath)
+			}
+			if isRemote != tt.isRemote {
+				t.Errorf("parseSCPArg() isRemote = %v, want %v", isRemote, tt.isRemote)
+			}
+		})
+	}
+}
+
+func TestParseSCPArgForms(t *testing.T) {
+	tests := []struct {
+		arg     string
+		want    scpOperand
+		wantErr bool
+	}{
+		{arg: "file.txt", want: scpOperand{Path: "file.txt"}},
+		{arg: "/tmp/a:b", want: scpOperand{Path: "/tmp/a:b"}},
+		{arg: "host:", want: scpOperand{Path: "host:"}},
+		{arg: "file[12].txt", want: scpOperand{Path: "file[12].txt"}},
+		{arg: "[ab]*.log", want: scpOperand{Path: "[ab]*.log"}},
+		{arg: `C:\Users\me\f.txt`, want: scpOperand{Path: `C:\Users\me\f.txt`}},
+		{arg: "d:/data", want: scpOperand{Path: "d:/data"}},
+		{arg: `\\server\share\f`, want: scpOperand{Path: `\\server\share\f`}},
+		{arg: "host:f.txt", want: scpOperand{Host: "host", Path: "f.txt", Remote: true}},
+		{arg: "alice@host:/srv/f", want: scpOperand{User: "alice", Host: "host", Path: "/srv/f", Remote: true}},
+		{arg: "alice@host:2222:/srv/f", want: scpOperand{User: "alice", Host: "host", Port: "2222", Path: "/srv/f", Remote: true}},
+		{arg: "host:22:f.txt", want: scpOperand{Host: "host", Port: "22", Path: "f.txt", Remote: true}},
+		{arg: "host:10:30.txt", want: scpOperand{Host: "host", Port: "10", Path: "30.txt", Remote: true}},
+		{arg: "host:2222:", want: scpOperand{Host: "host", Path: "2222:", Remote: true}},
+		{arg: "host:C:/Users/me", want: scpOperand{Host: "host", Path: "C:/Users/me", Remote: true}},
+		{arg: "alice@host:2222:C:/Users", want: scpOperand{User: "alice", Host: "host", Port: "2222", Path: "C:/Users", Remote: true}},
+		{arg: "[fd7a:115c:a1e0::5]:/tmp/f", want: scpOperand{Host: "fd7a:115c:a1e0::5", Path: "/tmp/f", Remote: true}},
+		{arg: "bob@[fd7a::5]:2222:/tmp/f", want: scpOperand{User: "bob", Host: "fd7a::5", Port: "2222", Path: "/tmp/f", Remote: true}},
+		{arg: "100.64.0.5:/tmp/f", want: scpOperand{Host: "100.64.0.5", Path: "/tmp/f", Remote: true}},
+		{arg: "host:99999:/tmp/f", wantErr: true},
+		{arg: "@host:/tmp/f", wantErr: true},
+		{arg: "bob@[fd7a::5:/tmp/f", wantErr: true},
+		{arg: "bob@:/tmp/f", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.arg, func(t *testing.T) {
+			got, err := parseSCPArg(tt.arg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseSCPArg(%q) error = %v, wantErr %v", tt.arg, err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("parseSCPArg(%q) = %+v, want %+v", tt.arg, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSCPOperandTarget(t *testing.T) {
+	tests := []struct {
+		op   scpOperand
+		want string
+	}{
+		{scpOperand{Path: "local"}, ""},
+		{scpOperand{Host: "web1", Remote: true}, "web1"},
+		{scpOperand{User: "alice", Host: "web1", Port: "2222", Remote: true}, "alice@web1:2222"},
+		{scpOperand{Host: "fd7a::5", Remote: true}, "[fd7a::5]"},
+		{scpOperand{User: "bob", Host: "fd7a::5", Port: "22", Remote: true}, "bob@[fd7a::5]:22"},
+	}
+	for _, tt := range tests {
+		got := tt.op.target()
+		if got != tt.want {
+			t.Errorf("%+v.target() = %q, want %q", tt.op, got, tt.want)
+		}
+		// The target round-trips through sshclient.ParseTarget
+		if got == "" {
+			continue
+		}
+		user, host, port, err := sshclient.ParseTarget(got, "", "")
+		if err != nil || user != tt.op.User || host != tt.op.Host || port != tt.op.Port {
+			t.Errorf("sshclient.ParseTarget(%q) = %q, %q, %q, %v", got, user, host, port, err)
+		}
+	}
+}
+
+func TestParseSCPArgsMultipleSources(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.log", "b.log", "notes.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0600); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	tests := []struct {
+		name        string
+		args        []string
+		wantHost    string
+		wantSources []string
+		wantDest    string
+		wantUpload  bool
+		wantErr     string
+	}{
+		{
+			name:        "several local files to a remote dir",
+			args:        []string{"a.txt", "b.txt", "web:/srv/drop/"},
+			wantHost:    "web",
+			wantSources: []string{"a.txt", "b.txt"},
+			wantDest:    "/srv/drop/",
+			wantUpload:  true,
+		},
+		{
+			name:        "local glob is expanded",
+			args:        []string{filepath.Join(dir, "*.log"), filepath.Join(dir, "notes.txt"), "alice@web:/tmp"},
+			wantHost:    "alice@web",
+			wantSources: []string{filepath.Join(dir, "a.log"), filepath.Join(dir, "b.log"), filepath.Join(dir, "notes.txt")},
+			wantDest:    "/tmp",
+			wantUpload:  true,
+		},
+		{
+			name:        "remote sources to a local dir keep their globs",
+			args:        []string{"web:/var/log/*.log", "web:/etc/hosts", dir},
+			wantHost:    "web",
+			wantSources: []string{"/var/log/*.log", "/etc/hosts"},
+			wantDest:    dir,
+		},
+		{
+			name:        "stdin to a remote file",
+			args:        []string{"-", "web:/srv/db.sql"},
+			wantHost:    "web",
+			wantSources: []string{"-"},
+			wantDest:    "/srv/db.sql",
+			wantUpload:  true,
+		},
+		{
+			name:        "remote files to stdout",
+			args:        []string{"web:/srv/a.sql", "web:/srv/b.sql", "-"},
+			wantHost:    "web",
+			wantSources: []string{"/srv/a.sql", "/srv/b.sql"},
+			wantDest:    "-",
+		},
+		{
+			name:    "stdin with other sources",
+			args:    []string{"-", "a.txt", "web:/srv/"},
+			wantErr: "only source",
+		},
+		{
+			name:    "stdin to a remote directory",
+			args:    []string{"-", "web:/srv/"},
+			wantErr: "remote file name",
+		},
+		{
+			name:    "local glob without matches",
+			args:    []string{filepath.Join(dir, "*.csv"), "web:/tmp"},
+			wantErr: "no matches",
+		},
+		{
+			name:    "mixed local and remote sources",
+			args:    []string{"a.txt", "web:/etc/hosts", "db:/tmp"},
+			wantErr: "exactly one",
+		},
+		{
+			name:    "remote sources on different hosts",
+			args:    []string{"web:/etc/hosts", "db:/etc/hosts", dir},
+			wantErr: "same host",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transfer, err := parseSCPArgs(tt.args)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseSCPArgs() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseSCPArgs() error = %v", err)
+			}
+			if transfer.host != tt.wantHost || transfer.dest != tt.wantDest || transfer.upload != tt.wantUpload {
+				t.Errorf("parseSCPArgs() = host %q dest %q upload %t, want %q %q %t",
+					transfer.host, transfer.dest, transfer.upload, tt.wantHost, tt.wantDest, tt.wantUpload)
+			}
+			if strings.Join(transfer.sources, ",") != strings.Join(tt.wantSources, ",") {
+				t.Errorf("parseSCPArgs() sources = %q, want %q", transfer.sources, tt.wantSources)
+			}
+		})
+	}
+}
+
+func TestSCPDestinations(t *testing.T) {
+	if got := uploadDestination("/home/me/a.txt", "/srv/drop", true); got != "/srv/drop/a.txt" {
+		t.Errorf("uploadDestination() multiple = %q", got)
+	}
+	if got := uploadDestination("/home/me/a.txt", "/srv/drop/", false); got != "/srv/drop/a.txt" {
+		t.Errorf("uploadDestination() into dir = %q", got)
+	}
+	if got := uploadDestination("/home/me/a.txt", "/srv/b.txt", false); got != "/srv/b.txt" {
+		t.Errorf("uploadDestination() rename = %q", got)
+	}
+
+	dir := t.TempDir()
+	if got, err := downloadDestination("/var/log/app.log", dir, true); err != nil || got != filepath.Join(dir, "app.log") {
+		t.Errorf("downloadDestination() into dir = %q, %v", got, err)
+	}
+	if _, err := downloadDestination("/var/log/app.log", filepath.Join(dir, "missing"), true); err == nil {
+		t.Error("downloadDestination() should require a directory for several files")
+	}
+	if got, err := downloadDestination("/var/log/app.log", filepath.Join(dir, "copy.log"), false); err != nil || got != filepath.Join(dir, "copy.log") {
+		t.Errorf("downloadDestination() rename = %q, %v", got, err)
+	}
+}
+
+func TestExpandOutputTemplate(t *testing.T) {
+	now := time.Date(2026, 3, 14, 23, 30, 0, 0, time.UTC)
+	tests := []struct {
+		template, remotePath string
+		want                 string
+	}{
+		{"{host}/{basename}", "/var/log/app.log", filepath.Join("web1", "app.log")},
+		{"{date}/{host}.log", "/var/log/syslog", filepath.Join("2026-03-14", "web1.log")},
+		{"out/{host}/{path}", "/etc/nginx/nginx.conf", filepath.Join("out", "web1", "etc", "nginx", "nginx.conf")},
+		{"{host}/{path}", "relative/file", filepath.Join("web1", "relative", "file")},
+		// A remote ".." stays inside the template's directory
+		{"out/{path}", "/../../etc/passwd", filepath.Join("out", "etc", "passwd")},
+		{"fixed.txt", "/anything", "fixed.txt"},
+	}
+	for _, tt := range tests {
+		got, err := expandOutputTemplate(tt.template, "web1", tt.remotePath, now)
+		if err != nil || got != tt.want {
+			t.Errorf("expandOutputTemplate(%q, %q) = %q, %v, want %q", tt.template, tt.remotePath, got, err, tt.want)
+		}
+	}
+
+	if _, err := expandOutputTemplate("{host}/{name}", "web1", "/a", now); err == nil || !strings.Contains(err.Error(), "{name}") {
+		t.Errorf("expandOutputTemplate() with unknown token error = %v", err)
+	}
+	if _, err := expandOutputTemplate("{basename}", "web1", "/", now); err == nil {
+		t.Error("expandOutputTemplate() accepted a remote path without a file name")
+	}
+}
+
+func TestCreateOutputPath(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2026, 3, 14, 0, 0, 0, 0, time.UTC)
+
+	got, err := createOutputPath(filepath.Join(dir, "{date}", "{host}", "{basename}"), "web1", "/var/log/app.log", now)
+	if err != nil {
+		t.Fatalf("createOutputPath() error = %v", err)
+	}
+	if want := filepath.Join(dir, "2026-03-14", "web1", "app.log"); got != want {
+		t.Errorf("createOutputPath() = %q, want %q", got, want)
+	}
+	info, err := os.Stat(filepath.Dir(got))
+	if err != nil || !info.IsDir() {
+		t.Fatalf("createOutputPath() did not create the directory: %v", err)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm() != DefaultDirPermissions {
+		t.Errorf("directory mode = %v, want %v", info.Mode().Perm(), os.FileMode(DefaultDirPermissions))
+	}
+	if _, err := os.Stat(got); !os.IsNotExist(err) {
+		t.Errorf("createOutputPath() created the file itself: %v", err)
+	}
+
+	// A file in the way of a directory is reported
+	blocker := filepath.Join(dir, "blocker")
+	if err := os.WriteFile(blocker, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := createOutputPath(filepath.Join(blocker, "{basename}"), "web1", "/a", now); err == nil {
+		t.Error("createOutputPath() should fail when a file blocks the directory")
+	}
+}
+
+func TestTransferManifest(t *testing.T) {
+	dir := t.TempDir()
+	localPath := filepath.Join(dir, "app.tar")
+	if err := os.WriteFile(localPath, []byte("release"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	manifest := transferManifest{Direction: "upload", Started: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)}
+	manifest.Files = append(manifest.Files,
+		newTransferRecord("web1:22", localPath, "/srv/app.tar", nil, nil),
+		newTransferRecord("web1:22", filepath.Join(dir, "missing"), "/srv/missing", nil, errors.New("scp: /srv: Permission denied")),
+	)
+
+	path := filepath.Join(dir, "manifest.json")
+	if err := writeTransferManifest(path, &manifest); err != nil {
+		t.Fatalf("writeTransferManifest() error = %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0600 {
+		t.Errorf("manifest mode = %o, want 600", mode)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		Direction string `json:"direction"`
+		Files     []struct {
+			Host       string `json:"host"`
+			LocalPath  string `json:"local_path"`
+			RemotePath string `json:"remote_path"`
+			Size       int64  `json:"size"`
+			SHA256     string `json:"sha256"`
+			Result     string `json:"result"`
+		} `json:"files"`
+	}
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("invalid manifest JSON %q: %v", data, err)
+	}
+	if got.Direction != "upload" || len(got.Files) != 2 {
+		t.Fatalf("manifest = %s", data)
+	}
+
+	ok := got.Files[0]
+	// sha256 of "release"
+	if ok.Host != "web1:22" || ok.RemotePath != "/srv/app.tar" || ok.Size != 7 || ok.Result != "ok" ||
+		ok.SHA256 != "a4d451ec23463726f72c43d64c710968f6b602cd653b4de8adee1b556240a829" {
+		t.Errorf("successful record = %+v", ok)
+	}
+	failedRecord := got.Files[1]
+	if failedRecord.Result != "scp: /srv: Permission denied" || failedRecord.SHA256 != "" || failedRecord.Size != 0 {
+		t.Errorf("failed record = %+v", failedRecord)
+	}
+}
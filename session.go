@@ -0,0 +1,411 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/derekg/ts-ssh/internal/client/forward"
+	sshclient "github.com/derekg/ts-ssh/internal/client/ssh"
+	"github.com/derekg/ts-ssh/internal/hooks"
+	"github.com/derekg/ts-ssh/internal/platform"
+	"github.com/derekg/ts-ssh/internal/security"
+	"github.com/derekg/ts-ssh/tsssh"
+)
+
+// useKeyAgent has keys decrypted with a passphrase added to the running SSH
+// agent as opts says, and taken from it on later connections instead of
+// prompting again. The returned function disconnects from the agent.
+func useKeyAgent(opts sshclient.AddKeysToAgent) func() {
+	if !opts.Enabled {
+		return func() {}
+	}
+	keyAgent, conn, err := sshclient.DialKeyAgent()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s %v\n", platform.Colorize(platform.ColorYellow, "Warning:"), err)
+		return func() {}
+	}
+	sshclient.UseKeyAgent(keyAgent, opts)
+	return func() {
+		sshclient.UseKeyAgent(nil, sshclient.AddKeysToAgent{})
+		conn.Close()
+	}
+}
+
+// sessionOptions are the settings for what runSSH does once connected
+type sessionOptions struct {
+	remoteCmd []string                // Command to run; empty starts a shell
+	policy    *security.CommandPolicy // Commands allowed to run, if set
+	skipIf    string                  // Guard command; remoteCmd is skipped where it succeeds
+	noCommand bool                    // -N: only keep forwards open
+
+	disablePTY  bool // -T
+	requirePTY  bool // -t
+	ptySize     tsssh.PTYSize
+	idleTimeout time.Duration
+	execTimeout time.Duration
+	osc52       sshclient.OSC52Mode
+	noEscapes   bool // -e none
+
+	dynamicForwards   []string // -D specs
+	localForwards     []string // -L specs
+	keepAlive         time.Duration
+	keepAliveCountMax int
+
+	preConnect   string // Local command that must succeed before connecting
+	postConnect  string // Local command run once connected
+	localCommand string // -local-command, already permitted
+	pidFile      string // Written once connected and forwarding
+	summary      bool   // Print a summary when the session ends
+}
+
+// runSSH handles the SSH connection
+func runSSH(target string, opts connectOptions, session sessionOptions) error {
+	logger, verbose := opts.logger, opts.verbose
+	remoteCmd, policy, skipIf := session.remoteCmd, session.policy, session.skipIf
+
+	// Parse target: [user@]host[:port]
+	sshUser, host, port, err := sshclient.ParseTarget(target, opts.defaultUser, opts.defaultPort)
+	if err != nil {
+		return err
+	}
+
+	// Validate inputs before bringing up Tailscale
+	if err := sshclient.ValidateTarget(sshUser, host, port); err != nil {
+		return err
+	}
+
+	if policy != nil {
+		if err := checkCommandPolicy(policy, sshUser, host, remoteCmd); err != nil {
+			return err
+		}
+		if skipIf != "" {
+			if err := checkCommandPolicy(policy, sshUser, host, []string{skipIf}); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := hooks.Run("pre-connect", session.preConnect, sshUser, host, port, logger); err != nil {
+		return err
+	}
+
+	// Initialize tsnet
+	srv, ctx, err := initTailscale(opts.tailnet, verbose, logger)
+	if err != nil {
+		return fmt.Errorf("failed to initialize Tailscale: %w", err)
+	}
+
+	// Clipboard sequences reach the terminal as they are unless -osc52 says
+	// otherwise. Without a PTY remote stderr is separate, so it is watched too.
+	var stdout, stderr io.Writer = os.Stdout, os.Stderr
+	if session.osc52 != sshclient.OSC52Pass {
+		onCopy := func(data []byte) {
+			if err := platform.CopyToClipboard(data); err != nil {
+				logger.Printf("OSC 52 copy failed: %v", err)
+			}
+		}
+		clipboardOut := sshclient.NewOSC52Writer(os.Stdout, session.osc52, onCopy)
+		clipboardErr := sshclient.NewOSC52Writer(os.Stderr, session.osc52, onCopy)
+		defer clipboardOut.Flush()
+		defer clipboardErr.Flush()
+		stdout, stderr = clipboardOut, clipboardErr
+	}
+
+	// Establish SSH connection
+	defer useKeyAgent(opts.addKeysToAgent)()
+	clientConfig := opts.clientConfig(sshUser, srv)
+	defer opts.passwordCache.Clear()
+	clientConfig.Stdin, clientConfig.Stdout, clientConfig.Stderr = os.Stdin, stdout, stderr
+	clientConfig.DisablePTY = session.disablePTY
+	clientConfig.RequirePTY = session.requirePTY
+	clientConfig.IdleTimeout = session.idleTimeout
+	clientConfig.ExecTimeout = session.execTimeout
+	clientConfig.PTYSize = session.ptySize
+	clientConfig.NoEscapes = session.noEscapes
+	clientConfig.JumpHosts, clientConfig.JumpTimeout = opts.jumpHosts, opts.jumpTimeout
+	client := tsssh.New(clientConfig)
+	if err := client.Connect(ctx, host, port); err != nil {
+		return fmt.Errorf("failed to connect via SSH: %w", err)
+	}
+	defer client.Close()
+	// Every hop and the destination are authenticated by now
+	opts.passwordCache.Clear()
+
+	// The connection is up, so a failing post-connect hook only warns
+	if err := hooks.Run("post-connect", session.postConnect, sshUser, host, port, logger); err != nil {
+		fmt.Fprintf(os.Stderr, "%s %v\n", platform.Colorize(platform.ColorYellow, "Warning:"), err)
+	}
+	if err := hooks.RunLocalCommand(session.localCommand, localCommandTokens(sshUser, host, port), logger); err != nil {
+		fmt.Fprintf(os.Stderr, "%s %v\n", platform.Colorize(platform.ColorYellow, "Warning:"), err)
+	}
+
+	// Setup dynamic port forwarding if requested
+	if len(session.dynamicForwards) > 0 {
+		listeners, err := forward.ListenAll(session.dynamicForwards, func(spec string) (net.Listener, error) {
+			return forward.ListenDynamic(client.SSHClient(), spec, verbose, logger)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to setup dynamic forwarding: %w", err)
+		}
+		// Closed before the client, so a normal exit isn't reported as a lost connection
+		for _, listener := range listeners {
+			defer listener.Close()
+		}
+	}
+
+	if len(session.localForwards) > 0 {
+		listeners, err := forward.ListenAll(session.localForwards, func(spec string) (net.Listener, error) {
+			fwd, err := forward.ParseLocal(spec)
+			if err != nil {
+				return nil, err
+			}
+			listener, err := forward.ListenLocal(client.SSHClient(), fwd, verbose, logger)
+			if err == nil && (session.noCommand || verbose) {
+				fmt.Fprintf(os.Stderr, "Forwarding %s to %s through %s\n", listener.Addr(), fwd.Target(), host)
+			}
+			return listener, err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to setup local forwarding: %w", err)
+		}
+		for _, listener := range listeners {
+			defer listener.Close()
+		}
+	}
+
+	// Forwards can sit idle for hours; keepalives stop a NAT from dropping them
+	if session.keepAlive > 0 && (session.noCommand || len(session.dynamicForwards) > 0 || len(session.localForwards) > 0) {
+		keepAliveCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go func() {
+			err := sshclient.KeepAlive(keepAliveCtx, client.SSHClient(), session.keepAlive, session.keepAliveCountMax, logger)
+			if keepAliveCtx.Err() == nil {
+				logger.Printf("Keepalives stopped: %v", err)
+			}
+		}()
+	}
+
+	// Set up, so whoever manages this process can find it now
+	if session.pidFile != "" {
+		removePIDFile, err := writePIDFile(session.pidFile)
+		if err != nil {
+			return err
+		}
+		defer removePIDFile()
+	}
+
+	if session.noCommand {
+		return forward.Wait(ctx, client.SSHClient(), logger)
+	}
+
+	if len(remoteCmd) > 0 && skipIf != "" {
+		skip, err := skipByGuard(ctx, client, skipIf, host, os.Stderr)
+		if err != nil {
+			return err
+		}
+		if skip {
+			return nil
+		}
+	}
+
+	// Execute command or start interactive session
+	started := time.Now()
+	var sessionErr error
+	if len(remoteCmd) > 0 {
+		// The command is parsed by the remote login shell, so say which one
+		// when debugging. Skipped under a policy, which the probe isn't in.
+		if verbose && policy == nil {
+			if shell, err := client.RemoteShell(ctx); err != nil {
+				logger.Printf("Could not detect the remote shell: %v", err)
+			} else if shell.POSIX() {
+				logger.Printf("Remote shell: %s", shell)
+			} else {
+				logger.Printf("Remote shell: %s; the command is parsed with its syntax, not sh's", shell)
+			}
+		}
+		// With -t the command gets a terminal, as with ssh -t. Its echo is
+		// then the remote terminal's, so a password prompt can turn it off;
+		// without one the local terminal echoes whatever is typed.
+		if session.requirePTY {
+			sessionErr = client.ShellCommand(ctx, remoteCommandLine(remoteCmd))
+		} else {
+			sessionErr = client.Run(ctx, remoteCommandLine(remoteCmd))
+		}
+	} else {
+		sessionErr = client.Shell(ctx)
+	}
+
+	if session.summary {
+		sent, received := client.BytesTransferred()
+		printSessionSummary(os.Stderr, host, time.Since(started), sent, received, sessionErr)
+	}
+
+	if len(remoteCmd) > 0 && sessionErr != nil {
+		var exitErr *ssh.ExitError
+		if errors.As(sessionErr, &exitErr) {
+			// Returned rather than exiting here, so deferred cleanup runs
+			return &remoteExitError{status: exitErr.ExitStatus()}
+		}
+		return fmt.Errorf("remote command failed: %w", sessionErr)
+	}
+	return sessionErr
+}
+
+// printSessionSummary reports how a session to host went, in the spirit of
+// ssh -v's "Transferred:" line: how long it ran, how many bytes of session
+// data went each way and how it ended
+func printSessionSummary(out io.Writer, host string, elapsed time.Duration, sent, received int64, sessionErr error) {
+	status := "exit status 0"
+	var exitErr *ssh.ExitError
+	switch {
+	case errors.As(sessionErr, &exitErr):
+		status = fmt.Sprintf("exit status %d", exitErr.ExitStatus())
+	case sessionErr != nil:
+		status = fmt.Sprintf("no exit status (%v)", sessionErr)
+	}
+	fmt.Fprintf(out, "Session to %s ended after %v: sent %d, received %d bytes, %s\n",
+		host, elapsed.Round(100*time.Millisecond), sent, received, status)
+}
+
+// bannerWriter returns where server banners are printed: stderr, like
+// OpenSSH, or nowhere with -no-banner
+func bannerWriter(noBanner bool) io.Writer {
+	if noBanner {
+		return nil
+	}
+	return os.Stderr
+}
+
+// writePIDFile writes this process's ID to path with mode 0600. The
+// returned function removes the file, unless another process has since
+// written its own ID there.
+func writePIDFile(path string) (func(), error) {
+	pid := fmt.Sprintf("%d\n", os.Getpid())
+	file, err := security.CreateSecureDownloadFileWithReplace(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create PID file: %w", err)
+	}
+	if _, err := file.WriteString(pid); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, fmt.Errorf("failed to write PID file %s: %w", path, err)
+	}
+	if err := security.CompleteAtomicReplacement(file); err != nil {
+		return nil, fmt.Errorf("failed to write PID file %s: %w", path, err)
+	}
+
+	return func() {
+		if data, err := os.ReadFile(path); err == nil && string(data) == pid {
+			os.Remove(path)
+		}
+	}, nil
+}
+
+// remoteCommandLine joins the command arguments into the line the remote
+// shell runs. A single argument is a command line of its own and is sent
+// unchanged, so "ts-ssh host 'ls | wc -l'" keeps its pipe. Several are each
+// quoted for a POSIX shell, so "ts-ssh host echo 'a b'" passes "a b" as one
+// argument instead of two.
+func remoteCommandLine(remoteCmd []string) string {
+	if len(remoteCmd) == 1 {
+		return remoteCmd[0]
+	}
+	quoted := make([]string, len(remoteCmd))
+	for i, arg := range remoteCmd {
+		quoted[i] = security.QuoteShellArg(arg)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// checkCommandPolicy refuses remoteCmd unless policy allows it, recording
+// the decision in the security audit log. Without a command the session
+// would be an unrestricted shell, so it is always refused.
+func checkCommandPolicy(policy *security.CommandPolicy, sshUser, host string, remoteCmd []string) error {
+	if len(remoteCmd) == 0 {
+		security.LogCommandPolicyDecision(host, sshUser, "", false, "interactive shell refused")
+		return errors.New("interactive shells are not allowed when a command policy is in effect")
+	}
+
+	command := remoteCommandLine(remoteCmd)
+	if err := policy.Check(command); err != nil {
+		security.LogCommandPolicyDecision(host, sshUser, command, false, err.Error())
+		return err
+	}
+	security.LogCommandPolicyDecision(host, sshUser, command, true, "allowed")
+	return nil
+}
+
+// applyForceCommand returns the command to run on target: forced, when set,
+// replaces whatever the user gave, and an attempt to run something else is
+// logged. Wrapper scripts distributed by an admin use this to pin ts-ssh to
+// one command.
+func applyForceCommand(forced string, remoteCmd []string, target string, logger *log.Logger) []string {
+	if forced == "" {
+		return remoteCmd
+	}
+	if attempted := remoteCommandLine(remoteCmd); attempted != forced {
+		if attempted == "" {
+			attempted = "interactive shell"
+		}
+		logger.Printf("Forced command %q replaces %q", forced, attempted)
+		security.LogForcedCommand(target, attempted, forced)
+	}
+	return []string{forced}
+}
+
+// localCommandTokens returns the % token values for a connection to
+// sshUser@host:port from this machine
+func localCommandTokens(sshUser, host, port string) sshclient.ConnectionTokens {
+	localHost, _ := os.Hostname()
+	return sshclient.ConnectionTokens{
+		LocalHost: localHost,
+		Host:      host,
+		Port:      port,
+		User:      sshUser,
+		LocalUser: currentUsername(),
+	}
+}
+
+// guardRunner runs a -skip-if guard; *tsssh.Client satisfies it
+type guardRunner interface {
+	Succeeds(ctx context.Context, cmd string) (bool, error)
+}
+
+// checkSkipIf checks that a -skip-if guard can be used with remoteCmd. With a
+// forced command nothing else may run on the host, and the guard would be an
+// arbitrary command run before it, so it is refused as -scp is.
+func checkSkipIf(skipIf, forceCommand string, remoteCmd []string) error {
+	if skipIf == "" {
+		return nil
+	}
+	if forceCommand != "" {
+		return errors.New("-skip-if is not available when a forced command is in effect")
+	}
+	if len(remoteCmd) == 0 {
+		return errors.New("-skip-if needs a remote command to skip")
+	}
+	return nil
+}
+
+// skipByGuard runs the -skip-if guard on host and reports whether the main
+// command should be skipped because the guard succeeded, noting the skipped
+// host on out. Re-running a fleet job then only does the work still missing.
+func skipByGuard(ctx context.Context, client guardRunner, guard, host string, out io.Writer) (bool, error) {
+	ok, err := client.Succeeds(ctx, guard)
+	if err != nil {
+		return false, fmt.Errorf("-skip-if command failed to run: %w", err)
+	}
+	if ok {
+		fmt.Fprintf(out, "Skipped %s: %q succeeded\n", host, guard)
+	}
+	return ok, nil
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	sshclient "github.com/derekg/ts-ssh/internal/client/ssh"
+)
+
+func TestWritePIDFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ts-ssh.pid")
+	remove, err := writePIDFile(path)
+	if err != nil {
+		t.Fatalf("writePIDFile() error = %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("PID file not written: %v", err)
+	}
+	if want := fmt.Sprintf("%d\n", os.Getpid()); string(data) != want {
+		t.Errorf("PID file = %q, want %q", data, want)
+	}
+	if info, err := os.Stat(path); err == nil && runtime.GOOS != "windows" && info.Mode().Perm() != 0600 {
+		t.Errorf("PID file mode = %v, want 0600", info.Mode().Perm())
+	}
+
+	remove()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("PID file still exists after removal: %v", err)
+	}
+
+	// A file taken over by another process is left alone
+	if remove, err = writePIDFile(path); err != nil {
+		t.Fatalf("writePIDFile() error = %v", err)
+	}
+	if err := os.WriteFile(path, []byte("1\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	remove()
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("another process's PID file was removed: %v", err)
+	}
+
+	if _, err := writePIDFile(filepath.Join(t.TempDir(), "missing", "ts-ssh.pid")); err == nil {
+		t.Error("writePIDFile() should fail when the directory doesn't exist")
+	}
+}
+
+func TestRemoteCommandLine(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"plain words", []string{"ls", "-la", "/tmp"}, "ls -la /tmp"},
+		{"argument with spaces", []string{"echo", "a b"}, "echo 'a b'"},
+		{"single command line", []string{"ls | wc -l"}, "ls | wc -l"},
+		{"no command", nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := remoteCommandLine(tt.args); got != tt.want {
+				t.Errorf("remoteCommandLine(%q) = %q, want %q", tt.args, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRemoteCommandLineRoundTrip(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("no POSIX shell to run the command line")
+	}
+
+	// Each argument reaches the command intact, as one argument
+	args := []string{"a b", "it's", "$HOME", "`id`", "*", "", "tab\there"}
+	line := remoteCommandLine(append([]string{"printf", "[%s]\\n"}, args...))
+	out, err := exec.Command(sh, "-c", line).Output()
+	if err != nil {
+		t.Fatalf("sh -c %q error = %v", line, err)
+	}
+	var want strings.Builder
+	for _, arg := range args {
+		want.WriteString("[" + arg + "]\n")
+	}
+	if string(out) != want.String() {
+		t.Errorf("sh -c %q printed %q, want %q", line, out, want.String())
+	}
+}
+
+func TestApplyForceCommand(t *testing.T) {
+	tests := []struct {
+		name      string
+		forced    string
+		remoteCmd []string
+		want      []string
+		wantLog   string
+	}{
+		{name: "no forced command", remoteCmd: []string{"ls", "-l"}, want: []string{"ls", "-l"}},
+		{name: "replaces user command", forced: "/usr/local/bin/deploy", remoteCmd: []string{"rm", "-rf", "/"}, want: []string{"/usr/local/bin/deploy"}, wantLog: `replaces "rm -rf /"`},
+		{name: "replaces interactive shell", forced: "uptime", want: []string{"uptime"}, wantLog: `replaces "interactive shell"`},
+		{name: "same command is not logged", forced: "uptime", remoteCmd: []string{"uptime"}, want: []string{"uptime"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			got := applyForceCommand(tt.forced, tt.remoteCmd, "web", log.New(&logs, "", 0))
+			if strings.Join(got, "\x00") != strings.Join(tt.want, "\x00") {
+				t.Errorf("applyForceCommand() = %q, want %q", got, tt.want)
+			}
+			if tt.wantLog == "" && logs.Len() != 0 {
+				t.Errorf("unexpected log output %q", logs.String())
+			}
+			if !strings.Contains(logs.String(), tt.wantLog) {
+				t.Errorf("log output %q does not mention %q", logs.String(), tt.wantLog)
+			}
+		})
+	}
+}
+
+// fakeGuardHost answers a -skip-if guard with a fixed result
+type fakeGuardHost struct {
+	ok  bool
+	err error
+}
+
+func (h fakeGuardHost) Succeeds(ctx context.Context, cmd string) (bool, error) {
+	return h.ok, h.err
+}
+
+func TestCheckSkipIf(t *testing.T) {
+	if err := checkSkipIf("", "uptime", []string{"uptime"}); err != nil {
+		t.Errorf("checkSkipIf() without a guard error = %v", err)
+	}
+	if err := checkSkipIf("test -f /done", "", []string{"make install"}); err != nil {
+		t.Errorf("checkSkipIf() error = %v", err)
+	}
+	if err := checkSkipIf("test -f /done", "", nil); err == nil {
+		t.Error("checkSkipIf() accepted a guard with nothing to skip")
+	}
+	// The forced command replaces remoteCmd, so the guard would be the only
+	// other command the user could run
+	if err := checkSkipIf("rm -rf ~", "uptime", []string{"uptime"}); err == nil || !strings.Contains(err.Error(), "forced command") {
+		t.Errorf("checkSkipIf() with a forced command error = %v, want it refused", err)
+	}
+}
+
+func TestSkipByGuard(t *testing.T) {
+	fleet := []struct {
+		host     string
+		guard    fakeGuardHost
+		wantSkip bool
+		wantErr  bool
+	}{
+		{"web1", fakeGuardHost{ok: true}, true, false},
+		{"web2", fakeGuardHost{ok: false}, false, false},
+		{"web3", fakeGuardHost{ok: true}, true, false},
+		{"web4", fakeGuardHost{err: errors.New("session refused")}, false, true},
+	}
+
+	var out bytes.Buffer
+	var ran []string
+	for _, h := range fleet {
+		skip, err := skipByGuard(context.Background(), h.guard, "test -f /done", h.host, &out)
+		if (err != nil) != h.wantErr || skip != h.wantSkip {
+			t.Errorf("skipByGuard(%s) = %v, %v; want skip %v, error %v", h.host, skip, err, h.wantSkip, h.wantErr)
+		}
+		if err == nil && !skip {
+			ran = append(ran, h.host)
+		}
+	}
+
+	if strings.Join(ran, ",") != "web2" {
+		t.Errorf("command ran on %v, want only web2", ran)
+	}
+	want := "Skipped web1: \"test -f /done\" succeeded\nSkipped web3: \"test -f /done\" succeeded\n"
+	if out.String() != want {
+		t.Errorf("report = %q, want %q", out.String(), want)
+	}
+}
+
+func TestFailingPreConnectHookStopsConnection(t *testing.T) {
+	tsnetDir := filepath.Join(t.TempDir(), "tsnet")
+	logger := log.New(io.Discard, "", 0)
+
+	opts := connectOptions{defaultPort: "22", tailnet: tailnetOptions{dir: tsnetDir}, logger: logger}
+	err := runSSH("alice@web1", opts, sessionOptions{preConnect: "false", osc52: sshclient.OSC52Pass})
+	if err == nil || !strings.Contains(err.Error(), "pre-connect hook") {
+		t.Fatalf("runSSH() error = %v, want a pre-connect hook failure", err)
+	}
+
+	// Tailscale was never started, so its state directory doesn't exist
+	if _, err := os.Stat(tsnetDir); !os.IsNotExist(err) {
+		t.Errorf("tsnet directory exists after a failed pre-connect hook: %v", err)
+	}
+}
+
+func TestPrintSessionSummary(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"clean exit", nil, "Session to web1 ended after 1.5s: sent 12, received 3456 bytes, exit status 0\n"},
+		{"lost connection", errors.New("connection reset"), "no exit status (connection reset)\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			printSessionSummary(&out, "web1", 1523*time.Millisecond, 12, 3456, tt.err)
+			if !strings.HasSuffix(out.String(), tt.want) {
+				t.Errorf("summary = %q, want it to end with %q", out.String(), tt.want)
+			}
+		})
+	}
+}
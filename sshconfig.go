@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	osuser "os/user"
+
+	sshclient "github.com/derekg/ts-ssh/internal/client/ssh"
+	"github.com/derekg/ts-ssh/internal/security"
+)
+
+// applySSHConfig fills the SSH user, key path, known_hosts file, forced and
+// remote commands and host key checking from an OpenSSH config file. A user
+// in target or an explicit -l/-i/-user-known-hosts-file/-force-command/
+// -remote-command wins over the config.
+func applySSHConfig(configFile, target string, allowMatchExec bool, explicit map[string]bool, sshUser, keyPath, knownHostsFile, forceCommand, remoteCommand *string, insecure *bool) error {
+	targetUser, host, _, err := sshclient.ParseTarget(target, "", "22")
+	if err != nil {
+		return err
+	}
+	// Match exec hands the host and user to a shell, so they are checked
+	// before the config is read rather than later in runSSH
+	if err := security.ValidateHostname(host); err != nil {
+		return fmt.Errorf("invalid hostname: %w", err)
+	}
+
+	var cfgUser, cfgKey string
+	if explicit["l"] {
+		cfgUser = *sshUser
+	}
+	if targetUser != "" {
+		cfgUser = targetUser
+	}
+	if explicit["i"] {
+		cfgKey = *keyPath
+	}
+
+	match := sshclient.MatchContext{
+		Host:      host,
+		User:      cfgUser,
+		LocalUser: currentUsername(),
+		AllowExec: allowMatchExec,
+	}
+	if match.User == "" {
+		match.User = *sshUser
+	}
+	if match.User != "" {
+		if err := security.ValidateSSHUser(match.User); err != nil {
+			return fmt.Errorf("invalid SSH user: %w", err)
+		}
+	}
+
+	if err := sshclient.ApplySSHConfigToConnection(configFile, match, &cfgUser, &cfgKey, knownHostsFile, forceCommand, remoteCommand, insecure); err != nil {
+		return fmt.Errorf("failed to apply SSH config: %w", err)
+	}
+
+	if cfgUser != "" {
+		*sshUser = cfgUser
+	}
+	if cfgKey != "" {
+		*keyPath = cfgKey
+	}
+	return nil
+}
+
+// runConfigCheck reports every problem in an SSH config file to out and
+// fails if there were any
+func runConfigCheck(configFile string, out io.Writer) error {
+	problems, err := sshclient.CheckSSHConfig(configFile)
+	if err != nil {
+		return err
+	}
+	for _, problem := range problems {
+		fmt.Fprintf(out, "%s: %s\n", configFile, problem)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%d problem(s) found in %s", len(problems), configFile)
+	}
+	fmt.Fprintf(out, "%s: OK\n", configFile)
+	return nil
+}
+
+// dumpEffectiveConfig prints the settings a connection to target would use,
+// after flags and -F have been applied, one lowercase "key value" per line
+func dumpEffectiveConfig(out io.Writer, target, defaultUser, defaultPort, keyPath, knownHostsFile string, insecure, readOnlyKnownHosts, identitiesOnly bool) error {
+	sshUser, host, port, err := sshclient.ParseTarget(target, defaultUser, defaultPort)
+	if err != nil {
+		return err
+	}
+	if err := sshclient.ValidateTarget(sshUser, host, port); err != nil {
+		return err
+	}
+
+	if knownHostsFile == "" {
+		currentUser, err := osuser.Current()
+		if err != nil {
+			return fmt.Errorf("failed to get current user: %w", err)
+		}
+		if knownHostsFile, err = sshclient.DefaultKnownHostsPath(currentUser); err != nil {
+			return err
+		}
+	}
+
+	hostKeyChecking := "ask"
+	if insecure {
+		hostKeyChecking = "no"
+	}
+	yesNo := map[bool]string{true: "yes", false: "no"}
+
+	fmt.Fprintf(out, "user %s\n", sshUser)
+	fmt.Fprintf(out, "hostname %s\n", host)
+	fmt.Fprintf(out, "port %s\n", port)
+	fmt.Fprintf(out, "identityfile %s\n", keyPath)
+	fmt.Fprintf(out, "identitiesonly %s\n", yesNo[identitiesOnly])
+	fmt.Fprintf(out, "stricthostkeychecking %s\n", hostKeyChecking)
+	fmt.Fprintf(out, "userknownhostsfile %s\n", knownHostsFile)
+	fmt.Fprintf(out, "globalknownhostsfile %s\n", sshclient.GlobalKnownHostsFile)
+	fmt.Fprintf(out, "knownhostsappend %s\n", yesNo[!readOnlyKnownHosts])
+	return nil
+}
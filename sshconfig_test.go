@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	sshclient "github.com/derekg/ts-ssh/internal/client/ssh"
+)
+
+func TestDumpEffectiveConfig(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config")
+	config := `
+Host web
+    User ops
+    IdentityFile /keys/web
+    StrictHostKeyChecking no
+    UserKnownHostsFile /keys/known_hosts
+`
+	if err := os.WriteFile(configPath, []byte(config), 0600); err != nil {
+		t.Fatalf("Failed to write SSH config: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		target   string
+		explicit map[string]bool
+		want     string
+	}{
+		{
+			name:   "config overrides defaults",
+			target: "web",
+			want: `user ops
+hostname web
+port 22
+identityfile /keys/web
+identitiesonly no
+stricthostkeychecking no
+userknownhostsfile /keys/known_hosts
+globalknownhostsfile /etc/ssh/ssh_known_hosts
+knownhostsappend yes
+`,
+		},
+		{
+			name:     "target and explicit flags win over config",
+			target:   "alice@web:2222",
+			explicit: map[string]bool{"i": true},
+			want: `user alice
+hostname web
+port 2222
+identityfile /home/me/.ssh/id_rsa
+identitiesonly no
+stricthostkeychecking no
+userknownhostsfile /keys/known_hosts
+globalknownhostsfile /etc/ssh/ssh_known_hosts
+knownhostsappend yes
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sshUser, keyPath, knownHostsFile, forceCommand, insecure := "me", "/home/me/.ssh/id_rsa", "", "", false
+			if err := applySSHConfig(configPath, tt.target, false, tt.explicit, &sshUser, &keyPath, &knownHostsFile, &forceCommand, new(string), &insecure); err != nil {
+				t.Fatalf("applySSHConfig() error = %v", err)
+			}
+
+			var out bytes.Buffer
+			if err := dumpEffectiveConfig(&out, tt.target, sshUser, "22", keyPath, knownHostsFile, insecure, false, false); err != nil {
+				t.Fatalf("dumpEffectiveConfig() error = %v", err)
+			}
+			if out.String() != tt.want {
+				t.Errorf("dumpEffectiveConfig() =\n%s\nwant\n%s", out.String(), tt.want)
+			}
+		})
+	}
+
+	// Without -F the defaults are reported, including ~/.ssh/known_hosts
+	var out bytes.Buffer
+	if err := dumpEffectiveConfig(&out, "db", "me", "22", "/home/me/.ssh/id_rsa", "", false, true, true); err != nil {
+		t.Fatalf("dumpEffectiveConfig() error = %v", err)
+	}
+	for _, line := range []string{"stricthostkeychecking ask\n", "identitiesonly yes\n", "knownhostsappend no\n", "known_hosts\n"} {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("dumpEffectiveConfig() output missing %q:\n%s", line, out.String())
+		}
+	}
+}
+
+func TestApplySSHConfigUserBySuffix(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config")
+	cfg := `Host *.corp-a.ts.net
+    User admin
+
+Host *.corp-b.ts.net
+    User deploy
+`
+	if err := os.WriteFile(configFile, []byte(cfg), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		target   string
+		explicit map[string]bool
+		want     string
+	}{
+		{name: "first tailnet", target: "db.corp-a.ts.net", want: "admin"},
+		{name: "second tailnet", target: "web.corp-b.ts.net:2222", want: "deploy"},
+		{name: "no matching suffix keeps the default", target: "web.other.ts.net", want: "localuser"},
+		{name: "user in target wins", target: "alice@db.corp-a.ts.net", want: "alice"},
+		{name: "explicit -l wins", target: "db.corp-a.ts.net", explicit: map[string]bool{"l": true}, want: "localuser"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sshUser, keyPath, knownHostsFile, forceCommand, insecure := "localuser", "", "", "", false
+			if err := applySSHConfig(configFile, tt.target, false, tt.explicit, &sshUser, &keyPath, &knownHostsFile, &forceCommand, new(string), &insecure); err != nil {
+				t.Fatalf("applySSHConfig() error = %v", err)
+			}
+			// The user in target is applied later by sshclient.ParseTarget
+			user, _, _, err := sshclient.ParseTarget(tt.target, sshUser, "22")
+			if err != nil {
+				t.Fatalf("sshclient.ParseTarget() error = %v", err)
+			}
+			if user != tt.want {
+				t.Errorf("user = %q, want %q", user, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplySSHConfigRejectsShellInTarget(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "ran")
+	configFile := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(configFile, []byte("Match exec \"test %h = web\"\n    User ops\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, target := range []string{"x$(touch${IFS}" + marker + ")", "x`touch " + marker + "`@web", "web;touch " + marker} {
+		sshUser, keyPath, knownHostsFile, forceCommand, insecure := "localuser", "", "", "", false
+		if err := applySSHConfig(configFile, target, true, nil, &sshUser, &keyPath, &knownHostsFile, &forceCommand, new(string), &insecure); err == nil {
+			t.Errorf("applySSHConfig(%q) should reject the target", target)
+		}
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Fatal("a command in the target ran under -allow-match-exec")
+	}
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/term"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tsnet"
+
+	"github.com/derekg/ts-ssh/internal/platform"
+)
+
+// tailnetOptions are the command-line settings for bringing up the tsnet node
+type tailnetOptions struct {
+	dir          string
+	controlURLs  []string // Tried in order until one brings the node up
+	controlProxy *url.URL // -control-proxy; nil uses the environment
+	log          *log.Logger
+	openBrowser  bool
+}
+
+// initTailscale initializes tsnet and returns server and context
+func initTailscale(tailnet tailnetOptions, verbose bool, logger *log.Logger) (*tsnet.Server, context.Context, error) {
+	// Ensure directory exists
+	if err := os.MkdirAll(tailnet.dir, 0700); err != nil {
+		return nil, nil, fmt.Errorf("failed to create tsnet directory: %w", err)
+	}
+
+	// tsnet has no proxy setting of its own: it takes its control plane
+	// proxy from the environment, the first time it reaches the control
+	// server, and keeps it. Setting the variables only while the node comes
+	// up keeps hooks, -local-command and anything else started later on the
+	// user's own environment.
+	defer setProxyEnv(tailnet.controlProxy)()
+	return bringUpWithFailover(tailnet.controlURLs, func(controlURL string, timeout time.Duration) (*tsnet.Server, context.Context, error) {
+		return startTailscale(tailnet.dir, controlURL, timeout, tailnet.log, tailnet.openBrowser, verbose, logger)
+	})
+}
+
+// tailscaleBringUp starts a tsnet node against one control server, giving
+// up after timeout when it is non-zero
+type tailscaleBringUp func(controlURL string, timeout time.Duration) (*tsnet.Server, context.Context, error)
+
+// bringUpWithFailover tries each control server in order until one comes
+// up. All but the last get ControlFailoverTimeout, so an unreachable server
+// doesn't stall the rest; no URLs uses the default control server.
+func bringUpWithFailover(controlURLs []string, bringUp tailscaleBringUp) (*tsnet.Server, context.Context, error) {
+	if len(controlURLs) == 0 {
+		return bringUp("", 0)
+	}
+
+	var errs []error
+	for i, controlURL := range controlURLs {
+		last := i == len(controlURLs)-1
+		timeout := ControlFailoverTimeout
+		if last {
+			timeout = 0
+		}
+		srv, ctx, err := bringUp(controlURL, timeout)
+		if err == nil {
+			return srv, ctx, nil
+		}
+		if len(controlURLs) == 1 {
+			return nil, nil, err
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", controlURL, err))
+		if !last {
+			fmt.Fprintf(os.Stderr, "%s control server %s failed (%v); trying %s\n", platform.Colorize(platform.ColorYellow, "Warning:"), controlURL, err, controlURLs[i+1])
+		}
+	}
+	return nil, nil, fmt.Errorf("no control server could be reached: %w", errors.Join(errs...))
+}
+
+// startTailscale brings up a tsnet node in tsnetDir against controlURL
+func startTailscale(tsnetDir, controlURL string, timeout time.Duration, tsnetLog *log.Logger, openBrowser, verbose bool, logger *log.Logger) (*tsnet.Server, context.Context, error) {
+	srv := &tsnet.Server{
+		Dir:        tsnetDir,
+		Hostname:   ClientName,
+		ControlURL: controlURL,
+	}
+
+	// Configure logging
+	srv.Logf, srv.UserLogf = tsnetLogfs(tsnetLog, openBrowser, verbose, logger)
+
+	ctx := context.Background()
+
+	if !verbose {
+		fmt.Fprintf(os.Stderr, "Connecting to Tailscale...\n")
+	}
+
+	// Without a terminal or an auth key nobody can complete a login, so
+	// fail instead of letting Up wait for one forever
+	if !term.IsTerminal(int(os.Stdin.Fd())) && os.Getenv("TS_AUTHKEY") == "" && os.Getenv("TS_AUTH_KEY") == "" {
+		if err := srv.Start(); err != nil {
+			return nil, nil, fmt.Errorf("failed to start Tailscale: %w", err)
+		}
+		lc, err := srv.LocalClient()
+		if err != nil {
+			srv.Close()
+			return nil, nil, fmt.Errorf("failed to get Tailscale client: %w", err)
+		}
+		checkCtx, cancel := context.WithTimeout(ctx, LoginCheckTimeout)
+		err = checkLoginNonInteractive(checkCtx, lc, LoginCheckInterval)
+		cancel()
+		if err != nil && !errors.Is(err, context.DeadlineExceeded) {
+			srv.Close()
+			return nil, nil, err
+		}
+	}
+
+	upCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		upCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	status, err := srv.Up(upCtx)
+	if err != nil {
+		// Release the state directory for the next control server
+		srv.Close()
+		return nil, nil, fmt.Errorf("failed to bring up Tailscale: %w", err)
+	}
+
+	// Show auth URL if needed
+	if status != nil && status.AuthURL != "" {
+		fmt.Fprintf(os.Stderr, "\nTo authenticate, visit:\n%s\n\n", status.AuthURL)
+	}
+
+	return srv, ctx, nil
+}
+
+// tailscaleStatus is the part of the tsnet local client used to check login state
+type tailscaleStatus interface {
+	Status(ctx context.Context) (*ipnstate.Status, error)
+}
+
+// checkLoginNonInteractive waits for the tsnet backend to leave its initial
+// state and fails with an actionable error if it needs an interactive login.
+// Any other state means the stored node key works and Up can proceed.
+func checkLoginNonInteractive(ctx context.Context, lc tailscaleStatus, interval time.Duration) error {
+	for {
+		status, err := lc.Status(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to get Tailscale status: %w", err)
+		}
+		switch status.BackendState {
+		case "NeedsLogin":
+			return errors.New("Tailscale login required, but stdin is not a terminal: " +
+				"set TS_AUTHKEY to an auth key from the Tailscale admin console, or run ts-ssh once interactively")
+		case "", "NoState":
+		default:
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// tsnetLogfs returns the tsnet backend and user log functions. tsnetLog, when
+// set, receives all tsnet output; otherwise it goes to logger in verbose mode.
+// Logs mask the login token, so auth URLs are always also shown on stderr,
+// and with openBrowser the first one is opened in the default browser.
+func tsnetLogfs(tsnetLog *log.Logger, openBrowser, verbose bool, logger *log.Logger) (logf, userLogf func(string, ...interface{})) {
+	showAuthURL := func(format string, args ...interface{}) {
+		msg := fmt.Sprintf(format, args...)
+		if strings.Contains(msg, "https://") {
+			fmt.Fprintf(os.Stderr, "\nTo authenticate, visit:\n%s\n\n", extractURL(msg))
+		}
+	}
+
+	switch {
+	case tsnetLog != nil:
+		logf = tsnetLog.Printf
+		userLogf = func(format string, args ...interface{}) {
+			tsnetLog.Printf(format, args...)
+			showAuthURL(format, args...)
+		}
+	case verbose:
+		logf = logger.Printf
+		userLogf = func(format string, args ...interface{}) {
+			logger.Printf(format, args...)
+			showAuthURL(format, args...)
+		}
+	default:
+		// Silent mode - only show auth URLs
+		logf, userLogf = func(string, ...interface{}) {}, showAuthURL
+	}
+
+	if openBrowser {
+		userLogf = openAuthURL(userLogf, logger)
+	}
+	return logf, userLogf
+}
+
+// openAuthURL wraps userLogf to open the first auth URL it logs in the
+// default browser. tsnet repeats the URL until login completes, so later
+// messages are only logged.
+func openAuthURL(userLogf func(string, ...interface{}), logger *log.Logger) func(string, ...interface{}) {
+	var once sync.Once
+	return func(format string, args ...interface{}) {
+		userLogf(format, args...)
+		msg := fmt.Sprintf(format, args...)
+		if !strings.Contains(msg, "https://") {
+			return
+		}
+		once.Do(func() {
+			if err := platform.OpenBrowser(extractURL(msg)); err != nil {
+				logger.Printf("Could not open browser: %v", err)
+			}
+		})
+	}
+}
+
+// validateControlURL checks that a -control-url is an https URL, since the
+// control server hands out node keys and the tailnet's peer list
+func validateControlURL(controlURL string) error {
+	if controlURL == "" {
+		return nil
+	}
+	u, err := url.Parse(controlURL)
+	if err != nil {
+		return fmt.Errorf("invalid control URL %q: %w", controlURL, err)
+	}
+	if u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("invalid control URL %q: must be an https:// URL", controlURL)
+	}
+	return nil
+}
+
+// parseControlProxy validates -control-proxy, returning nil when it is unset
+func parseControlProxy(proxy string) (*url.URL, error) {
+	if proxy == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid control proxy %q: %w", proxy, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid control proxy %q: must be an http:// or https:// URL", proxy)
+	}
+	if u.Path != "" && u.Path != "/" {
+		return nil, fmt.Errorf("invalid control proxy %q: must not have a path", proxy)
+	}
+	return u, nil
+}
+
+// setProxyEnv points HTTPS_PROXY and HTTP_PROXY at proxy, the only way to
+// hand tsnet a proxy, and returns a function that puts back whatever was set
+// before. A nil proxy leaves the environment alone.
+func setProxyEnv(proxy *url.URL) (restore func()) {
+	if proxy == nil {
+		return func() {}
+	}
+	names := []string{"HTTPS_PROXY", "HTTP_PROXY"}
+	saved := make(map[string]*string, len(names))
+	for _, name := range names {
+		if old, ok := os.LookupEnv(name); ok {
+			saved[name] = &old
+		} else {
+			saved[name] = nil
+		}
+		os.Setenv(name, proxy.String())
+	}
+	return func() {
+		for _, name := range names {
+			if old := saved[name]; old != nil {
+				os.Setenv(name, *old)
+			} else {
+				os.Unsetenv(name)
+			}
+		}
+	}
+}
+
+func extractURL(msg string) string {
+	if idx := strings.Index(msg, "https://"); idx != -1 {
+		url := msg[idx:]
+		if endIdx := strings.IndexAny(url, " \n\r\t"); endIdx != -1 {
+			url = url[:endIdx]
+		}
+		return url
+	}
+	return msg
+}
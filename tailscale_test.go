@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tsnet"
+)
+
+func TestExtractURL(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  string
+		want string
+	}{
+		{
+			name: "URL in middle of message",
+			msg:  "Please visit https://login.tailscale.com/a/123 to authenticate",
+			want: "https://login.tailscale.com/a/123",
+		},
+		{
+			name: "URL at start",
+			msg:  "https://login.tailscale.com/a/456",
+			want: "https://login.tailscale.com/a/456",
+		},
+		{
+			name: "No URL",
+			msg:  "No URL here",
+			want: "No URL here",
+		},
+		{
+			name: "URL with newline",
+			msg:  "Visit https://example.com\nfor more info",
+			want: "https://example.com",
+		},
+		{
+			name: "URL with tab",
+			msg:  "Visit https://example.com\tfor details",
+			want: "https://example.com",
+		},
+		{
+			name: "URL with space",
+			msg:  "Visit https://example.com and continue",
+			want: "https://example.com",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := extractURL(tt.msg)
+			if got != tt.want {
+				t.Errorf("extractURL() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateControlURL(t *testing.T) {
+	tests := []struct {
+		url     string
+		wantErr bool
+	}{
+		{url: "", wantErr: false},
+		{url: "https://controlplane.example.com", wantErr: false},
+		{url: "https://headscale.example.com:8443/", wantErr: false},
+		{url: "http://controlplane.example.com", wantErr: true},
+		{url: "controlplane.example.com", wantErr: true},
+		{url: "ftp://controlplane.example.com", wantErr: true},
+		{url: "https://", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			err := validateControlURL(tt.url)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateControlURL(%q) error = %v, wantErr %v", tt.url, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// fakeTailscaleStatus returns the given backend states in order, then repeats the last
+type fakeTailscaleStatus struct {
+	states []string
+}
+
+func (f *fakeTailscaleStatus) Status(ctx context.Context) (*ipnstate.Status, error) {
+	state := f.states[0]
+	if len(f.states) > 1 {
+		f.states = f.states[1:]
+	}
+	return &ipnstate.Status{BackendState: state}, nil
+}
+
+func TestBringUpWithFailover(t *testing.T) {
+	var tried []string
+	var timeouts []time.Duration
+	bringUp := func(controlURL string, timeout time.Duration) (*tsnet.Server, context.Context, error) {
+		tried = append(tried, controlURL)
+		timeouts = append(timeouts, timeout)
+		if strings.Contains(controlURL, "down") {
+			return nil, nil, errors.New("connection refused")
+		}
+		return &tsnet.Server{ControlURL: controlURL}, context.Background(), nil
+	}
+
+	srv, _, err := bringUpWithFailover([]string{"https://down.example.com", "https://hs2.example.com", "https://hs3.example.com"}, bringUp)
+	if err != nil {
+		t.Fatalf("bringUpWithFailover() error = %v", err)
+	}
+	if srv.ControlURL != "https://hs2.example.com" {
+		t.Errorf("came up with %q, want the second control server", srv.ControlURL)
+	}
+	if len(tried) != 2 || timeouts[0] != ControlFailoverTimeout {
+		t.Errorf("tried %q with timeouts %v, want the first two with a failover timeout on the first", tried, timeouts)
+	}
+
+	// Every server failing reports each one; the last waits without a timeout
+	tried, timeouts = nil, nil
+	_, _, err = bringUpWithFailover([]string{"https://down1.example.com", "https://down2.example.com"}, bringUp)
+	if err == nil || !strings.Contains(err.Error(), "down1.example.com") || !strings.Contains(err.Error(), "down2.example.com") {
+		t.Errorf("bringUpWithFailover() error = %v, want both servers named", err)
+	}
+	if len(timeouts) != 2 || timeouts[1] != 0 {
+		t.Errorf("timeouts = %v, want none on the last server", timeouts)
+	}
+
+	// No -control-url uses the default server, with no timeout
+	tried, timeouts = nil, nil
+	if _, _, err := bringUpWithFailover(nil, bringUp); err != nil || len(tried) != 1 || tried[0] != "" || timeouts[0] != 0 {
+		t.Errorf("bringUpWithFailover(nil) tried %q, %v, error %v", tried, timeouts, err)
+	}
+}
+
+func TestCheckLoginNonInteractive(t *testing.T) {
+	ctx := context.Background()
+
+	needsLogin := &fakeTailscaleStatus{states: []string{"NoState", "NoState", "NeedsLogin"}}
+	err := checkLoginNonInteractive(ctx, needsLogin, time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "TS_AUTHKEY") {
+		t.Errorf("checkLoginNonInteractive() error = %v, want one suggesting TS_AUTHKEY", err)
+	}
+
+	loggedIn := &fakeTailscaleStatus{states: []string{"NoState", "Starting"}}
+	if err := checkLoginNonInteractive(ctx, loggedIn, time.Millisecond); err != nil {
+		t.Errorf("checkLoginNonInteractive() with a stored login error = %v", err)
+	}
+
+	stuck := &fakeTailscaleStatus{states: []string{"NoState"}}
+	timeoutCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if err := checkLoginNonInteractive(timeoutCtx, stuck, time.Millisecond); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("checkLoginNonInteractive() while starting error = %v, want deadline exceeded", err)
+	}
+}
+
+func TestParseControlProxy(t *testing.T) {
+	for _, proxy := range []string{"proxy.example.com:3128", "socks5://proxy.example.com:1080", "http://", "http://proxy.example.com:3128/path"} {
+		if _, err := parseControlProxy(proxy); err == nil {
+			t.Errorf("parseControlProxy(%q) should fail", proxy)
+		}
+	}
+
+	if u, err := parseControlProxy(""); err != nil || u != nil {
+		t.Errorf("parseControlProxy(\"\") = %v, %v, want nil, nil", u, err)
+	}
+	u, err := parseControlProxy("http://proxy.example.com:3128")
+	if err != nil {
+		t.Fatalf("parseControlProxy() error = %v", err)
+	}
+	if u.String() != "http://proxy.example.com:3128" {
+		t.Errorf("parseControlProxy() = %v", u)
+	}
+}
+
+func TestSetProxyEnvRestores(t *testing.T) {
+	t.Setenv("HTTPS_PROXY", "http://user-proxy:8080")
+	t.Setenv("HTTP_PROXY", "")
+	os.Unsetenv("HTTP_PROXY")
+
+	proxy, err := parseControlProxy("http://proxy.example.com:3128")
+	if err != nil {
+		t.Fatal(err)
+	}
+	restore := setProxyEnv(proxy)
+	for _, name := range []string{"HTTPS_PROXY", "HTTP_PROXY"} {
+		if got := os.Getenv(name); got != "http://proxy.example.com:3128" {
+			t.Errorf("%s = %q while tsnet comes up, want the control proxy", name, got)
+		}
+	}
+
+	restore()
+	if got := os.Getenv("HTTPS_PROXY"); got != "http://user-proxy:8080" {
+		t.Errorf("HTTPS_PROXY = %q after restore, want the user's own proxy", got)
+	}
+	if _, ok := os.LookupEnv("HTTP_PROXY"); ok {
+		t.Error("HTTP_PROXY should be unset again after restore")
+	}
+
+	setProxyEnv(nil)()
+	if got := os.Getenv("HTTPS_PROXY"); got != "http://user-proxy:8080" {
+		t.Errorf("a nil proxy changed HTTPS_PROXY to %q", got)
+	}
+}
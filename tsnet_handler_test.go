@@ -2,7 +2,10 @@ package main
 
 import (
 	"bytes"
+	"io"
 	"log"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -86,3 +89,45 @@ func TestStderrCapture(t *testing.T) {
 		t.Errorf("Expected to find 2 auth URLs in test data, found %d", authURLCount)
 	}
 }
+
+// TestTsnetLogfsToFile verifies that -tsnet-log-file keeps tsnet output off the console
+func TestTsnetLogfsToFile(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "tsnet.log")
+	f, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatalf("Failed to create log file: %v", err)
+	}
+	defer f.Close()
+
+	// Capture the console while tsnet logs are written
+	origStdout, origStderr := os.Stdout, os.Stderr
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Failed to create pipe: %v", err)
+	}
+	os.Stdout, os.Stderr = w, w
+
+	// Even with -v, tsnet output belongs in the tsnet log file
+	sshLog := log.New(w, "", 0)
+	logf, userLogf := tsnetLogfs(log.New(f, "", 0), false, true, sshLog)
+	logf("magicsock: backend message %d", 1)
+	userLogf("tailscale: user message")
+
+	w.Close()
+	os.Stdout, os.Stderr = origStdout, origStderr
+	console, _ := io.ReadAll(r)
+
+	if len(console) != 0 {
+		t.Errorf("tsnet logs leaked to the console: %q", console)
+	}
+
+	data, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatalf("Failed to read log file: %v", err)
+	}
+	for _, want := range []string{"magicsock: backend message 1", "tailscale: user message"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("tsnet log file missing %q, got %q", want, data)
+		}
+	}
+}
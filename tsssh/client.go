@@ -0,0 +1,679 @@
+// Package tsssh lets other programs use ts-ssh as a library: it connects to
+// SSH servers on a Tailnet through a userspace tsnet node, without requiring a
+// Tailscale daemon, and runs commands, shells and SCP transfers over them.
+//
+// The ts-ssh command is a thin wrapper around this package.
+package tsssh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/netip"
+	"net/url"
+	"os"
+	osuser "os/user"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+	"tailscale.com/client/local"
+	"tailscale.com/ipn/ipnstate"
+	"tailscale.com/tsnet"
+
+	"github.com/derekg/ts-ssh/internal/client/scp"
+	sshclient "github.com/derekg/ts-ssh/internal/client/ssh"
+	"github.com/derekg/ts-ssh/internal/config"
+	"github.com/derekg/ts-ssh/internal/crypto/pqc"
+	"github.com/derekg/ts-ssh/internal/security"
+)
+
+// Dialer opens network connections to SSH hosts. *tsnet.Server satisfies it.
+type Dialer interface {
+	Dial(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// PasswordCache holds a password for reuse across connections.
+// Call Clear once connected so later connections prompt again.
+type PasswordCache = sshclient.PasswordCache
+
+// NewPasswordCache returns an empty PasswordCache.
+func NewPasswordCache() *PasswordCache {
+	return sshclient.NewPasswordCache()
+}
+
+// AuthThrottle spaces out authentication attempts per host, backing off while
+// they fail, and caps how many connections authenticate at once.
+type AuthThrottle = sshclient.AuthThrottle
+
+// NewAuthThrottle returns an AuthThrottle that waits at least delay between
+// attempts to a host and lets maxConcurrent connections authenticate at once;
+// 0 uses the default cap.
+func NewAuthThrottle(delay time.Duration, maxConcurrent int) *AuthThrottle {
+	return sshclient.NewAuthThrottle(delay, maxConcurrent)
+}
+
+// Config configures a Client. The zero value connects as the current user
+// with automatic key discovery through a tsnet node in ~/.config/ts-ssh.
+type Config struct {
+	User            string // SSH user, defaults to the current user
+	KeyPath         string // SSH private key, empty uses key discovery
+	IdentitiesOnly  bool   // Only offer KeyPath, skip key discovery
+	InsecureHostKey bool   // Skip host key verification (insecure)
+	// Identity is a private key already in memory, e.g. from a secret
+	// manager; when set it is offered instead of KeyPath and discovered keys
+	Identity ssh.Signer
+	// UserKnownHostsFile replaces ~/.ssh/known_hosts; /etc/ssh/ssh_known_hosts is also checked
+	UserKnownHostsFile string
+	ReadOnlyKnownHosts bool          // Verify host keys but never write to known_hosts
+	AuthDelay          time.Duration // Minimum delay between authentication attempts
+	// AuthThrottle, when shared by several Clients, paces and caps their
+	// authentication together and replaces AuthDelay. When nil each Client
+	// has its own, built from AuthDelay.
+	AuthThrottle *AuthThrottle
+	// HostKeyAlias, when set, is the name host keys are checked and saved
+	// under in known_hosts instead of the host connected to. A tailnet IP
+	// otherwise uses its peer's name, as connecting by name would.
+	HostKeyAlias string
+	// AcceptChangedFor lists hosts whose changed host key is accepted once,
+	// replacing the old known_hosts entry, instead of failing the connection
+	AcceptChangedFor []string
+	// NoKeyboardInteractive skips keyboard-interactive (OTP) authentication
+	NoKeyboardInteractive bool
+	// PasswordCache, when shared by several Clients, lets a password typed
+	// for one host be tried on the next before prompting again. Nil, the
+	// default, prompts for each host.
+	PasswordCache *PasswordCache
+
+	// HandshakeRetries redials a host this many times when it accepts the
+	// connection but never starts the SSH handshake, as happens over a
+	// half-open path.
+	HandshakeRetries int
+
+	// PQCLevel asks for a post-quantum key exchange: 1 (hybrid) prefers one
+	// and falls back to classical with a logged and audited downgrade, 2
+	// (strict) refuses hosts that would only use classical. 0 leaves the key
+	// exchange as it is.
+	PQCLevel int
+
+	// AddressFamily restricts the address dialled to "4" (IPv4) or "6" (IPv6),
+	// chosen from the host's tailnet addresses. It needs a tsnet node, so a
+	// custom Dialer must also provide LocalClient like *tsnet.Server does.
+	AddressFamily string
+
+	// HTTPProxy is an http://[user:password@]host:port proxy, reached
+	// through the Dialer, whose CONNECT method tunnels to hosts that only
+	// the proxy can reach. The proxy resolves the host, so AddressFamily
+	// can't be used with it.
+	HTTPProxy string
+
+	// JumpHosts are reached in turn, each through the one before, and the
+	// host through the last, like ssh -J. Each is "[user@]host[:port]", with
+	// the current user and port 22 by default, and is connected with the
+	// settings above; AddressFamily and HTTPProxy apply to the first hop
+	// only. Hosts behind a jump host have their keys checked under
+	// "jumphost/host", as names there may mean a different machine.
+	JumpHosts []string
+	// JumpTimeout bounds each jump host from being dialed to presenting its
+	// host key. Prompts don't count against it. Zero means no limit.
+	JumpTimeout time.Duration
+
+	// Dialer overrides how connections to SSH hosts are made, for example
+	// with an already running *tsnet.Server. When nil, Connect starts a tsnet
+	// node from the settings below and Close shuts it down.
+	Dialer     Dialer
+	TsnetDir   string // Tailscale state directory, defaults to ~/.config/ts-ssh
+	Hostname   string // Tailnet node name, defaults to "ts-ssh"
+	ControlURL string // Tailscale control server URL
+	AuthKey    string // Optional Tailscale auth key
+
+	// TsnetLogf receives tsnet backend logs and is discarded when nil.
+	// TsnetUserLogf receives user-facing messages such as login URLs and
+	// uses the standard logger when nil.
+	TsnetLogf     func(format string, args ...interface{})
+	TsnetUserLogf func(format string, args ...interface{})
+
+	// Stdin, Stdout and Stderr are connected to remote commands and shells.
+	// Nil readers and writers behave like the null device.
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// Banner receives the message some servers send before authentication,
+	// such as a legal notice. It is discarded when nil.
+	Banner io.Writer
+
+	DisablePTY  bool          // Never request a pseudo-terminal for Shell
+	RequirePTY  bool          // Fail Shell when the server refuses a pseudo-terminal instead of continuing without one
+	IdleTimeout time.Duration // Close Shell sessions after this long without input or output
+	ExecTimeout time.Duration // Close Run sessions after this long; Run then returns ErrExecTimeout
+	Preserve    bool          // Upload and Download keep modes and modification times, like scp -p
+	PTYSize     PTYSize       // Fixed pseudo-terminal size, zero follows the local terminal
+	NoEscapes   bool          // Send "~" through unchanged instead of handling ~. and ~?, like ssh -e none
+	Logger      *log.Logger   // Debug logging, discarded when nil
+}
+
+// ErrExecTimeout is returned, wrapped, by Run when the command outlives
+// Config.ExecTimeout and its session is closed.
+var ErrExecTimeout = errors.New("remote command timed out")
+
+// RemoteShell describes the login shell a host runs commands with.
+type RemoteShell = sshclient.RemoteShell
+
+// Client is an SSH connection to one host on a Tailnet.
+type Client struct {
+	config   Config
+	logger   *log.Logger
+	throttle *AuthThrottle
+	srv      *tsnet.Server // Started by Connect when no Dialer is configured
+	hops     []jumpHop     // Connected JumpHosts, in order
+	client   *ssh.Client
+	shell    *RemoteShell // Cached by RemoteShell
+	bytes    sshclient.ByteCounter
+}
+
+// New returns a Client for config. No network activity happens until Connect.
+func New(config Config) *Client {
+	logger := config.Logger
+	if logger == nil {
+		logger = log.New(io.Discard, "", 0)
+	}
+	throttle := config.AuthThrottle
+	if throttle == nil {
+		throttle = NewAuthThrottle(config.AuthDelay, 0)
+	}
+	return &Client{config: config, logger: logger, throttle: throttle}
+}
+
+// Connect establishes the SSH connection to host, through JumpHosts when
+// set. An empty port means 22.
+func (c *Client) Connect(ctx context.Context, host, port string) error {
+	if c.client != nil {
+		return errors.New("already connected")
+	}
+	if port == "" {
+		port = config.DefaultSSHPort
+	}
+
+	currentUser, err := osuser.Current()
+	if err != nil {
+		currentUser = &osuser.User{Username: c.config.User}
+	}
+	sshUser := c.config.User
+	if sshUser == "" {
+		sshUser = currentUser.Username
+	}
+
+	// Validate inputs
+	if err := security.ValidateSSHUser(sshUser); err != nil {
+		return fmt.Errorf("invalid SSH user: %w", err)
+	}
+	if err := security.ValidateHostname(host); err != nil {
+		return fmt.Errorf("invalid hostname: %w", err)
+	}
+	if err := security.ValidatePort(port); err != nil {
+		return fmt.Errorf("invalid port: %w", err)
+	}
+	pqcConfig, err := pqc.ConfigForLevel(c.config.PQCLevel)
+	if err != nil {
+		return err
+	}
+	var proxy *url.URL
+	if c.config.HTTPProxy != "" {
+		if c.config.AddressFamily != "" {
+			return errors.New("AddressFamily can't be used with HTTPProxy")
+		}
+		if proxy, err = sshclient.ParseHTTPProxyURL(c.config.HTTPProxy); err != nil {
+			return err
+		}
+	}
+
+	dialer := c.config.Dialer
+	if dialer == nil {
+		if err := c.startTsnet(ctx, currentUser); err != nil {
+			return fmt.Errorf("failed to initialize Tailscale: %w", err)
+		}
+		dialer = c.srv
+	}
+	addressFamily, hostKeyAlias := c.config.AddressFamily, c.config.HostKeyAlias
+	if len(c.config.JumpHosts) > 0 {
+		hops, via, err := connectJumpHosts(ctx, dialer, c.config.JumpHosts, currentUser.Username, c.connectHop)
+		if err != nil {
+			return err
+		}
+		c.hops = hops
+		dialer, proxy, addressFamily = hops[len(hops)-1], nil, ""
+		if hostKeyAlias == "" {
+			hostKeyAlias = jumpedHostKeyAlias(via, host)
+		}
+	}
+	if proxy != nil {
+		c.logger.Printf("Connecting to %s through HTTP proxy %s", host, proxy.Redacted())
+		dialer = sshclient.NewHTTPConnectDialer(dialer, proxy)
+	}
+
+	var dialAddress string
+	if addressFamily != "" {
+		addr, err := c.resolveAddress(ctx, dialer, host)
+		if err != nil {
+			return err
+		}
+		dialAddress = addr.String()
+	}
+	if hostKeyAlias == "" {
+		hostKeyAlias = c.peerHostKeyAlias(ctx, dialer, host)
+	}
+
+	client, err := sshclient.EstablishSSHConnection(dialer, ctx, sshclient.SSHConnectionConfig{
+		User:                  sshUser,
+		KeyPath:               c.config.KeyPath,
+		Identity:              c.config.Identity,
+		TargetHost:            host,
+		TargetPort:            port,
+		InsecureHostKey:       c.config.InsecureHostKey,
+		UserKnownHostsFile:    c.config.UserKnownHostsFile,
+		ReadOnlyKnownHosts:    c.config.ReadOnlyKnownHosts,
+		AcceptChangedFor:      c.config.AcceptChangedFor,
+		IdentitiesOnly:        c.config.IdentitiesOnly,
+		AuthThrottle:          c.throttle,
+		PasswordCache:         c.config.PasswordCache,
+		DialAddress:           dialAddress,
+		HostKeyAlias:          hostKeyAlias,
+		HandshakeRetries:      c.config.HandshakeRetries,
+		NoKeyboardInteractive: c.config.NoKeyboardInteractive,
+		Banner:                c.config.Banner,
+		PQCConfig:             pqcConfig,
+		Verbose:               c.config.Logger != nil,
+		CurrentUser:           currentUser,
+		Logger:                c.logger,
+	})
+	if err != nil {
+		c.closeHops()
+		return err
+	}
+	c.client = client
+	return nil
+}
+
+// resolveAddress picks the configured address family for host from the
+// tailnet peer list
+func (c *Client) resolveAddress(ctx context.Context, dialer Dialer, host string) (netip.Addr, error) {
+	status, err := tailnetStatus(ctx, dialer)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("AddressFamily: %w", err)
+	}
+	return sshclient.ResolvePeerAddress(status, host, c.config.AddressFamily)
+}
+
+// peerHostKeyAlias returns the tailnet peer name to check host's key under
+// when host is a tailnet IP, so connecting by address and by name verify
+// against the same known_hosts entry. It returns "" for anything else.
+func (c *Client) peerHostKeyAlias(ctx context.Context, dialer Dialer, host string) string {
+	if _, err := netip.ParseAddr(host); err != nil {
+		return ""
+	}
+	status, err := tailnetStatus(ctx, dialer)
+	if err != nil {
+		return ""
+	}
+	name := sshclient.PeerNameForIP(status, host)
+	if name != "" {
+		c.logger.Printf("%s is tailnet peer %s; checking its host key as %s", host, name, name)
+	}
+	return name
+}
+
+// tailnetStatus returns the tailnet status from a tsnet Dialer
+func tailnetStatus(ctx context.Context, dialer Dialer) (*ipnstate.Status, error) {
+	statusDialer, ok := dialer.(interface {
+		LocalClient() (*local.Client, error)
+	})
+	if !ok {
+		return nil, errors.New("the tailnet peer list needs a tsnet Dialer")
+	}
+	lc, err := statusDialer.LocalClient()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get Tailscale client: %w", err)
+	}
+	status, err := lc.Status(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tailnet status: %w", err)
+	}
+	return status, nil
+}
+
+// startTsnet brings up the tsnet node used when no Dialer is configured
+func (c *Client) startTsnet(ctx context.Context, currentUser *osuser.User) error {
+	dir := c.config.TsnetDir
+	if dir == "" {
+		dir = filepath.Join(currentUser.HomeDir, ".config", config.ClientName)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create tsnet directory: %w", err)
+	}
+
+	hostname := c.config.Hostname
+	if hostname == "" {
+		hostname = config.ClientName
+	}
+
+	srv := &tsnet.Server{
+		Dir:        dir,
+		Hostname:   hostname,
+		ControlURL: c.config.ControlURL,
+		AuthKey:    c.config.AuthKey,
+		Logf:       func(string, ...interface{}) {},
+		UserLogf:   c.config.TsnetUserLogf,
+	}
+	if c.config.TsnetLogf != nil {
+		srv.Logf = c.config.TsnetLogf
+	}
+
+	if _, err := srv.Up(ctx); err != nil {
+		srv.Close()
+		return fmt.Errorf("failed to bring up Tailscale: %w", err)
+	}
+	c.srv = srv
+	return nil
+}
+
+// SSHClient returns the underlying SSH connection, or nil before Connect.
+func (c *Client) SSHClient() *ssh.Client {
+	return c.client
+}
+
+// Run runs cmd on the remote host with the configured Stdin, Stdout and
+// Stderr. A non-zero remote exit status is reported as an *ssh.ExitError.
+// Cancelling ctx closes the session and returns the context's error; so
+// does ExecTimeout running out, with an error matching ErrExecTimeout.
+func (c *Client) Run(ctx context.Context, cmd string) error {
+	session, err := c.newSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	c.logger.Printf("Executing remote command: %s", cmd)
+	session.Stdin = c.bytes.Reader(c.config.Stdin)
+	session.Stdout = c.bytes.Writer(c.config.Stdout)
+	session.Stderr = c.bytes.Writer(c.config.Stderr)
+	if err := session.Start(cmd); err != nil {
+		return fmt.Errorf("failed to start remote command: %w", err)
+	}
+	if c.config.ExecTimeout <= 0 {
+		return sshclient.WaitSession(ctx, session)
+	}
+
+	execCtx, cancel := context.WithTimeout(ctx, c.config.ExecTimeout)
+	defer cancel()
+	err = sshclient.WaitSession(execCtx, session)
+	if ctx.Err() == nil && errors.Is(err, context.DeadlineExceeded) {
+		c.logger.Printf("Closed remote command after %v", c.config.ExecTimeout)
+		return fmt.Errorf("%w after %v", ErrExecTimeout, c.config.ExecTimeout)
+	}
+	return err
+}
+
+// Succeeds runs cmd as a check, without input and with its output discarded,
+// and reports whether it exited with status 0. A non-zero exit status is a
+// false result; failing to run cmd at all is an error.
+func (c *Client) Succeeds(ctx context.Context, cmd string) (bool, error) {
+	session, err := c.newSession()
+	if err != nil {
+		return false, err
+	}
+	defer session.Close()
+
+	c.logger.Printf("Executing remote check: %s", cmd)
+	if err := session.Start(cmd); err != nil {
+		return false, fmt.Errorf("failed to start remote command: %w", err)
+	}
+	err = sshclient.WaitSession(ctx, session)
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Shell starts an interactive login shell and waits for it to exit. A
+// pseudo-terminal is requested when Stdin is a terminal and DisablePTY is unset;
+// if the server refuses one the shell runs without it, unless RequirePTY is set.
+// With a PTY, typing ~. at the start of a line closes the session like ssh.
+// Cancelling ctx closes the session.
+func (c *Client) Shell(ctx context.Context) error {
+	return c.interactive(ctx, "")
+}
+
+// ShellCommand runs cmd the way Shell runs a login shell: on a
+// pseudo-terminal when Stdin is one, with the ~ escapes and the terminal
+// size kept in sync. Like OpenSSH's RemoteCommand with RequestTTY, a command
+// that ends by starting a shell (for example "tmux attach || exec bash -l")
+// keeps the session open. An unsuccessful exit is returned as an
+// *ssh.ExitError.
+func (c *Client) ShellCommand(ctx context.Context, cmd string) error {
+	return c.interactive(ctx, cmd)
+}
+
+// interactive runs cmd, or a login shell when cmd is empty, attached to the
+// local terminal
+func (c *Client) interactive(ctx context.Context, cmd string) error {
+	session, err := c.newSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	// Close the session once neither side has sent anything for IdleTimeout
+	idle := sshclient.NewIdleTimer(c.config.IdleTimeout, func() {
+		c.logger.Printf("Closing session after %v of inactivity", c.config.IdleTimeout)
+		session.Close()
+	})
+	defer idle.Stop()
+
+	session.Stdout = idle.Writer(c.bytes.Writer(c.config.Stdout))
+	session.Stderr = idle.Writer(c.bytes.Writer(c.config.Stderr))
+
+	// Setup PTY if we're in a terminal and PTY is not disabled
+	fd, followResize := -1, false
+	if f, ok := c.config.Stdin.(*os.File); ok && !c.config.DisablePTY && term.IsTerminal(int(f.Fd())) {
+		var allocated bool
+		allocated, followResize, err = c.allocatePTY(session, int(f.Fd()))
+		if err != nil {
+			return err
+		}
+
+		// Put terminal in raw mode, restoring it on return or on a signal.
+		// Without a remote PTY the local terminal keeps line editing and echo.
+		if allocated {
+			fd = int(f.Fd())
+			if err := sshclient.MakeTerminalRaw(fd); err != nil {
+				// The local terminal still echoes, whatever the remote side asks
+				if c.config.Stderr != nil {
+					fmt.Fprintf(c.config.Stderr, "Warning: failed to set raw mode: %v; typed input, passwords included, will be shown\n", err)
+				}
+			} else {
+				stop := sshclient.RestoreTerminalOnSignal()
+				defer stop()
+				defer sshclient.RestoreTerminal()
+			}
+		}
+	}
+
+	// Setup I/O
+	stdinPipe, err := session.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("failed to setup stdin: %w", err)
+	}
+
+	if cmd == "" {
+		if err := session.Shell(); err != nil {
+			return fmt.Errorf("failed to start shell: %w", err)
+		}
+	} else {
+		c.logger.Printf("Executing remote command: %s", cmd)
+		if err := session.Start(cmd); err != nil {
+			return fmt.Errorf("failed to start remote command: %w", err)
+		}
+	}
+
+	// Keep the remote size in sync with the local terminal
+	if followResize {
+		watchCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go sshclient.WatchWindowSize(fd, session, watchCtx, c.logger)
+	}
+
+	// Copy stdin to session
+	var escaped atomic.Bool
+	if c.config.Stdin != nil {
+		in := c.sessionInput(idle.Reader(c.config.Stdin), fd >= 0, func() {
+			escaped.Store(true)
+			if c.config.Stderr != nil {
+				fmt.Fprint(c.config.Stderr, "Connection closed.\r\n")
+			}
+			session.Close()
+		})
+		go c.forwardInput(stdinPipe, c.bytes.Reader(in))
+	}
+
+	// Wait for session to finish
+	err = sshclient.WaitSession(ctx, session)
+	if escaped.Load() {
+		return nil
+	}
+	if idle.Expired() {
+		return fmt.Errorf("session closed after %v of inactivity", c.config.IdleTimeout)
+	}
+	return err
+}
+
+// BytesTransferred returns how much session data Run and Shell have sent
+// from Stdin and received on Stdout and Stderr. Protocol overhead and SCP
+// transfers are not included.
+func (c *Client) BytesTransferred() (sent, received int64) {
+	return c.bytes.Totals()
+}
+
+// sessionInput returns in as it should reach an interactive session. On a
+// terminal the ~ escapes are handled, ~. calling onTerminate, unless
+// NoEscapes says to pass every byte through.
+func (c *Client) sessionInput(in io.Reader, terminal bool, onTerminate func()) io.Reader {
+	if !terminal || c.config.NoEscapes {
+		return in
+	}
+	return sshclient.NewEscapeReader(in, c.config.Stderr, onTerminate)
+}
+
+// forwardInput copies in to the remote stdin, then closes it so the server
+// sees EOF. The session doesn't end there: a shell may keep running after
+// EOF, and Shell keeps waiting for it to exit, for ctx or for ~.
+func (c *Client) forwardInput(stdin io.WriteCloser, in io.Reader) {
+	if _, err := io.Copy(stdin, in); err != nil {
+		c.logger.Printf("Error forwarding input: %v", err)
+	}
+	if err := stdin.Close(); err != nil {
+		c.logger.Printf("Error sending EOF: %v", err)
+		return
+	}
+	c.logger.Printf("Input closed; sent EOF to the remote shell")
+}
+
+// RemoteShell reports the login shell and OS of the connected host, which
+// decide how a command passed to Run is parsed. The host is probed once by
+// running a short command; later calls return the cached result.
+func (c *Client) RemoteShell(ctx context.Context) (RemoteShell, error) {
+	if c.client == nil {
+		return RemoteShell{}, errors.New("not connected")
+	}
+	if c.shell == nil {
+		shell, err := sshclient.ProbeRemoteShell(ctx, c.client)
+		if err != nil {
+			return RemoteShell{}, err
+		}
+		c.shell = &shell
+	}
+	return *c.shell, nil
+}
+
+// Upload copies localPath to remotePath on the connected host.
+func (c *Client) Upload(ctx context.Context, localPath, remotePath string) error {
+	if c.client == nil {
+		return errors.New("not connected")
+	}
+	return scp.CopyToRemote(ctx, c.client, localPath, remotePath, c.config.Preserve, c.logger)
+}
+
+// Download copies remotePath on the connected host to localPath.
+func (c *Client) Download(ctx context.Context, remotePath, localPath string) error {
+	if c.client == nil {
+		return errors.New("not connected")
+	}
+	return scp.CopyFromRemote(ctx, c.client, remotePath, localPath, c.config.Preserve, c.logger)
+}
+
+// UploadFrom copies everything read from r to remotePath on the connected
+// host. r is spooled to a temporary file first, as scp needs the size up
+// front; the remote file gets mode 0600.
+func (c *Client) UploadFrom(ctx context.Context, r io.Reader, remotePath string) error {
+	if c.client == nil {
+		return errors.New("not connected")
+	}
+	return scp.CopyStreamToRemote(ctx, c.client, r, remotePath, c.logger)
+}
+
+// DownloadTo writes the contents of remotePath on the connected host to w.
+func (c *Client) DownloadTo(ctx context.Context, remotePath string, w io.Writer) error {
+	if c.client == nil {
+		return errors.New("not connected")
+	}
+	return scp.CopyRemoteToStream(ctx, c.client, remotePath, w, c.logger)
+}
+
+// Glob returns the paths on the connected host matching pattern, for use
+// with Download. The pattern is expanded by the remote shell.
+func (c *Client) Glob(ctx context.Context, pattern string) ([]string, error) {
+	if c.client == nil {
+		return nil, errors.New("not connected")
+	}
+	return scp.ExpandRemoteGlob(ctx, c.client, pattern)
+}
+
+// Dial opens a connection to addr from the remote host, as used for port
+// forwarding. The connection is tunnelled over the SSH session.
+func (c *Client) Dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	if c.client == nil {
+		return nil, errors.New("not connected")
+	}
+	return c.client.DialContext(ctx, network, addr)
+}
+
+// Close closes the SSH connection and any tsnet node started by Connect.
+func (c *Client) Close() error {
+	var err error
+	if c.client != nil {
+		err = c.client.Close()
+		c.client = nil
+	}
+	c.closeHops()
+	if c.srv != nil {
+		if closeErr := c.srv.Close(); err == nil {
+			err = closeErr
+		}
+		c.srv = nil
+	}
+	return err
+}
+
+// newSession opens a session on the established connection
+func (c *Client) newSession() (*ssh.Session, error) {
+	if c.client == nil {
+		return nil, errors.New("not connected")
+	}
+	return sshclient.CreateSSHSession(c.client)
+}
@@ -0,0 +1,570 @@
+package tsssh
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	sshclient "github.com/derekg/ts-ssh/internal/client/ssh"
+)
+
+// netDialer dials directly, standing in for tsnet in tests
+type netDialer struct{}
+
+func (netDialer) Dial(ctx context.Context, network, address string) (net.Conn, error) {
+	var d net.Dialer
+	return d.DialContext(ctx, network, address)
+}
+
+// ptyRequestMsg is the payload of a "pty-req" channel request (RFC 4254 6.2)
+type ptyRequestMsg struct {
+	Term    string
+	Columns uint32
+	Rows    uint32
+	Width   uint32
+	Height  uint32
+	Modes   string
+}
+
+// testBanner is sent by the test server before authentication; the escape
+// sequence must never reach the local terminal
+const testBanner = "Authorized use only\x1b[2J\r\n"
+
+// newHostSigner returns a fresh ed25519 host key
+func newHostSigner(t *testing.T) ssh.Signer {
+	t.Helper()
+	_, hostPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate host key: %v", err)
+	}
+	hostSigner, err := ssh.NewSignerFromKey(hostPriv)
+	if err != nil {
+		t.Fatalf("Failed to create host signer: %v", err)
+	}
+	return hostSigner
+}
+
+// startTestServer runs a local SSH server accepting clientKey, served as
+// by listenTestServer
+func startTestServer(t *testing.T, clientKey ssh.PublicKey) (host, port string, ptyReqs <-chan ptyRequestMsg) {
+	t.Helper()
+
+	serverConfig := &ssh.ServerConfig{
+		BannerCallback: func(ssh.ConnMetadata) string { return testBanner },
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if bytes.Equal(key.Marshal(), clientKey.Marshal()) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("unknown public key for %s", conn.User())
+		},
+	}
+	serverConfig.AddHostKey(newHostSigner(t))
+	return listenTestServer(t, serverConfig)
+}
+
+// listenTestServer runs a local SSH server that authenticates with config.
+// It handles "echo <text>", "exit <status>" and "block" exec requests, opens
+// direct-tcpip channels as a jump host's sshd does, and reports pty-req
+// payloads on the returned channel. A pty-req for the terminal type "refuse"
+// is rejected, as by an sshd with PermitTTY no.
+func listenTestServer(t *testing.T, serverConfig *ssh.ServerConfig) (host, port string, ptyReqs <-chan ptyRequestMsg) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	ptyCh := make(chan ptyRequestMsg, 1)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveTestConn(conn, serverConfig, ptyCh)
+		}
+	}()
+
+	host, port, _ = net.SplitHostPort(listener.Addr().String())
+	return host, port, ptyCh
+}
+
+func serveTestConn(conn net.Conn, config *ssh.ServerConfig, ptyReqs chan<- ptyRequestMsg) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		switch newChannel.ChannelType() {
+		case "session":
+		case "direct-tcpip":
+			go serveTestTunnel(newChannel)
+			continue
+		default:
+			newChannel.Reject(ssh.UnknownChannelType, "unsupported channel type")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			defer channel.Close()
+			for req := range requests {
+				switch req.Type {
+				case "pty-req":
+					var msg ptyRequestMsg
+					ssh.Unmarshal(req.Payload, &msg)
+					select {
+					case ptyReqs <- msg:
+					default:
+					}
+					req.Reply(msg.Term != "refuse", nil)
+					continue
+				case "exec":
+				default:
+					req.Reply(false, nil)
+					continue
+				}
+				var payload struct{ Command string }
+				ssh.Unmarshal(req.Payload, &payload)
+				req.Reply(true, nil)
+
+				status := uint32(0)
+				switch {
+				case strings.HasPrefix(payload.Command, "echo "):
+					fmt.Fprintln(channel, strings.TrimPrefix(payload.Command, "echo "))
+				case payload.Command == "block":
+					// Never finishes on its own; requests end once the client closes the channel
+					for range requests {
+					}
+					return
+				case strings.HasPrefix(payload.Command, "exit "):
+					fmt.Sscanf(payload.Command, "exit %d", &status)
+				default:
+					fmt.Fprintf(channel.Stderr(), "unknown command: %s\n", payload.Command)
+					status = 127
+				}
+				channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+				return
+			}
+		}()
+	}
+}
+
+// serveTestTunnel connects a direct-tcpip channel to the address it asks for
+func serveTestTunnel(newChannel ssh.NewChannel) {
+	var dest struct {
+		Host     string
+		Port     uint32
+		OrigHost string
+		OrigPort uint32
+	}
+	if err := ssh.Unmarshal(newChannel.ExtraData(), &dest); err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, "malformed direct-tcpip request")
+		return
+	}
+	target, err := net.Dial("tcp", net.JoinHostPort(dest.Host, fmt.Sprint(dest.Port)))
+	if err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	defer target.Close()
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		return
+	}
+	defer channel.Close()
+	go ssh.DiscardRequests(requests)
+	go io.Copy(target, channel)
+	io.Copy(channel, target)
+}
+
+// writeTestKey writes an unencrypted ed25519 client key and returns its path
+func writeTestKey(t *testing.T) (string, ssh.PublicKey) {
+	t.Helper()
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate client key: %v", err)
+	}
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		t.Fatalf("Failed to marshal client key: %v", err)
+	}
+	keyPath := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatalf("Failed to write client key: %v", err)
+	}
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatalf("Failed to convert public key: %v", err)
+	}
+	return keyPath, sshPub
+}
+
+// connectTestClient connects a Client built from config to a new test server
+func connectTestClient(t *testing.T, config Config) (*Client, <-chan ptyRequestMsg) {
+	t.Helper()
+
+	keyPath, pub := writeTestKey(t)
+	host, port, ptyReqs := startTestServer(t, pub)
+
+	config.User = "testuser"
+	config.KeyPath = keyPath
+	config.IdentitiesOnly = true
+	config.InsecureHostKey = true
+	config.Dialer = netDialer{}
+
+	client := New(config)
+	if err := client.Connect(context.Background(), host, port); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client, ptyReqs
+}
+
+func TestClientRun(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	client, _ := connectTestClient(t, Config{Stdout: &stdout, Stderr: &stderr})
+
+	if err := client.Run(context.Background(), "echo hello tailnet"); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if got := stdout.String(); got != "hello tailnet\n" {
+		t.Errorf("Run() stdout = %q, want %q", got, "hello tailnet\n")
+	}
+
+	// Each Run uses a fresh session on the same connection
+	stdout.Reset()
+	if err := client.Run(context.Background(), "echo again"); err != nil {
+		t.Fatalf("second Run() error = %v", err)
+	}
+	if got := stdout.String(); got != "again\n" {
+		t.Errorf("second Run() stdout = %q, want %q", got, "again\n")
+	}
+}
+
+func TestClientShellCommand(t *testing.T) {
+	var stdout bytes.Buffer
+	client, _ := connectTestClient(t, Config{Stdin: strings.NewReader(""), Stdout: &stdout, Stderr: io.Discard})
+
+	// The command is sent as the session's exec request, not a shell
+	if err := client.ShellCommand(context.Background(), "echo attached"); err != nil {
+		t.Fatalf("ShellCommand() error = %v", err)
+	}
+	if got := stdout.String(); got != "attached\n" {
+		t.Errorf("ShellCommand() stdout = %q, want %q", got, "attached\n")
+	}
+
+	var exitErr *ssh.ExitError
+	if err := client.ShellCommand(context.Background(), "exit 3"); !errors.As(err, &exitErr) || exitErr.ExitStatus() != 3 {
+		t.Errorf("ShellCommand(exit 3) error = %v, want exit status 3", err)
+	}
+}
+
+func TestClientBanner(t *testing.T) {
+	var banner bytes.Buffer
+	connectTestClient(t, Config{Banner: &banner})
+
+	// The ESC is dropped, leaving the rest of the sequence as harmless text
+	if got := banner.String(); got != "Authorized use only[2J\n" {
+		t.Errorf("banner = %q, want the message without control characters", got)
+	}
+
+	// Without a Banner writer the message is dropped, not sent to Stderr
+	var stderr bytes.Buffer
+	connectTestClient(t, Config{Stderr: &stderr})
+	if stderr.Len() != 0 {
+		t.Errorf("Stderr = %q, want nothing", stderr.String())
+	}
+}
+
+func TestClientBytesTransferred(t *testing.T) {
+	var stdout bytes.Buffer
+	client, _ := connectTestClient(t, Config{Stdin: strings.NewReader("ignored input"), Stdout: &stdout})
+
+	if err := client.Run(context.Background(), "echo hello tailnet"); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	sent, received := client.BytesTransferred()
+	if received != int64(len("hello tailnet\n")) {
+		t.Errorf("received = %d, want the %d bytes of output", received, len("hello tailnet\n"))
+	}
+	if sent > int64(len("ignored input")) {
+		t.Errorf("sent = %d, more than the input", sent)
+	}
+}
+
+func TestClientRunExitStatus(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	client, _ := connectTestClient(t, Config{Stdout: &stdout, Stderr: &stderr})
+
+	err := client.Run(context.Background(), "exit 3")
+	var exitErr *ssh.ExitError
+	if !errors.As(err, &exitErr) {
+		t.Fatalf("Run() error = %v, want *ssh.ExitError", err)
+	}
+	if exitErr.ExitStatus() != 3 {
+		t.Errorf("ExitStatus() = %d, want 3", exitErr.ExitStatus())
+	}
+
+	if err := client.Run(context.Background(), "bogus"); err == nil {
+		t.Error("Run() of unknown command should fail")
+	}
+	if !strings.Contains(stderr.String(), "unknown command: bogus") {
+		t.Errorf("Run() stderr = %q, want unknown command message", stderr.String())
+	}
+}
+
+func TestClientRunCancel(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	client, _ := connectTestClient(t, Config{Stdout: &stdout, Stderr: &stderr})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() { errCh <- client.Run(ctx, "block") }()
+
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Run() error = %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run() did not return after the context was cancelled")
+	}
+
+	// The connection stays usable after a cancelled command
+	if err := client.Run(context.Background(), "echo still here"); err != nil {
+		t.Fatalf("Run() after cancel error = %v", err)
+	}
+}
+
+func TestClientRunExecTimeout(t *testing.T) {
+	client, _ := connectTestClient(t, Config{ExecTimeout: 50 * time.Millisecond})
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- client.Run(context.Background(), "block") }()
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, ErrExecTimeout) {
+			t.Errorf("Run() error = %v, want ErrExecTimeout", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run() kept waiting past ExecTimeout")
+	}
+
+	// Commands that finish in time are unaffected
+	if err := client.Run(context.Background(), "exit 0"); err != nil {
+		t.Errorf("Run() of a quick command error = %v", err)
+	}
+}
+
+func TestClientNotConnected(t *testing.T) {
+	client := New(Config{Dialer: netDialer{}})
+
+	if err := client.Run(context.Background(), "echo hi"); err == nil {
+		t.Error("Run() before Connect should fail")
+	}
+	if err := client.Upload(context.Background(), "a", "b"); err == nil {
+		t.Error("Upload() before Connect should fail")
+	}
+	if _, err := client.Dial(context.Background(), "tcp", "localhost:80"); err == nil {
+		t.Error("Dial() before Connect should fail")
+	}
+	if err := client.Close(); err != nil {
+		t.Errorf("Close() before Connect error = %v", err)
+	}
+}
+
+func TestClientsShareAuthThrottle(t *testing.T) {
+	keyPath, pub := writeTestKey(t)
+
+	// The server counts the clients it is authenticating at once
+	var authenticating, most atomic.Int32
+	serverConfig := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			n := authenticating.Add(1)
+			defer authenticating.Add(-1)
+			for m := most.Load(); n > m && !most.CompareAndSwap(m, n); m = most.Load() {
+			}
+			time.Sleep(20 * time.Millisecond)
+			if bytes.Equal(key.Marshal(), pub.Marshal()) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("unknown public key for %s", conn.User())
+		},
+	}
+	serverConfig.AddHostKey(newHostSigner(t))
+	host, port, _ := listenTestServer(t, serverConfig)
+
+	const maxConcurrent, clients = 2, 8
+	throttle := NewAuthThrottle(0, maxConcurrent)
+	var wg sync.WaitGroup
+	errs := make(chan error, clients)
+	for range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			client := New(Config{
+				User:            "testuser",
+				KeyPath:         keyPath,
+				IdentitiesOnly:  true,
+				InsecureHostKey: true,
+				AuthThrottle:    throttle,
+				Dialer:          netDialer{},
+			})
+			if err := client.Connect(context.Background(), host, port); err != nil {
+				errs <- err
+				return
+			}
+			client.Close()
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("Connect() error = %v", err)
+	}
+	if got := most.Load(); got > maxConcurrent {
+		t.Errorf("%d clients authenticated at once, want at most %d", got, maxConcurrent)
+	}
+}
+
+func TestClientConnectValidation(t *testing.T) {
+	client := New(Config{User: "testuser", Dialer: netDialer{}})
+
+	if err := client.Connect(context.Background(), "bad host;rm -rf", "22"); err == nil {
+		t.Error("Connect() should reject an invalid hostname")
+	}
+	if err := client.Connect(context.Background(), "example", "99999"); err == nil {
+		t.Error("Connect() should reject an invalid port")
+	}
+}
+
+// fakeSessionStdin stands in for a session's stdin pipe, recording the input
+// and whether EOF was sent
+type fakeSessionStdin struct {
+	bytes.Buffer
+	eof chan struct{}
+}
+
+func (f *fakeSessionStdin) Close() error {
+	close(f.eof)
+	return nil
+}
+
+func TestForwardInputEOF(t *testing.T) {
+	client := New(Config{})
+
+	// The remote shell keeps running after EOF, so only the input side ends
+	stdin := &fakeSessionStdin{eof: make(chan struct{})}
+	client.forwardInput(stdin, strings.NewReader("echo hi\n"))
+	select {
+	case <-stdin.eof:
+	default:
+		t.Fatal("forwardInput() did not send EOF when input ended")
+	}
+	if stdin.String() != "echo hi\n" {
+		t.Errorf("remote stdin = %q, want %q", stdin.String(), "echo hi\n")
+	}
+}
+
+func TestForwardInputEscapeWithShellRunning(t *testing.T) {
+	client := New(Config{})
+	shellExited := make(chan struct{})
+
+	// Ctrl-D in a raw terminal is just a byte; a shell with ignoreeof keeps
+	// running, and ~. on a new line must still close the session
+	stdin := &fakeSessionStdin{eof: make(chan struct{})}
+	in := sshclient.NewEscapeReader(strings.NewReader("sleep 100\n\x04\r~.ignored"), nil, func() { close(shellExited) })
+
+	done := make(chan struct{})
+	go func() {
+		client.forwardInput(stdin, in)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("forwardInput() did not return after ~.")
+	}
+	select {
+	case <-shellExited:
+	default:
+		t.Error("~. did not close the session")
+	}
+	if got := stdin.String(); got != "sleep 100\n\x04\r" {
+		t.Errorf("remote stdin = %q, want input up to the escape", got)
+	}
+}
+
+func TestSessionInputEscapes(t *testing.T) {
+	const input = "cat > blob\r~.\x00~?\r~~\n"
+	read := func(config Config, terminal bool) (string, bool) {
+		terminated := false
+		in := New(config).sessionInput(strings.NewReader(input), terminal, func() { terminated = true })
+		data, err := io.ReadAll(in)
+		if err != nil {
+			t.Fatalf("ReadAll() error = %v", err)
+		}
+		return string(data), terminated
+	}
+
+	if got, terminated := read(Config{}, true); got != "cat > blob\r" || !terminated {
+		t.Errorf("with escapes, input = %q (terminated %v), want it cut at ~.", got, terminated)
+	}
+	// -e none and input that isn't a terminal pass every byte through
+	for _, tt := range []struct {
+		name     string
+		config   Config
+		terminal bool
+	}{
+		{"NoEscapes", Config{NoEscapes: true}, true},
+		{"not a terminal", Config{}, false},
+	} {
+		if got, terminated := read(tt.config, tt.terminal); got != input || terminated {
+			t.Errorf("%s: input = %q (terminated %v), want %q verbatim", tt.name, got, terminated, input)
+		}
+	}
+}
+
+func TestClientSucceeds(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	client, _ := connectTestClient(t, Config{Stdout: &stdout, Stderr: &stderr})
+
+	ok, err := client.Succeeds(context.Background(), "echo done")
+	if err != nil || !ok {
+		t.Errorf("Succeeds() = %v, %v, want true", ok, err)
+	}
+	if ok, err := client.Succeeds(context.Background(), "exit 1"); err != nil || ok {
+		t.Errorf("Succeeds() of a failing command = %v, %v, want false", ok, err)
+	}
+	if stdout.Len() != 0 || stderr.Len() != 0 {
+		t.Errorf("Succeeds() wrote stdout %q, stderr %q; want its output discarded", stdout.String(), stderr.String())
+	}
+}
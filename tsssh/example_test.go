@@ -0,0 +1,76 @@
+package tsssh_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+
+	"github.com/derekg/ts-ssh/tsssh"
+)
+
+func ExampleClient_Run() {
+	client := tsssh.New(tsssh.Config{
+		User:   "admin",
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+	})
+	defer client.Close()
+
+	ctx := context.Background()
+	if err := client.Connect(ctx, "web1", "22"); err != nil {
+		log.Fatal(err)
+	}
+	if err := client.Run(ctx, "uptime"); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func ExampleClient_Upload() {
+	client := tsssh.New(tsssh.Config{User: "deploy"})
+	defer client.Close()
+
+	ctx := context.Background()
+	if err := client.Connect(ctx, "web1", ""); err != nil {
+		log.Fatal(err)
+	}
+	if err := client.Upload(ctx, "app.tar.gz", "/tmp/app.tar.gz"); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// Forward local port 5432 to a database reachable from the remote host.
+func ExampleClient_Dial() {
+	client := tsssh.New(tsssh.Config{})
+	defer client.Close()
+
+	ctx := context.Background()
+	if err := client.Connect(ctx, "bastion", ""); err != nil {
+		log.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "localhost:5432")
+	if err != nil {
+		log.Fatal(err)
+	}
+	for {
+		local, err := listener.Accept()
+		if err != nil {
+			log.Fatal(err)
+		}
+		remote, err := client.Dial(ctx, "tcp", "db.internal:5432")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			local.Close()
+			continue
+		}
+		go func() {
+			defer local.Close()
+			defer remote.Close()
+			go io.Copy(remote, local)
+			io.Copy(local, remote)
+		}()
+	}
+}
@@ -0,0 +1,104 @@
+package tsssh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	sshclient "github.com/derekg/ts-ssh/internal/client/ssh"
+	"github.com/derekg/ts-ssh/internal/config"
+	tserrors "github.com/derekg/ts-ssh/internal/errors"
+)
+
+// jumpHop is a connected jump host, which dials onward for the next hop;
+// *Client satisfies it
+type jumpHop interface {
+	Dialer
+	Close() error
+}
+
+// hopConnector connects to one jump host through dialer. via is the jump
+// host before it, empty for the hop dialled directly over the tailnet.
+type hopConnector func(ctx context.Context, user, host, port string, dialer Dialer, via string) (jumpHop, error)
+
+// connectJumpHosts connects to each jump host in turn, the first through
+// base and each later one through the hop before it, like ssh -J. It returns
+// the hops in order and the host name of the last one; hops connected before
+// a failure are closed.
+func connectJumpHosts(ctx context.Context, base Dialer, jumps []string, defaultUser string, connect hopConnector) ([]jumpHop, string, error) {
+	var hops []jumpHop
+	dialer, via := base, ""
+	for _, jump := range jumps {
+		sshUser, host, port, err := sshclient.ParseTarget(jump, defaultUser, config.DefaultSSHPort)
+		if err != nil {
+			closeHops(hops)
+			return nil, "", fmt.Errorf("invalid jump host %q: %w", jump, err)
+		}
+		hop, err := connect(ctx, sshUser, host, port, dialer, via)
+		if err != nil {
+			closeHops(hops)
+			return nil, "", fmt.Errorf("failed to connect to jump host %s: %w", host, err)
+		}
+		hops = append(hops, hop)
+		dialer, via = hop, host
+	}
+	return hops, via, nil
+}
+
+// closeHops closes hops, the last one first
+func closeHops(hops []jumpHop) {
+	for i := len(hops) - 1; i >= 0; i-- {
+		hops[i].Close()
+	}
+}
+
+// closeHops closes the connected jump hosts
+func (c *Client) closeHops() {
+	closeHops(c.hops)
+	c.hops = nil
+}
+
+// connectHop connects to one jump host with the same settings as the
+// destination. Only the first hop dials the tailnet, so AddressFamily and
+// HTTPProxy apply to it rather than the destination, and later hops have
+// their keys checked under jumpedHostKeyAlias. The hop has JumpTimeout, if
+// set, to be dialed and present its host key, so a dead hop fails fast; the
+// time starts afresh for every hop, so host key and password prompts on one
+// hop never count against the next.
+func (c *Client) connectHop(ctx context.Context, user, host, port string, dialer Dialer, via string) (jumpHop, error) {
+	cfg := c.config
+	cfg.User, cfg.Dialer, cfg.AuthThrottle = user, dialer, c.throttle
+	cfg.JumpHosts, cfg.HostKeyAlias = nil, ""
+	cfg.Stdin, cfg.Stdout, cfg.Stderr = nil, nil, nil
+	if via != "" {
+		cfg.AddressFamily, cfg.HTTPProxy = "", ""
+		cfg.HostKeyAlias = jumpedHostKeyAlias(via, host)
+	}
+
+	// The deadline bounds the dial and, through the handshake timeout, the
+	// wait for the host key. The hop only uses the context while
+	// connecting, so the connection outlives it.
+	hopCtx := ctx
+	if c.config.JumpTimeout > 0 {
+		var cancel context.CancelFunc
+		hopCtx, cancel = context.WithTimeout(ctx, c.config.JumpTimeout)
+		defer cancel()
+	}
+	hop := New(cfg)
+	if err := hop.Connect(hopCtx, host, port); err != nil {
+		if tserrors.CodeOf(err) == tserrors.ErrCodeTimeout && errors.Is(hopCtx.Err(), context.DeadlineExceeded) {
+			err = fmt.Errorf("%w (jump timeout %v)", err, c.config.JumpTimeout)
+		}
+		return nil, err
+	}
+	return hop, nil
+}
+
+// jumpedHostKeyAlias is the known_hosts name for host reached through the
+// jump host via. Names resolve on via's network, so "db" there may be a
+// different machine from "db" on the tailnet; keeping their keys apart
+// stops one from being reported as the other's changed key. A "/" can't
+// occur in a host name, so the alias can't collide with a direct entry.
+func jumpedHostKeyAlias(via, host string) string {
+	return via + "/" + host
+}
@@ -0,0 +1,275 @@
+package tsssh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	tserrors "github.com/derekg/ts-ssh/internal/errors"
+)
+
+// fakeHop is a jump host that records dials made through it
+type fakeHop struct {
+	name  string
+	log   *[]string
+	close *[]string
+}
+
+func (h fakeHop) Dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	*h.log = append(*h.log, h.name+" -> "+addr)
+	return nil, errors.New("fake hop")
+}
+
+func (h fakeHop) Close() error {
+	*h.close = append(*h.close, h.name)
+	return nil
+}
+
+func TestConnectJumpHosts(t *testing.T) {
+	var dials, closed, connected []string
+	base := fakeHop{name: "tailnet", log: &dials, close: &closed}
+	connect := func(ctx context.Context, user, host, port string, dialer Dialer, via string) (jumpHop, error) {
+		// Each hop is reached through the dialer before it
+		dialer.Dial(ctx, "tcp", net.JoinHostPort(host, port))
+		connected = append(connected, fmt.Sprintf("%s@%s:%s via=%q", user, host, port, via))
+		if host == "down" {
+			return nil, errors.New("connection refused")
+		}
+		return fakeHop{name: host, log: &dials, close: &closed}, nil
+	}
+
+	hops, via, err := connectJumpHosts(context.Background(), base, []string{"bastion", "ops@inner:2222"}, "alice", connect)
+	if err != nil {
+		t.Fatalf("connectJumpHosts() error = %v", err)
+	}
+	if via != "inner" {
+		t.Errorf("connectJumpHosts() last hop = %q, want inner", via)
+	}
+	// The destination is then dialed through the last hop
+	hops[len(hops)-1].Dial(context.Background(), "tcp", "web1:22")
+
+	wantDials := []string{"tailnet -> bastion:22", "bastion -> inner:2222", "inner -> web1:22"}
+	if strings.Join(dials, "; ") != strings.Join(wantDials, "; ") {
+		t.Errorf("dials = %q, want %q", dials, wantDials)
+	}
+	wantConnected := []string{`alice@bastion:22 via=""`, `ops@inner:2222 via="bastion"`}
+	if strings.Join(connected, "; ") != strings.Join(wantConnected, "; ") {
+		t.Errorf("connected = %q, want %q", connected, wantConnected)
+	}
+	closeHops(hops)
+	if strings.Join(closed, ",") != "inner,bastion" {
+		t.Errorf("closed = %q, want the last hop first", closed)
+	}
+
+	// A failed hop closes the ones already connected
+	closed = nil
+	_, _, err = connectJumpHosts(context.Background(), base, []string{"bastion", "down", "inner"}, "alice", connect)
+	if err == nil || !strings.Contains(err.Error(), "jump host down") {
+		t.Errorf("connectJumpHosts() error = %v, want the failed hop named", err)
+	}
+	if strings.Join(closed, ",") != "bastion" {
+		t.Errorf("closed after failure = %q, want bastion", closed)
+	}
+}
+
+// jumpTestConfig returns a Config for connecting to test servers through
+// JumpHosts without keys or keyboard-interactive prompts
+func jumpTestConfig(jumps ...string) Config {
+	return Config{
+		User:                  "alice",
+		IdentitiesOnly:        true,
+		NoKeyboardInteractive: true,
+		InsecureHostKey:       true,
+		JumpHosts:             jumps,
+		Dialer:                netDialer{},
+	}
+}
+
+func TestJumpHostsSharePasswordCache(t *testing.T) {
+	var mu sync.Mutex
+	var attempts []string
+	passwordServer := func(name string) string {
+		config := &ssh.ServerConfig{
+			PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+				mu.Lock()
+				attempts = append(attempts, name+" "+string(password))
+				mu.Unlock()
+				if string(password) == "s3cret" {
+					return nil, nil
+				}
+				return nil, errors.New("wrong password")
+			},
+		}
+		config.AddHostKey(newHostSigner(t))
+		host, port, _ := listenTestServer(t, config)
+		return net.JoinHostPort(host, port)
+	}
+	bastion, dest := passwordServer("bastion"), passwordServer("dest")
+	destHost, destPort, _ := net.SplitHostPort(dest)
+
+	// As if typed at the first hop's prompt; without the cache reaching
+	// every hop the next one would prompt again
+	config := jumpTestConfig("alice@" + bastion)
+	config.PasswordCache = NewPasswordCache()
+	config.PasswordCache.Set("s3cret")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client := New(config)
+	if err := client.Connect(ctx, destHost, destPort); err != nil {
+		t.Fatalf("Connect() through the jump host error = %v", err)
+	}
+	client.Close()
+
+	want := "bastion s3cret; dest s3cret"
+	if got := strings.Join(attempts, "; "); got != want {
+		t.Errorf("password attempts = %q, want %q", got, want)
+	}
+}
+
+func TestJumpTimeoutPerHop(t *testing.T) {
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(newHostSigner(t))
+	bastionHost, bastionPort, _ := listenTestServer(t, config)
+	bastion := net.JoinHostPort(bastionHost, bastionPort)
+
+	// A hop that accepts the connection but never starts the handshake
+	silent, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer silent.Close()
+	go func() {
+		for {
+			conn, err := silent.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	// The dead second hop fails once its JumpTimeout is up, well before its
+	// own handshake timeout
+	clientConfig := jumpTestConfig(bastion, silent.Addr().String())
+	clientConfig.JumpTimeout = 300 * time.Millisecond
+	start := time.Now()
+	err = New(clientConfig).Connect(context.Background(), "web1", "22")
+	elapsed := time.Since(start)
+	if tserrors.CodeOf(err) != tserrors.ErrCodeTimeout || !strings.Contains(err.Error(), "jump timeout") {
+		t.Errorf("Connect() error = %v, want a jump timeout", err)
+	}
+	if elapsed > 3*time.Second {
+		t.Errorf("Connect() took %v, want it stopped by the 300ms JumpTimeout", elapsed)
+	}
+
+	// Once connected, the chain outlives its timeout: a destination slower
+	// to authenticate than JumpTimeout is still reached through it
+	slow := &ssh.ServerConfig{
+		NoClientAuth: true,
+		NoClientAuthCallback: func(ssh.ConnMetadata) (*ssh.Permissions, error) {
+			time.Sleep(400 * time.Millisecond)
+			return nil, nil
+		},
+	}
+	slow.AddHostKey(newHostSigner(t))
+	destHost, destPort, _ := listenTestServer(t, slow)
+	clientConfig = jumpTestConfig(bastion)
+	clientConfig.JumpTimeout = 300 * time.Millisecond
+	client := New(clientConfig)
+	if err := client.Connect(context.Background(), destHost, destPort); err != nil {
+		t.Fatalf("Connect() through the jump host after its timeout error = %v", err)
+	}
+	client.Close()
+}
+
+func TestJumpTimeoutSkipsPrompts(t *testing.T) {
+	// The first hop takes longer than JumpTimeout to authenticate, as a
+	// password prompt would after the host key has arrived
+	slow := &ssh.ServerConfig{
+		PasswordCallback: func(ssh.ConnMetadata, []byte) (*ssh.Permissions, error) {
+			time.Sleep(600 * time.Millisecond)
+			return nil, nil
+		},
+	}
+	slow.AddHostKey(newHostSigner(t))
+	fast := &ssh.ServerConfig{NoClientAuth: true}
+	fast.AddHostKey(newHostSigner(t))
+	firstHost, firstPort, _ := listenTestServer(t, slow)
+	secondHost, secondPort, _ := listenTestServer(t, fast)
+
+	clientConfig := jumpTestConfig(net.JoinHostPort(firstHost, firstPort), net.JoinHostPort(secondHost, secondPort))
+	clientConfig.JumpTimeout = 300 * time.Millisecond
+	clientConfig.PasswordCache = NewPasswordCache()
+	clientConfig.PasswordCache.Set("s3cret")
+
+	// Neither the slow authentication nor anything before the second hop
+	// counts against the second hop's time
+	client := New(clientConfig)
+	if err := client.Connect(context.Background(), secondHost, secondPort); err != nil {
+		t.Fatalf("Connect() error = %v, want the slow first hop's authentication not to count", err)
+	}
+	client.Close()
+}
+
+func TestChangedJumpHostKeyAbortsChain(t *testing.T) {
+	var reachedInner atomic.Bool
+	bastionKey, innerKey := newHostSigner(t), newHostSigner(t)
+	bastionConfig := &ssh.ServerConfig{NoClientAuth: true}
+	bastionConfig.AddHostKey(bastionKey)
+	innerConfig := &ssh.ServerConfig{
+		NoClientAuth: true,
+		NoClientAuthCallback: func(ssh.ConnMetadata) (*ssh.Permissions, error) {
+			reachedInner.Store(true)
+			return nil, nil
+		},
+	}
+	innerConfig.AddHostKey(innerKey)
+	bastionHost, bastionPort, _ := listenTestServer(t, bastionConfig)
+	innerHost, innerPort, _ := listenTestServer(t, innerConfig)
+	bastion, inner := net.JoinHostPort(bastionHost, bastionPort), net.JoinHostPort(innerHost, innerPort)
+
+	// The inner hop is recorded under "bastion/inner", as it is reached
+	// through the bastion, and the destination, the bastion again, under
+	// "inner/bastion"
+	connectChain := func(innerRecorded ssh.PublicKey) error {
+		lines := knownhosts.Line([]string{knownhosts.Normalize(bastion)}, bastionKey.PublicKey()) + "\n" +
+			knownhosts.Line([]string{knownhosts.Normalize(net.JoinHostPort(jumpedHostKeyAlias(bastionHost, innerHost), innerPort))}, innerRecorded) + "\n" +
+			knownhosts.Line([]string{knownhosts.Normalize(net.JoinHostPort(jumpedHostKeyAlias(innerHost, bastionHost), bastionPort))}, bastionKey.PublicKey()) + "\n"
+		knownHosts := filepath.Join(t.TempDir(), "known_hosts")
+		if err := os.WriteFile(knownHosts, []byte(lines), 0600); err != nil {
+			t.Fatal(err)
+		}
+		config := jumpTestConfig(bastion, inner)
+		config.InsecureHostKey = false
+		config.UserKnownHostsFile, config.ReadOnlyKnownHosts = knownHosts, true
+		client := New(config)
+		err := client.Connect(context.Background(), bastionHost, bastionPort)
+		client.Close()
+		return err
+	}
+
+	if err := connectChain(innerKey.PublicKey()); err != nil || !reachedInner.Load() {
+		t.Fatalf("Connect() with the recorded keys = %v, want the chain connected", err)
+	}
+
+	reachedInner.Store(false)
+	err := connectChain(newHostSigner(t).PublicKey())
+	if tserrors.CodeOf(err) != tserrors.ErrCodeHostKeyVerification || !strings.Contains(err.Error(), "jump host "+innerHost) {
+		t.Errorf("Connect() with a changed inner key = %v, want a host key error for the inner hop", err)
+	}
+	if reachedInner.Load() {
+		t.Error("the chain authenticated to a hop whose key changed")
+	}
+}
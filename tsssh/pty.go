@@ -0,0 +1,90 @@
+package tsssh
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+
+	sshclient "github.com/derekg/ts-ssh/internal/client/ssh"
+)
+
+// maxPTYDimension bounds fixed terminal sizes to something a terminal can render
+const maxPTYDimension = 10000
+
+// PTYSize is a fixed pseudo-terminal size in character cells.
+type PTYSize struct {
+	Cols int
+	Rows int
+}
+
+// ParsePTYSize parses a COLSxROWS size such as "120x40".
+func ParsePTYSize(s string) (PTYSize, error) {
+	colsStr, rowsStr, ok := strings.Cut(strings.ToLower(s), "x")
+	if !ok {
+		return PTYSize{}, fmt.Errorf("invalid PTY size %q, expected COLSxROWS", s)
+	}
+	cols, err := strconv.Atoi(colsStr)
+	if err != nil || cols < 1 || cols > maxPTYDimension {
+		return PTYSize{}, fmt.Errorf("invalid PTY columns %q", colsStr)
+	}
+	rows, err := strconv.Atoi(rowsStr)
+	if err != nil || rows < 1 || rows > maxPTYDimension {
+		return PTYSize{}, fmt.Errorf("invalid PTY rows %q", rowsStr)
+	}
+	return PTYSize{Cols: cols, Rows: rows}, nil
+}
+
+// ptyModes are the terminal modes sent with a pty-req. The local terminal
+// is raw and echoes nothing, so echo is the remote pseudo-terminal's job:
+// it starts on, and a password prompt such as sudo's turns it off there.
+var ptyModes = ssh.TerminalModes{
+	ssh.ECHO:          1,
+	ssh.ICANON:        1,
+	ssh.ISIG:          1,
+	ssh.TTY_OP_ISPEED: 38400,
+	ssh.TTY_OP_OSPEED: 38400,
+}
+
+// requestPTY requests a pseudo-terminal sized to the local terminal on fd, or
+// to Config.PTYSize when set. It reports whether the remote size should follow
+// local window changes, which a fixed size suppresses.
+func (c *Client) requestPTY(session *ssh.Session, fd int) (followResize bool, err error) {
+	width, height := c.config.PTYSize.Cols, c.config.PTYSize.Rows
+	followResize = width == 0 || height == 0
+	if followResize {
+		width, height, err = sshclient.TerminalSize(fd)
+		if err != nil {
+			width, height = sshclient.DefaultTerminalWidth, sshclient.DefaultTerminalHeight
+		}
+	}
+
+	termType := os.Getenv("TERM")
+	if termType == "" {
+		termType = sshclient.DefaultTerminalType
+	}
+
+	if err := session.RequestPty(termType, height, width, ptyModes); err != nil {
+		return false, fmt.Errorf("failed to request PTY: %w", err)
+	}
+	return followResize, nil
+}
+
+// allocatePTY requests a pseudo-terminal for an interactive shell. Some sshd
+// configurations refuse them (PermitTTY no); like OpenSSH the shell then runs
+// without one after a warning on Stderr, unless RequirePTY is set.
+func (c *Client) allocatePTY(session *ssh.Session, fd int) (allocated, followResize bool, err error) {
+	followResize, err = c.requestPTY(session, fd)
+	if err == nil {
+		return true, followResize, nil
+	}
+	if c.config.RequirePTY {
+		return false, false, err
+	}
+	if c.config.Stderr != nil {
+		fmt.Fprintf(c.config.Stderr, "Warning: %v; continuing without a terminal\n", err)
+	}
+	return false, false, nil
+}
@@ -0,0 +1,171 @@
+package tsssh
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestParsePTYSize(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    PTYSize
+		wantErr bool
+	}{
+		{input: "120x40", want: PTYSize{Cols: 120, Rows: 40}},
+		{input: "80X24", want: PTYSize{Cols: 80, Rows: 24}},
+		{input: "120", wantErr: true},
+		{input: "x40", wantErr: true},
+		{input: "0x24", wantErr: true},
+		{input: "80x-1", wantErr: true},
+		{input: "99999x24", wantErr: true},
+		{input: "80x24x1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParsePTYSize(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParsePTYSize(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParsePTYSize(%q) = %+v, want %+v", tt.input, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestPTYFixedSize(t *testing.T) {
+	client, ptyReqs := connectTestClient(t, Config{PTYSize: PTYSize{Cols: 132, Rows: 43}})
+
+	session, err := client.newSession()
+	if err != nil {
+		t.Fatalf("newSession() error = %v", err)
+	}
+	defer session.Close()
+
+	// fd -1 is never a terminal, so any size sent must come from the override
+	followResize, err := client.requestPTY(session, -1)
+	if err != nil {
+		t.Fatalf("requestPTY() error = %v", err)
+	}
+	if followResize {
+		t.Error("requestPTY() should suppress resizing when a fixed size is set")
+	}
+
+	req := <-ptyReqs
+	if req.Columns != 132 || req.Rows != 43 {
+		t.Errorf("pty-req size = %dx%d, want 132x43", req.Columns, req.Rows)
+	}
+}
+
+func TestRequestPTYFollowsTerminal(t *testing.T) {
+	client, ptyReqs := connectTestClient(t, Config{})
+
+	session, err := client.newSession()
+	if err != nil {
+		t.Fatalf("newSession() error = %v", err)
+	}
+	defer session.Close()
+
+	followResize, err := client.requestPTY(session, -1)
+	if err != nil {
+		t.Fatalf("requestPTY() error = %v", err)
+	}
+	if !followResize {
+		t.Error("requestPTY() should follow window changes without a fixed size")
+	}
+
+	// Without a terminal the defaults are used
+	req := <-ptyReqs
+	if req.Columns != 80 || req.Rows != 24 {
+		t.Errorf("pty-req size = %dx%d, want default 80x24", req.Columns, req.Rows)
+	}
+}
+
+func TestRequestPTYEchoModes(t *testing.T) {
+	client, ptyReqs := connectTestClient(t, Config{})
+
+	session, err := client.newSession()
+	if err != nil {
+		t.Fatalf("newSession() error = %v", err)
+	}
+	defer session.Close()
+	if _, err := client.requestPTY(session, -1); err != nil {
+		t.Fatalf("requestPTY() error = %v", err)
+	}
+
+	// The encoded modes are opcode and uint32 value pairs ending in TTY_OP_END
+	req := <-ptyReqs
+	modes := make(map[uint8]uint32)
+	b := []byte(req.Modes)
+	for len(b) > 0 && b[0] != 0 {
+		if len(b) < 5 {
+			t.Fatalf("truncated terminal modes %x", req.Modes)
+		}
+		modes[b[0]] = uint32(b[1])<<24 | uint32(b[2])<<16 | uint32(b[3])<<8 | uint32(b[4])
+		b = b[5:]
+	}
+	if len(b) == 0 {
+		t.Fatalf("terminal modes %x lack TTY_OP_END", req.Modes)
+	}
+
+	// Echo starts on at the remote end, which can turn it off for a password
+	if v, ok := modes[ssh.ECHO]; !ok || v != 1 {
+		t.Errorf("ECHO = %d (sent %v), want 1", v, ok)
+	}
+	if v := modes[ssh.ICANON]; v != 1 {
+		t.Errorf("ICANON = %d, want 1", v)
+	}
+}
+
+func TestAllocatePTYRefused(t *testing.T) {
+	t.Setenv("TERM", "refuse")
+	var stderr bytes.Buffer
+	client, _ := connectTestClient(t, Config{Stderr: &stderr})
+
+	session, err := client.newSession()
+	if err != nil {
+		t.Fatalf("newSession() error = %v", err)
+	}
+	defer session.Close()
+
+	// A refused PTY degrades to a plain shell with a warning
+	allocated, followResize, err := client.allocatePTY(session, -1)
+	if err != nil {
+		t.Fatalf("allocatePTY() error = %v", err)
+	}
+	if allocated || followResize {
+		t.Errorf("allocatePTY() = %v, %v, want no PTY", allocated, followResize)
+	}
+	if !strings.Contains(stderr.String(), "continuing without a terminal") {
+		t.Errorf("stderr = %q, want a warning", stderr.String())
+	}
+
+	// The session is still usable
+	var stdout bytes.Buffer
+	session.Stdout = &stdout
+	if err := session.Run("echo no pty"); err != nil {
+		t.Fatalf("Run() after refused PTY error = %v", err)
+	}
+	if stdout.String() != "no pty\n" {
+		t.Errorf("Run() stdout = %q", stdout.String())
+	}
+}
+
+func TestAllocatePTYRequired(t *testing.T) {
+	t.Setenv("TERM", "refuse")
+	client, _ := connectTestClient(t, Config{RequirePTY: true})
+
+	session, err := client.newSession()
+	if err != nil {
+		t.Fatalf("newSession() error = %v", err)
+	}
+	defer session.Close()
+
+	if _, _, err := client.allocatePTY(session, -1); err == nil {
+		t.Error("allocatePTY() with RequirePTY should fail when the PTY is refused")
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"runtime"
+	"runtime/debug"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/derekg/ts-ssh/internal/crypto/pqc"
+)
+
+// version, commit and date are set at build time via -ldflags, e.g.
+//
+//	-X main.version=v1.0.0 -X main.commit=$(git rev-parse HEAD) -X main.date=$(date -u +%FT%TZ)
+//
+// commit and date fall back to the VCS information Go embeds when building
+// from a checkout.
+var (
+	version = "dev"
+	commit  = ""
+	date    = ""
+)
+
+// versionInfo is the -version -json output
+type versionInfo struct {
+	Version string   `json:"version"`
+	Commit  string   `json:"commit"`
+	Date    string   `json:"date"`
+	Go      string   `json:"go"`
+	OS      string   `json:"os"`
+	Arch    string   `json:"arch"`
+	PQC     []string `json:"pqc"` // Post-quantum key exchanges offered by default
+}
+
+// buildVersionInfo collects the version and build metadata of this binary
+func buildVersionInfo() versionInfo {
+	info := versionInfo{
+		Version: version,
+		Commit:  commit,
+		Date:    date,
+		Go:      runtime.Version(),
+		OS:      runtime.GOOS,
+		Arch:    runtime.GOARCH,
+		PQC:     []string{},
+	}
+
+	if build, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range build.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && info.Commit == "":
+				info.Commit = setting.Value
+			case setting.Key == "vcs.time" && info.Date == "":
+				info.Date = setting.Value
+			}
+		}
+	}
+
+	config := &ssh.ClientConfig{}
+	pqc.ConfigureSSHConfig(config, pqc.DefaultConfig())
+	for _, kex := range config.KeyExchanges {
+		if pqc.IsPQCKeyExchange(kex) {
+			info.PQC = append(info.PQC, kex)
+		}
+	}
+	return info
+}
+
+// printVersion writes the version, or with jsonOut all build metadata as JSON
+func printVersion(out io.Writer, jsonOut bool) error {
+	if !jsonOut {
+		_, err := fmt.Fprintln(out, version)
+		return err
+	}
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(buildVersionInfo())
+}
@@ -1,7 +1,10 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"runtime"
 	"testing"
 )
 
@@ -11,3 +14,37 @@ func TestVersionVariable(t *testing.T) {
 		t.Error("Version variable should not be empty")
 	}
 }
+
+func TestPrintVersionJSON(t *testing.T) {
+	// Simulate -ldflags "-X main.version=... -X main.commit=... -X main.date=..."
+	origVersion, origCommit, origDate := version, commit, date
+	version, commit, date = "v9.9.9", "0123456789abcdef", "2025-01-02T03:04:05Z"
+	defer func() { version, commit, date = origVersion, origCommit, origDate }()
+
+	var out bytes.Buffer
+	if err := printVersion(&out, true); err != nil {
+		t.Fatalf("printVersion() error = %v", err)
+	}
+
+	var info versionInfo
+	if err := json.Unmarshal(out.Bytes(), &info); err != nil {
+		t.Fatalf("output is not JSON: %v\n%s", err, out.String())
+	}
+	if info.Version != "v9.9.9" || info.Commit != "0123456789abcdef" || info.Date != "2025-01-02T03:04:05Z" {
+		t.Errorf("build metadata = %+v, want the values set at build time", info)
+	}
+	if info.Go != runtime.Version() || info.OS != runtime.GOOS || info.Arch != runtime.GOARCH {
+		t.Errorf("runtime metadata = %+v", info)
+	}
+	if len(info.PQC) == 0 {
+		t.Error("pqc should list the post-quantum key exchanges offered by default")
+	}
+
+	out.Reset()
+	if err := printVersion(&out, false); err != nil {
+		t.Fatalf("printVersion() error = %v", err)
+	}
+	if out.String() != "v9.9.9\n" {
+		t.Errorf("text output = %q, want just the version", out.String())
+	}
+}